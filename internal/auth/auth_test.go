@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/sithusan/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// whoami answers with the name of the principal
+func whoami(w *response.Writer, req *request.Request) {
+	principal := FromRequest(req)
+	body := []byte(principal.Scheme + " " + principal.Name)
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func serve(t *testing.T, handler server.Handler, fields ...string) *response.Response {
+	t.Helper()
+
+	raw := "GET /admin HTTP/1.1\r\nHost: localhost\r\n"
+
+	for _, field := range fields {
+		raw += field + "\r\n"
+	}
+
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	buffer := &bytes.Buffer{}
+	handler(response.NewWriter(buffer), req)
+
+	resp, err := response.ResponseFromReader(buffer, "GET")
+	require.NoError(t, err)
+
+	return resp
+}
+
+func htpasswd(t *testing.T) *Htpasswd {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret:with colon"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	h, err := ParseHtpasswd(strings.NewReader("# admins\n\nalice:" + string(hash) + "\n"))
+	require.NoError(t, err)
+
+	return h
+}
+
+func basic(user, password string) string {
+	return "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestBasic(t *testing.T) {
+	mw, err := Middleware(Options{Realm: `admin "area"`, Basic: htpasswd(t)})
+	require.NoError(t, err)
+	handler := server.Chain(whoami, mw)
+
+	resp := serve(t, handler, basic("alice", "s3cret:with colon"))
+	assert.Equal(t, response.StatusCode(response.OK), resp.StatusLine.StatusCode)
+	assert.Equal(t, "Basic alice", string(resp.Body))
+
+	// the scheme is case insensitive
+	resp = serve(t, handler, strings.Replace(basic("alice", "s3cret:with colon"), "Basic", "bAsIc", 1))
+	assert.Equal(t, "Basic alice", string(resp.Body))
+
+	for _, fields := range [][]string{
+		{},
+		{basic("alice", "wrong")},
+		{basic("bob", "s3cret:with colon")},
+		{"Authorization: Basic not-base64!"},
+		{"Authorization: Bearer abc"},
+	} {
+		resp := serve(t, handler, fields...)
+		assert.Equal(t, response.StatusCode(response.UNAUTHORIZED), resp.StatusLine.StatusCode, fields)
+		assert.Equal(t, []string{`Basic realm="admin \"area\"", charset="UTF-8"`}, resp.Headers.Values(KEY_WWW_AUTHENTICATE))
+		assert.NotContains(t, string(resp.Body), "alice")
+	}
+}
+
+func TestChallengesAndErrorFormat(t *testing.T) {
+	verifier, err := NewJWTVerifier(JWTOptions{HMACKeys: [][]byte{hmacKey}})
+	require.NoError(t, err)
+
+	mw, err := Middleware(Options{Basic: htpasswd(t), Bearer: verifier})
+	require.NoError(t, err)
+	handler := server.Chain(whoami, mw)
+
+	resp := serve(t, handler)
+	assert.Equal(t, response.StatusCode(response.UNAUTHORIZED), resp.StatusLine.StatusCode)
+	assert.Equal(t, []string{
+		`Basic realm="restricted", charset="UTF-8"`,
+		`Bearer realm="restricted"`,
+	}, resp.Headers.Values(KEY_WWW_AUTHENTICATE))
+
+	resp = serve(t, handler, "Authorization: Bearer "+signHS256(t, map[string]any{"sub": "alice", "exp": 1}), "Accept: application/json")
+	assert.Equal(t, response.StatusCode(response.UNAUTHORIZED), resp.StatusLine.StatusCode)
+	assert.Contains(t, resp.Headers.Values(KEY_WWW_AUTHENTICATE), `Bearer realm="restricted", error="invalid_token", error_description="invalid token: token expired"`)
+	assert.Equal(t, response.CONTENT_TYPE_JSON, resp.Headers["content-type"])
+	assert.Contains(t, string(resp.Body), `"status":401`)
+
+	resp = serve(t, handler, "Authorization: Bearer not a token")
+	assert.Equal(t, response.StatusCode(response.BAD_REQUEST), resp.StatusLine.StatusCode)
+	assert.Contains(t, resp.Headers.Values(KEY_WWW_AUTHENTICATE)[1], `error="invalid_request"`)
+
+	resp = serve(t, handler, "Authorization: Bearer "+signHS256(t, map[string]any{"sub": "alice", "exp": farFuture}))
+	assert.Equal(t, "Bearer alice", string(resp.Body))
+}
+
+func TestHtpasswd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	hash, err := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	// htpasswd -B writes $2y$, which is $2b$ under another name
+	require.NoError(t, os.WriteFile(path, []byte("bob:$2y$"+string(hash[4:])+"\n"), 0o600))
+
+	h, err := LoadHtpasswd(path)
+	require.NoError(t, err)
+
+	principal, err := h.VerifyBasic("bob", "pw")
+	require.NoError(t, err)
+	assert.Equal(t, "bob", principal.Name)
+
+	_, err = h.VerifyBasic("bob", "PW")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	_, err = ParseHtpasswd(strings.NewReader("carol:$apr1$salt$hash\n"))
+	assert.ErrorContains(t, err, "only bcrypt")
+
+	_, err = ParseHtpasswd(strings.NewReader("no colon here\n"))
+	assert.Error(t, err)
+
+	_, err = Middleware(Options{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/content"
+	"github.com/sithusan/httpfromtcp/internal/request"
+)
+
+const KEY_DATE = "Date"
+const KEY_CONTENT_DIGEST = "Content-Digest"
+
+// DEFAULT_CLOCK_SKEW is how far the Date of a signed request may be from the clock of the server.
+const DEFAULT_CLOCK_SKEW = 5 * time.Minute
+
+type HMACOptions struct {
+	// Keys are the shared secrets by key id, at least MIN_HMAC_KEY_SIZE bytes each. The key id
+	// is the name of the principal.
+	Keys map[string][]byte
+	// ClockSkew is empty means DEFAULT_CLOCK_SKEW.
+	ClockSkew time.Duration
+	// Now is empty means time.Now, for tests.
+	Now func() time.Time
+}
+
+/*
+HMACVerifier checks requests signed with a shared secret. The client sends the Date of the
+request, the SHA-256 digest of its body in Content-Digest (RFC9530), and an HMAC-SHA256 over
+the method, the request target, the date and the digest, one per line:
+
+	POST /orders?dry=1 HTTP/1.1
+	Date: Sun, 19 Oct 2026 10:00:00 GMT
+	Content-Digest: sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:
+	Authorization: HMAC keyId="billing", signature="<base64 of the HMAC>"
+
+The body cannot be changed without the digest, nor the digest without the signature. A date
+outside the clock skew window is refused, so a captured request cannot be replayed later on.
+*/
+type HMACVerifier struct {
+	options HMACOptions
+}
+
+func NewHMACVerifier(options HMACOptions) (*HMACVerifier, error) {
+	if len(options.Keys) == 0 {
+		return nil, fmt.Errorf("hmac verifier needs keys")
+	}
+
+	for id, key := range options.Keys {
+		if len(key) < MIN_HMAC_KEY_SIZE {
+			return nil, fmt.Errorf("HMAC key %q must be at least %d bytes, got %d", id, MIN_HMAC_KEY_SIZE, len(key))
+		}
+	}
+
+	if options.ClockSkew == 0 {
+		options.ClockSkew = DEFAULT_CLOCK_SKEW
+	}
+
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+
+	return &HMACVerifier{options: options}, nil
+}
+
+// VerifyRequest checks the signature in credentials against req, reading a streamed body whole to digest it.
+func (v *HMACVerifier) VerifyRequest(credentials string, req *request.Request) (*Principal, error) {
+	params, err := parseAuthParams(credentials)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, signature := params["keyid"], params["signature"]
+
+	if keyID == "" || signature == "" {
+		return nil, fmt.Errorf("%w: keyId and signature are required", ErrMalformedAuthorization)
+	}
+
+	mac, err := base64.StdEncoding.DecodeString(signature)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature is not base64", ErrMalformedAuthorization)
+	}
+
+	date, ok := req.Headers.Get(KEY_DATE)
+
+	if !ok {
+		return nil, fmt.Errorf("%w: no %s", ErrMalformedAuthorization, KEY_DATE)
+	}
+
+	signedAt, err := content.ParseTime(date)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedAuthorization, err)
+	}
+
+	digest, ok := req.Headers.Get(KEY_CONTENT_DIGEST)
+
+	if !ok {
+		return nil, fmt.Errorf("%w: no %s", ErrMalformedAuthorization, KEY_CONTENT_DIGEST)
+	}
+
+	key, ok := v.options.Keys[keyID]
+
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key", ErrInvalidCredentials)
+	}
+
+	expected := signHMAC(key, req.RequestLine.Method, req.RequestLine.RequestTarget, date, digest)
+
+	if !hmac.Equal(mac, expected) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidCredentials)
+	}
+
+	if skew := v.options.Now().Sub(signedAt).Abs(); skew > v.options.ClockSkew {
+		return nil, fmt.Errorf("%w: date is %s off", ErrInvalidCredentials, skew.Round(time.Second))
+	}
+
+	// the signature covers the digest, the body still has to match it
+	if err := req.ReadBody(); err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal([]byte(digest), []byte(ContentDigest(req.Body))) {
+		return nil, fmt.Errorf("%w: body does not match %s", ErrInvalidCredentials, KEY_CONTENT_DIGEST)
+	}
+
+	return &Principal{Name: keyID}, nil
+}
+
+// ContentDigest is the Content-Digest field of body, its SHA-256 per RFC9530 2.
+func ContentDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+// SignHMAC is the Authorization field for a request to method target, with the date and the digest it sends.
+func SignHMAC(keyID string, key []byte, method, target, date, digest string) string {
+	signature := base64.StdEncoding.EncodeToString(signHMAC(key, method, target, date, digest))
+
+	return SCHEME_HMAC + " keyId=" + quote(keyID) + ", signature=" + quote(signature)
+}
+
+/**
+* Helpers
+**/
+
+func signHMAC(key []byte, method, target, date, digest string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join([]string{method, target, date, digest}, "\n")))
+
+	return mac.Sum(nil)
+}
+
+/*
+parseAuthParams reads the auth-params of RFC9110 11.2, `name=token` or `name="quoted"` split by
+commas. Names are case insensitive, they come back lower case.
+*/
+func parseAuthParams(credentials string) (map[string]string, error) {
+	params := map[string]string{}
+	rest := strings.TrimSpace(credentials)
+
+	for rest != "" {
+		name, value, ok := strings.Cut(rest, "=")
+
+		if !ok {
+			return nil, fmt.Errorf("%w: auth-param without a value", ErrMalformedAuthorization)
+		}
+
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimLeft(value, " \t")
+
+		if strings.HasPrefix(value, `"`) {
+			unquoted, remaining, err := unquote(value)
+
+			if err != nil {
+				return nil, err
+			}
+
+			value, rest = unquoted, strings.TrimLeft(remaining, " \t")
+
+			if rest != "" && rest[0] != ',' {
+				return nil, fmt.Errorf("%w: auth-params must be split by commas", ErrMalformedAuthorization)
+			}
+
+			rest = strings.TrimPrefix(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(value, ",")
+			value = strings.TrimSpace(value)
+		}
+
+		params[name] = value
+		rest = strings.TrimSpace(rest)
+	}
+
+	return params, nil
+}
+
+// unquote reads the quoted-string s starts with, undoing quote, and returns what follows it.
+func unquote(s string) (string, string, error) {
+	var b strings.Builder
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+
+			if i < len(s) {
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:], nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return "", "", fmt.Errorf("%w: unterminated quoted-string", ErrMalformedAuthorization)
+}
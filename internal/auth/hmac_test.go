@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/content"
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/sithusan/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const signedTarget = "/orders?dry=1"
+
+// signed is a POST of body signed at date, its body left on the reader, edit changes the fields before it is sent.
+func signed(t *testing.T, date time.Time, body string, edit func(fields map[string]string)) *request.Request {
+	t.Helper()
+
+	fields := map[string]string{
+		KEY_DATE:           content.FormatTime(date),
+		KEY_CONTENT_DIGEST: ContentDigest([]byte(body)),
+	}
+	fields[KEY_AUTHORIZATION] = SignHMAC("billing", hmacKey, "POST", signedTarget, fields[KEY_DATE], fields[KEY_CONTENT_DIGEST])
+
+	if edit != nil {
+		edit(fields)
+	}
+
+	raw := "POST " + signedTarget + " HTTP/1.1\r\nHost: localhost\r\n"
+
+	for key, value := range fields {
+		raw += key + ": " + value + "\r\n"
+	}
+
+	raw += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+
+	req, err := request.HeadFromReader(strings.NewReader(raw), request.Limits{})
+	require.NoError(t, err)
+
+	return req
+}
+
+func TestHMAC(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	verifier, err := NewHMACVerifier(HMACOptions{
+		Keys:      map[string][]byte{"billing": hmacKey},
+		ClockSkew: time.Minute,
+		Now:       func() time.Time { return now },
+	})
+	require.NoError(t, err)
+
+	mw, err := Middleware(Options{HMAC: verifier})
+	require.NoError(t, err)
+
+	// the handler still gets the body the signature was checked against
+	handler := server.Chain(func(w *response.Writer, req *request.Request) {
+		body := []byte(FromRequest(req).Scheme + " " + FromRequest(req).Name + " " + string(req.Body))
+
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}, mw)
+
+	serveSigned := func(req *request.Request) *response.Response {
+		buffer := &bytes.Buffer{}
+		handler(response.NewWriter(buffer), req)
+
+		resp, err := response.ResponseFromReader(buffer, "POST")
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	resp := serveSigned(signed(t, now.Add(-30*time.Second), `{"amount":10}`, nil))
+	assert.Equal(t, response.StatusCode(response.OK), resp.StatusLine.StatusCode)
+	assert.Equal(t, `HMAC billing {"amount":10}`, string(resp.Body))
+
+	otherKey := []byte("another key that is 32 bytes !!!")
+
+	cases := []struct {
+		name       string
+		date       time.Time
+		body       string
+		edit       func(fields map[string]string)
+		statusCode response.StatusCode
+	}{
+		{name: "body changed", body: `{"amount":1000}`, edit: func(f map[string]string) {
+			f[KEY_CONTENT_DIGEST] = ContentDigest([]byte(`{"amount":10}`))
+			f[KEY_AUTHORIZATION] = SignHMAC("billing", hmacKey, "POST", signedTarget, f[KEY_DATE], f[KEY_CONTENT_DIGEST])
+		}, statusCode: response.UNAUTHORIZED},
+		{name: "digest changed", edit: func(f map[string]string) {
+			f[KEY_CONTENT_DIGEST] = ContentDigest([]byte("other"))
+		}, statusCode: response.UNAUTHORIZED},
+		{name: "other target", edit: func(f map[string]string) {
+			f[KEY_AUTHORIZATION] = SignHMAC("billing", hmacKey, "POST", "/orders", f[KEY_DATE], f[KEY_CONTENT_DIGEST])
+		}, statusCode: response.UNAUTHORIZED},
+		{name: "other method", edit: func(f map[string]string) {
+			f[KEY_AUTHORIZATION] = SignHMAC("billing", hmacKey, "PUT", signedTarget, f[KEY_DATE], f[KEY_CONTENT_DIGEST])
+		}, statusCode: response.UNAUTHORIZED},
+		{name: "date changed", edit: func(f map[string]string) {
+			f[KEY_DATE] = content.FormatTime(now.Add(time.Second))
+		}, statusCode: response.UNAUTHORIZED},
+		{name: "wrong key", edit: func(f map[string]string) {
+			f[KEY_AUTHORIZATION] = SignHMAC("billing", otherKey, "POST", signedTarget, f[KEY_DATE], f[KEY_CONTENT_DIGEST])
+		}, statusCode: response.UNAUTHORIZED},
+		{name: "unknown key id", edit: func(f map[string]string) {
+			f[KEY_AUTHORIZATION] = SignHMAC("shipping", hmacKey, "POST", signedTarget, f[KEY_DATE], f[KEY_CONTENT_DIGEST])
+		}, statusCode: response.UNAUTHORIZED},
+		{name: "too old", date: now.Add(-2 * time.Minute), statusCode: response.UNAUTHORIZED},
+		{name: "too far ahead", date: now.Add(2 * time.Minute), statusCode: response.UNAUTHORIZED},
+		{name: "no date", edit: func(f map[string]string) { delete(f, KEY_DATE) }, statusCode: response.BAD_REQUEST},
+		{name: "no digest", edit: func(f map[string]string) { delete(f, KEY_CONTENT_DIGEST) }, statusCode: response.BAD_REQUEST},
+		{name: "no signature", edit: func(f map[string]string) {
+			f[KEY_AUTHORIZATION] = `HMAC keyId="billing"`
+		}, statusCode: response.BAD_REQUEST},
+		{name: "unterminated quote", edit: func(f map[string]string) {
+			f[KEY_AUTHORIZATION] = `HMAC keyId="billing, signature="abc"`
+		}, statusCode: response.BAD_REQUEST},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			date := tc.date
+
+			if date.IsZero() {
+				date = now
+			}
+
+			body := tc.body
+
+			if body == "" {
+				body = `{"amount":10}`
+			}
+
+			resp := serveSigned(signed(t, date, body, tc.edit))
+			assert.Equal(t, tc.statusCode, resp.StatusLine.StatusCode)
+			assert.Equal(t, []string{`HMAC realm="restricted"`}, resp.Headers.Values(KEY_WWW_AUTHENTICATE))
+		})
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	params, err := parseAuthParams(`keyId="a \"quoted\", id" ,Signature=abc==,  extra = "x"`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"keyid": `a "quoted", id`, "signature": "abc==", "extra": "x"}, params)
+
+	for _, credentials := range []string{`keyId`, `keyId="a" signature="b"`, `keyId="a`} {
+		_, err := parseAuthParams(credentials)
+		assert.ErrorIs(t, err, ErrMalformedAuthorization, credentials)
+	}
+
+	_, err = NewHMACVerifier(HMACOptions{Keys: map[string][]byte{"short": []byte("key")}})
+	assert.ErrorContains(t, err, "at least 32 bytes")
+}
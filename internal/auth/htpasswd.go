@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+Htpasswd checks Basic credentials against lines of "user:bcrypt hash", the format
+"htpasswd -B" writes. Other hash formats of htpasswd (MD5, SHA1, crypt) are refused when
+loading, none of them is fit to store passwords anymore. Blank lines and "#" comments are skipped:
+
+	# users of the admin area
+	alice:$2y$10$3dc0l3xnbBG1F3DBLK7CgejkJDuBSnWpmN4Pq0QobeGw.nuBLwSoy
+*/
+type Htpasswd struct {
+	hashes map[string][]byte
+	// dummy is compared against for unknown users, so they take as long as known ones
+	dummy []byte
+}
+
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return ParseHtpasswd(file)
+}
+
+func ParseHtpasswd(reader io.Reader) (*Htpasswd, error) {
+	h := &Htpasswd{hashes: map[string][]byte{}}
+	cost := bcrypt.DefaultCost
+	scanner := bufio.NewScanner(reader)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(line, ":")
+
+		if !ok || user == "" {
+			return nil, fmt.Errorf("htpasswd line %d: expected \"user:hash\"", lineNumber)
+		}
+
+		hashCost, err := bcrypt.Cost([]byte(hash))
+
+		if err != nil {
+			return nil, fmt.Errorf("htpasswd line %d: user %q: only bcrypt hashes are supported: %w", lineNumber, user, err)
+		}
+
+		cost = hashCost
+		h.hashes[user] = []byte(hash)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	dummy, err := bcrypt.GenerateFromPassword([]byte("dummy password"), cost)
+
+	if err != nil {
+		return nil, err
+	}
+
+	h.dummy = dummy
+
+	return h, nil
+}
+
+func (h *Htpasswd) VerifyBasic(user, password string) (*Principal, error) {
+	hash, ok := h.hashes[user]
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(h.dummy, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Principal{Name: user}, nil
+}
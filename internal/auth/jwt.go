@@ -0,0 +1,308 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const ALG_HS256 = "HS256"
+const ALG_RS256 = "RS256"
+
+// MIN_HMAC_KEY_SIZE is the shortest HS256 key accepted, RFC7518 3.2 asks for the size of the hash output.
+const MIN_HMAC_KEY_SIZE = 32
+
+// MIN_RSA_KEY_BITS is the smallest RS256 key accepted, RFC7518 3.3.
+const MIN_RSA_KEY_BITS = 2048
+
+var ErrTokenExpired = fmt.Errorf("%w: token expired", ErrInvalidToken)
+
+type JWTOptions struct {
+	// HMACKeys verify HS256 tokens, at least MIN_HMAC_KEY_SIZE bytes each. All of them are
+	// tried, so a new key can be added before tokens are issued with it.
+	HMACKeys [][]byte
+	// RSAKeys verify RS256 tokens, see LoadRSAPublicKey.
+	RSAKeys []*rsa.PublicKey
+	// Issuer is checked against "iss" when set.
+	Issuer string
+	// Audience must be in "aud" when set.
+	Audience string
+	// Leeway tolerates clocks that are a little apart when checking "exp" and "nbf".
+	Leeway time.Duration
+	// Now is empty means time.Now, for tests.
+	Now func() time.Time
+}
+
+/*
+According to RFC7519, a JWT is three base64url parts split by ".": a header naming the
+algorithm, the claims, and the signature over the first two:
+
+	eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJzdWIiOiJhbGljZSIsImV4cCI6MTcwMDAwMDAwMH0.<signature>
+
+JWTVerifier accepts HS256 and RS256 tokens signed with its local keys. A token must carry "exp",
+one that lives forever cannot be taken back. The "sub" claim is the name of the principal.
+*/
+type JWTVerifier struct {
+	options JWTOptions
+}
+
+func NewJWTVerifier(options JWTOptions) (*JWTVerifier, error) {
+	if len(options.HMACKeys) == 0 && len(options.RSAKeys) == 0 {
+		return nil, fmt.Errorf("jwt verifier needs HMAC or RSA keys")
+	}
+
+	for _, key := range options.HMACKeys {
+		if len(key) < MIN_HMAC_KEY_SIZE {
+			return nil, fmt.Errorf("HS256 keys must be at least %d bytes, got %d", MIN_HMAC_KEY_SIZE, len(key))
+		}
+	}
+
+	for _, key := range options.RSAKeys {
+		if key == nil || key.N.BitLen() < MIN_RSA_KEY_BITS {
+			return nil, fmt.Errorf("RS256 keys must be at least %d bits", MIN_RSA_KEY_BITS)
+		}
+	}
+
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+
+	return &JWTVerifier{options: options}, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	// Crit lists extensions the verifier must understand (RFC7515 4.1.11), this one knows none
+	Crit []string `json:"crit"`
+}
+
+func (v *JWTVerifier) VerifyToken(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+
+	var header jwtHeader
+
+	if err := decodePart(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	if len(header.Crit) > 0 {
+		return nil, fmt.Errorf("%w: unsupported critical extensions %v", ErrInvalidToken, header.Crit)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature is not base64url", ErrInvalidToken)
+	}
+
+	// the algorithm comes from the token, the key type is bound to it, so an RSA public key is never an HMAC secret
+	signed := []byte(parts[0] + "." + parts[1])
+
+	switch header.Alg {
+	case ALG_HS256:
+		err = v.verifyHMAC(signed, signature)
+	case ALG_RS256:
+		err = v.verifyRSA(signed, signature)
+	default:
+		err = fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, header.Alg)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims := map[string]any{}
+
+	if err := decodePart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	sub, _ := claims["sub"].(string)
+
+	return &Principal{Name: sub, Claims: claims}, nil
+}
+
+func (v *JWTVerifier) verifyHMAC(signed, signature []byte) error {
+	for _, key := range v.options.HMACKeys {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signed)
+
+		if hmac.Equal(mac.Sum(nil), signature) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+}
+
+func (v *JWTVerifier) verifyRSA(signed, signature []byte) error {
+	digest := sha256.Sum256(signed)
+
+	for _, key := range v.options.RSAKeys {
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+}
+
+// checkClaims checks the registered claims of RFC7519 4.1 that bound where and when the token is good.
+func (v *JWTVerifier) checkClaims(claims map[string]any) error {
+	now := v.options.Now()
+
+	exp, ok, err := numericDate(claims, "exp")
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("%w: no exp", ErrInvalidToken)
+	}
+
+	if !now.Before(exp.Add(v.options.Leeway)) {
+		return ErrTokenExpired
+	}
+
+	nbf, ok, err := numericDate(claims, "nbf")
+
+	if err != nil {
+		return err
+	}
+
+	if ok && now.Add(v.options.Leeway).Before(nbf) {
+		return fmt.Errorf("%w: token not valid yet", ErrInvalidToken)
+	}
+
+	if v.options.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.options.Issuer {
+			return fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+		}
+	}
+
+	if v.options.Audience != "" && !hasAudience(claims["aud"], v.options.Audience) {
+		return fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+// LoadRSAPublicKey reads an RSA public key from a PEM file, "PUBLIC KEY" (PKIX) or "RSA PUBLIC KEY" (PKCS #1).
+func LoadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRSAPublicKey(data)
+}
+
+func ParseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+
+		if err != nil {
+			return nil, err
+		}
+
+		rsaKey, ok := key.(*rsa.PublicKey)
+
+		if !ok {
+			return nil, fmt.Errorf("public key is a %T, not RSA", key)
+		}
+
+		return rsaKey, nil
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+}
+
+/**
+* Helpers
+**/
+
+func decodePart(part string, v any) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(part)
+
+	if err != nil {
+		return fmt.Errorf("%w: part is not base64url", ErrInvalidToken)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(decoded))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w: part is not a JSON object", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+// numericDate reads a claim in seconds since the epoch, fractions allowed (RFC7519 2).
+func numericDate(claims map[string]any, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	number, ok := value.(json.Number)
+
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrInvalidToken, name)
+	}
+
+	seconds, err := number.Float64()
+
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrInvalidToken, name)
+	}
+
+	return time.UnixMilli(int64(seconds * 1000)), true, nil
+}
+
+// hasAudience tells whether aud, a string or a list of them (RFC7519 4.1.3), names audience.
+func hasAudience(aud any, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var hmacKey = []byte("0123456789abcdef0123456789abcdef")
+
+const farFuture = 4102444800 // 2100-01-01
+
+func encodePart(t *testing.T, v any) string {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signHS256WithHeader(t *testing.T, header, claims map[string]any, key []byte) string {
+	signed := encodePart(t, header) + "." + encodePart(t, claims)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signHS256(t *testing.T, claims map[string]any) string {
+	return signHS256WithHeader(t, map[string]any{"alg": "HS256", "typ": "JWT"}, claims, hmacKey)
+}
+
+func TestJWTHS256(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	verifier, err := NewJWTVerifier(JWTOptions{
+		HMACKeys: [][]byte{[]byte("a new key that is 32 bytes long!"), hmacKey},
+		Issuer:   "https://auth.example.com",
+		Audience: "orders",
+		Leeway:   time.Minute,
+		Now:      func() time.Time { return now },
+	})
+	require.NoError(t, err)
+
+	claims := map[string]any{
+		"sub": "alice",
+		"iss": "https://auth.example.com",
+		"aud": []string{"billing", "orders"},
+		"exp": now.Unix() + 60,
+		"nbf": now.Unix() + 30,
+	}
+
+	principal, err := verifier.VerifyToken(signHS256(t, claims))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", principal.Name)
+	assert.Equal(t, "orders", principal.Claims["aud"].([]any)[1])
+
+	for name, change := range map[string]func(map[string]any){
+		"expired":      func(c map[string]any) { c["exp"] = now.Unix() - 61 },
+		"no exp":       func(c map[string]any) { delete(c, "exp") },
+		"exp a string": func(c map[string]any) { c["exp"] = "tomorrow" },
+		"not yet":      func(c map[string]any) { c["nbf"] = now.Unix() + 61 },
+		"issuer":       func(c map[string]any) { c["iss"] = "https://evil.example" },
+		"audience":     func(c map[string]any) { c["aud"] = "billing" },
+	} {
+		changed := map[string]any{}
+		for k, v := range claims {
+			changed[k] = v
+		}
+		change(changed)
+
+		_, err := verifier.VerifyToken(signHS256(t, changed))
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+
+	_, err = verifier.VerifyToken(signHS256WithHeader(t, map[string]any{"alg": "HS256"}, claims, []byte("some other key, 32 bytes long...")))
+	assert.ErrorContains(t, err, "bad signature")
+
+	_, err = verifier.VerifyToken(signHS256WithHeader(t, map[string]any{"alg": "none"}, claims, hmacKey))
+	assert.ErrorContains(t, err, "unsupported alg")
+
+	_, err = verifier.VerifyToken(signHS256WithHeader(t, map[string]any{"alg": "HS256", "crit": []string{"exp"}}, claims, hmacKey))
+	assert.ErrorContains(t, err, "critical")
+
+	_, err = verifier.VerifyToken("a.b")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = NewJWTVerifier(JWTOptions{HMACKeys: [][]byte{[]byte("short")}})
+	assert.Error(t, err)
+}
+
+func TestJWTRS256(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	require.NoError(t, err)
+
+	public, err := ParseRSAPublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	require.NoError(t, err)
+
+	verifier, err := NewJWTVerifier(JWTOptions{RSAKeys: []*rsa.PublicKey{public}})
+	require.NoError(t, err)
+
+	signed := encodePart(t, map[string]any{"alg": "RS256"}) + "." + encodePart(t, map[string]any{"sub": "svc", "exp": farFuture})
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, private, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+
+	principal, err := verifier.VerifyToken(signed + "." + base64.RawURLEncoding.EncodeToString(signature))
+	require.NoError(t, err)
+	assert.Equal(t, "svc", principal.Name)
+
+	// an HS256 token "signed" with the public key must not pass: the verifier has no HMAC key at all
+	_, err = verifier.VerifyToken(signHS256WithHeader(t, map[string]any{"alg": "HS256"}, map[string]any{"sub": "svc", "exp": farFuture}, der))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	pkcs1, err := ParseRSAPublicKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&private.PublicKey)}))
+	require.NoError(t, err)
+	assert.True(t, public.Equal(pkcs1))
+}
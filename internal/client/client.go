@@ -0,0 +1,323 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+)
+
+const USER_AGENT = "httpfromtcp"
+
+const DEFAULT_MAX_REDIRECTS = 10
+const DEFAULT_MAX_IDLE_CONNS_PER_HOST = 2
+const DEFAULT_IDLE_TIMEOUT = 90 * time.Second
+
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+type Options struct {
+	// Timeout bounds a whole exchange, from sending the request to the last byte of the body, redirects each get their own.
+	// Zero means no timeout.
+	Timeout time.Duration
+	// DialTimeout bounds opening a connection (and the TLS handshake), zero means Timeout.
+	DialTimeout time.Duration
+
+	// MaxRedirects is how many redirects are followed, zero means DEFAULT_MAX_REDIRECTS and a negative number none.
+	MaxRedirects int
+
+	// MaxIdleConnsPerHost is how many connections wait for reuse per host, zero means DEFAULT_MAX_IDLE_CONNS_PER_HOST.
+	MaxIdleConnsPerHost int
+	// IdleTimeout closes connections idle for longer, zero means DEFAULT_IDLE_TIMEOUT.
+	IdleTimeout time.Duration
+
+	// MaxBodyBytes bounds a response body, zero means no limit.
+	MaxBodyBytes int
+
+	// TLSConfig is used for https, nil means the system roots.
+	TLSConfig *tls.Config
+}
+
+/*
+Client is a minimal HTTP/1.1 client. Responses are read whole, connections are kept alive
+and reused when the server allows it, and redirects are followed.
+*/
+type Client struct {
+	options Options
+	pool    *pool
+}
+
+func New(options Options) *Client {
+	if options.DialTimeout == 0 {
+		options.DialTimeout = options.Timeout
+	}
+
+	if options.MaxRedirects == 0 {
+		options.MaxRedirects = DEFAULT_MAX_REDIRECTS
+	}
+
+	if options.MaxIdleConnsPerHost == 0 {
+		options.MaxIdleConnsPerHost = DEFAULT_MAX_IDLE_CONNS_PER_HOST
+	}
+
+	if options.IdleTimeout == 0 {
+		options.IdleTimeout = DEFAULT_IDLE_TIMEOUT
+	}
+
+	return &Client{
+		options: options,
+		pool:    newPool(options.MaxIdleConnsPerHost, options.IdleTimeout),
+	}
+}
+
+// Close closes the idle connections, the client can still be used afterwards.
+func (c *Client) Close() error {
+	c.pool.closeIdle()
+
+	return nil
+}
+
+func (c *Client) Get(rawURL string) (*Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Do(req)
+}
+
+func (c *Client) Post(rawURL, contentType string, body []byte) (*Response, error) {
+	req, err := NewRequest("POST", rawURL, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Headers.Override("Content-Type", contentType)
+
+	return c.Do(req)
+}
+
+// Do sends req and follows the redirects it gets, the response is the last one.
+func (c *Client) Do(req *Request) (*Response, error) {
+	for redirects := 0; ; redirects++ {
+		resp, err := c.roundTrip(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		next, ok := redirect(req, resp)
+
+		if !ok || c.options.MaxRedirects < 0 {
+			return resp, nil
+		}
+
+		if redirects >= c.options.MaxRedirects {
+			return nil, fmt.Errorf("%w: stopped after %d", ErrTooManyRedirects, redirects)
+		}
+
+		req = next
+	}
+}
+
+/*
+roundTrip sends one request. A pooled connection may have been closed by the server while it
+was idle, that only shows when nothing comes back, so the request is sent again on a new one.
+The server may as well have run it and died before answering, so according to RFC9110 9.2.2
+only an idempotent method is sent again, any other only when none of it was written.
+*/
+func (c *Client) roundTrip(req *Request) (*Response, error) {
+	key := req.URL.Scheme + "://" + req.hostPort()
+
+	if pc := c.pool.get(key); pc != nil {
+		resp, err := c.exchange(key, pc, req)
+
+		if err == nil || !staleConn(err) {
+			return resp, err
+		}
+
+		if !idempotent(req.Method) && !errors.Is(err, errNotSent) {
+			return nil, err
+		}
+	}
+
+	pc, err := c.dial(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.exchange(key, pc, req)
+}
+
+func (c *Client) exchange(key string, pc *persistConn, req *Request) (*Response, error) {
+	if c.options.Timeout > 0 {
+		pc.conn.SetDeadline(time.Now().Add(c.options.Timeout))
+	} else {
+		pc.conn.SetDeadline(time.Time{})
+	}
+
+	counter := &countingWriter{w: pc.conn}
+
+	if err := req.write(counter); err != nil {
+		pc.conn.Close()
+
+		if counter.n == 0 {
+			return nil, fmt.Errorf("%w: %w", errNotSent, err)
+		}
+
+		return nil, err
+	}
+
+	resp, keepAlive, err := readResponse(pc.reader, req.Method, c.options.MaxBodyBytes)
+
+	if err != nil {
+		pc.conn.Close()
+		return nil, err
+	}
+
+	resp.Request = req
+
+	// whatever the server sent past the response would be read as the next one, so the connection is not reused then
+	if keepAlive && pc.reader.Buffered() == 0 {
+		c.pool.put(key, pc)
+	} else {
+		pc.conn.Close()
+	}
+
+	return resp, nil
+}
+
+func (c *Client) dial(req *Request) (*persistConn, error) {
+	ctx := context.Background()
+
+	if c.options.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.options.DialTimeout)
+		defer cancel()
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", req.hostPort())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if req.URL.Scheme == "https" {
+		config := &tls.Config{}
+
+		if c.options.TLSConfig != nil {
+			config = c.options.TLSConfig.Clone()
+		}
+
+		if config.ServerName == "" {
+			config.ServerName = req.URL.Hostname()
+		}
+
+		tlsConn := tls.Client(conn, config)
+
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		conn = tlsConn
+	}
+
+	return &persistConn{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+/*
+According to RFC9110 15.4, 301 and 302 are followed with GET for historical reasons like 303,
+while 307 and 308 repeat the method and the body. Credentials are not sent to another host.
+*/
+func redirect(req *Request, resp *Response) (*Request, bool) {
+	location, ok := resp.Headers.Get("Location")
+
+	if !ok {
+		return nil, false
+	}
+
+	method := req.Method
+	body := req.Body
+
+	switch resp.StatusCode {
+	case 301, 302, 303:
+		if method != "HEAD" {
+			method = "GET"
+		}
+		body = nil
+	case 307, 308:
+	default:
+		return nil, false
+	}
+
+	target, err := req.URL.Parse(location)
+
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		return nil, false
+	}
+
+	next := &Request{
+		Method:  method,
+		URL:     target,
+		Headers: headers.NewHeaders(),
+		Body:    body,
+	}
+
+	crossHost := !strings.EqualFold(target.Host, req.URL.Host)
+
+	for key, value := range req.Headers {
+		switch strings.ToLower(key) {
+		case "authorization", "cookie", "host":
+			if crossHost {
+				continue
+			}
+		case "content-type", "content-length":
+			if body == nil {
+				continue
+			}
+		}
+
+		next.Headers.Override(key, value)
+	}
+
+	return next, true
+}
+
+// idempotent methods have the same effect on the server sent once or several times, RFC9110 9.2.2.
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+
+	return false
+}
+
+// staleConn tells a connection the server closed while idle apart from a real failure.
+func staleConn(err error) bool {
+	return errors.Is(err, errNoResponse) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// countingWriter counts the bytes that reached w, to know whether a failed request can be sent again.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+
+	return n, err
+}
@@ -0,0 +1,319 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/fileserver"
+	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/sithusan/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	contentType, _ := req.Headers.Get("Content-Type")
+	body := []byte(req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + contentType + " " + string(req.Body))
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func chunkedHandler(w *response.Writer, req *request.Request) {
+	h := response.GetDefaultHeaders(0)
+	h.Remove("Content-Length")
+	h.Override("Transfer-Encoding", "chunked")
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(h)
+	w.WriteChunkedBody([]byte("hello "))
+	w.WriteChunkedBody([]byte("chunks"))
+
+	trailers := headers.NewHeaders()
+	trailers.Override("X-Checksum", "abc")
+	w.WriteTrailers(trailers)
+}
+
+func startServer(t *testing.T, handler server.Handler) string {
+	s, err := server.ServeWithOptions(handler, server.Options{Addr: "127.0.0.1:0"})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	return "http://" + s.Addr().String()
+}
+
+/*
+startRawServer answers every request read on a connection with the next of responses,
+it keeps the connection open between them and counts the connections it accepted.
+*/
+func startRawServer(t *testing.T, responses ...string) (string, *atomic.Int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	accepted := &atomic.Int32{}
+	next := &atomic.Int32{}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			accepted.Add(1)
+
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+
+				for {
+					if _, err := request.RequestFromReader(reader); err != nil {
+						return
+					}
+
+					index := int(next.Add(1)-1) % len(responses)
+					io.WriteString(conn, responses[index])
+
+					if strings.Contains(responses[index], "Connection: close") {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return "http://" + listener.Addr().String(), accepted
+}
+
+func TestGetAndPostAgainstServer(t *testing.T) {
+	url := startServer(t, echoHandler)
+	c := New(Options{Timeout: 5 * time.Second})
+	defer c.Close()
+
+	resp, err := c.Get(url + "/path?q=1")
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "OK", resp.ReasonPhrase)
+	assert.Equal(t, "GET /path?q=1  ", string(resp.Body))
+
+	resp, err = c.Post(url+"/submit", "application/json", []byte(`{"a":1}`))
+	require.NoError(t, err)
+	assert.Equal(t, `POST /submit application/json {"a":1}`, string(resp.Body))
+}
+
+func TestReadsChunkedBodyAndTrailers(t *testing.T) {
+	url := startServer(t, chunkedHandler)
+
+	resp, err := New(Options{}).Get(url)
+	require.NoError(t, err)
+	assert.Equal(t, "hello chunks", string(resp.Body))
+	assert.Equal(t, "abc", resp.Trailers["x-checksum"])
+}
+
+func TestFollowsRedirects(t *testing.T) {
+	fsys := fstest.MapFS{"docs/index.html": {Data: []byte("<h1>docs</h1>")}}
+	url := startServer(t, fileserver.New("/", fsys, fileserver.Options{}))
+
+	resp, err := New(Options{}).Get(url + "/docs")
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "/docs/", resp.Request.URL.Path)
+	assert.Equal(t, "<h1>docs</h1>", string(resp.Body))
+
+	resp, err = New(Options{MaxRedirects: -1}).Get(url + "/docs")
+	require.NoError(t, err)
+	assert.Equal(t, 301, resp.StatusCode)
+}
+
+func TestRedirectMethods(t *testing.T) {
+	url, _ := startRawServer(t,
+		"HTTP/1.1 303 See Other\r\nLocation: /other\r\nContent-Length: 0\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok",
+	)
+
+	req, err := NewRequest("POST", url+"/form", []byte("a=1"))
+	require.NoError(t, err)
+	req.Headers.Override("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := New(Options{}).Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, "GET", resp.Request.Method)
+	assert.Nil(t, resp.Request.Body)
+	_, ok := resp.Request.Headers.Get("Content-Type")
+	assert.False(t, ok)
+
+	loop, _ := startRawServer(t, "HTTP/1.1 307 Temporary Redirect\r\nLocation: /again\r\nContent-Length: 0\r\n\r\n")
+
+	_, err = New(Options{MaxRedirects: 3}).Get(loop)
+	require.ErrorIs(t, err, ErrTooManyRedirects)
+}
+
+func TestReusesKeepAliveConnections(t *testing.T) {
+	url, accepted := startRawServer(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	c := New(Options{})
+	defer c.Close()
+
+	for range 3 {
+		resp, err := c.Get(url)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(resp.Body))
+	}
+
+	assert.Equal(t, int32(1), accepted.Load())
+}
+
+func TestRetriesConnectionClosedWhileIdle(t *testing.T) {
+	// the server closes after every response without saying so
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			request.RequestFromReader(conn)
+			io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+			conn.Close()
+		}
+	}()
+
+	c := New(Options{Timeout: 5 * time.Second})
+	url := "http://" + listener.Addr().String()
+
+	for range 3 {
+		resp, err := c.Get(url)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(resp.Body))
+	}
+}
+
+func TestDoesNotResendNonIdempotentRequestOnStaleConnection(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := &atomic.Int32{}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			// the first request of a connection is answered, whatever comes next gets no response
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+
+				for first := true; ; first = false {
+					if _, err := request.RequestFromReader(reader); err != nil {
+						return
+					}
+
+					received.Add(1)
+
+					if !first {
+						return
+					}
+
+					io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+				}
+			}()
+		}
+	}()
+
+	c := New(Options{Timeout: 5 * time.Second})
+	defer c.Close()
+	url := "http://" + listener.Addr().String()
+
+	_, err = c.Get(url)
+	require.NoError(t, err)
+
+	// the server read the POST and died before answering, sending it again could run it twice
+	_, err = c.Post(url, "text/plain", []byte("charge"))
+	require.Error(t, err)
+	assert.Equal(t, int32(2), received.Load())
+
+	// a GET is safe to send again, the second one is read and dropped on the pooled connection then sent on a new one
+	_, err = c.Get(url)
+	require.NoError(t, err)
+
+	_, err = c.Get(url)
+	require.NoError(t, err)
+	assert.Equal(t, int32(5), received.Load())
+}
+
+func TestCloseDelimitedAndBodylessResponses(t *testing.T) {
+	url, _ := startRawServer(t, "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nuntil the end")
+
+	resp, err := New(Options{}).Get(url)
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(resp.Body))
+
+	url, accepted := startRawServer(t,
+		"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n",
+	)
+
+	c := New(Options{})
+	resp, err = c.Get(url)
+	require.NoError(t, err)
+	assert.Equal(t, 204, resp.StatusCode)
+	assert.Empty(t, resp.Body)
+
+	req, err := NewRequest("HEAD", url, nil)
+	require.NoError(t, err)
+
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Empty(t, resp.Body)
+	assert.Equal(t, int32(1), accepted.Load())
+}
+
+func TestTimeoutAndBodyLimit(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// accepts and never answers
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	start := time.Now()
+	_, err = New(Options{Timeout: 100 * time.Millisecond}).Get("http://" + listener.Addr().String())
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	url, _ := startRawServer(t, fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n%0100d", 0))
+
+	_, err = New(Options{MaxBodyBytes: 10}).Get(url)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// persistConn is a connection kept open between requests, with the reader that buffers its responses.
+type persistConn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	idleFrom time.Time
+}
+
+// pool keeps idle connections per "host:port" and scheme, most recently used last.
+type pool struct {
+	mu          sync.Mutex
+	idle        map[string][]*persistConn
+	maxPerHost  int
+	idleTimeout time.Duration
+}
+
+func newPool(maxPerHost int, idleTimeout time.Duration) *pool {
+	return &pool{
+		idle:        map[string][]*persistConn{},
+		maxPerHost:  maxPerHost,
+		idleTimeout: idleTimeout,
+	}
+}
+
+// get returns an idle connection for key, nil when there is none left that has not timed out.
+func (p *pool) get(key string) *persistConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := p.idle[key]
+
+	for len(conns) > 0 {
+		pc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+
+		if p.idleTimeout > 0 && time.Since(pc.idleFrom) > p.idleTimeout {
+			pc.conn.Close()
+			continue
+		}
+
+		p.idle[key] = conns
+
+		return pc
+	}
+
+	delete(p.idle, key)
+
+	return nil
+}
+
+// put keeps pc for later, or closes it when the host has enough idle connections already.
+func (p *pool) put(key string, pc *persistConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle[key]) >= p.maxPerHost {
+		pc.conn.Close()
+		return
+	}
+
+	pc.idleFrom = time.Now()
+	p.idle[key] = append(p.idle[key], pc)
+}
+
+func (p *pool) closeIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, conns := range p.idle {
+		for _, pc := range conns {
+			pc.conn.Close()
+		}
+
+		delete(p.idle, key)
+	}
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+)
+
+type Request struct {
+	Method  string
+	URL     *url.URL
+	Headers headers.Headers
+	Body    []byte
+}
+
+func NewRequest(method, rawURL string, body []byte) (*Request, error) {
+	parsed, err := url.Parse(rawURL)
+
+	if err != nil {
+		return nil, fmt.Errorf("malformed url %q: %s", rawURL, err)
+	}
+
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("url %q must be an absolute http or https URL", rawURL)
+	}
+
+	return &Request{
+		Method:  method,
+		URL:     parsed,
+		Headers: headers.NewHeaders(),
+		Body:    body,
+	}, nil
+}
+
+/*
+write sends the request in origin-form, the way the server parser reads it:
+
+	POST /path?query HTTP/1.1
+	host: example.com
+	content-length: 5
+
+	hello
+*/
+func (r *Request) write(w io.Writer) error {
+	h := headers.NewHeaders()
+
+	for key, value := range r.Headers {
+		h.Override(key, value)
+	}
+
+	if _, ok := h.Get("Host"); !ok {
+		h.Override("Host", r.URL.Host)
+	}
+
+	if _, ok := h.Get("User-Agent"); !ok {
+		h.Override("User-Agent", USER_AGENT)
+	}
+
+	// without a length the server assumes there is no body
+	if len(r.Body) > 0 || r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH" {
+		h.Override("Content-Length", strconv.Itoa(len(r.Body)))
+	}
+
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, "%s %s HTTP/1.1\r\n", r.Method, r.URL.RequestURI())
+
+	for key, value := range h {
+		fmt.Fprintf(buffer, "%s: %s\r\n", key, value)
+	}
+
+	buffer.WriteString("\r\n")
+	buffer.Write(r.Body)
+
+	_, err := w.Write(buffer.Bytes())
+
+	return err
+}
+
+// hostPort is where the request goes, with the default port of its scheme when the URL has none.
+func (r *Request) hostPort() string {
+	port := r.URL.Port()
+
+	if port == "" {
+		port = "80"
+
+		if r.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	return net.JoinHostPort(r.URL.Hostname(), port)
+}
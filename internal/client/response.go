@@ -0,0 +1,68 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/sithusan/httpfromtcp/internal/response"
+)
+
+var ErrBodyTooLarge = response.ErrBodyTooLarge
+
+// errNoResponse is a connection closed before the first byte of the response, the request can be sent again.
+var errNoResponse = errors.New("connection closed before the response")
+
+// errNotSent is a request that failed before any byte of it was written, any method can be sent again.
+var errNotSent = errors.New("request not sent")
+
+// MAX_HEADER_BYTES bounds the status line and the header section of a response.
+const MAX_HEADER_BYTES = 64 * 1024
+
+type Response struct {
+	HttpVersion  string
+	StatusCode   int
+	ReasonPhrase string
+	Headers      headers.Headers
+	Body         []byte
+	// Trailers are the fields sent after a chunked body, nil when there were none.
+	Trailers headers.Headers
+
+	// Request is the request that got this response, the last one after redirects.
+	Request *Request
+}
+
+/*
+readResponse reads one response off a connection with the same parser proxies and tests use.
+method tells whether a body can follow, and keepAlive reports whether the connection can carry
+another request afterwards.
+*/
+func readResponse(reader *bufio.Reader, method string, maxBodyBytes int) (*Response, bool, error) {
+	parsed, err := response.ResponseFromReaderWithLimits(reader, method, response.Limits{
+		MaxHeaderBytes: MAX_HEADER_BYTES,
+		MaxBodyBytes:   maxBodyBytes,
+	})
+
+	if err == io.EOF {
+		return nil, false, errNoResponse
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp := &Response{
+		HttpVersion:  parsed.StatusLine.HttpVersion,
+		StatusCode:   int(parsed.StatusLine.StatusCode),
+		ReasonPhrase: parsed.StatusLine.ReasonPhrase,
+		Headers:      parsed.Headers,
+		Body:         parsed.Body,
+		Trailers:     parsed.Trailers,
+	}
+
+	// whatever the parser read past the response would be lost to the next one
+	keepAlive := parsed.Persistent() && len(parsed.Unread()) == 0
+
+	return resp, keepAlive, nil
+}
@@ -0,0 +1,231 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/sithusan/httpfromtcp/internal/server"
+)
+
+const KEY_ACCEPT_ENCODING = "Accept-Encoding"
+const KEY_CONTENT_ENCODING = "Content-Encoding"
+
+const GZIP = "gzip"
+const DEFLATE = "deflate"
+
+// DEFAULT_MIN_SIZE skips bodies that are known to be smaller, the gzip header alone is 18 bytes.
+const DEFAULT_MIN_SIZE = 1024
+
+type Options struct {
+	// Level is a compress/flate level, 0 means gzip.DefaultCompression.
+	Level int
+	// MinSize is the smallest Content-Length worth compressing, 0 means DEFAULT_MIN_SIZE.
+	// Bodies without Content-Length are always compressed.
+	MinSize int
+}
+
+/*
+Middleware compresses responses with gzip or deflate, whichever Accept-Encoding prefers.
+The handler writes as usual, the body is compressed on the way out and sent chunked since
+its compressed length is only known at the end.
+*/
+func Middleware(options Options) server.Middleware {
+	if options.Level == 0 {
+		options.Level = gzip.DefaultCompression
+	}
+
+	if options.MinSize == 0 {
+		options.MinSize = DEFAULT_MIN_SIZE
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			acceptEncoding, _ := req.Headers.Get(KEY_ACCEPT_ENCODING)
+			encoding := negotiateEncoding(acceptEncoding)
+
+			// a HEAD response must announce the headers of the GET, whose compressed length is unknown here
+			if req.RequestLine.Method == "HEAD" {
+				encoding = ""
+			}
+
+			stream := &compressStream{
+				next:     w.Stream(),
+				encoding: encoding,
+				options:  options,
+			}
+
+			next(w.Filter(stream), req)
+		}
+	}
+}
+
+// compressStream is the filter between the handler and the connection.
+type compressStream struct {
+	next     response.Stream
+	encoding string
+	options  Options
+
+	// encoder is nil when the response passes through untouched
+	encoder encoder
+}
+
+// encoder is a gzip.Writer or a zlib.Writer.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func (c *compressStream) WriteHead(statusCode response.StatusCode, h headers.Headers) error {
+	if !c.eligible(statusCode, h) {
+		return c.next.WriteHead(statusCode, h)
+	}
+
+	addVary(h, KEY_ACCEPT_ENCODING)
+
+	if c.encoding == "" || c.tooSmall(h) {
+		return c.next.WriteHead(statusCode, h)
+	}
+
+	encoder, err := c.newEncoder()
+
+	if err != nil {
+		return err
+	}
+
+	c.encoder = encoder
+
+	h.Remove("Content-Length")
+	h.Override(KEY_CONTENT_ENCODING, c.encoding)
+	h.Override("Transfer-Encoding", "chunked")
+
+	// the compressed bytes are another representation, so a strong validator no longer holds
+	if etag, ok := h.Get("ETag"); ok && !strings.HasPrefix(etag, "W/") {
+		h.Override("ETag", "W/"+etag)
+	}
+
+	return c.next.WriteHead(statusCode, h)
+}
+
+func (c *compressStream) WriteData(p []byte, endStream bool) error {
+	if c.encoder == nil {
+		return c.next.WriteData(p, endStream)
+	}
+
+	if _, err := c.encoder.Write(p); err != nil {
+		return err
+	}
+
+	// a streamed body like NDJSON or a log tail is read as it comes, so each chunk leaves the encoder at once
+	if !endStream {
+		if len(p) == 0 {
+			return nil
+		}
+
+		return c.Flush()
+	}
+
+	// closing writes what is left in the encoder and its footer
+	if err := c.encoder.Close(); err != nil {
+		return err
+	}
+
+	return c.next.WriteData(nil, true)
+}
+
+func (c *compressStream) WriteTrailers(h headers.Headers) error {
+	if c.encoder != nil {
+		if err := c.encoder.Close(); err != nil {
+			return err
+		}
+	}
+
+	return c.next.WriteTrailers(h)
+}
+
+// Flush sends what the encoder holds so far, without ending the compressed stream.
+func (c *compressStream) Flush() error {
+	if c.encoder == nil {
+		return nil
+	}
+
+	return c.encoder.Flush()
+}
+
+// Write receives the output of the encoder.
+func (c *compressStream) Write(p []byte) (int, error) {
+	if err := c.next.WriteData(p, false); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (c *compressStream) newEncoder() (encoder, error) {
+	if c.encoding == GZIP {
+		return gzip.NewWriterLevel(c, c.options.Level)
+	}
+
+	// the "deflate" coding is the zlib format of RFC1950, not a raw deflate stream
+	return zlib.NewWriterLevel(c, c.options.Level)
+}
+
+// eligible responses have a body worth compressing that is not encoded already.
+func (c *compressStream) eligible(statusCode response.StatusCode, h headers.Headers) bool {
+	if statusCode < 200 || statusCode == 204 || statusCode == response.PARTIAL_CONTENT || statusCode == response.NOT_MODIFIED {
+		return false
+	}
+
+	if _, ok := h.Get(KEY_CONTENT_ENCODING); ok {
+		return false
+	}
+
+	// a range is a range of the identity bytes, compressing it would break the offsets
+	if _, ok := h.Get("Content-Range"); ok {
+		return false
+	}
+
+	contentType, _ := h.Get("Content-Type")
+
+	return compressible(contentType)
+}
+
+func (c *compressStream) tooSmall(h headers.Headers) bool {
+	contentLength, ok := h.Get("Content-Length")
+
+	if !ok {
+		return false
+	}
+
+	length, err := strconv.Atoi(strings.TrimSpace(contentLength))
+
+	return err == nil && length < c.options.MinSize
+}
+
+/**
+* Helpers
+**/
+
+func addVary(h headers.Headers, key string) {
+	vary, ok := h.Get("Vary")
+
+	if !ok || strings.TrimSpace(vary) == "" {
+		h.Override("Vary", key)
+		return
+	}
+
+	for _, field := range strings.Split(vary, ",") {
+		field = strings.TrimSpace(field)
+
+		if field == "*" || strings.EqualFold(field, key) {
+			return
+		}
+	}
+
+	h.Override("Vary", vary+", "+key)
+}
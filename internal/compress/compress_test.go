@@ -0,0 +1,269 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/sithusan/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var text = []byte(strings.Repeat("compress me, I repeat myself. ", 100))
+
+func textHandler(contentType string, body []byte) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		headers := response.GetDefaultHeaders(len(body))
+		headers.Override("Content-Type", contentType)
+		headers.Override("ETag", `"v1"`)
+
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(headers)
+		w.WriteBody(body)
+	}
+}
+
+func chunkedHandler(w *response.Writer, req *request.Request) {
+	headers := response.GetDefaultHeaders(0)
+	headers.Remove("Content-Length")
+	headers.Override("Transfer-Encoding", "chunked")
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(headers)
+
+	for i := 0; i < 10; i++ {
+		w.WriteChunkedBody(text[:100])
+	}
+
+	w.WriteChunkedBodyDone()
+}
+
+// serve returns the response head and the body with the chunked framing removed
+func serve(t *testing.T, handler server.Handler, method, acceptEncoding string) (string, []byte) {
+	buffer := &bytes.Buffer{}
+	req := request.NewRequest()
+	req.RequestLine = request.RequestLine{Method: method, RequestTarget: "/", HttpVersion: "1.1"}
+
+	if acceptEncoding != "" {
+		req.Headers.Override("Accept-Encoding", acceptEncoding)
+	}
+
+	server.Chain(handler, Middleware(Options{}))(response.NewWriter(buffer), req)
+
+	head, body, ok := strings.Cut(buffer.String(), "\r\n\r\n")
+	require.True(t, ok)
+
+	if !strings.Contains(head, "transfer-encoding: chunked") {
+		return head, []byte(body)
+	}
+
+	return head, dechunk(t, body)
+}
+
+func dechunk(t *testing.T, body string) []byte {
+	reader := bufio.NewReader(strings.NewReader(body))
+	out := []byte{}
+
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+
+		size := 0
+		_, err = fmt.Sscanf(strings.TrimSpace(line), "%x", &size)
+		require.NoError(t, err)
+
+		if size == 0 {
+			return out
+		}
+
+		chunk := make([]byte, size+2)
+		_, err = io.ReadFull(reader, chunk)
+		require.NoError(t, err)
+
+		out = append(out, chunk[:size]...)
+	}
+}
+
+func gunzip(t *testing.T, body []byte) []byte {
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	return out
+}
+
+func TestGzipsWhenAccepted(t *testing.T) {
+	head, body := serve(t, textHandler("text/plain", text), "GET", "gzip, deflate")
+
+	assert.Contains(t, head, "content-encoding: gzip")
+	assert.Contains(t, head, "vary: Accept-Encoding")
+	assert.Contains(t, head, `etag: W/"v1"`)
+	assert.NotContains(t, head, "content-length")
+	assert.Less(t, len(body), len(text))
+	assert.Equal(t, text, gunzip(t, body))
+}
+
+func TestDeflatesWhenPreferred(t *testing.T) {
+	head, body := serve(t, textHandler("application/json", text), "GET", "gzip;q=0.5, deflate")
+
+	assert.Contains(t, head, "content-encoding: deflate")
+
+	reader, err := zlib.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, text, out)
+}
+
+func TestCompressesChunkedBodies(t *testing.T) {
+	head, body := serve(t, chunkedHandler, "GET", "gzip")
+
+	assert.Contains(t, head, "content-encoding: gzip")
+	assert.Equal(t, bytes.Repeat(text[:100], 10), gunzip(t, body))
+}
+
+func TestFlushesEachChunk(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	var sent []byte
+
+	// a tail that never ends: what was written so far must be readable before the handler returns
+	handler := func(w *response.Writer, req *request.Request) {
+		headers := response.GetDefaultHeaders(0)
+		headers.Remove("Content-Length")
+		headers.Override("Content-Type", "application/x-ndjson")
+		headers.Override("Transfer-Encoding", "chunked")
+
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(headers)
+		w.WriteChunkedBody([]byte(`{"line":1}` + "\n"))
+
+		sent = bytes.Clone(buffer.Bytes())
+
+		w.WriteChunkedBodyDone()
+	}
+
+	req := request.NewRequest()
+	req.RequestLine = request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"}
+	req.Headers.Override("Accept-Encoding", "gzip")
+
+	server.Chain(handler, Middleware(Options{}))(response.NewWriter(buffer), req)
+
+	_, body, ok := strings.Cut(string(sent), "\r\n\r\n")
+	require.True(t, ok)
+
+	// the chunks so far, without the last one that has not been written yet
+	reader, err := gzip.NewReader(bytes.NewReader(dechunk(t, body+"0\r\n\r\n")))
+	require.NoError(t, err)
+
+	line := make([]byte, 11)
+	_, err = io.ReadFull(reader, line)
+	require.NoError(t, err)
+	assert.Equal(t, `{"line":1}`+"\n", string(line))
+}
+
+func TestKeepsHijackAndFlushOfTheWrappedWriter(t *testing.T) {
+	req := request.NewRequest()
+	req.RequestLine = request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"}
+	req.Headers.Override("Accept-Encoding", "gzip")
+
+	// Flush reaches the buffered writer under the filter
+	buffer := &bytes.Buffer{}
+	buffered := bufio.NewWriter(buffer)
+
+	flushing := func(w *response.Writer, req *request.Request) {
+		headers := response.GetDefaultHeaders(0)
+		headers.Remove("Content-Length")
+		headers.Override("Transfer-Encoding", "chunked")
+
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(headers)
+		w.WriteChunkedBody(text[:100])
+		require.NoError(t, w.Flush())
+	}
+
+	server.Chain(flushing, Middleware(Options{}))(response.NewWriter(buffered), req)
+	assert.Contains(t, buffer.String(), "content-encoding: gzip")
+
+	// Hijack hands over the connection of the wrapped writer
+	client, conn := net.Pipe()
+	defer client.Close()
+	defer conn.Close()
+
+	var hijacked net.Conn
+
+	hijacking := func(w *response.Writer, req *request.Request) {
+		var err error
+		hijacked, _, err = w.Hijack()
+		require.NoError(t, err)
+	}
+
+	server.Chain(hijacking, Middleware(Options{}))(response.NewConnWriter(conn, bufio.NewReader(conn)), req)
+	assert.Equal(t, conn, hijacked)
+}
+
+func TestLeavesResponsesAlone(t *testing.T) {
+	// identity only
+	head, body := serve(t, textHandler("text/plain", text), "GET", "gzip;q=0, *;q=0")
+	assert.NotContains(t, head, "content-encoding")
+	assert.Contains(t, head, "vary: Accept-Encoding")
+	assert.Equal(t, text, body)
+
+	// too small to bother
+	head, body = serve(t, textHandler("text/plain", text[:100]), "GET", "gzip")
+	assert.NotContains(t, head, "content-encoding")
+	assert.Contains(t, head, "content-length: 100")
+	assert.Equal(t, text[:100], body)
+
+	// compressed already
+	head, _ = serve(t, textHandler("image/png", text), "GET", "gzip")
+	assert.NotContains(t, head, "content-encoding")
+	assert.NotContains(t, head, "vary")
+
+	head, _ = serve(t, textHandler("text/plain", text), "HEAD", "gzip")
+	assert.NotContains(t, head, "content-encoding")
+	assert.Contains(t, head, "content-length: 3000")
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]string{
+		"":                         "",
+		"gzip":                     GZIP,
+		"x-gzip":                   GZIP,
+		"deflate":                  DEFLATE,
+		"deflate, gzip":            GZIP,
+		"br;q=1.0, deflate;q=0.2":  DEFLATE,
+		"*":                        GZIP,
+		"*;q=0.1, gzip;q=0":        DEFLATE,
+		"identity":                 "",
+		"GZIP;Q=0.7, deflate;q=.5": GZIP,
+		// a malformed or out of range weight is never preferred
+		"gzip;q=abc, deflate":     DEFLATE,
+		"gzip;q=2, deflate;q=0.5": DEFLATE,
+		"gzip;q=-1":               "",
+		"*;q=high":                "",
+	}
+
+	for acceptEncoding, expected := range cases {
+		assert.Equal(t, expected, negotiateEncoding(acceptEncoding), acceptEncoding)
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	assert.True(t, compressible("text/html; charset=utf-8"))
+	assert.True(t, compressible("image/svg+xml"))
+	assert.False(t, compressible("image/webp"))
+	assert.False(t, compressible("application/zip"))
+	assert.False(t, compressible("text/event-stream"))
+}
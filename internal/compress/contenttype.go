@@ -0,0 +1,42 @@
+package compress
+
+import "strings"
+
+// formats that are compressed already, running them through gzip again only costs CPU
+var compressedTypes = map[string]bool{
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/zip":              true,
+	"application/zstd":             true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/pdf":              true,
+	"font/woff":                    true,
+	"font/woff2":                   true,
+	// events must reach the client as they are sent, an encoder would hold them back
+	"text/event-stream": true,
+}
+
+// compressible treats images, audio and video as compressed already, except the textual SVG.
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	if mediaType == "" {
+		return false
+	}
+
+	if compressedTypes[mediaType] {
+		return false
+	}
+
+	if mediaType == "image/svg+xml" {
+		return true
+	}
+
+	major, _, _ := strings.Cut(mediaType, "/")
+
+	return major != "image" && major != "audio" && major != "video"
+}
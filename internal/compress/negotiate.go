@@ -0,0 +1,46 @@
+package compress
+
+import "github.com/sithusan/httpfromtcp/internal/negotiate"
+
+// supported codings, in order of preference when the client weighs them the same
+var encodings = []string{GZIP, DEFLATE}
+
+/*
+According to RFC9110 12.5.3, Accept-Encoding is a list of codings with optional weights:
+
+	Accept-Encoding: gzip;q=1.0, deflate;q=0.5, *;q=0
+
+"*" weighs every coding not listed and a weight of 0 means "not acceptable", like a malformed
+one, see negotiate.Parse. negotiateEncoding returns the supported coding with the highest
+weight, or "" for identity.
+*/
+func negotiateEncoding(acceptEncoding string) string {
+	weights := map[string]float64{}
+
+	for _, preference := range negotiate.Parse(acceptEncoding) {
+		weights[preference.Value] = preference.Weight
+	}
+
+	best := ""
+	bestWeight := 0.0
+
+	for _, encoding := range encodings {
+		weight, ok := weights[encoding]
+
+		// x-gzip is an alias kept for old clients
+		if !ok && encoding == GZIP {
+			weight, ok = weights["x-gzip"]
+		}
+
+		if !ok {
+			weight, ok = weights["*"]
+		}
+
+		if ok && weight > bestWeight {
+			best = encoding
+			bestWeight = weight
+		}
+	}
+
+	return best
+}
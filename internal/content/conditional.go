@@ -0,0 +1,199 @@
+package content
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+)
+
+const KEY_IF_MATCH = "If-Match"
+const KEY_IF_NONE_MATCH = "If-None-Match"
+const KEY_IF_MODIFIED_SINCE = "If-Modified-Since"
+const KEY_IF_UNMODIFIED_SINCE = "If-Unmodified-Since"
+
+// Validators identify the current state of a representation, either may be left empty.
+type Validators struct {
+	// ETag is a quoted entity tag, like "\"v1\"" or "W/\"v1\"".
+	ETag    string
+	ModTime time.Time
+}
+
+// ETag computes a strong entity tag from the bytes of a representation.
+func ETag(body []byte) string {
+	sum := sha256.Sum256(body)
+
+	return "\"" + hex.EncodeToString(sum[:16]) + "\""
+}
+
+/*
+According to RFC9110 13.2.2, preconditions are evaluated in this order:
+ 1. If-Match, or If-Unmodified-Since when If-Match is absent, false is 412
+ 2. If-None-Match, or If-Modified-Since on GET and HEAD when If-None-Match is absent,
+    false is 304 for GET and HEAD and 412 for the other methods
+
+Evaluate returns the status to answer with, ok is true when the request should go on.
+*/
+func Evaluate(req *request.Request, v Validators) (response.StatusCode, bool) {
+	method := req.RequestLine.Method
+	safe := method == "GET" || method == "HEAD"
+
+	if ifMatch, ok := req.Headers.Get(KEY_IF_MATCH); ok {
+		if !matchAny(ifMatch, v.ETag, strongMatch) {
+			return response.PRECONDITION_FAILED, false
+		}
+	} else if since, ok := req.Headers.Get(KEY_IF_UNMODIFIED_SINCE); ok {
+		if modified, ok := modifiedSince(v.ModTime, since); ok && modified {
+			return response.PRECONDITION_FAILED, false
+		}
+	}
+
+	if ifNoneMatch, ok := req.Headers.Get(KEY_IF_NONE_MATCH); ok {
+		if matchAny(ifNoneMatch, v.ETag, weakMatch) {
+			if safe {
+				return response.NOT_MODIFIED, false
+			}
+
+			return response.PRECONDITION_FAILED, false
+		}
+	} else if since, ok := req.Headers.Get(KEY_IF_MODIFIED_SINCE); ok && safe {
+		if modified, ok := modifiedSince(v.ModTime, since); ok && !modified {
+			return response.NOT_MODIFIED, false
+		}
+	}
+
+	return response.OK, true
+}
+
+/*
+Precondition answers 304 or 412 when a conditional header says so and reports true, the handler
+is then done. Otherwise nothing is written and the handler goes on with the full response.
+*/
+func Precondition(w *response.Writer, req *request.Request, v Validators) bool {
+	statusCode, ok := Evaluate(req, v)
+
+	if ok {
+		return false
+	}
+
+	if statusCode == response.NOT_MODIFIED {
+		writeNotModified(w, v)
+		return true
+	}
+
+	message := []byte("precondition failed\n")
+
+	w.WriteStatusLine(response.PRECONDITION_FAILED)
+	w.WriteHeaders(response.GetDefaultHeaders(len(message)))
+	w.WriteBody(message)
+
+	return true
+}
+
+// ServeBytes serves an in-memory body with a computed ETag, so polling clients get a cheap 304.
+func ServeBytes(w *response.Writer, req *request.Request, contentType string, body []byte) {
+	Serve(w, req, Content{
+		Reader:      bytes.NewReader(body),
+		Size:        int64(len(body)),
+		ContentType: contentType,
+		ETag:        ETag(body),
+	})
+}
+
+// a 304 has no body, it repeats the validators the client should store
+func writeNotModified(w *response.Writer, v Validators) {
+	headers := response.GetDefaultHeaders(0)
+	headers.Remove("Content-Length")
+	headers.Remove("Content-Type")
+
+	if v.ETag != "" {
+		headers.Override("ETag", v.ETag)
+	}
+
+	if !v.ModTime.IsZero() {
+		headers.Override("Last-Modified", FormatTime(v.ModTime))
+	}
+
+	w.WriteStatusLine(response.NOT_MODIFIED)
+	w.WriteHeaders(headers)
+	w.WriteBody(nil)
+}
+
+// ok is false for a malformed date or an unknown modification time, the header is then ignored
+func modifiedSince(modTime time.Time, value string) (bool, bool) {
+	date, err := ParseTime(strings.TrimSpace(value))
+
+	if err != nil || modTime.IsZero() {
+		return false, false
+	}
+
+	return modTime.Truncate(time.Second).After(date), true
+}
+
+/*
+According to RFC9110 8.8.3.2, the strong comparison needs both tags strong and equal,
+the weak comparison only compares the opaque tags.
+*/
+func strongMatch(a, b string) bool {
+	return !strings.HasPrefix(a, "W/") && a == b
+}
+
+func weakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// matchAny checks etag against a list like "\"a\", W/\"b\"", or "*" that matches the current representation whatever its tag.
+func matchAny(list, etag string, match func(a, b string) bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+
+	if etag == "" {
+		return false
+	}
+
+	for _, candidate := range parseETags(list) {
+		if match(candidate, etag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// the opaque part of a tag may contain commas, so the list is scanned quote by quote
+func parseETags(list string) []string {
+	etags := []string{}
+
+	for {
+		list = strings.TrimLeft(list, " \t,")
+
+		if list == "" {
+			return etags
+		}
+
+		prefix := ""
+
+		if strings.HasPrefix(list, "W/") {
+			prefix = "W/"
+			list = list[2:]
+		}
+
+		if !strings.HasPrefix(list, "\"") {
+			return etags
+		}
+
+		end := strings.IndexByte(list[1:], '"')
+
+		if end < 0 {
+			return etags
+		}
+
+		etags = append(etags, prefix+list[:end+2])
+		list = list[end+2:]
+	}
+}
@@ -0,0 +1,107 @@
+package content
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+)
+
+var testValidators = Validators{ETag: `"v1"`, ModTime: testModTime}
+
+func evaluate(method string, headers map[string]string) (response.StatusCode, bool) {
+	req := request.NewRequest()
+	req.RequestLine = request.RequestLine{Method: method, RequestTarget: "/", HttpVersion: "1.1"}
+
+	for key, value := range headers {
+		req.Headers.Override(key, value)
+	}
+
+	return Evaluate(req, testValidators)
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	cases := map[string]bool{
+		`"v1"`:               false,
+		`W/"v1"`:             false,
+		`"v0", "v1"`:         false,
+		`*`:                  false,
+		`"v0"`:               true,
+		`"v1,v2", W/"other"`: true,
+	}
+
+	for header, proceed := range cases {
+		statusCode, ok := evaluate("GET", map[string]string{"If-None-Match": header})
+		assert.Equal(t, proceed, ok, header)
+
+		if !proceed {
+			assert.Equal(t, response.StatusCode(response.NOT_MODIFIED), statusCode, header)
+		}
+	}
+
+	statusCode, ok := evaluate("PUT", map[string]string{"If-None-Match": "*"})
+	assert.False(t, ok)
+	assert.Equal(t, response.StatusCode(response.PRECONDITION_FAILED), statusCode)
+}
+
+func TestIfMatchUsesStrongComparison(t *testing.T) {
+	_, ok := evaluate("PUT", map[string]string{"If-Match": `"v1"`})
+	assert.True(t, ok)
+
+	_, ok = evaluate("PUT", map[string]string{"If-Match": "*"})
+	assert.True(t, ok)
+
+	statusCode, ok := evaluate("PUT", map[string]string{"If-Match": `W/"v1"`})
+	assert.False(t, ok)
+	assert.Equal(t, response.StatusCode(response.PRECONDITION_FAILED), statusCode)
+}
+
+func TestModifiedSinceDates(t *testing.T) {
+	statusCode, ok := evaluate("GET", map[string]string{"If-Modified-Since": "Fri, 01 Mar 2024 12:00:00 GMT"})
+	assert.False(t, ok)
+	assert.Equal(t, response.StatusCode(response.NOT_MODIFIED), statusCode)
+
+	_, ok = evaluate("GET", map[string]string{"If-Modified-Since": "Thu, 29 Feb 2024 12:00:00 GMT"})
+	assert.True(t, ok)
+
+	_, ok = evaluate("GET", map[string]string{"If-Modified-Since": "yesterday"})
+	assert.True(t, ok)
+
+	// If-None-Match takes precedence over If-Modified-Since
+	_, ok = evaluate("GET", map[string]string{"If-None-Match": `"v0"`, "If-Modified-Since": "Fri, 01 Mar 2024 12:00:00 GMT"})
+	assert.True(t, ok)
+
+	statusCode, ok = evaluate("DELETE", map[string]string{"If-Unmodified-Since": "Thu, 29 Feb 2024 12:00:00 GMT"})
+	assert.False(t, ok)
+	assert.Equal(t, response.StatusCode(response.PRECONDITION_FAILED), statusCode)
+
+	_, ok = evaluate("DELETE", map[string]string{"If-Unmodified-Since": "Friday, 01-Mar-24 12:00:00 GMT"})
+	assert.True(t, ok)
+}
+
+func TestServeAnswersNotModified(t *testing.T) {
+	out := serve("GET", map[string]string{"If-None-Match": `"v1"`, "Range": "bytes=0-1"})
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, out, `etag: "v1"`)
+	assert.NotContains(t, out, "content-length")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+}
+
+func TestServeBytesComputesETag(t *testing.T) {
+	body := []byte(`{"count":1}`)
+	etag := ETag(body)
+
+	assert.Equal(t, etag, ETag([]byte(`{"count":1}`)))
+	assert.NotEqual(t, etag, ETag([]byte(`{"count":2}`)))
+
+	buffer := &strings.Builder{}
+	req := request.NewRequest()
+	req.RequestLine = request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"}
+	req.Headers.Override("If-None-Match", etag)
+
+	ServeBytes(response.NewWriter(buffer), req, "application/json", body)
+	assert.True(t, strings.HasPrefix(buffer.String(), "HTTP/1.1 304 Not Modified\r\n"))
+}
@@ -0,0 +1,268 @@
+package content
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+)
+
+// Content describes a representation that can be served whole or in ranges.
+type Content struct {
+	Reader io.ReadSeeker
+	// Size is the length of Reader, zero or less finds it by seeking to the end.
+	Size        int64
+	ContentType string
+	// ModTime and ETag validate If-Range, they are sent as Last-Modified and ETag when set.
+	ModTime time.Time
+	ETag    string
+}
+
+/*
+Serve answers GET and HEAD for c, conditional headers come first and may answer 304 or 412,
+then Range is honored:
+  - no (usable) Range, or an If-Range that does not match: 200 with the whole content
+  - one range: 206 with Content-Range
+  - several ranges: 206 with a multipart/byteranges body
+  - no satisfiable range: 416 with the real size in Content-Range
+*/
+func Serve(w *response.Writer, req *request.Request, c Content) {
+	if Precondition(w, req, Validators{ETag: c.ETag, ModTime: c.ModTime}) {
+		return
+	}
+
+	size, err := c.size()
+
+	if err != nil {
+		internalError(w)
+		return
+	}
+
+	headers := response.GetDefaultHeaders(0)
+	headers.Override("Accept-Ranges", "bytes")
+	headers.Override("Content-Type", c.ContentType)
+
+	if !c.ModTime.IsZero() {
+		headers.Override("Last-Modified", FormatTime(c.ModTime))
+	}
+
+	if c.ETag != "" {
+		headers.Override("ETag", c.ETag)
+	}
+
+	ranges, err := c.requestedRanges(req, size)
+	bodyless := req.RequestLine.Method == "HEAD"
+
+	if errors.Is(err, ErrUnsatisfiable) {
+		headers.Override("Content-Range", fmt.Sprintf("bytes */%d", size))
+
+		w.WriteStatusLine(response.RANGE_NOT_SATISFIABLE)
+		w.WriteHeaders(headers)
+		w.WriteBody(nil)
+		return
+	}
+
+	switch len(ranges) {
+	case 0:
+		if _, err := c.Reader.Seek(0, io.SeekStart); err != nil {
+			internalError(w)
+			return
+		}
+
+		headers.Override("Content-Length", strconv.FormatInt(size, 10))
+		writeBody(w, response.OK, headers, io.LimitReader(c.Reader, size), bodyless)
+	case 1:
+		byteRange := ranges[0]
+
+		if _, err := c.Reader.Seek(byteRange.First, io.SeekStart); err != nil {
+			internalError(w)
+			return
+		}
+
+		headers.Override("Content-Range", byteRange.ContentRange(size))
+		headers.Override("Content-Length", strconv.FormatInt(byteRange.Length(), 10))
+		writeBody(w, response.PARTIAL_CONTENT, headers, io.LimitReader(c.Reader, byteRange.Length()), bodyless)
+	default:
+		body, length, boundary := c.multipart(ranges, size)
+
+		headers.Override("Content-Type", "multipart/byteranges; boundary="+boundary)
+		headers.Override("Content-Length", strconv.FormatInt(length, 10))
+		writeBody(w, response.PARTIAL_CONTENT, headers, body, bodyless)
+	}
+}
+
+func (c Content) size() (int64, error) {
+	if c.Size > 0 {
+		return c.Size, nil
+	}
+
+	size, err := c.Reader.Seek(0, io.SeekEnd)
+
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = c.Reader.Seek(0, io.SeekStart)
+
+	return size, err
+}
+
+// Range only applies to GET (HEAD mirrors GET), and If-Range turns it off when the content changed.
+func (c Content) requestedRanges(req *request.Request, size int64) ([]ByteRange, error) {
+	method := req.RequestLine.Method
+
+	if method != "GET" && method != "HEAD" {
+		return nil, nil
+	}
+
+	rangeHeader, ok := req.Headers.Get(KEY_RANGE)
+
+	if !ok {
+		return nil, nil
+	}
+
+	if ifRange, ok := req.Headers.Get(KEY_IF_RANGE); ok && !c.ifRangeMatches(ifRange) {
+		return nil, nil
+	}
+
+	return ParseRange(rangeHeader, size)
+}
+
+/*
+According to RFC9110 13.1.5, If-Range holds either an entity tag, compared with the strong
+comparison, or an HTTP date that must be exactly the Last-Modified date.
+*/
+func (c Content) ifRangeMatches(ifRange string) bool {
+	ifRange = strings.TrimSpace(ifRange)
+
+	if strings.HasPrefix(ifRange, "\"") || strings.HasPrefix(ifRange, "W/") {
+		return strongMatch(ifRange, c.ETag)
+	}
+
+	date, err := ParseTime(ifRange)
+
+	if err != nil || c.ModTime.IsZero() {
+		return false
+	}
+
+	return c.ModTime.Truncate(time.Second).Equal(date)
+}
+
+/*
+According to RFC9110 14.6, each part of a multipart/byteranges body carries its own
+Content-Type and Content-Range:
+
+	--boundary
+	Content-Type: text/plain
+	Content-Range: bytes 0-4/100
+
+	<bytes 0 to 4>
+	--boundary--
+*/
+func (c Content) multipart(ranges []ByteRange, size int64) (io.Reader, int64, string) {
+	boundary := newBoundary()
+	readers := []io.Reader{}
+	length := int64(0)
+
+	for i, byteRange := range ranges {
+		partHeader := "\r\n"
+
+		// no CRLF before the first delimiter, the body starts right with it
+		if i == 0 {
+			partHeader = ""
+		}
+
+		partHeader += "--" + boundary + "\r\n"
+
+		if c.ContentType != "" {
+			partHeader += "Content-Type: " + c.ContentType + "\r\n"
+		}
+
+		partHeader += "Content-Range: " + byteRange.ContentRange(size) + "\r\n\r\n"
+
+		readers = append(readers, strings.NewReader(partHeader), &sectionReader{
+			reader:    c.Reader,
+			offset:    byteRange.First,
+			remaining: byteRange.Length(),
+		})
+		length += int64(len(partHeader)) + byteRange.Length()
+	}
+
+	closing := "\r\n--" + boundary + "--\r\n"
+	readers = append(readers, strings.NewReader(closing))
+	length += int64(len(closing))
+
+	return io.MultiReader(readers...), length, boundary
+}
+
+// sectionReader seeks to its offset on first read, so parts are read one after the other from a single ReadSeeker.
+type sectionReader struct {
+	reader    io.ReadSeeker
+	offset    int64
+	remaining int64
+	seeked    bool
+}
+
+func (s *sectionReader) Read(p []byte) (int, error) {
+	if s.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if !s.seeked {
+		if _, err := s.reader.Seek(s.offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+		s.seeked = true
+	}
+
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+
+	n, err := s.reader.Read(p)
+	s.remaining -= int64(n)
+
+	if errors.Is(err, io.EOF) && s.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+/**
+* Helpers
+**/
+
+func writeBody(w *response.Writer, statusCode response.StatusCode, h headers.Headers, body io.Reader, bodyless bool) {
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+
+	if bodyless {
+		return
+	}
+
+	w.WriteBodyFrom(body)
+}
+
+func internalError(w *response.Writer) {
+	message := []byte("cannot read content\n")
+
+	w.WriteStatusLine(response.INTERNAL_SERVER_ERROR)
+	w.WriteHeaders(response.GetDefaultHeaders(len(message)))
+	w.WriteBody(message)
+}
+
+func newBoundary() string {
+	random := make([]byte, 16)
+	rand.Read(random)
+
+	return hex.EncodeToString(random)
+}
@@ -0,0 +1,115 @@
+package content
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+)
+
+const testBody = "0123456789abcdefghij"
+
+var testModTime = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func serve(method string, headers map[string]string) string {
+	buffer := &bytes.Buffer{}
+	req := request.NewRequest()
+	req.RequestLine = request.RequestLine{Method: method, RequestTarget: "/", HttpVersion: "1.1"}
+
+	for key, value := range headers {
+		req.Headers.Override(key, value)
+	}
+
+	Serve(response.NewWriter(buffer), req, Content{
+		Reader:      strings.NewReader(testBody),
+		Size:        -1,
+		ContentType: "text/plain",
+		ModTime:     testModTime,
+		ETag:        `"v1"`,
+	})
+
+	return buffer.String()
+}
+
+func TestServesWholeContentWithoutRange(t *testing.T) {
+	out := serve("GET", nil)
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "accept-ranges: bytes")
+	assert.Contains(t, out, "content-length: 20")
+	assert.Contains(t, out, "last-modified: Fri, 01 Mar 2024 12:00:00 GMT")
+	assert.Contains(t, out, `etag: "v1"`)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"+testBody))
+}
+
+func TestFindsSizeWhenUnset(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	req := request.NewRequest()
+	req.RequestLine = request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"}
+
+	Serve(response.NewWriter(buffer), req, Content{Reader: strings.NewReader(testBody), ContentType: "text/plain"})
+
+	out := buffer.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-length: 20")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"+testBody))
+}
+
+func TestServesSingleRange(t *testing.T) {
+	out := serve("GET", map[string]string{"Range": "bytes=10-14"})
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, out, "content-range: bytes 10-14/20")
+	assert.Contains(t, out, "content-length: 5")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nabcde"))
+}
+
+func TestServesMultipleRangesAsMultipart(t *testing.T) {
+	out := serve("GET", map[string]string{"Range": "bytes=0-1,-2"})
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, out, "content-type: multipart/byteranges; boundary=")
+	assert.Contains(t, out, "Content-Range: bytes 0-1/20\r\n\r\n01\r\n")
+	assert.Contains(t, out, "Content-Range: bytes 18-19/20\r\n\r\nij\r\n")
+
+	head, body, _ := strings.Cut(out, "\r\n\r\n")
+	_, boundary, _ := strings.Cut(head, "boundary=")
+	boundary = strings.Fields(boundary)[0]
+
+	assert.True(t, strings.HasPrefix(body, "--"+boundary+"\r\n"))
+	assert.True(t, strings.HasSuffix(body, "\r\n--"+boundary+"--\r\n"))
+	assert.Contains(t, head, "content-length: "+strconv.Itoa(len(body)))
+}
+
+func TestRejectsUnsatisfiableRange(t *testing.T) {
+	out := serve("GET", map[string]string{"Range": "bytes=50-"})
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 416 Range Not Satisfiable\r\n"))
+	assert.Contains(t, out, "content-range: bytes */20")
+}
+
+func TestIfRangeFallsBackToWholeContent(t *testing.T) {
+	out := serve("GET", map[string]string{"Range": "bytes=0-1", "If-Range": `"v1"`})
+	assert.Contains(t, out, "206 Partial Content")
+
+	out = serve("GET", map[string]string{"Range": "bytes=0-1", "If-Range": "Fri, 01 Mar 2024 12:00:00 GMT"})
+	assert.Contains(t, out, "206 Partial Content")
+
+	out = serve("GET", map[string]string{"Range": "bytes=0-1", "If-Range": `"v0"`})
+	assert.Contains(t, out, "200 OK")
+
+	out = serve("GET", map[string]string{"Range": "bytes=0-1", "If-Range": "Thu, 29 Feb 2024 12:00:00 GMT"})
+	assert.Contains(t, out, "200 OK")
+}
+
+func TestHeadRangeHasNoBody(t *testing.T) {
+	out := serve("HEAD", map[string]string{"Range": "bytes=0-4"})
+
+	assert.Contains(t, out, "content-length: 5")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+}
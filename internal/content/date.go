@@ -0,0 +1,33 @@
+package content
+
+import (
+	"fmt"
+	"time"
+)
+
+// TIME_FORMAT is the IMF-fixdate of RFC9110 5.6.7, always in GMT.
+const TIME_FORMAT = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// obsolete formats that recipients must still accept
+var obsoleteTimeFormats = []string{
+	"Monday, 02-Jan-06 15:04:05 GMT", // RFC 850
+	"Mon Jan _2 15:04:05 2006",       // ANSI C asctime
+}
+
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TIME_FORMAT)
+}
+
+func ParseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(TIME_FORMAT, value); err == nil {
+		return t, nil
+	}
+
+	for _, format := range obsoleteTimeFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("malformed http date: %s", value)
+}
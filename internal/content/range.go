@@ -0,0 +1,163 @@
+package content
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const KEY_RANGE = "Range"
+const KEY_IF_RANGE = "If-Range"
+
+// MAX_RANGES caps a Range header, a client asking for more gets the whole representation instead.
+const MAX_RANGES = 32
+
+var ErrUnsatisfiable = errors.New("range not satisfiable")
+var errIgnoreRange = errors.New("range ignored")
+
+// ByteRange is the inclusive span First..Last, like "bytes=First-Last".
+type ByteRange struct {
+	First int64
+	Last  int64
+}
+
+func (b ByteRange) Length() int64 {
+	return b.Last - b.First + 1
+}
+
+func (b ByteRange) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", b.First, b.Last, size)
+}
+
+/*
+According to RFC9110 14.1.2, a byte range set is a comma separated list of
+  - "first-last": both inclusive, last is clamped to the end
+  - "first-": from first to the end
+  - "-suffix": the last suffix bytes
+
+Ranges starting past the end are dropped, when nothing is left the set is unsatisfiable.
+A malformed header is ignored as if it was not sent, so the result is nil without error.
+*/
+func ParseRange(header string, size int64) ([]ByteRange, error) {
+	ranges, err := parseRange(header, size)
+
+	if errors.Is(err, errIgnoreRange) {
+		return nil, nil
+	}
+
+	return ranges, err
+}
+
+func parseRange(header string, size int64) ([]ByteRange, error) {
+	unit, set, ok := strings.Cut(header, "=")
+
+	if !ok || strings.TrimSpace(unit) != "bytes" {
+		return nil, errIgnoreRange
+	}
+
+	specs := strings.Split(set, ",")
+
+	if len(specs) > MAX_RANGES {
+		return nil, errIgnoreRange
+	}
+
+	ranges := []ByteRange{}
+	total := int64(0)
+
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+
+		if spec == "" {
+			continue
+		}
+
+		firstStr, lastStr, ok := strings.Cut(spec, "-")
+
+		if !ok {
+			return nil, errIgnoreRange
+		}
+
+		byteRange, satisfiable, err := parseSpec(strings.TrimSpace(firstStr), strings.TrimSpace(lastStr), size)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !satisfiable {
+			continue
+		}
+
+		ranges = append(ranges, byteRange)
+		total += byteRange.Length()
+	}
+
+	if len(ranges) == 0 {
+		return nil, ErrUnsatisfiable
+	}
+
+	// overlapping ranges that add up to more than the whole thing are cheaper to send as a 200
+	if total > size {
+		return nil, errIgnoreRange
+	}
+
+	return ranges, nil
+}
+
+func parseSpec(firstStr, lastStr string, size int64) (ByteRange, bool, error) {
+	if firstStr == "" {
+		suffix, err := parsePosition(lastStr)
+
+		if err != nil {
+			return ByteRange{}, false, err
+		}
+
+		if suffix == 0 || size == 0 {
+			return ByteRange{}, false, nil
+		}
+
+		return ByteRange{First: max(size-suffix, 0), Last: size - 1}, true, nil
+	}
+
+	first, err := parsePosition(firstStr)
+
+	if err != nil {
+		return ByteRange{}, false, err
+	}
+
+	last := size - 1
+
+	if lastStr != "" {
+		last, err = parsePosition(lastStr)
+
+		if err != nil {
+			return ByteRange{}, false, err
+		}
+
+		if last < first {
+			return ByteRange{}, false, errIgnoreRange
+		}
+
+		last = min(last, size-1)
+	}
+
+	if first >= size {
+		return ByteRange{}, false, nil
+	}
+
+	return ByteRange{First: first, Last: last}, true, nil
+}
+
+func parsePosition(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, errIgnoreRange
+	}
+
+	position, err := strconv.ParseInt(s, 10, 64)
+
+	if err != nil {
+		return 0, errIgnoreRange
+	}
+
+	return position, nil
+}
@@ -0,0 +1,54 @@
+package content
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSingleRanges(t *testing.T) {
+	cases := map[string]ByteRange{
+		"bytes=0-4":     {0, 4},
+		"bytes=5-":      {5, 99},
+		"bytes=-10":     {90, 99},
+		"bytes=-500":    {0, 99},
+		"bytes=90-1000": {90, 99},
+		"bytes= 1 - 2":  {1, 2},
+	}
+
+	for header, expected := range cases {
+		ranges, err := ParseRange(header, 100)
+		require.NoError(t, err, header)
+		assert.Equal(t, []ByteRange{expected}, ranges, header)
+	}
+}
+
+func TestParseMultipleRangesDropsUnsatisfiableOnes(t *testing.T) {
+	ranges, err := ParseRange("bytes=0-1, 200-300, 10-11", 100)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{0, 1}, {10, 11}}, ranges)
+}
+
+func TestParseUnsatisfiableRange(t *testing.T) {
+	_, err := ParseRange("bytes=100-", 100)
+	require.ErrorIs(t, err, ErrUnsatisfiable)
+
+	_, err = ParseRange("bytes=-0", 100)
+	require.ErrorIs(t, err, ErrUnsatisfiable)
+}
+
+func TestMalformedRangesAreIgnored(t *testing.T) {
+	for _, header := range []string{
+		"items=0-1",
+		"bytes=5-1",
+		"bytes=a-b",
+		"bytes=1",
+		"bytes=+1-2",
+		"bytes=0-99,0-99",
+	} {
+		ranges, err := ParseRange(header, 100)
+		require.NoError(t, err, header)
+		assert.Nil(t, ranges, header)
+	}
+}
@@ -0,0 +1,278 @@
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/content"
+	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/sithusan/httpfromtcp/internal/request"
+)
+
+const KEY_COOKIE = "Cookie"
+const KEY_SET_COOKIE = "Set-Cookie"
+
+type SameSite int
+
+const (
+	// SAME_SITE_DEFAULT leaves the attribute out, browsers then treat the cookie as Lax
+	SAME_SITE_DEFAULT SameSite = iota
+	SAME_SITE_LAX
+	SAME_SITE_STRICT
+	SAME_SITE_NONE
+)
+
+var ErrInvalidCookie = errors.New("invalid cookie")
+
+/*
+According to RFC6265 4.1, a server sends one Set-Cookie field per cookie:
+
+	Set-Cookie: id=a3fWa; Path=/; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Secure; HttpOnly; SameSite=Lax
+
+and the user agent returns the name/value pairs that match the request in a single Cookie field:
+
+	Cookie: id=a3fWa; theme=dark
+*/
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path   string
+	Domain string
+	// Expires is left out when zero.
+	Expires time.Time
+	// MaxAge is in seconds and left out when zero, a negative MaxAge deletes the cookie right away ("Max-Age=0").
+	MaxAge int
+
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+}
+
+// Parse reads the pairs of a Cookie field, malformed pairs are skipped like user agents may send them.
+func Parse(value string) []Cookie {
+	cookies := []Cookie{}
+
+	for _, pair := range strings.Split(value, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+
+		if !ok || validName(name) != nil {
+			continue
+		}
+
+		value, ok = parseValue(value)
+
+		if !ok {
+			continue
+		}
+
+		cookies = append(cookies, Cookie{Name: name, Value: value})
+	}
+
+	return cookies
+}
+
+// FromRequest returns the cookies the client sent, in the order it sent them.
+func FromRequest(req *request.Request) []Cookie {
+	value, ok := req.Headers.Get(KEY_COOKIE)
+
+	if !ok {
+		return []Cookie{}
+	}
+
+	return Parse(value)
+}
+
+// Get returns the value of the first cookie named name.
+func Get(req *request.Request, name string) (string, bool) {
+	for _, cookie := range FromRequest(req) {
+		if cookie.Name == name {
+			return cookie.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// Set validates c and adds its Set-Cookie field to h, next to those already there.
+func Set(h headers.Headers, c Cookie) error {
+	line, err := c.Line()
+
+	if err != nil {
+		return err
+	}
+
+	h.Add(KEY_SET_COOKIE, line)
+
+	return nil
+}
+
+// Delete asks the client to drop the cookie name, path and domain must match the ones it was set with.
+func Delete(h headers.Headers, name, path, domain string) error {
+	return Set(h, Cookie{Name: name, Path: path, Domain: domain, MaxAge: -1, Expires: time.Unix(0, 0)})
+}
+
+// Line builds the value of the Set-Cookie field for c.
+func (c Cookie) Line() (string, error) {
+	if err := c.Valid(); err != nil {
+		return "", err
+	}
+
+	b := strings.Builder{}
+	b.WriteString(c.Name + "=" + c.Value)
+
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + content.FormatTime(c.Expires))
+	}
+
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+
+	switch c.SameSite {
+	case SAME_SITE_LAX:
+		b.WriteString("; SameSite=Lax")
+	case SAME_SITE_STRICT:
+		b.WriteString("; SameSite=Strict")
+	case SAME_SITE_NONE:
+		b.WriteString("; SameSite=None")
+	}
+
+	return b.String(), nil
+}
+
+/*
+Valid checks c against the grammar of RFC6265 4.1.1 and the rules browsers enforce on top of it:
+SameSite=None needs Secure, a "__Secure-" name needs Secure, and a "__Host-" name needs
+Secure, Path=/ and no Domain.
+*/
+func (c Cookie) Valid() error {
+	if err := validName(c.Name); err != nil {
+		return err
+	}
+
+	if _, ok := parseValue(c.Value); !ok {
+		return fmt.Errorf("%w: value of %s has characters outside cookie-octet", ErrInvalidCookie, c.Name)
+	}
+
+	if !validAttribute(c.Path) {
+		return fmt.Errorf("%w: path %q", ErrInvalidCookie, c.Path)
+	}
+
+	if c.Domain != "" && !validDomain(strings.TrimPrefix(c.Domain, ".")) {
+		return fmt.Errorf("%w: domain %q", ErrInvalidCookie, c.Domain)
+	}
+
+	if c.SameSite == SAME_SITE_NONE && !c.Secure {
+		return fmt.Errorf("%w: SameSite=None requires Secure", ErrInvalidCookie)
+	}
+
+	if strings.HasPrefix(c.Name, "__Secure-") && !c.Secure {
+		return fmt.Errorf("%w: %s requires Secure", ErrInvalidCookie, c.Name)
+	}
+
+	if strings.HasPrefix(c.Name, "__Host-") && (!c.Secure || c.Path != "/" || c.Domain != "") {
+		return fmt.Errorf("%w: %s requires Secure, Path=/ and no Domain", ErrInvalidCookie, c.Name)
+	}
+
+	return nil
+}
+
+/**
+* Helpers
+**/
+
+// According to RFC6265 4.1.1, a cookie name is a token of RFC2616.
+func validName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty name", ErrInvalidCookie)
+	}
+
+	for i := 0; i < len(name); i++ {
+		if !strings.ContainsRune(tokenChars, rune(name[i])) {
+			return fmt.Errorf("%w: name %q", ErrInvalidCookie, name)
+		}
+	}
+
+	return nil
+}
+
+const tokenChars = "!#$%&'*+-.^_`|~0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+/*
+According to RFC6265 4.1.1, a value is made of cookie-octets, optionally in double quotes:
+US-ASCII characters excluding controls, whitespace, double quote, comma, semicolon and backslash.
+The quotes are part of the value, they are returned as they came.
+*/
+func parseValue(value string) (string, bool) {
+	raw := value
+
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
+	for i := 0; i < len(value); i++ {
+		b := value[i]
+
+		if b < 0x21 || b > 0x7e || b == '"' || b == ',' || b == ';' || b == '\\' {
+			return "", false
+		}
+	}
+
+	return raw, true
+}
+
+// According to RFC6265 4.1.1, Path is any character but controls and ";".
+func validAttribute(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x20 || value[i] == 0x7f || value[i] == ';' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validDomain accepts host names made of letters, digits and hyphens between dots.
+func validDomain(domain string) bool {
+	if domain == "" || len(domain) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+
+		for i := 0; i < len(label); i++ {
+			b := label[i]
+
+			if !(b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '-') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,93 @@
+package cookie
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsesRequestCookies(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader(
+		"GET / HTTP/1.1\r\nHost: localhost\r\nCookie: id=a3fWa; theme=\"dark\";bad name=x; empty=; broken\r\n\r\n",
+	))
+	require.NoError(t, err)
+
+	assert.Equal(t, []Cookie{
+		{Name: "id", Value: "a3fWa"},
+		{Name: "theme", Value: `"dark"`},
+		{Name: "empty", Value: ""},
+	}, FromRequest(req))
+
+	value, ok := Get(req, "id")
+	assert.True(t, ok)
+	assert.Equal(t, "a3fWa", value)
+
+	_, ok = Get(req, "missing")
+	assert.False(t, ok)
+}
+
+func TestBuildsSetCookieLine(t *testing.T) {
+	line, err := Cookie{
+		Name:     "id",
+		Value:    "a3fWa",
+		Path:     "/",
+		Domain:   ".example.com",
+		Expires:  time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC),
+		MaxAge:   3600,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: SAME_SITE_STRICT,
+	}.Line()
+	require.NoError(t, err)
+	assert.Equal(t, "id=a3fWa; Path=/; Domain=example.com; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=Strict", line)
+
+	line, err = Cookie{Name: "gone", MaxAge: -1}.Line()
+	require.NoError(t, err)
+	assert.Equal(t, "gone=; Max-Age=0", line)
+}
+
+func TestRejectsInvalidCookies(t *testing.T) {
+	for _, c := range []Cookie{
+		{Name: "", Value: "x"},
+		{Name: "bad name", Value: "x"},
+		{Name: "a=b", Value: "x"},
+		{Name: "id", Value: "with space"},
+		{Name: "id", Value: "semi;colon"},
+		{Name: "id", Value: "com,ma"},
+		{Name: "id", Value: "ünïcode"},
+		{Name: "id", Value: "x", Path: "/a;b"},
+		{Name: "id", Value: "x", Domain: "bad_domain.com"},
+		{Name: "id", Value: "x", SameSite: SAME_SITE_NONE},
+		{Name: "__Secure-id", Value: "x"},
+		{Name: "__Host-id", Value: "x", Secure: true, Path: "/", Domain: "example.com"},
+	} {
+		_, err := c.Line()
+		require.ErrorIs(t, err, ErrInvalidCookie, c.Name+"="+c.Value)
+	}
+}
+
+func TestWritesOneSetCookieFieldPerCookie(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	w := response.NewWriter(buffer)
+	h := response.GetDefaultHeaders(0)
+
+	require.NoError(t, Set(h, Cookie{Name: "id", Value: "1", Expires: time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)}))
+	require.NoError(t, Set(h, Cookie{Name: "theme", Value: "dark", SameSite: SAME_SITE_LAX}))
+	require.NoError(t, Delete(h, "old", "/", ""))
+	require.Error(t, Set(h, Cookie{Name: "bad name"}))
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(h)
+
+	out := buffer.String()
+	assert.Contains(t, out, "set-cookie: id=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT \r\n")
+	assert.Contains(t, out, "set-cookie: theme=dark; SameSite=Lax \r\n")
+	assert.Contains(t, out, "set-cookie: old=; Path=/; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0 \r\n")
+	assert.Equal(t, 3, strings.Count(out, "set-cookie:"))
+}
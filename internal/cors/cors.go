@@ -0,0 +1,322 @@
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/sithusan/httpfromtcp/internal/server"
+)
+
+const KEY_ORIGIN = "Origin"
+const KEY_VARY = "Vary"
+
+const KEY_REQUEST_METHOD = "Access-Control-Request-Method"
+const KEY_REQUEST_HEADERS = "Access-Control-Request-Headers"
+
+const KEY_ALLOW_ORIGIN = "Access-Control-Allow-Origin"
+const KEY_ALLOW_CREDENTIALS = "Access-Control-Allow-Credentials"
+const KEY_ALLOW_METHODS = "Access-Control-Allow-Methods"
+const KEY_ALLOW_HEADERS = "Access-Control-Allow-Headers"
+const KEY_EXPOSE_HEADERS = "Access-Control-Expose-Headers"
+const KEY_MAX_AGE = "Access-Control-Max-Age"
+
+// DEFAULT_METHODS are the CORS-safelisted methods, a browser never preflights them.
+var DEFAULT_METHODS = []string{"GET", "HEAD", "POST"}
+
+var ErrInvalidOptions = errors.New("invalid cors options")
+
+type Options struct {
+	// AllowedOrigins are origins like "https://example.com", patterns with one "*" standing for
+	// any non empty host part like "https://*.example.com", or "*" for any origin.
+	AllowedOrigins []string
+	// AllowedMethods is empty means DEFAULT_METHODS.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers a preflight may ask for, "*" allows any.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read beyond the safelisted ones.
+	ExposedHeaders []string
+	// AllowCredentials lets cookies and Authorization go along, it cannot be combined with "*".
+	AllowCredentials bool
+	// MaxAge is how long a browser may cache a preflight, zero leaves it to the browser.
+	MaxAge time.Duration
+}
+
+/*
+According to the Fetch Standard 3.2, a browser only hands a cross-origin response to a script
+when the server says so with Access-Control-* headers. A request that is not "simple", like a
+PUT or one with a JSON Content-Type, is first asked about with a preflight:
+
+	OPTIONS /orders HTTP/1.1
+	Origin: https://app.example.com
+	Access-Control-Request-Method: PUT
+	Access-Control-Request-Headers: content-type
+
+Middleware answers preflights itself, without calling the handler, and adds the headers to the
+responses of the actual requests. Requests from an origin that is not allowed pass untouched,
+without the headers the browser refuses them.
+*/
+func Middleware(options Options) (server.Middleware, error) {
+	p, err := newPolicy(options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			origin, ok := req.Headers.Get(KEY_ORIGIN)
+
+			if !ok {
+				next(w, req)
+				return
+			}
+
+			if _, preflight := req.Headers.Get(KEY_REQUEST_METHOD); preflight && req.RequestLine.Method == "OPTIONS" {
+				p.preflight(w, req, origin)
+				return
+			}
+
+			stream := &corsStream{next: w.Stream(), policy: p, origin: origin}
+			next(w.Filter(stream), req)
+		}
+	}, nil
+}
+
+type policy struct {
+	options  Options
+	any      bool
+	origins  map[string]struct{}
+	patterns [][2]string
+	methods  map[string]struct{}
+	headers  map[string]struct{}
+	// anyHeader is an AllowedHeaders of "*"
+	anyHeader bool
+}
+
+func newPolicy(options Options) (*policy, error) {
+	if len(options.AllowedMethods) == 0 {
+		options.AllowedMethods = DEFAULT_METHODS
+	}
+
+	p := &policy{
+		options: options,
+		origins: map[string]struct{}{},
+		methods: map[string]struct{}{},
+		headers: map[string]struct{}{},
+	}
+
+	for _, origin := range options.AllowedOrigins {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+
+		switch strings.Count(origin, "*") {
+		case 0:
+			p.origins[origin] = struct{}{}
+		case 1:
+			if origin == "*" {
+				p.any = true
+				continue
+			}
+
+			prefix, suffix, _ := strings.Cut(origin, "*")
+			p.patterns = append(p.patterns, [2]string{prefix, suffix})
+		default:
+			return nil, fmt.Errorf("%w: origin pattern %q has more than one \"*\"", ErrInvalidOptions, origin)
+		}
+	}
+
+	// Fetch Standard 3.2.5: "*" is not honored with credentials, reflecting any origin instead would trust every site
+	if p.any && options.AllowCredentials {
+		return nil, fmt.Errorf("%w: AllowCredentials with any origin", ErrInvalidOptions)
+	}
+
+	for _, method := range options.AllowedMethods {
+		p.methods[strings.ToUpper(method)] = struct{}{}
+	}
+
+	for _, header := range options.AllowedHeaders {
+		if header == "*" {
+			p.anyHeader = true
+			continue
+		}
+
+		p.headers[strings.ToLower(header)] = struct{}{}
+	}
+
+	if p.anyHeader && options.AllowCredentials {
+		return nil, fmt.Errorf("%w: AllowCredentials with any header", ErrInvalidOptions)
+	}
+
+	return p, nil
+}
+
+// allowed tells whether origin may read the responses, origins are compared case insensitively.
+func (p *policy) allowed(origin string) bool {
+	if p.any {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+
+	if _, ok := p.origins[origin]; ok {
+		return true
+	}
+
+	// the opaque origin of sandboxed pages or files only passes when listed
+	if origin == "null" {
+		return false
+	}
+
+	for _, pattern := range p.patterns {
+		prefix, suffix := pattern[0], pattern[1]
+
+		if len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+			continue
+		}
+
+		// "*" stands for host labels, not for a path, a port or another scheme
+		if !strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addOrigin adds the fields every response to an allowed origin carries.
+func (p *policy) addOrigin(h headers.Headers, origin string) {
+	if p.any {
+		h.Override(KEY_ALLOW_ORIGIN, "*")
+	} else {
+		h.Override(KEY_ALLOW_ORIGIN, origin)
+	}
+
+	if p.options.AllowCredentials {
+		h.Override(KEY_ALLOW_CREDENTIALS, "true")
+	}
+}
+
+func (p *policy) preflight(w *response.Writer, req *request.Request, origin string) {
+	h := response.GetDefaultHeaders(0)
+	h.Remove("Content-Type")
+
+	// the answer depends on these, a cache must not hand it to another origin
+	addVary(h, KEY_ORIGIN, KEY_REQUEST_METHOD, KEY_REQUEST_HEADERS)
+
+	method, _ := req.Headers.Get(KEY_REQUEST_METHOD)
+	requested, _ := req.Headers.Get(KEY_REQUEST_HEADERS)
+
+	// without the Access-Control-Allow-* fields the browser does not send the actual request
+	if p.allowed(origin) && p.allowsMethod(method) && p.allowsHeaders(requested) {
+		p.addOrigin(h, origin)
+		h.Override(KEY_ALLOW_METHODS, strings.Join(p.options.AllowedMethods, ", "))
+
+		if strings.TrimSpace(requested) != "" {
+			h.Override(KEY_ALLOW_HEADERS, requested)
+		}
+
+		if p.options.MaxAge > 0 {
+			h.Override(KEY_MAX_AGE, strconv.Itoa(int(p.options.MaxAge/time.Second)))
+		}
+	}
+
+	w.WriteStatusLine(response.NO_CONTENT)
+	w.WriteHeaders(h)
+	w.WriteBody(nil)
+}
+
+func (p *policy) allowsMethod(method string) bool {
+	_, ok := p.methods[strings.ToUpper(strings.TrimSpace(method))]
+
+	return ok
+}
+
+// allowsHeaders tells whether every header in the Access-Control-Request-Headers list is allowed.
+func (p *policy) allowsHeaders(requested string) bool {
+	if p.anyHeader {
+		return true
+	}
+
+	for header := range strings.SplitSeq(requested, ",") {
+		header = strings.ToLower(strings.TrimSpace(header))
+
+		if header == "" {
+			continue
+		}
+
+		if _, ok := p.headers[header]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// corsStream adds the Access-Control-* fields to the response of an actual request.
+type corsStream struct {
+	next   response.Stream
+	policy *policy
+	origin string
+}
+
+func (s *corsStream) WriteHead(statusCode response.StatusCode, h headers.Headers) error {
+	// with a list of origins the field names one of them, so the response varies with Origin
+	if !s.policy.any {
+		addVary(h, KEY_ORIGIN)
+	}
+
+	if s.policy.allowed(s.origin) {
+		s.policy.addOrigin(h, s.origin)
+
+		if len(s.policy.options.ExposedHeaders) > 0 {
+			h.Override(KEY_EXPOSE_HEADERS, strings.Join(s.policy.options.ExposedHeaders, ", "))
+		}
+	}
+
+	return s.next.WriteHead(statusCode, h)
+}
+
+func (s *corsStream) WriteData(p []byte, endStream bool) error {
+	return s.next.WriteData(p, endStream)
+}
+
+func (s *corsStream) WriteTrailers(h headers.Headers) error {
+	return s.next.WriteTrailers(h)
+}
+
+/**
+* Helpers
+**/
+
+// addVary appends names to the Vary field the handler may already have set.
+func addVary(h headers.Headers, names ...string) {
+	vary, _ := h.Get(KEY_VARY)
+
+	for _, name := range names {
+		if vary == "" {
+			vary = name
+			continue
+		}
+
+		if !containsToken(vary, name) {
+			vary += ", " + name
+		}
+	}
+
+	h.Override(KEY_VARY, vary)
+}
+
+func containsToken(list, token string) bool {
+	for part := range strings.SplitSeq(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+
+	return false
+}
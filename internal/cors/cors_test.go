@@ -0,0 +1,139 @@
+package cors
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/sithusan/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hello(w *response.Writer, req *request.Request) {
+	body := []byte("hello")
+	h := response.GetDefaultHeaders(len(body))
+	h.Override("Vary", "Accept")
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+// serve runs handler for one request with the given extra header lines
+func serve(t *testing.T, handler server.Handler, method string, fields ...string) *response.Response {
+	t.Helper()
+
+	raw := method + " /orders HTTP/1.1\r\nHost: api.example.com\r\n"
+
+	for _, field := range fields {
+		raw += field + "\r\n"
+	}
+
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	buffer := &bytes.Buffer{}
+	handler(response.NewWriter(buffer), req)
+
+	resp, err := response.ResponseFromReader(buffer, method)
+	require.NoError(t, err)
+
+	return resp
+}
+
+func middleware(t *testing.T, options Options) server.Handler {
+	mw, err := Middleware(options)
+	require.NoError(t, err)
+
+	return server.Chain(hello, mw)
+}
+
+func TestPreflight(t *testing.T) {
+	handler := middleware(t, Options{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{"GET", "PUT"},
+		AllowedHeaders:   []string{"Content-Type", "X-Request-ID"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+
+	resp := serve(t, handler, "OPTIONS",
+		"Origin: https://app.example.com",
+		"Access-Control-Request-Method: PUT",
+		"Access-Control-Request-Headers: content-type, x-request-id")
+
+	assert.Equal(t, response.StatusCode(response.NO_CONTENT), resp.StatusLine.StatusCode)
+	assert.Equal(t, "https://app.example.com", resp.Headers["access-control-allow-origin"])
+	assert.Equal(t, "true", resp.Headers["access-control-allow-credentials"])
+	assert.Equal(t, "GET, PUT", resp.Headers["access-control-allow-methods"])
+	assert.Equal(t, "content-type, x-request-id", resp.Headers["access-control-allow-headers"])
+	assert.Equal(t, "600", resp.Headers["access-control-max-age"])
+	assert.Contains(t, resp.Headers["vary"], "Origin")
+	assert.Empty(t, resp.Body)
+
+	// a method, a header or an origin that is not allowed gets no Access-Control-* fields
+	for _, fields := range [][]string{
+		{"Origin: https://app.example.com", "Access-Control-Request-Method: DELETE"},
+		{"Origin: https://app.example.com", "Access-Control-Request-Method: PUT", "Access-Control-Request-Headers: authorization"},
+		{"Origin: https://evil.example", "Access-Control-Request-Method: PUT"},
+	} {
+		resp := serve(t, handler, "OPTIONS", fields...)
+		assert.Equal(t, response.StatusCode(response.NO_CONTENT), resp.StatusLine.StatusCode)
+		assert.NotContains(t, resp.Headers, "access-control-allow-origin", fields)
+	}
+
+	// an OPTIONS that is not a preflight is the handler's
+	resp = serve(t, handler, "OPTIONS", "Origin: https://app.example.com")
+	assert.Equal(t, "hello", string(resp.Body))
+}
+
+func TestActualRequest(t *testing.T) {
+	handler := middleware(t, Options{
+		AllowedOrigins: []string{"https://*.example.com"},
+		ExposedHeaders: []string{"X-Total-Count"},
+	})
+
+	resp := serve(t, handler, "GET", "Origin: https://shop.eu.example.com")
+	assert.Equal(t, "hello", string(resp.Body))
+	assert.Equal(t, "https://shop.eu.example.com", resp.Headers["access-control-allow-origin"])
+	assert.Equal(t, "X-Total-Count", resp.Headers["access-control-expose-headers"])
+	assert.Equal(t, "Accept, Origin", resp.Headers["vary"])
+	assert.NotContains(t, resp.Headers, "access-control-allow-credentials")
+
+	for _, origin := range []string{"https://example.com", "https://evil.com/.example.com", "http://shop.example.com", "null"} {
+		resp := serve(t, handler, "GET", "Origin: "+origin)
+		assert.Equal(t, "hello", string(resp.Body))
+		assert.NotContains(t, resp.Headers, "access-control-allow-origin", origin)
+	}
+
+	// without Origin it is not a CORS request
+	resp = serve(t, handler, "GET")
+	assert.Equal(t, "Accept", resp.Headers["vary"])
+}
+
+func TestAnyOrigin(t *testing.T) {
+	handler := middleware(t, Options{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}})
+
+	resp := serve(t, handler, "GET", "Origin: https://anywhere.test")
+	assert.Equal(t, "*", resp.Headers["access-control-allow-origin"])
+	assert.Equal(t, "Accept", resp.Headers["vary"])
+
+	resp = serve(t, handler, "OPTIONS", "Origin: https://anywhere.test", "Access-Control-Request-Method: POST", "Access-Control-Request-Headers: x-anything")
+	assert.Equal(t, "*", resp.Headers["access-control-allow-origin"])
+	assert.Equal(t, "x-anything", resp.Headers["access-control-allow-headers"])
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, options := range []Options{
+		{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+		{AllowedOrigins: []string{"https://a.com"}, AllowedHeaders: []string{"*"}, AllowCredentials: true},
+		{AllowedOrigins: []string{"https://*.*.example.com"}},
+	} {
+		_, err := Middleware(options)
+		assert.ErrorIs(t, err, ErrInvalidOptions)
+	}
+}
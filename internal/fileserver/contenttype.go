@@ -0,0 +1,119 @@
+package fileserver
+
+import (
+	"bytes"
+	"mime"
+	"path"
+	"unicode/utf8"
+)
+
+const DEFAULT_CONTENT_TYPE = "application/octet-stream"
+
+// SNIFF_LENGTH is how many bytes are looked at, the same as the WHATWG MIME Sniffing standard.
+const SNIFF_LENGTH = 512
+
+// the system mime table differs between machines, the common web types are pinned here
+var extensionTypes = map[string]string{
+	".html":  "text/html; charset=utf-8",
+	".htm":   "text/html; charset=utf-8",
+	".css":   "text/css; charset=utf-8",
+	".js":    "text/javascript; charset=utf-8",
+	".mjs":   "text/javascript; charset=utf-8",
+	".json":  "application/json",
+	".map":   "application/json",
+	".txt":   "text/plain; charset=utf-8",
+	".svg":   "image/svg+xml",
+	".png":   "image/png",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".gif":   "image/gif",
+	".webp":  "image/webp",
+	".ico":   "image/x-icon",
+	".wasm":  "application/wasm",
+	".pdf":   "application/pdf",
+	".zip":   "application/zip",
+	".gz":    "application/gzip",
+	".mp4":   "video/mp4",
+	".webm":  "video/webm",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+}
+
+func typeByExtension(name string) string {
+	extension := path.Ext(name)
+
+	if contentType, ok := extensionTypes[extension]; ok {
+		return contentType
+	}
+
+	return mime.TypeByExtension(extension)
+}
+
+type signature struct {
+	prefix      []byte
+	contentType string
+}
+
+var signatures = []signature{
+	{[]byte("\x89PNG\r\n\x1a\n"), "image/png"},
+	{[]byte("\xff\xd8\xff"), "image/jpeg"},
+	{[]byte("GIF87a"), "image/gif"},
+	{[]byte("GIF89a"), "image/gif"},
+	{[]byte("%PDF-"), "application/pdf"},
+	{[]byte("\x1f\x8b\x08"), "application/gzip"},
+	{[]byte("PK\x03\x04"), "application/zip"},
+	{[]byte("\x00asm"), "application/wasm"},
+}
+
+var htmlMarkers = [][]byte{
+	[]byte("<!doctype html"),
+	[]byte("<html"),
+	[]byte("<head"),
+	[]byte("<body"),
+}
+
+/*
+sniff guesses the type of extension-less files from their first bytes, a small subset of
+the WHATWG MIME Sniffing standard: well known magic numbers, then text vs binary.
+*/
+func sniff(head []byte) string {
+	for _, s := range signatures {
+		if bytes.HasPrefix(head, s.prefix) {
+			return s.contentType
+		}
+	}
+
+	trimmed := bytes.TrimLeft(head, "\t\n\r ")
+
+	for _, marker := range htmlMarkers {
+		if len(trimmed) >= len(marker) && bytes.EqualFold(trimmed[:len(marker)], marker) {
+			return "text/html; charset=utf-8"
+		}
+	}
+
+	if isText(head) {
+		return "text/plain; charset=utf-8"
+	}
+
+	return DEFAULT_CONTENT_TYPE
+}
+
+func isText(head []byte) bool {
+	// the sniffed bytes may end in the middle of a multi byte character
+	for i := 0; i < utf8.UTFMax-1 && len(head) > 0 && !utf8.Valid(head); i++ {
+		head = head[:len(head)-1]
+	}
+
+	if !utf8.Valid(head) {
+		return false
+	}
+
+	for _, b := range head {
+		// control characters other than tab, newlines, form feed and escape mean binary
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != 0x1b {
+			return false
+		}
+	}
+
+	return true
+}
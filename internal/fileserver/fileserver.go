@@ -0,0 +1,301 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/sithusan/httpfromtcp/internal/content"
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/sithusan/httpfromtcp/internal/server"
+)
+
+const DEFAULT_INDEX_FILE = "index.html"
+
+type Options struct {
+	// IndexFile is served for a directory, empty means DEFAULT_INDEX_FILE.
+	IndexFile string
+	// Listing renders an HTML list of the entries of a directory without index file, instead of a 404.
+	Listing bool
+}
+
+type fileServer struct {
+	prefix  string
+	fsys    fs.FS
+	options Options
+}
+
+/*
+New serves the files of fsys under the URL prefix, "/static/app.js" with the prefix "/static"
+maps to "app.js" in fsys. Only GET and HEAD are allowed.
+*/
+func New(prefix string, fsys fs.FS, options Options) server.Handler {
+	if options.IndexFile == "" {
+		options.IndexFile = DEFAULT_INDEX_FILE
+	}
+
+	fileServer := &fileServer{
+		prefix:  strings.TrimSuffix(prefix, "/"),
+		fsys:    fsys,
+		options: options,
+	}
+
+	return fileServer.serve
+}
+
+/*
+Dir serves a directory of the local file system. It is opened as an os.Root, so a symlink
+inside it that points above dir is not followed, like ".." in the URL is not either. The root
+holds dir open until the returned io.Closer is closed, the handler answers 404 after that.
+*/
+func Dir(prefix, dir string, options Options) (server.Handler, io.Closer, error) {
+	root, err := os.OpenRoot(dir)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return New(prefix, root.FS(), options), root, nil
+}
+
+var notFoundMessage = []byte("not found\n")
+
+func (f *fileServer) serve(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+
+	if method != "GET" && method != "HEAD" {
+		headers := response.GetDefaultHeaders(0)
+		headers.Override("Allow", "GET, HEAD")
+
+		w.WriteStatusLine(response.METHOD_NOT_ALLOWED)
+		w.WriteHeaders(headers)
+		w.WriteBody(nil)
+		return
+	}
+
+	urlPath, name, ok := f.resolve(req.RequestLine.RequestTarget)
+
+	if !ok {
+		notFound(w, req)
+		return
+	}
+
+	file, err := f.fsys.Open(name)
+
+	if err != nil {
+		notFound(w, req)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		notFound(w, req)
+		return
+	}
+
+	if info.IsDir() {
+		f.serveDir(w, req, urlPath, name)
+		return
+	}
+
+	serveFile(w, req, file, info)
+}
+
+/*
+resolve turns the request target into a name inside fsys. The path is unescaped then cleaned,
+so "/static/../../etc/passwd" can never climb above the root of fsys, fs.ValidPath rejects the rest.
+*/
+func (f *fileServer) resolve(target string) (string, string, bool) {
+	rawPath, _, _ := strings.Cut(target, "?")
+
+	urlPath, err := url.PathUnescape(rawPath)
+
+	if err != nil || strings.ContainsAny(urlPath, "\\\x00") {
+		return "", "", false
+	}
+
+	if urlPath != f.prefix && !strings.HasPrefix(urlPath, f.prefix+"/") {
+		return "", "", false
+	}
+
+	cleaned := path.Clean("/" + strings.TrimPrefix(urlPath, f.prefix))
+	name := strings.TrimPrefix(cleaned, "/")
+
+	if name == "" {
+		name = "."
+	}
+
+	if !fs.ValidPath(name) {
+		return "", "", false
+	}
+
+	return urlPath, name, true
+}
+
+func (f *fileServer) serveDir(w *response.Writer, req *request.Request, urlPath, name string) {
+	// relative links in the page only resolve against a path ending with "/"
+	if !strings.HasSuffix(urlPath, "/") {
+		redirect(w, urlPath+"/")
+		return
+	}
+
+	indexName := path.Join(name, f.options.IndexFile)
+	index, err := f.fsys.Open(indexName)
+
+	if err == nil {
+		defer index.Close()
+
+		if info, err := index.Stat(); err == nil && !info.IsDir() {
+			serveFile(w, req, index, info)
+			return
+		}
+	}
+
+	if !f.options.Listing {
+		notFound(w, req)
+		return
+	}
+
+	entries, err := fs.ReadDir(f.fsys, name)
+
+	if err != nil {
+		notFound(w, req)
+		return
+	}
+
+	body := listing(urlPath, entries)
+	headers := response.GetDefaultHeaders(len(body))
+	headers.Override("Content-Type", "text/html; charset=utf-8")
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(headers)
+	w.WriteBody(body)
+}
+
+func serveFile(w *response.Writer, req *request.Request, file fs.File, info fs.FileInfo) {
+	contentType, err := detectContentType(info.Name(), file)
+
+	if err != nil {
+		server.HandleError{
+			StatusCode: response.INTERNAL_SERVER_ERROR,
+			Message:    []byte("cannot read file\n"),
+		}.RespondTo(w, req)
+		return
+	}
+
+	validators := content.Validators{ETag: fileETag(info), ModTime: info.ModTime()}
+
+	// files that can seek (every os.File does) get Range support
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		content.Serve(w, req, content.Content{
+			Reader:      seeker,
+			Size:        info.Size(),
+			ContentType: contentType,
+			ModTime:     validators.ModTime,
+			ETag:        validators.ETag,
+		})
+		return
+	}
+
+	if content.Precondition(w, req, validators) {
+		return
+	}
+
+	headers := response.GetDefaultHeaders(0)
+	headers.Override("Content-Length", strconv.FormatInt(info.Size(), 10))
+	headers.Override("Content-Type", contentType)
+	headers.Override("ETag", validators.ETag)
+
+	if !validators.ModTime.IsZero() {
+		headers.Override("Last-Modified", content.FormatTime(validators.ModTime))
+	}
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(headers)
+	w.WriteBodyFrom(file)
+}
+
+/**
+* Helpers
+**/
+
+func notFound(w *response.Writer, req *request.Request) {
+	server.HandleError{
+		StatusCode: response.NOT_FOUND,
+		Message:    notFoundMessage,
+	}.RespondTo(w, req)
+}
+
+// fileETag changes whenever the file is rewritten, without reading it
+func fileETag(info fs.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size())
+}
+
+func redirect(w *response.Writer, location string) {
+	headers := response.GetDefaultHeaders(0)
+	headers.Override("Location", (&url.URL{Path: location}).EscapedPath())
+
+	w.WriteStatusLine(response.MOVED_PERMANENTLY)
+	w.WriteHeaders(headers)
+	w.WriteBody(nil)
+}
+
+func listing(urlPath string, entries []fs.DirEntry) []byte {
+	var page strings.Builder
+	title := html.EscapeString(urlPath)
+
+	fmt.Fprintf(&page, "<html>\n<head>\n    <title>Index of %s</title>\n  </head>\n  <body>\n    <h1>Index of %s</h1>\n    <ul>\n", title, title)
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		if entry.IsDir() {
+			name += "/"
+		}
+
+		href := (&url.URL{Path: name}).EscapedPath()
+
+		// "./" keeps a name like "a:b" from being read as a URL scheme
+		fmt.Fprintf(&page, "      <li><a href=\"./%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+
+	page.WriteString("    </ul>\n  </body>\n</html>\n")
+
+	return []byte(page.String())
+}
+
+// detectContentType reads the first bytes when the extension is unknown and rewinds the file if it can.
+func detectContentType(name string, file fs.File) (string, error) {
+	if contentType := typeByExtension(name); contentType != "" {
+		return contentType, nil
+	}
+
+	seeker, ok := file.(io.Seeker)
+
+	if !ok {
+		return DEFAULT_CONTENT_TYPE, nil
+	}
+
+	head := make([]byte, SNIFF_LENGTH)
+	n, err := io.ReadFull(file, head)
+
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return sniff(head[:n]), nil
+}
@@ -0,0 +1,171 @@
+package fileserver
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/sithusan/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testFS = fstest.MapFS{
+	"index.html":       {Data: []byte("<h1>home</h1>")},
+	"app.js":           {Data: []byte("console.log(1)")},
+	"docs/guide.txt":   {Data: []byte("read me")},
+	"docs/a b.txt":     {Data: []byte("spaced")},
+	"blob":             {Data: []byte("\x89PNG\r\n\x1a\nrest")},
+	"notes":            {Data: []byte("plain words")},
+	"assets/.keep":     {Data: []byte{}},
+	"assets/logo.webp": {Data: []byte("RIFF")},
+}
+
+func serve(handler server.Handler, method, target string) string {
+	buffer := &bytes.Buffer{}
+	req := request.NewRequest()
+	req.RequestLine = request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "1.1"}
+
+	// like the server does
+	w := response.NewWriter(buffer)
+
+	if method == "HEAD" {
+		w.SuppressBody()
+	}
+
+	handler(w, req)
+
+	return buffer.String()
+}
+
+func TestServesFileWithContentTypeFromExtension(t *testing.T) {
+	out := serve(New("/static", testFS, Options{}), "GET", "/static/app.js")
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-type: text/javascript; charset=utf-8")
+	assert.Contains(t, out, "content-length: 14")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nconsole.log(1)"))
+}
+
+func TestSniffsContentTypeWithoutExtension(t *testing.T) {
+	handler := New("/", testFS, Options{})
+
+	assert.Contains(t, serve(handler, "GET", "/blob"), "content-type: image/png")
+	assert.Contains(t, serve(handler, "GET", "/notes"), "content-type: text/plain; charset=utf-8")
+}
+
+func TestServesIndexFileAndRedirectsDirectories(t *testing.T) {
+	handler := New("/static", testFS, Options{})
+
+	assert.Contains(t, serve(handler, "GET", "/static/"), "<h1>home</h1>")
+
+	out := serve(handler, "GET", "/static/docs")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 301 Moved Permanently"))
+	assert.Contains(t, out, "location: /static/docs/")
+}
+
+func TestDirectoryListing(t *testing.T) {
+	assert.Contains(t, serve(New("/", testFS, Options{}), "GET", "/docs/"), "404 Not Found")
+
+	out := serve(New("/", testFS, Options{Listing: true}), "GET", "/docs/")
+	assert.Contains(t, out, "Index of /docs/")
+	assert.Contains(t, out, `<a href="./guide.txt">guide.txt</a>`)
+	assert.Contains(t, out, `<a href="./a%20b.txt">a b.txt</a>`)
+}
+
+func TestPreventsPathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "public")
+	require.NoError(t, os.Mkdir(root, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "ok.txt"), []byte("public"), 0o600))
+
+	handler, closer, err := Dir("/files", root, Options{})
+	require.NoError(t, err)
+	defer closer.Close()
+
+	assert.Contains(t, serve(handler, "GET", "/files/ok.txt"), "public")
+
+	for _, target := range []string{
+		"/files/../secret.txt",
+		"/files/%2e%2e/secret.txt",
+		"/files/..%2fsecret.txt",
+		"/files/..\\secret.txt",
+		"/secret.txt",
+	} {
+		out := serve(handler, "GET", target)
+		assert.NotContains(t, out, "secret\n", target)
+		assert.NotContains(t, out, "200 OK", target)
+	}
+
+	// closed, the directory is let go
+	require.NoError(t, closer.Close())
+	assert.True(t, strings.HasPrefix(serve(handler, "GET", "/files/ok.txt"), "HTTP/1.1 404 Not Found\r\n"))
+}
+
+func TestDoesNotFollowSymlinksOutOfRoot(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "public")
+	require.NoError(t, os.Mkdir(root, 0o755))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "private"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "private", "secret.txt"), []byte("secret\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "ok.txt"), []byte("public"), 0o600))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "private", "secret.txt"), filepath.Join(root, "file-link")))
+	require.NoError(t, os.Symlink("../private", filepath.Join(root, "dir-link")))
+	require.NoError(t, os.Symlink("ok.txt", filepath.Join(root, "inside-link")))
+
+	handler, closer, err := Dir("/files", root, Options{Listing: true})
+	require.NoError(t, err)
+	defer closer.Close()
+
+	for _, target := range []string{"/files/file-link", "/files/dir-link/secret.txt", "/files/dir-link/"} {
+		out := serve(handler, "GET", target)
+		assert.NotContains(t, out, "secret\n", target)
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"), target)
+	}
+
+	// a symlink that stays inside the root still works
+	assert.Contains(t, serve(handler, "GET", "/files/inside-link"), "public")
+}
+
+func TestRejectsOtherMethodsAndSkipsBodyForHead(t *testing.T) {
+	handler := New("/", testFS, Options{})
+
+	out := serve(handler, "POST", "/app.js")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed"))
+	assert.Contains(t, out, "allow: GET, HEAD")
+
+	out = serve(handler, "HEAD", "/app.js")
+	assert.Contains(t, out, "content-length: 14")
+	assert.NotContains(t, out, "console.log")
+}
+
+func TestStreamsLargeFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), response.BODY_CHUNK_SIZE/5)
+	fsys := fstest.MapFS{"large.bin": {Data: content}}
+
+	out := serve(New("/", fsys, Options{}), "GET", "/large.bin?download=1")
+	assert.True(t, strings.HasSuffix(out, string(content)))
+}
+
+func TestAnswersNotModifiedForFileETag(t *testing.T) {
+	handler := New("/", testFS, Options{})
+
+	out := serve(handler, "GET", "/app.js")
+	_, etag, _ := strings.Cut(out, "etag: ")
+	etag = strings.Fields(etag)[0]
+
+	buffer := &bytes.Buffer{}
+	req := request.NewRequest()
+	req.RequestLine = request.RequestLine{Method: "GET", RequestTarget: "/app.js", HttpVersion: "1.1"}
+	req.Headers.Override("If-None-Match", etag)
+
+	handler(response.NewWriter(buffer), req)
+	assert.True(t, strings.HasPrefix(buffer.String(), "HTTP/1.1 304 Not Modified\r\n"))
+	assert.NotContains(t, buffer.String(), "console.log")
+}
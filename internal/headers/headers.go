@@ -9,6 +9,20 @@ import (
 
 var crlf = []byte("\r\n")
 
+/*
+According to RFC9110 5.3, repeated field lines can be combined into one, separated by commas,
+except Set-Cookie whose values (like an Expires date) contain commas themselves. Those are kept
+apart by FIELD_LINE_SEPARATOR, a newline cannot appear in a field value, see Values and Each.
+WWW-Authenticate may be combined, but challenges with commas in them are hard to split again
+(RFC9110 11.6.1), so they are kept apart too.
+*/
+const FIELD_LINE_SEPARATOR = "\n"
+
+var uncombinable = map[string]struct{}{
+	"set-cookie":       {},
+	"www-authenticate": {},
+}
+
 type Headers map[string]string
 
 func NewHeaders() Headers {
@@ -19,6 +33,10 @@ func (h Headers) Override(key, value string) {
 	h[strings.ToLower(key)] = value
 }
 
+func (h Headers) Remove(key string) {
+	delete(h, strings.ToLower(key))
+}
+
 func (h Headers) Parse(data []byte) (int, bool, error) {
 	idx := bytes.Index(data, crlf)
 
@@ -50,11 +68,49 @@ func (h Headers) Set(key, value []byte) {
 	stringKey := strings.ToLower(string(bytes.TrimSpace(key)))
 	stringValue := string(bytes.TrimSpace(value))
 
-	if val, ok := h[stringKey]; ok {
-		stringValue = val + ", " + stringValue
+	h.Add(stringKey, stringValue)
+}
+
+// Add appends a field line to key, the way a repeated field is received.
+func (h Headers) Add(key, value string) {
+	key = strings.ToLower(key)
+
+	if val, ok := h[key]; ok {
+		separator := ", "
+
+		if _, ok := uncombinable[key]; ok {
+			separator = FIELD_LINE_SEPARATOR
+		}
+
+		value = val + separator + value
+	}
+
+	h[key] = value
+}
+
+// Values returns the field lines of key, one per Set-Cookie and the combined line for any other field.
+func (h Headers) Values(key string) []string {
+	key = strings.ToLower(key)
+	value, ok := h[key]
+
+	if !ok {
+		return nil
 	}
 
-	h[stringKey] = stringValue
+	if _, ok := uncombinable[key]; ok {
+		return strings.Split(value, FIELD_LINE_SEPARATOR)
+	}
+
+	return []string{value}
+}
+
+// Each calls fn with every field line to send, keys are lower case.
+func (h Headers) Each(fn func(key, value string)) {
+	for key := range h {
+		for _, value := range h.Values(key) {
+			fn(strings.ToLower(key), value)
+		}
+	}
 }
 
 func (h Headers) Get(key string) (string, bool) {
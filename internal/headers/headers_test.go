@@ -72,3 +72,32 @@ func TestInvalidCharacterHeader(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 }
+
+func TestRepeatedSetCookieFieldsStayApart(t *testing.T) {
+	headers := NewHeaders()
+	data := []byte("Set-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\nSet-Cookie: b=2\r\nAccept: a\r\nAccept: b\r\n\r\n")
+
+	for {
+		n, done, err := headers.Parse(data)
+		require.NoError(t, err)
+		data = data[n:]
+
+		if done {
+			break
+		}
+	}
+
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2"}, headers.Values("Set-Cookie"))
+	assert.Equal(t, []string{"a, b"}, headers.Values("Accept"))
+	assert.Nil(t, headers.Values("Missing"))
+
+	lines := []string{}
+	headers.Each(func(key, value string) {
+		lines = append(lines, key+": "+value)
+	})
+	assert.ElementsMatch(t, []string{
+		"set-cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT",
+		"set-cookie: b=2",
+		"accept: a, b",
+	}, lines)
+}
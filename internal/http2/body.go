@@ -0,0 +1,126 @@
+package http2
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+var errStreamReset = errors.New("http2: stream reset")
+
+/*
+requestBody holds what the client sent on a stream until the handler reads it. The flow control
+windows are only given back as it is read, so a handler that does not read stops the client
+once the window is used up, instead of the body piling up in memory.
+*/
+type requestBody struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buffer bytes.Buffer
+	// err is what Read returns once the buffer is drained: io.EOF at END_STREAM, or why the body broke off
+	err error
+	// closed is the handler being done, what arrives after is dropped
+	closed bool
+	// unacked was read but not given back to the windows yet
+	unacked int64
+	// consumed gives n bytes back, to the stream window too while the client is still sending
+	consumed func(n int64, receiving bool)
+}
+
+func newRequestBody(consumed func(n int64, receiving bool)) *requestBody {
+	b := &requestBody{consumed: consumed}
+	b.cond = sync.NewCond(&b.mu)
+
+	return b
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+
+	for b.buffer.Len() == 0 && b.err == nil {
+		b.cond.Wait()
+	}
+
+	// a broken body is broken at once, only a complete one is drained first
+	if b.buffer.Len() == 0 || !errors.Is(b.err, io.EOF) && b.err != nil {
+		err := b.err
+		b.mu.Unlock()
+		return 0, err
+	}
+
+	n, _ := b.buffer.Read(p)
+	b.unacked += int64(n)
+
+	var ack int64
+
+	// one WINDOW_UPDATE per half window, or once the reader caught up with the client
+	if b.unacked >= DEFAULT_INITIAL_WINDOW/2 || b.buffer.Len() == 0 {
+		ack, b.unacked = b.unacked, 0
+	}
+
+	receiving := b.err == nil
+	b.mu.Unlock()
+
+	if ack > 0 {
+		b.consumed(ack, receiving)
+	}
+
+	return n, nil
+}
+
+// write adds the data of a DATA frame, false when nobody is going to read it.
+func (b *requestBody) write(data []byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed || b.err != nil {
+		return false
+	}
+
+	b.buffer.Write(data)
+	b.cond.Broadcast()
+
+	return true
+}
+
+/*
+finish ends the body with err, io.EOF when the client sent all of it. Any other error drops
+what was not read yet, finish returns how much so its share of the connection window goes back.
+*/
+func (b *requestBody) finish(err error) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return 0
+	}
+
+	b.err = err
+	b.cond.Broadcast()
+
+	if errors.Is(err, io.EOF) {
+		return 0
+	}
+
+	dropped := int64(b.buffer.Len()) + b.unacked
+	b.buffer.Reset()
+	b.unacked = 0
+
+	return dropped
+}
+
+// close is the handler being done, it returns what it left unread and whether the client is still sending.
+func (b *requestBody) close() (int64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	receiving := b.err == nil
+	dropped := int64(b.buffer.Len()) + b.unacked
+
+	b.closed = true
+	b.buffer.Reset()
+	b.unacked = 0
+
+	return dropped, receiving
+}
@@ -0,0 +1,870 @@
+package http2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"golang.org/x/net/http2/hpack"
+)
+
+/*
+Handler has the same shape as server.Handler, so existing handlers are served over HTTP/2
+unchanged. It runs once the headers of a stream are in, the body is still to be read from the
+request, see Options.
+*/
+type Handler func(w *response.Writer, req *request.Request)
+
+type serverConn struct {
+	reader  io.Reader
+	writer  io.Writer
+	handler Handler
+	// remoteAddr is copied to every request, empty when writer is not a connection
+	remoteAddr string
+
+	maxHeaderBytes int
+	maxBodyBytes   int64
+	readTimeout    time.Duration
+	// deadliner is the connection when it can time out reads, nil otherwise
+	deadliner interface{ SetReadDeadline(time.Time) error }
+
+	// writeMu keeps frames whole on the wire, and HEADERS + CONTINUATION back to back
+	writeMu   sync.Mutex
+	encoder   *hpack.Encoder
+	encodeBuf bytes.Buffer
+
+	// only the read loop touches the decoder and the header block in progress
+	decoder             *hpack.Decoder
+	continuingStreamID  uint32
+	continuingEndStream bool
+	headerBlock         []byte
+
+	// mu guards the streams and the flow control windows, cond wakes writers waiting for window
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*stream
+	lastStreamID      uint32
+	sendWindow        int64
+	recvWindow        int64
+	peerInitialWindow int64
+	closed            bool
+
+	peerMaxFrameSize atomic.Uint32
+
+	handlers sync.WaitGroup
+	// ctx is the parent of the context of every request, cancelled when the connection goes away
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newServerConn(reader io.Reader, writer io.Writer, handler Handler, options Options) *serverConn {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if options.MaxHeaderBytes <= 0 {
+		options.MaxHeaderBytes = MAX_HEADER_LIST_SIZE
+	}
+
+	sc := &serverConn{
+		ctx:               ctx,
+		cancel:            cancel,
+		reader:            reader,
+		writer:            writer,
+		handler:           handler,
+		maxHeaderBytes:    options.MaxHeaderBytes,
+		maxBodyBytes:      int64(options.MaxBodyBytes),
+		readTimeout:       options.ReadTimeout,
+		decoder:           hpack.NewDecoder(DEFAULT_HEADER_TABLE_SIZE, nil),
+		streams:           map[uint32]*stream{},
+		sendWindow:        DEFAULT_INITIAL_WINDOW,
+		recvWindow:        DEFAULT_INITIAL_WINDOW,
+		peerInitialWindow: DEFAULT_INITIAL_WINDOW,
+	}
+
+	if conn, ok := writer.(interface{ RemoteAddr() net.Addr }); ok {
+		sc.remoteAddr = conn.RemoteAddr().String()
+	}
+
+	if conn, ok := writer.(interface{ SetReadDeadline(time.Time) error }); ok {
+		sc.deadliner = conn
+	}
+
+	sc.cond = sync.NewCond(&sc.mu)
+	sc.encoder = hpack.NewEncoder(&sc.encodeBuf)
+	sc.decoder.SetMaxStringLength(sc.maxHeaderBytes)
+	sc.peerMaxFrameSize.Store(DEFAULT_MAX_FRAME_SIZE)
+
+	return sc
+}
+
+/*
+serve runs the connection until the client goes away or a connection error happens.
+The server preface is our SETTINGS frame, the client preface is the magic string followed
+by its SETTINGS frame. upgraded is the HTTP/1.1 request that asked for h2c, it becomes stream 1.
+*/
+func (sc *serverConn) serve(upgraded *request.Request) error {
+	defer sc.shutdown()
+
+	err := sc.writeFrame(frameSettings, 0, 0, appendSettings(nil,
+		setting{settingMaxConcurrentStreams, MAX_CONCURRENT_STREAMS},
+		setting{settingMaxHeaderListSize, uint32(sc.maxHeaderBytes)},
+		setting{settingEnablePush, 0},
+	))
+
+	if err != nil {
+		return err
+	}
+
+	if upgraded != nil {
+		// the body came whole with the HTTP/1.1 request
+		sc.lastStreamID = 1
+		st := sc.newStream(1)
+		st.request = upgraded
+		st.recvClosed = true
+		st.body.finish(io.EOF)
+		sc.dispatch(st)
+	}
+
+	sc.armReadDeadline()
+
+	preface := make([]byte, len(CLIENT_PREFACE))
+
+	if _, err := io.ReadFull(sc.reader, preface); err != nil {
+		return err
+	}
+
+	if string(preface) != CLIENT_PREFACE {
+		return fmt.Errorf("error: invalid http2 client preface")
+	}
+
+	first := true
+
+	for {
+		sc.armReadDeadline()
+
+		f, err := readFrame(sc.reader, DEFAULT_MAX_FRAME_SIZE)
+
+		if err == nil && first && f.typ != frameSettings {
+			err = connError{errProtocol, "client preface must start with SETTINGS"}
+		}
+
+		first = false
+
+		if err == nil {
+			err = sc.processFrame(f)
+		}
+
+		if err == nil {
+			continue
+		}
+
+		var se streamError
+		if errors.As(err, &se) {
+			sc.resetStream(se.streamID, se.code)
+			continue
+		}
+
+		if errors.Is(err, errGoAwayReceived) || errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		var ce connError
+		if errors.As(err, &ce) {
+			sc.writeGoAway(ce.code)
+		}
+
+		return err
+	}
+}
+
+var errGoAwayReceived = errors.New("http2: client sent GOAWAY")
+
+// shutdown wakes writers blocked on flow control and readers of a body, cancels the requests and waits for the handlers to return.
+func (sc *serverConn) shutdown() {
+	sc.mu.Lock()
+	sc.closed = true
+	sc.cond.Broadcast()
+
+	for _, st := range sc.streams {
+		st.body.finish(io.ErrUnexpectedEOF)
+	}
+	sc.mu.Unlock()
+
+	sc.cancel()
+	sc.handlers.Wait()
+}
+
+/*
+State Machine (per frame type, RFC9113 6)
+*/
+func (sc *serverConn) processFrame(f *frame) error {
+	if sc.continuingStreamID != 0 && (f.typ != frameContinuation || f.streamID != sc.continuingStreamID) {
+		return connError{errProtocol, "expected CONTINUATION"}
+	}
+
+	switch f.typ {
+	case frameData:
+		return sc.processData(f)
+	case frameHeaders:
+		return sc.processHeaders(f)
+	case frameContinuation:
+		return sc.processContinuation(f)
+	case framePriority:
+		if f.streamID == 0 {
+			return connError{errProtocol, "PRIORITY on stream 0"}
+		}
+		// deprecated by RFC9113, streams are served in arrival order
+		return nil
+	case frameRSTStream:
+		return sc.processRSTStream(f)
+	case frameSettings:
+		return sc.processSettings(f)
+	case framePushPromise:
+		return connError{errProtocol, "clients cannot push"}
+	case framePing:
+		return sc.processPing(f)
+	case frameGoAway:
+		return errGoAwayReceived
+	case frameWindowUpdate:
+		return sc.processWindowUpdate(f)
+	default:
+		// unknown frame types must be ignored
+		return nil
+	}
+}
+
+func (sc *serverConn) processHeaders(f *frame) error {
+	if f.streamID == 0 || f.streamID%2 == 0 {
+		return connError{errProtocol, "HEADERS on invalid stream id"}
+	}
+
+	fragment, err := f.headerBlockFragment()
+
+	if err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	st, exists := sc.streams[f.streamID]
+	sc.mu.Unlock()
+
+	if exists {
+		// a second HEADERS is the trailer section, it must end the stream
+		if st.recvClosed || !f.has(flagEndStream) {
+			return connError{errProtocol, "HEADERS on open stream"}
+		}
+	} else if f.streamID <= sc.lastStreamID {
+		return connError{errStreamClosed, "HEADERS on closed stream"}
+	}
+
+	if len(fragment) > sc.maxHeaderBytes {
+		return connError{errEnhanceYourCalm, "header block too large"}
+	}
+
+	sc.continuingStreamID = f.streamID
+	sc.continuingEndStream = f.has(flagEndStream)
+	sc.headerBlock = append(sc.headerBlock[:0], fragment...)
+
+	if f.has(flagEndHeaders) {
+		return sc.endHeaders()
+	}
+
+	return nil
+}
+
+func (sc *serverConn) processContinuation(f *frame) error {
+	if sc.continuingStreamID == 0 {
+		return connError{errProtocol, "CONTINUATION without HEADERS"}
+	}
+
+	if len(sc.headerBlock)+len(f.payload) > sc.maxHeaderBytes {
+		return connError{errEnhanceYourCalm, "header block too large"}
+	}
+
+	sc.headerBlock = append(sc.headerBlock, f.payload...)
+
+	if f.has(flagEndHeaders) {
+		return sc.endHeaders()
+	}
+
+	return nil
+}
+
+func (sc *serverConn) endHeaders() error {
+	streamID := sc.continuingStreamID
+	endStream := sc.continuingEndStream
+	sc.continuingStreamID = 0
+
+	// always decode, even for refused streams, so the dynamic table stays in sync with the client
+	fields, err := sc.decoder.DecodeFull(sc.headerBlock)
+
+	if err != nil {
+		return connError{errCompression, err.Error()}
+	}
+
+	sc.mu.Lock()
+	st, exists := sc.streams[streamID]
+	active := len(sc.streams)
+	sc.mu.Unlock()
+
+	if !exists {
+		sc.lastStreamID = streamID
+	}
+
+	// the decoded list is bounded too, the SETTINGS_MAX_HEADER_LIST_SIZE we announced (RFC9113 6.5.2)
+	size := 0
+
+	for _, field := range fields {
+		size += int(field.Size())
+	}
+
+	if size > sc.maxHeaderBytes {
+		return streamError{streamID, errEnhanceYourCalm}
+	}
+
+	if exists {
+		if err := addTrailers(st.request, fields); err != nil {
+			return streamError{streamID, errProtocol}
+		}
+		return sc.endStream(st)
+	}
+
+	if active >= MAX_CONCURRENT_STREAMS {
+		return streamError{streamID, errRefusedStream}
+	}
+
+	req, err := requestFromFields(fields)
+
+	if err != nil {
+		return streamError{streamID, errProtocol}
+	}
+
+	length, err := contentLength(req)
+
+	if err != nil {
+		return streamError{streamID, errProtocol}
+	}
+
+	req.RemoteAddr = sc.remoteAddr
+
+	st = sc.newStream(streamID)
+	st.request = req
+	req.SetBodyReader(st.body, length)
+
+	// refused up front, the handler answers it while the client is told to stop by the window it never gets back
+	if sc.maxBodyBytes > 0 && length > sc.maxBodyBytes {
+		st.body.finish(sc.errBodyTooLarge())
+	}
+
+	// the handler starts with the headers, the body streams to it as DATA frames come
+	sc.dispatch(st)
+
+	if endStream {
+		return sc.endStream(st)
+	}
+
+	return nil
+}
+
+func (sc *serverConn) processData(f *frame) error {
+	if f.streamID == 0 {
+		return connError{errProtocol, "DATA on stream 0"}
+	}
+
+	// padding counts against flow control too
+	size := int64(len(f.payload))
+
+	sc.mu.Lock()
+	sc.recvWindow -= size
+	overflow := sc.recvWindow < 0
+	st, exists := sc.streams[f.streamID]
+	sc.mu.Unlock()
+
+	if overflow {
+		return connError{errFlowControl, "connection receive window exceeded"}
+	}
+
+	// bad padding is a connection error whatever the state of the stream (RFC9113 6.1)
+	data, err := f.unpad()
+
+	if err != nil {
+		return err
+	}
+
+	if !exists || st.recvClosed {
+		// nobody reads it, the connection window goes back at once
+		if err := sc.replenish(0, size); err != nil {
+			return err
+		}
+
+		if f.streamID > sc.lastStreamID {
+			return connError{errProtocol, "DATA on idle stream"}
+		}
+		return streamError{f.streamID, errStreamClosed}
+	}
+
+	sc.mu.Lock()
+	st.recvWindow -= size
+	overflow = st.recvWindow < 0
+	sc.mu.Unlock()
+
+	if overflow {
+		if err := sc.replenish(0, size); err != nil {
+			return err
+		}
+		return streamError{f.streamID, errFlowControl}
+	}
+
+	st.received += int64(len(data))
+	kept := int64(0)
+	dropped := int64(0)
+
+	if sc.maxBodyBytes > 0 && st.received > sc.maxBodyBytes {
+		dropped = st.body.finish(sc.errBodyTooLarge())
+	} else if st.body.write(data) {
+		kept = int64(len(data))
+	}
+
+	/*
+		Only the data the handler is going to read waits for it. The rest goes back to the
+		connection at once, so the other streams keep going, and the padding to the stream too.
+		Data refused keeps the stream window, the client stops sending on that stream.
+	*/
+	if err := sc.replenish(0, size-kept+dropped); err != nil {
+		return err
+	}
+
+	if padding := size - int64(len(data)); padding > 0 {
+		sc.mu.Lock()
+		st.recvWindow += padding
+		sc.mu.Unlock()
+
+		if err := sc.replenish(f.streamID, padding); err != nil {
+			return err
+		}
+	}
+
+	if f.has(flagEndStream) {
+		return sc.endStream(st)
+	}
+
+	return nil
+}
+
+func (sc *serverConn) processRSTStream(f *frame) error {
+	if f.streamID == 0 {
+		return connError{errProtocol, "RST_STREAM on stream 0"}
+	}
+
+	if len(f.payload) != 4 {
+		return connError{errFrameSize, "RST_STREAM payload must be 4 bytes"}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if st, ok := sc.streams[f.streamID]; ok {
+		st.reset = true
+		delete(sc.streams, f.streamID)
+		sc.cond.Broadcast()
+		st.body.finish(errStreamReset)
+
+		// the client gave up on the stream, its handler should too
+		if st.cancel != nil {
+			st.cancel()
+		}
+	}
+
+	return nil
+}
+
+func (sc *serverConn) processSettings(f *frame) error {
+	if f.streamID != 0 {
+		return connError{errProtocol, "SETTINGS on a stream"}
+	}
+
+	if f.has(flagAck) {
+		if len(f.payload) != 0 {
+			return connError{errFrameSize, "SETTINGS ack with payload"}
+		}
+		return nil
+	}
+
+	settings, err := parseSettings(f.payload)
+
+	if err != nil {
+		return err
+	}
+
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+
+	return sc.writeFrame(frameSettings, flagAck, 0, nil)
+}
+
+func (sc *serverConn) applySettings(settings []setting) error {
+	for _, s := range settings {
+		switch s.id {
+		case settingHeaderTableSize:
+			sc.writeMu.Lock()
+			sc.encoder.SetMaxDynamicTableSizeLimit(s.value)
+			sc.writeMu.Unlock()
+		case settingEnablePush:
+			if s.value > 1 {
+				return connError{errProtocol, "ENABLE_PUSH must be 0 or 1"}
+			}
+		case settingInitialWindowSize:
+			if s.value > MAX_WINDOW_SIZE {
+				return connError{errFlowControl, "INITIAL_WINDOW_SIZE too large"}
+			}
+
+			// the change applies to every open stream (RFC9113 6.9.2)
+			sc.mu.Lock()
+			delta := int64(s.value) - sc.peerInitialWindow
+			sc.peerInitialWindow = int64(s.value)
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+			}
+			sc.cond.Broadcast()
+			sc.mu.Unlock()
+		case settingMaxFrameSize:
+			if s.value < DEFAULT_MAX_FRAME_SIZE || s.value > MAX_ALLOWED_FRAME_SIZE {
+				return connError{errProtocol, "MAX_FRAME_SIZE out of range"}
+			}
+			sc.peerMaxFrameSize.Store(s.value)
+		}
+	}
+
+	return nil
+}
+
+func (sc *serverConn) processPing(f *frame) error {
+	if f.streamID != 0 {
+		return connError{errProtocol, "PING on a stream"}
+	}
+
+	if len(f.payload) != PING_PAYLOAD_SIZE {
+		return connError{errFrameSize, "PING payload must be 8 bytes"}
+	}
+
+	if f.has(flagAck) {
+		return nil
+	}
+
+	return sc.writeFrame(framePing, flagAck, 0, f.payload)
+}
+
+func (sc *serverConn) processWindowUpdate(f *frame) error {
+	if len(f.payload) != WINDOW_UPDATE_PAYLOAD_SIZE {
+		return connError{errFrameSize, "WINDOW_UPDATE payload must be 4 bytes"}
+	}
+
+	increment := int64(uint32(f.payload[0]&0x7f)<<24 | uint32(f.payload[1])<<16 | uint32(f.payload[2])<<8 | uint32(f.payload[3]))
+
+	if increment == 0 {
+		if f.streamID == 0 {
+			return connError{errProtocol, "WINDOW_UPDATE of 0"}
+		}
+		return streamError{f.streamID, errProtocol}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if f.streamID == 0 {
+		sc.sendWindow += increment
+
+		if sc.sendWindow > MAX_WINDOW_SIZE {
+			return connError{errFlowControl, "connection window overflow"}
+		}
+
+		sc.cond.Broadcast()
+		return nil
+	}
+
+	st, ok := sc.streams[f.streamID]
+
+	if !ok {
+		return nil
+	}
+
+	st.sendWindow += increment
+
+	if st.sendWindow > MAX_WINDOW_SIZE {
+		return streamError{f.streamID, errFlowControl}
+	}
+
+	sc.cond.Broadcast()
+	return nil
+}
+
+/**
+* Streams
+**/
+
+func (sc *serverConn) newStream(id uint32) *stream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	st := &stream{
+		id:         id,
+		conn:       sc,
+		sendWindow: sc.peerInitialWindow,
+		recvWindow: DEFAULT_INITIAL_WINDOW,
+		deadline:   time.Now().Add(sc.readTimeout),
+	}
+
+	st.body = newRequestBody(func(n int64, receiving bool) {
+		sc.consumed(st, n, receiving)
+	})
+
+	sc.streams[id] = st
+
+	return st
+}
+
+// endStream is called when the client is done sending, the handler reads to the end of the body.
+func (sc *serverConn) endStream(st *stream) error {
+	st.recvClosed = true
+
+	if length, _ := contentLength(st.request); length >= 0 && length != st.received {
+		return streamError{st.id, errProtocol}
+	}
+
+	st.body.finish(io.EOF)
+
+	return nil
+}
+
+func (sc *serverConn) dispatch(st *stream) {
+	sc.handlers.Add(1)
+
+	ctx, cancel := context.WithCancel(sc.ctx)
+	st.request.SetContext(ctx)
+
+	sc.mu.Lock()
+	st.cancel = cancel
+	sc.mu.Unlock()
+
+	go func() {
+		defer sc.handlers.Done()
+		defer cancel()
+
+		w := response.NewStreamWriter(st)
+
+		if st.request.RequestLine.Method == "HEAD" {
+			w.SuppressBody()
+		}
+
+		sc.handler(w, st.request)
+		st.finish()
+		sc.closeStream(st)
+	}()
+}
+
+func (sc *serverConn) resetStream(streamID uint32, code errorCode) {
+	var dropped int64
+
+	sc.mu.Lock()
+	if st, ok := sc.streams[streamID]; ok {
+		st.reset = true
+		delete(sc.streams, streamID)
+		sc.cond.Broadcast()
+
+		// a handler still reading the body must not wait for the rest of it
+		dropped = st.body.finish(errStreamReset)
+	}
+	sc.mu.Unlock()
+
+	sc.replenish(0, dropped)
+
+	payload := []byte{byte(code >> 24), byte(code >> 16), byte(code >> 8), byte(code)}
+	sc.writeFrame(frameRSTStream, 0, streamID, payload)
+}
+
+/*
+closeStream runs once the handler returned. What it left unread goes back to the connection
+window. A client still sending is told to stop with NO_ERROR, the response is complete
+(RFC9113 8.1).
+*/
+func (sc *serverConn) closeStream(st *stream) {
+	dropped, receiving := st.body.close()
+
+	sc.mu.Lock()
+	_, open := sc.streams[st.id]
+	delete(sc.streams, st.id)
+	idle := len(sc.streams) == 0
+	sc.mu.Unlock()
+
+	sc.replenish(0, dropped)
+
+	if open && receiving {
+		sc.resetStream(st.id, errNo)
+	}
+
+	// the read loop may be waiting for a frame with no deadline while the handlers were answering
+	if idle && sc.readTimeout > 0 && sc.deadliner != nil {
+		sc.deadliner.SetReadDeadline(time.Now().Add(sc.readTimeout))
+	}
+}
+
+// consumed gives back what a handler read of the body, to the stream window as well while the client is still sending.
+func (sc *serverConn) consumed(st *stream, n int64, receiving bool) {
+	sc.replenish(0, n)
+
+	if !receiving {
+		return
+	}
+
+	sc.mu.Lock()
+	st.recvWindow += n
+	sc.mu.Unlock()
+
+	sc.replenish(st.id, n)
+}
+
+/*
+armReadDeadline bounds the next read by ReadTimeout: counted from the HEADERS of the oldest
+request still being received, or from now on a connection without streams. While the handlers
+only answer, nothing is read that could be late.
+*/
+func (sc *serverConn) armReadDeadline() {
+	if sc.readTimeout <= 0 || sc.deadliner == nil {
+		return
+	}
+
+	var deadline time.Time
+
+	sc.mu.Lock()
+	for _, st := range sc.streams {
+		if !st.recvClosed && (deadline.IsZero() || st.deadline.Before(deadline)) {
+			deadline = st.deadline
+		}
+	}
+	idle := len(sc.streams) == 0
+	sc.mu.Unlock()
+
+	if idle {
+		deadline = time.Now().Add(sc.readTimeout)
+	}
+
+	sc.deadliner.SetReadDeadline(deadline)
+}
+
+func (sc *serverConn) errBodyTooLarge() error {
+	return fmt.Errorf("%w: stream body exceeds %d bytes", request.ErrBodyTooLarge, sc.maxBodyBytes)
+}
+
+/**
+* Writing
+**/
+
+func (sc *serverConn) writeFrame(typ frameType, flags uint8, streamID uint32, payload []byte) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	_, err := sc.writer.Write(appendFrame(nil, typ, flags, streamID, payload))
+
+	return err
+}
+
+// writeHeaders encodes fields and sends them as one HEADERS frame plus as many CONTINUATION frames as needed.
+func (sc *serverConn) writeHeaders(streamID uint32, fields []hpack.HeaderField, endStream bool) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	sc.encodeBuf.Reset()
+
+	for _, field := range fields {
+		if err := sc.encoder.WriteField(field); err != nil {
+			return err
+		}
+	}
+
+	block := sc.encodeBuf.Bytes()
+	maxFrameSize := int(sc.peerMaxFrameSize.Load())
+	typ := frameHeaders
+	out := []byte{}
+
+	for first := true; first || len(block) > 0; first = false {
+		fragment := block[:min(len(block), maxFrameSize)]
+		block = block[len(fragment):]
+
+		var flags uint8
+
+		if first && endStream {
+			flags |= flagEndStream
+		}
+
+		if len(block) == 0 {
+			flags |= flagEndHeaders
+		}
+
+		out = appendFrame(out, typ, flags, streamID, fragment)
+		typ = frameContinuation
+	}
+
+	_, err := sc.writer.Write(out)
+
+	return err
+}
+
+func (sc *serverConn) writeGoAway(code errorCode) {
+	payload := []byte{
+		byte(sc.lastStreamID >> 24), byte(sc.lastStreamID >> 16), byte(sc.lastStreamID >> 8), byte(sc.lastStreamID),
+		byte(code >> 24), byte(code >> 16), byte(code >> 8), byte(code),
+	}
+
+	sc.writeFrame(frameGoAway, 0, 0, payload)
+}
+
+func (sc *serverConn) replenish(streamID uint32, size int64) error {
+	if size == 0 {
+		return nil
+	}
+
+	if streamID == 0 {
+		sc.mu.Lock()
+		sc.recvWindow += size
+		sc.mu.Unlock()
+	}
+
+	payload := []byte{byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size)}
+
+	return sc.writeFrame(frameWindowUpdate, 0, streamID, payload)
+}
+
+// reserve blocks until the stream may send up to want bytes, and takes them from both windows.
+func (sc *serverConn) reserve(st *stream, want int) (int, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for {
+		if st.reset || sc.closed {
+			return 0, fmt.Errorf("error: http2 stream %d is closed", st.id)
+		}
+
+		// an empty END_STREAM needs no window
+		if want == 0 {
+			return 0, nil
+		}
+
+		available := min(int64(want), sc.sendWindow, st.sendWindow, int64(sc.peerMaxFrameSize.Load()))
+
+		if available > 0 {
+			sc.sendWindow -= available
+			st.sendWindow -= available
+			return int(available), nil
+		}
+
+		sc.cond.Wait()
+	}
+}
@@ -0,0 +1,328 @@
+package http2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2/hpack"
+)
+
+// newTestConn is a connection whose frames are fed to processFrame directly, what it writes lands in the buffer.
+func newTestConn(handler Handler) (*serverConn, *syncBuffer) {
+	return newTestConnWithOptions(handler, Options{})
+}
+
+func newTestConnWithOptions(handler Handler, options Options) (*serverConn, *syncBuffer) {
+	out := &syncBuffer{}
+
+	if handler == nil {
+		handler = func(w *response.Writer, req *request.Request) {}
+	}
+
+	return newServerConn(nil, out, handler, options), out
+}
+
+// syncBuffer is written by the read loop and the handlers at once.
+type syncBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buffer.Write(p)
+}
+
+// frames reads back what was written so far.
+func (b *syncBuffer) frames() []*frame {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	frames := []*frame{}
+	reader := bytes.NewReader(b.buffer.Bytes())
+
+	for {
+		f, err := readFrame(reader, MAX_ALLOWED_FRAME_SIZE)
+
+		if err != nil {
+			return frames
+		}
+
+		frames = append(frames, f)
+	}
+}
+
+// windowUpdates sums the increments written for streamID.
+func windowUpdates(frames []*frame, streamID uint32) int64 {
+	total := int64(0)
+
+	for _, f := range frames {
+		if f.typ == frameWindowUpdate && f.streamID == streamID {
+			total += int64(binary.BigEndian.Uint32(f.payload))
+		}
+	}
+
+	return total
+}
+
+func newFrame(typ frameType, flags uint8, streamID uint32, payload []byte) *frame {
+	return &frame{length: uint32(len(payload)), typ: typ, flags: flags, streamID: streamID, payload: payload}
+}
+
+// block encodes name, value pairs as one header block.
+func block(pairs ...string) []byte {
+	buffer := &bytes.Buffer{}
+	encoder := hpack.NewEncoder(buffer)
+
+	for i := 0; i+1 < len(pairs); i += 2 {
+		encoder.WriteField(hpack.HeaderField{Name: pairs[i], Value: pairs[i+1]})
+	}
+
+	return buffer.Bytes()
+}
+
+func requestBlock(pairs ...string) []byte {
+	return block(append([]string{":method", "POST", ":scheme", "http", ":path", "/"}, pairs...)...)
+}
+
+func windowUpdate(streamID uint32, increment uint32) *frame {
+	return newFrame(frameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, increment))
+}
+
+func TestProcessFrameErrors(t *testing.T) {
+	open := newFrame(frameHeaders, flagEndHeaders, 1, requestBlock())
+	ended := newFrame(frameHeaders, flagEndHeaders|flagEndStream, 1, requestBlock())
+	unfinished := newFrame(frameHeaders, 0, 1, requestBlock())
+
+	cases := []struct {
+		name   string
+		setup  []*frame
+		frame  *frame
+		code   errorCode
+		stream bool
+	}{
+		// DATA
+		{name: "DATA on stream 0", frame: newFrame(frameData, 0, 0, []byte("x")), code: errProtocol},
+		{name: "DATA on idle stream", frame: newFrame(frameData, 0, 3, []byte("x")), code: errProtocol},
+		{name: "DATA on closed stream", setup: []*frame{ended}, frame: newFrame(frameData, 0, 1, []byte("x")), code: errStreamClosed, stream: true},
+		{name: "DATA without pad length", setup: []*frame{open}, frame: newFrame(frameData, flagPadded, 1, nil), code: errProtocol},
+		{name: "DATA padding longer than payload", setup: []*frame{open}, frame: newFrame(frameData, flagPadded, 1, []byte("\x05ab")), code: errProtocol},
+		{name: "DATA bad padding on closed stream", setup: []*frame{ended}, frame: newFrame(frameData, flagPadded, 1, []byte("\x09")), code: errProtocol},
+		{name: "DATA beyond connection window", setup: []*frame{open}, frame: newFrame(frameData, 0, 1, make([]byte, DEFAULT_INITIAL_WINDOW+1)), code: errFlowControl},
+
+		// flow control
+		{name: "WINDOW_UPDATE overflows connection window", frame: windowUpdate(0, MAX_WINDOW_SIZE), code: errFlowControl},
+		{name: "WINDOW_UPDATE overflows stream window", setup: []*frame{open}, frame: windowUpdate(1, MAX_WINDOW_SIZE), code: errFlowControl, stream: true},
+		{name: "WINDOW_UPDATE of 0 on connection", frame: windowUpdate(0, 0), code: errProtocol},
+		{name: "WINDOW_UPDATE of 0 on stream", setup: []*frame{open}, frame: windowUpdate(1, 0), code: errProtocol, stream: true},
+		{name: "WINDOW_UPDATE wrong size", frame: newFrame(frameWindowUpdate, 0, 0, []byte{0, 1}), code: errFrameSize},
+		{name: "INITIAL_WINDOW_SIZE too large", frame: newFrame(frameSettings, 0, 0, appendSettings(nil, setting{settingInitialWindowSize, MAX_WINDOW_SIZE + 1})), code: errFlowControl},
+
+		// control frames
+		{name: "SETTINGS on a stream", frame: newFrame(frameSettings, 0, 1, nil), code: errProtocol},
+		{name: "SETTINGS wrong size", frame: newFrame(frameSettings, 0, 0, []byte{0, 1, 0}), code: errFrameSize},
+		{name: "SETTINGS ack with payload", frame: newFrame(frameSettings, flagAck, 0, appendSettings(nil, setting{settingEnablePush, 0})), code: errFrameSize},
+		{name: "ENABLE_PUSH out of range", frame: newFrame(frameSettings, 0, 0, appendSettings(nil, setting{settingEnablePush, 2})), code: errProtocol},
+		{name: "MAX_FRAME_SIZE too small", frame: newFrame(frameSettings, 0, 0, appendSettings(nil, setting{settingMaxFrameSize, 100})), code: errProtocol},
+		{name: "PING wrong size", frame: newFrame(framePing, 0, 0, []byte("ping")), code: errFrameSize},
+		{name: "PING on a stream", frame: newFrame(framePing, 0, 1, make([]byte, PING_PAYLOAD_SIZE)), code: errProtocol},
+		{name: "RST_STREAM on stream 0", frame: newFrame(frameRSTStream, 0, 0, make([]byte, 4)), code: errProtocol},
+		{name: "PRIORITY on stream 0", frame: newFrame(framePriority, 0, 0, make([]byte, 5)), code: errProtocol},
+		{name: "PUSH_PROMISE from client", frame: newFrame(framePushPromise, flagEndHeaders, 1, nil), code: errProtocol},
+
+		// HEADERS and CONTINUATION
+		{name: "HEADERS on even stream", frame: newFrame(frameHeaders, flagEndHeaders, 2, requestBlock()), code: errProtocol},
+		{name: "HEADERS on closed stream id", setup: []*frame{newFrame(frameHeaders, flagEndHeaders|flagEndStream, 3, requestBlock())}, frame: open, code: errStreamClosed},
+		{name: "HEADERS padding over priority", frame: newFrame(frameHeaders, flagPadded|flagPriority|flagEndHeaders, 1, []byte("\x03\x00\x00\x00\x03\x00\x00\x00")), code: errProtocol},
+		{name: "HEADERS broken block", frame: newFrame(frameHeaders, flagEndHeaders, 1, []byte{0xff, 0xff, 0xff}), code: errCompression},
+		{name: "trailers that do not end the stream", setup: []*frame{open}, frame: newFrame(frameHeaders, flagEndHeaders, 1, block("x-checksum", "abc")), code: errProtocol},
+		{name: "CONTINUATION without HEADERS", frame: newFrame(frameContinuation, flagEndHeaders, 1, nil), code: errProtocol},
+		{name: "other frame during CONTINUATION", setup: []*frame{unfinished}, frame: newFrame(framePing, 0, 0, make([]byte, PING_PAYLOAD_SIZE)), code: errProtocol},
+		{name: "CONTINUATION of another stream", setup: []*frame{unfinished}, frame: newFrame(frameContinuation, flagEndHeaders, 3, nil), code: errProtocol},
+		{name: "CONTINUATION too large", setup: []*frame{unfinished}, frame: newFrame(frameContinuation, 0, 1, make([]byte, MAX_HEADER_LIST_SIZE)), code: errEnhanceYourCalm},
+
+		// malformed requests only reset their stream (RFC9113 8.1.1)
+		{name: "connection header", frame: newFrame(frameHeaders, flagEndHeaders, 1, requestBlock("connection", "keep-alive")), code: errProtocol, stream: true},
+		{name: "keep-alive header", frame: newFrame(frameHeaders, flagEndHeaders, 1, requestBlock("keep-alive", "timeout=5")), code: errProtocol, stream: true},
+		{name: "transfer-encoding header", frame: newFrame(frameHeaders, flagEndHeaders, 1, requestBlock("transfer-encoding", "chunked")), code: errProtocol, stream: true},
+		{name: "upgrade header", frame: newFrame(frameHeaders, flagEndHeaders, 1, requestBlock("upgrade", "websocket")), code: errProtocol, stream: true},
+		{name: "te other than trailers", frame: newFrame(frameHeaders, flagEndHeaders, 1, requestBlock("te", "gzip")), code: errProtocol, stream: true},
+		{name: "content-length not matching body", frame: newFrame(frameHeaders, flagEndHeaders|flagEndStream, 1, requestBlock("content-length", "5")), code: errProtocol, stream: true},
+		{name: "pseudo header in trailers", setup: []*frame{open}, frame: newFrame(frameHeaders, flagEndHeaders|flagEndStream, 1, block(":path", "/")), code: errProtocol, stream: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			sc, _ := newTestConn(nil)
+
+			for _, f := range tc.setup {
+				require.NoError(t, sc.processFrame(f))
+			}
+
+			err := sc.processFrame(tc.frame)
+
+			if tc.stream {
+				var se streamError
+				require.ErrorAs(t, err, &se)
+				assert.Equal(t, tc.code, se.code)
+				assert.Equal(t, tc.frame.streamID, se.streamID)
+				return
+			}
+
+			var ce connError
+			require.ErrorAs(t, err, &ce)
+			assert.Equal(t, tc.code, ce.code)
+		})
+	}
+}
+
+func TestPaddedDataAndContinuation(t *testing.T) {
+	received := make(chan *request.Request, 1)
+	sc, out := newTestConn(func(w *response.Writer, req *request.Request) {
+		req.ReadBody()
+		received <- req
+	})
+
+	header := requestBlock("content-length", "5")
+
+	require.NoError(t, sc.processFrame(newFrame(frameHeaders, 0, 1, header[:3])))
+	require.NoError(t, sc.processFrame(newFrame(frameContinuation, flagEndHeaders, 1, header[3:])))
+	require.NoError(t, sc.processFrame(newFrame(frameData, flagPadded, 1, []byte("\x02he\x00\x00"))))
+	require.NoError(t, sc.processFrame(newFrame(frameData, flagEndStream, 1, []byte("llo"))))
+
+	req := <-received
+	sc.shutdown()
+
+	assert.Equal(t, "POST", req.RequestLine.Method)
+	assert.Equal(t, "hello", string(req.Body))
+
+	// the padding went back at once, the data once read, all of it on the connection
+	frames := out.frames()
+	assert.Equal(t, int64(len("\x02he\x00\x00")+len("llo")), windowUpdates(frames, 0))
+
+	sc.mu.Lock()
+	assert.Equal(t, int64(DEFAULT_INITIAL_WINDOW), sc.recvWindow)
+	sc.mu.Unlock()
+}
+
+func TestWindowsOpenAsTheHandlerReads(t *testing.T) {
+	read := make(chan struct{})
+	done := make(chan []byte, 2)
+
+	sc, out := newTestConn(func(w *response.Writer, req *request.Request) {
+		<-read
+		body, _ := io.ReadAll(req.BodyReader())
+		done <- body
+	})
+	defer sc.shutdown()
+
+	require.NoError(t, sc.processFrame(newFrame(frameHeaders, flagEndHeaders, 1, requestBlock())))
+	require.NoError(t, sc.processFrame(newFrame(frameData, 0, 1, make([]byte, 40_000))))
+
+	// nothing was read, nothing goes back, the client can only send what is left of the window
+	sc.mu.Lock()
+	assert.Equal(t, int64(DEFAULT_INITIAL_WINDOW-40_000), sc.recvWindow)
+	assert.Equal(t, int64(DEFAULT_INITIAL_WINDOW-40_000), sc.streams[1].recvWindow)
+	sc.mu.Unlock()
+	assert.Zero(t, windowUpdates(out.frames(), 0))
+
+	err := sc.processFrame(newFrame(frameData, 0, 1, make([]byte, 30_000)))
+	var ce connError
+	require.ErrorAs(t, err, &ce)
+	assert.Equal(t, errFlowControl, ce.code)
+
+	sc, out = newTestConn(func(w *response.Writer, req *request.Request) {
+		<-read
+		body, _ := io.ReadAll(req.BodyReader())
+		done <- body
+	})
+	defer sc.shutdown()
+
+	require.NoError(t, sc.processFrame(newFrame(frameHeaders, flagEndHeaders, 1, requestBlock())))
+	require.NoError(t, sc.processFrame(newFrame(frameData, 0, 1, make([]byte, 40_000))))
+	close(read)
+
+	require.Eventually(t, func() bool {
+		return windowUpdates(out.frames(), 0) == 40_000 && windowUpdates(out.frames(), 1) == 40_000
+	}, 5*time.Second, time.Millisecond)
+
+	require.NoError(t, sc.processFrame(newFrame(frameData, flagEndStream, 1, make([]byte, 30_000))))
+	assert.Len(t, <-done, 70_000)
+}
+
+func TestBodyOverMaxBodyBytes(t *testing.T) {
+	errs := make(chan error, 2)
+	handler := func(w *response.Writer, req *request.Request) {
+		errs <- req.ReadBody()
+	}
+
+	// announced too large, refused before any data
+	sc, _ := newTestConnWithOptions(handler, Options{MaxBodyBytes: 10})
+	require.NoError(t, sc.processFrame(newFrame(frameHeaders, flagEndHeaders, 1, requestBlock("content-length", "11"))))
+	assert.ErrorIs(t, <-errs, request.ErrBodyTooLarge)
+	sc.shutdown()
+
+	// found too large while streaming, the connection window comes back, the stream window does not
+	block := make(chan struct{})
+	sc, out := newTestConnWithOptions(func(w *response.Writer, req *request.Request) {
+		<-block
+		handler(w, req)
+	}, Options{MaxBodyBytes: 10})
+	defer sc.shutdown()
+
+	require.NoError(t, sc.processFrame(newFrame(frameHeaders, flagEndHeaders, 1, requestBlock())))
+	require.NoError(t, sc.processFrame(newFrame(frameData, 0, 1, make([]byte, 8))))
+	require.NoError(t, sc.processFrame(newFrame(frameData, 0, 1, make([]byte, 8))))
+	close(block)
+	assert.ErrorIs(t, <-errs, request.ErrBodyTooLarge)
+
+	frames := out.frames()
+	assert.Equal(t, int64(16), windowUpdates(frames, 0))
+	assert.Zero(t, windowUpdates(frames, 1))
+}
+
+func TestHeaderListOverMaxHeaderBytes(t *testing.T) {
+	sc, _ := newTestConnWithOptions(nil, Options{MaxHeaderBytes: 100})
+	defer sc.shutdown()
+
+	err := sc.processFrame(newFrame(frameHeaders, flagEndHeaders, 1, requestBlock("x-large", strings.Repeat("a", 100))))
+
+	var se streamError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, errEnhanceYourCalm, se.code)
+}
+
+func TestResetCancelsTheRequest(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+
+	sc, _ := newTestConn(func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-req.Context().Done()
+		close(cancelled)
+	})
+
+	require.NoError(t, sc.processFrame(newFrame(frameHeaders, flagEndHeaders|flagEndStream, 1, requestBlock())))
+	<-started
+
+	require.NoError(t, sc.processFrame(newFrame(frameRSTStream, 0, 1, make([]byte, 4))))
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RST_STREAM did not cancel the handler")
+	}
+
+	sc.shutdown()
+}
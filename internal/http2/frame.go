@@ -0,0 +1,231 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+/*
+According to RFC9113 4.1, every frame starts with a fixed 9 byte header:
+
+	+-----------------------------------------------+
+	|                 Length (24)                   |
+	+---------------+---------------+---------------+
+	|   Type (8)    |   Flags (8)   |
+	+-+-------------+---------------+-------------------------------+
+	|R|                 Stream Identifier (31)                      |
+	+=+=============================================================+
+	|                   Frame Payload (0...)                      ...
+	+---------------------------------------------------------------+
+*/
+const FRAME_HEADER_SIZE = 9
+
+type frameType uint8
+
+const (
+	frameData         frameType = 0x0
+	frameHeaders      frameType = 0x1
+	framePriority     frameType = 0x2
+	frameRSTStream    frameType = 0x3
+	frameSettings     frameType = 0x4
+	framePushPromise  frameType = 0x5
+	framePing         frameType = 0x6
+	frameGoAway       frameType = 0x7
+	frameWindowUpdate frameType = 0x8
+	frameContinuation frameType = 0x9
+)
+
+const (
+	flagEndStream  uint8 = 0x1
+	flagAck        uint8 = 0x1
+	flagEndHeaders uint8 = 0x4
+	flagPadded     uint8 = 0x8
+	flagPriority   uint8 = 0x20
+)
+
+type errorCode uint32
+
+// RFC9113 7
+const (
+	errNo              errorCode = 0x0
+	errProtocol        errorCode = 0x1
+	errInternal        errorCode = 0x2
+	errFlowControl     errorCode = 0x3
+	errStreamClosed    errorCode = 0x5
+	errFrameSize       errorCode = 0x6
+	errRefusedStream   errorCode = 0x7
+	errCancel          errorCode = 0x8
+	errCompression     errorCode = 0x9
+	errEnhanceYourCalm errorCode = 0xb
+	errHTTP11Required  errorCode = 0xd
+)
+
+// connError ends the whole connection with a GOAWAY, streamError only resets one stream.
+type connError struct {
+	code   errorCode
+	reason string
+}
+
+func (e connError) Error() string {
+	return fmt.Sprintf("http2: connection error %d: %s", e.code, e.reason)
+}
+
+type streamError struct {
+	streamID uint32
+	code     errorCode
+}
+
+func (e streamError) Error() string {
+	return fmt.Sprintf("http2: stream %d error %d", e.streamID, e.code)
+}
+
+type settingID uint16
+
+// RFC9113 6.5.2
+const (
+	settingHeaderTableSize      settingID = 0x1
+	settingEnablePush           settingID = 0x2
+	settingMaxConcurrentStreams settingID = 0x3
+	settingInitialWindowSize    settingID = 0x4
+	settingMaxFrameSize         settingID = 0x5
+	settingMaxHeaderListSize    settingID = 0x6
+)
+
+const (
+	DEFAULT_HEADER_TABLE_SIZE  = 4096
+	DEFAULT_INITIAL_WINDOW     = 65535
+	DEFAULT_MAX_FRAME_SIZE     = 16384
+	MAX_ALLOWED_FRAME_SIZE     = 1<<24 - 1
+	MAX_WINDOW_SIZE            = 1<<31 - 1
+	MAX_CONCURRENT_STREAMS     = 100
+	MAX_HEADER_LIST_SIZE       = 1 << 20
+	SETTING_SIZE               = 6
+	WINDOW_UPDATE_PAYLOAD_SIZE = 4
+	PING_PAYLOAD_SIZE          = 8
+)
+
+type setting struct {
+	id    settingID
+	value uint32
+}
+
+type frame struct {
+	length   uint32
+	typ      frameType
+	flags    uint8
+	streamID uint32
+	payload  []byte
+}
+
+func (f *frame) has(flag uint8) bool {
+	return f.flags&flag == flag
+}
+
+// readFrame reads one frame, rejecting payloads bigger than the max frame size we advertised.
+func readFrame(r io.Reader, maxFrameSize uint32) (*frame, error) {
+	header := make([]byte, FRAME_HEADER_SIZE)
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	f := &frame{
+		length:   uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2]),
+		typ:      frameType(header[3]),
+		flags:    header[4],
+		streamID: binary.BigEndian.Uint32(header[5:]) & (1<<31 - 1),
+	}
+
+	if f.length > maxFrameSize {
+		return nil, connError{errFrameSize, fmt.Sprintf("frame of %d bytes exceeds %d", f.length, maxFrameSize)}
+	}
+
+	f.payload = make([]byte, f.length)
+
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func appendFrame(dst []byte, typ frameType, flags uint8, streamID uint32, payload []byte) []byte {
+	length := len(payload)
+
+	dst = append(dst, byte(length>>16), byte(length>>8), byte(length), byte(typ), flags)
+	dst = binary.BigEndian.AppendUint32(dst, streamID&(1<<31-1))
+
+	return append(dst, payload...)
+}
+
+/*
+Padding (RFC9113 6.1) is only there to hide the real size, a padded payload starts
+with the pad length and ends with that many zero bytes which are thrown away.
+*/
+func (f *frame) unpad() ([]byte, error) {
+	if !f.has(flagPadded) {
+		return f.payload, nil
+	}
+
+	if len(f.payload) < 1 {
+		return nil, connError{errProtocol, "padded frame without pad length"}
+	}
+
+	padLength := int(f.payload[0])
+
+	if padLength >= len(f.payload) {
+		return nil, connError{errProtocol, "padding longer than payload"}
+	}
+
+	return f.payload[1 : len(f.payload)-padLength], nil
+}
+
+// headerBlockFragment strips padding and the optional priority fields of a HEADERS frame.
+func (f *frame) headerBlockFragment() ([]byte, error) {
+	payload, err := f.unpad()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if f.has(flagPriority) {
+		// exclusive bit + stream dependency (4 bytes) + weight (1 byte)
+		if len(payload) < 5 {
+			// padding that leaves no room for the priority fields is a PROTOCOL_ERROR (RFC9113 6.2)
+			if f.has(flagPadded) {
+				return nil, connError{errProtocol, "padding longer than header block"}
+			}
+			return nil, connError{errFrameSize, "headers priority fields truncated"}
+		}
+		payload = payload[5:]
+	}
+
+	return payload, nil
+}
+
+func parseSettings(payload []byte) ([]setting, error) {
+	if len(payload)%SETTING_SIZE != 0 {
+		return nil, connError{errFrameSize, "settings payload is not a multiple of 6"}
+	}
+
+	settings := make([]setting, 0, len(payload)/SETTING_SIZE)
+
+	for i := 0; i < len(payload); i += SETTING_SIZE {
+		settings = append(settings, setting{
+			id:    settingID(binary.BigEndian.Uint16(payload[i:])),
+			value: binary.BigEndian.Uint32(payload[i+2:]),
+		})
+	}
+
+	return settings, nil
+}
+
+func appendSettings(dst []byte, settings ...setting) []byte {
+	for _, s := range settings {
+		dst = binary.BigEndian.AppendUint16(dst, uint16(s.id))
+		dst = binary.BigEndian.AppendUint32(dst, s.value)
+	}
+
+	return dst
+}
@@ -0,0 +1,143 @@
+package http2
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFrame(t *testing.T) {
+	wire := appendFrame(nil, frameData, flagEndStream, 7, []byte("hello"))
+	// the reserved bit of the stream identifier is ignored when reading (RFC9113 4.1)
+	wire[5] |= 0x80
+
+	f, err := readFrame(bytes.NewReader(wire), DEFAULT_MAX_FRAME_SIZE)
+	require.NoError(t, err)
+	assert.Equal(t, frameData, f.typ)
+	assert.True(t, f.has(flagEndStream))
+	assert.Equal(t, uint32(7), f.streamID)
+	assert.Equal(t, []byte("hello"), f.payload)
+
+	cases := []struct {
+		name string
+		wire []byte
+		err  error
+		code errorCode
+	}{
+		{
+			name: "payload bigger than max frame size",
+			wire: appendFrame(nil, frameData, 0, 1, make([]byte, DEFAULT_MAX_FRAME_SIZE+1)),
+			code: errFrameSize,
+		},
+		{
+			name: "truncated header",
+			wire: []byte{0, 0, 5, 0},
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "truncated payload",
+			wire: appendFrame(nil, frameData, 0, 1, []byte("hello"))[:FRAME_HEADER_SIZE+2],
+			err:  io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := readFrame(bytes.NewReader(tc.wire), DEFAULT_MAX_FRAME_SIZE)
+
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			var ce connError
+			require.ErrorAs(t, err, &ce)
+			assert.Equal(t, tc.code, ce.code)
+		})
+	}
+}
+
+func TestUnpad(t *testing.T) {
+	cases := []struct {
+		name    string
+		flags   uint8
+		payload []byte
+		data    []byte
+		invalid bool
+	}{
+		{name: "not padded", payload: []byte("abc"), data: []byte("abc")},
+		{name: "padded", flags: flagPadded, payload: []byte("\x02abc\x00\x00"), data: []byte("abc")},
+		{name: "padding only", flags: flagPadded, payload: []byte("\x02\x00\x00"), data: []byte{}},
+		{name: "no pad length", flags: flagPadded, payload: []byte{}, invalid: true},
+		{name: "padding as long as payload", flags: flagPadded, payload: []byte("\x03ab"), invalid: true},
+		{name: "padding longer than payload", flags: flagPadded, payload: []byte("\xffabc"), invalid: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &frame{typ: frameData, flags: tc.flags, payload: tc.payload, length: uint32(len(tc.payload))}
+			data, err := f.unpad()
+
+			if !tc.invalid {
+				require.NoError(t, err)
+				assert.Equal(t, tc.data, data)
+				return
+			}
+
+			var ce connError
+			require.ErrorAs(t, err, &ce)
+			assert.Equal(t, errProtocol, ce.code)
+		})
+	}
+}
+
+func TestHeaderBlockFragment(t *testing.T) {
+	cases := []struct {
+		name     string
+		flags    uint8
+		payload  []byte
+		fragment []byte
+		code     errorCode
+	}{
+		{name: "plain", payload: []byte("block"), fragment: []byte("block")},
+		{name: "priority", flags: flagPriority, payload: []byte("\x80\x00\x00\x03\x10block"), fragment: []byte("block")},
+		{name: "padded priority", flags: flagPadded | flagPriority, payload: []byte("\x01\x00\x00\x00\x03\x10block\x00"), fragment: []byte("block")},
+		{name: "priority truncated", flags: flagPriority, payload: []byte("\x00\x00"), code: errFrameSize},
+		{name: "padding over priority", flags: flagPadded | flagPriority, payload: []byte("\x03\x00\x00\x00\x03\x00\x00\x00"), code: errProtocol},
+		{name: "padding over payload", flags: flagPadded, payload: []byte("\x09block"), code: errProtocol},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &frame{typ: frameHeaders, flags: tc.flags, payload: tc.payload, length: uint32(len(tc.payload))}
+			fragment, err := f.headerBlockFragment()
+
+			if tc.code == errNo {
+				require.NoError(t, err)
+				assert.Equal(t, tc.fragment, fragment)
+				return
+			}
+
+			var ce connError
+			require.ErrorAs(t, err, &ce)
+			assert.Equal(t, tc.code, ce.code)
+		})
+	}
+}
+
+func TestParseSettings(t *testing.T) {
+	payload := appendSettings(nil, setting{settingInitialWindowSize, 1 << 20}, setting{settingEnablePush, 0})
+
+	settings, err := parseSettings(payload)
+	require.NoError(t, err)
+	assert.Equal(t, []setting{{settingInitialWindowSize, 1 << 20}, {settingEnablePush, 0}}, settings)
+
+	_, err = parseSettings(payload[:7])
+
+	var ce connError
+	require.ErrorAs(t, err, &ce)
+	assert.Equal(t, errFrameSize, ce.code)
+}
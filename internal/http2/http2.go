@@ -0,0 +1,131 @@
+package http2
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+)
+
+/*
+HTTP/2 over cleartext TCP (h2c) starts in one of two ways (RFC9113 3.3 and RFC7540 3.2):
+  - prior knowledge: the client knows the server speaks HTTP/2 and opens with CLIENT_PREFACE,
+    which reads like a request line with the PRI method so HTTP/1.1 servers reject it.
+  - upgrade: the client sends an HTTP/1.1 request with "Upgrade: h2c" and its settings in
+    HTTP2-Settings, the server answers 101 and the response to that request comes on stream 1.
+*/
+const CLIENT_PREFACE = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const KEY_HTTP2_SETTINGS = "HTTP2-Settings"
+
+/*
+HasPreface peeks at the first bytes of the connection without consuming them.
+It stops at the first byte that differs, so a short HTTP/1.1 request does not block it.
+*/
+func HasPreface(reader *bufio.Reader) bool {
+	for n := 1; n <= len(CLIENT_PREFACE); n++ {
+		peeked, err := reader.Peek(n)
+
+		if err != nil {
+			return false
+		}
+
+		if peeked[n-1] != CLIENT_PREFACE[n-1] {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+Options carry the limits of the server over to the streams of a connection. A handler starts
+as soon as the headers of its stream are in, the body streams to it through BodyReader and
+the flow control windows only open up again as it reads.
+*/
+type Options struct {
+	// MaxHeaderBytes bounds the header block of a request, zero means MAX_HEADER_LIST_SIZE.
+	MaxHeaderBytes int
+	// MaxBodyBytes bounds the body of each stream, reading past it fails with request.ErrBodyTooLarge.
+	MaxBodyBytes int
+	// ReadTimeout bounds receiving a request, from its HEADERS to its END_STREAM, and how long
+	// a connection without streams is kept.
+	ReadTimeout time.Duration
+}
+
+// ServeConn serves a prior knowledge connection, reader still holds the client preface.
+func ServeConn(conn io.Writer, reader *bufio.Reader, handler Handler, options Options) error {
+	return newServerConn(reader, conn, handler, options).serve(nil)
+}
+
+func IsUpgradeRequest(req *request.Request) bool {
+	upgrade, _ := req.Headers.Get("Upgrade")
+
+	if !hasToken(upgrade, "h2c") {
+		return false
+	}
+
+	_, ok := req.Headers.Get(KEY_HTTP2_SETTINGS)
+
+	return ok
+}
+
+// ServeUpgrade switches an HTTP/1.1 connection to HTTP/2 and answers req on stream 1.
+func ServeUpgrade(conn io.Writer, reader *bufio.Reader, req *request.Request, handler Handler, options Options) error {
+	encoded, _ := req.Headers.Get(KEY_HTTP2_SETTINGS)
+
+	// a repeated header would have been joined with ", ", which base64url never contains
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+
+	if err != nil {
+		return fmt.Errorf("error: malformed HTTP2-Settings: %w", err)
+	}
+
+	settings, err := parseSettings(payload)
+
+	if err != nil {
+		return err
+	}
+
+	sc := newServerConn(reader, conn, handler, options)
+
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+
+	w := response.NewWriter(conn)
+	switching := headers.NewHeaders()
+	switching.Override("Connection", "Upgrade")
+	switching.Override("Upgrade", "h2c")
+
+	if err := w.WriteStatusLine(response.SWITCHING_PROTOCOLS); err != nil {
+		return err
+	}
+
+	if err := w.WriteHeaders(switching); err != nil {
+		return err
+	}
+
+	// the upgrade headers belong to the HTTP/1.1 hop, the handler sees the plain request
+	req.Headers.Remove("Upgrade")
+	req.Headers.Remove(KEY_HTTP2_SETTINGS)
+	req.Headers.Remove("Connection")
+
+	return sc.serve(req)
+}
+
+func hasToken(list, token string) bool {
+	for part := range strings.SplitSeq(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+
+	return false
+}
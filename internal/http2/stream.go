@@ -0,0 +1,281 @@
+package http2
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"golang.org/x/net/http2/hpack"
+)
+
+type stream struct {
+	id      uint32
+	conn    *serverConn
+	request *request.Request
+
+	// body is read by the handler as the read loop fills it
+	body *requestBody
+
+	// read loop only
+	recvClosed bool
+	received   int64
+	// deadline is when the request must have been received, see ReadTimeout
+	deadline time.Time
+
+	// guarded by conn.mu
+	sendWindow int64
+	recvWindow int64
+	reset      bool
+	cancel     context.CancelFunc
+
+	// handler goroutine only
+	headWritten bool
+	ended       bool
+}
+
+// connection specific headers are meaningless in HTTP/2 and must not be sent (RFC9113 8.2.2)
+var connectionHeaders = map[string]struct{}{
+	"connection":        {},
+	"keep-alive":        {},
+	"proxy-connection":  {},
+	"transfer-encoding": {},
+	"upgrade":           {},
+}
+
+func (st *stream) WriteHead(statusCode response.StatusCode, h headers.Headers) error {
+	fields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(int(statusCode))}}
+	fields = append(fields, headerFields(h)...)
+
+	if err := st.conn.writeHeaders(st.id, fields, false); err != nil {
+		return err
+	}
+
+	st.headWritten = true
+
+	return nil
+}
+
+func (st *stream) WriteData(p []byte, endStream bool) error {
+	if st.ended {
+		return fmt.Errorf("error: http2 stream %d already ended", st.id)
+	}
+
+	for {
+		n, err := st.conn.reserve(st, len(p))
+
+		if err != nil {
+			return err
+		}
+
+		chunk := p[:n]
+		p = p[n:]
+
+		var flags uint8
+
+		if endStream && len(p) == 0 {
+			flags = flagEndStream
+		}
+
+		if err := st.conn.writeFrame(frameData, flags, st.id, chunk); err != nil {
+			return err
+		}
+
+		if flags == flagEndStream {
+			st.end()
+			return nil
+		}
+
+		if len(p) == 0 {
+			return nil
+		}
+	}
+}
+
+func (st *stream) WriteTrailers(h headers.Headers) error {
+	if st.ended {
+		return fmt.Errorf("error: http2 stream %d already ended", st.id)
+	}
+
+	if err := st.conn.writeHeaders(st.id, headerFields(h), true); err != nil {
+		return err
+	}
+
+	st.end()
+
+	return nil
+}
+
+func (st *stream) end() {
+	st.ended = true
+}
+
+/*
+finish runs after the handler returned. A handler that wrote the headers but never ended
+the body gets an empty END_STREAM, one that wrote nothing gets its stream reset, which is
+what closing the connection without a response would be in HTTP/1.1.
+*/
+func (st *stream) finish() {
+	if st.ended {
+		return
+	}
+
+	if !st.headWritten {
+		st.conn.resetStream(st.id, errInternal)
+		return
+	}
+
+	st.WriteData(nil, true)
+}
+
+/**
+* Helpers
+**/
+
+func headerFields(h headers.Headers) []hpack.HeaderField {
+	fields := make([]hpack.HeaderField, 0, len(h))
+
+	h.Each(func(name, value string) {
+		if _, ok := connectionHeaders[name]; ok {
+			return
+		}
+
+		fields = append(fields, hpack.HeaderField{Name: name, Value: value})
+	})
+
+	return fields
+}
+
+/*
+According to RFC9113 8.3.1, the request line is carried by pseudo-header fields that
+come before every regular field: :method, :scheme, :authority and :path.
+*/
+func requestFromFields(fields []hpack.HeaderField) (*request.Request, error) {
+	req := request.NewRequest()
+	var method, path, scheme, authority string
+	regular := false
+
+	for _, field := range fields {
+		if strings.HasPrefix(field.Name, ":") {
+			if regular {
+				return nil, fmt.Errorf("pseudo header after regular header")
+			}
+
+			switch field.Name {
+			case ":method":
+				method = field.Value
+			case ":path":
+				path = field.Value
+			case ":scheme":
+				scheme = field.Value
+			case ":authority":
+				authority = field.Value
+			default:
+				return nil, fmt.Errorf("unknown pseudo header %s", field.Name)
+			}
+			continue
+		}
+
+		regular = true
+
+		if err := addField(req, field); err != nil {
+			return nil, err
+		}
+	}
+
+	if method == "" || path == "" || scheme == "" {
+		return nil, fmt.Errorf("missing pseudo headers")
+	}
+
+	if _, ok := req.Headers.Get("Host"); !ok && authority != "" {
+		req.Headers.Override("Host", authority)
+	}
+
+	req.RequestLine = request.RequestLine{
+		HttpVersion:   "2",
+		RequestTarget: path,
+		Method:        method,
+	}
+
+	return req, nil
+}
+
+/*
+addTrailers keeps the trailer section in req.Trailers, apart from the headers it could otherwise
+override, like a chunked body does in HTTP/1.1. According to RFC9113 8.1, it carries no
+pseudo-header fields.
+*/
+func addTrailers(req *request.Request, fields []hpack.HeaderField) error {
+	trailers := headers.NewHeaders()
+
+	for _, field := range fields {
+		if strings.HasPrefix(field.Name, ":") {
+			return fmt.Errorf("pseudo header in trailers")
+		}
+
+		if err := checkField(field); err != nil {
+			return err
+		}
+
+		trailers.Set([]byte(field.Name), []byte(field.Value))
+	}
+
+	req.Trailers = trailers
+
+	return nil
+}
+
+func addField(req *request.Request, field hpack.HeaderField) error {
+	if err := checkField(field); err != nil {
+		return err
+	}
+
+	// cookies may be split across fields, they are joined back with "; " (RFC9113 8.2.3)
+	if field.Name == "cookie" {
+		if existing, ok := req.Headers.Get("Cookie"); ok {
+			req.Headers.Override("Cookie", existing+"; "+field.Value)
+			return nil
+		}
+	}
+
+	req.Headers.Set([]byte(field.Name), []byte(field.Value))
+
+	return nil
+}
+
+func checkField(field hpack.HeaderField) error {
+	if field.Name != strings.ToLower(field.Name) {
+		return fmt.Errorf("uppercase header name %s", field.Name)
+	}
+
+	if _, ok := connectionHeaders[field.Name]; ok {
+		return fmt.Errorf("connection specific header %s", field.Name)
+	}
+
+	if field.Name == "te" && field.Value != "trailers" {
+		return fmt.Errorf("te header other than trailers")
+	}
+
+	return nil
+}
+
+// contentLength is the length the client announced for the body, -1 when it did not.
+func contentLength(req *request.Request) (int64, error) {
+	value, ok := req.Headers.Get(request.KEY_CONTENT_LENGTH)
+
+	if !ok {
+		return -1, nil
+	}
+
+	length, err := strconv.ParseInt(value, 10, 64)
+
+	if err != nil || length < 0 {
+		return 0, fmt.Errorf("malformed content-length %s", value)
+	}
+
+	return length, nil
+}
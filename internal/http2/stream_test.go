@@ -0,0 +1,79 @@
+package http2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2/hpack"
+)
+
+func fields(pairs ...string) []hpack.HeaderField {
+	fields := []hpack.HeaderField{}
+
+	for i := 0; i+1 < len(pairs); i += 2 {
+		fields = append(fields, hpack.HeaderField{Name: pairs[i], Value: pairs[i+1]})
+	}
+
+	return fields
+}
+
+func TestRequestFromFields(t *testing.T) {
+	req, err := requestFromFields(fields(
+		":method", "GET", ":scheme", "https", ":authority", "example.com", ":path", "/a?b=c",
+		"cookie", "a=1", "accept", "*/*", "cookie", "b=2", "te", "trailers",
+	))
+	require.NoError(t, err)
+
+	assert.Equal(t, "GET", req.RequestLine.Method)
+	assert.Equal(t, "/a?b=c", req.RequestLine.RequestTarget)
+	assert.Equal(t, "2", req.RequestLine.HttpVersion)
+
+	host, _ := req.Headers.Get("Host")
+	assert.Equal(t, "example.com", host)
+
+	cookie, _ := req.Headers.Get("Cookie")
+	assert.Equal(t, "a=1; b=2", cookie)
+}
+
+func TestRequestFromFieldsRejectsMalformed(t *testing.T) {
+	cases := map[string][]hpack.HeaderField{
+		"missing :method":          fields(":scheme", "http", ":path", "/"),
+		"missing :path":            fields(":method", "GET", ":scheme", "http"),
+		"missing :scheme":          fields(":method", "GET", ":path", "/"),
+		"unknown pseudo header":    fields(":method", "GET", ":scheme", "http", ":path", "/", ":status", "200"),
+		"pseudo after regular":     fields(":method", "GET", ":scheme", "http", "accept", "*/*", ":path", "/"),
+		"uppercase name":           fields(":method", "GET", ":scheme", "http", ":path", "/", "Accept", "*/*"),
+		"connection":               fields(":method", "GET", ":scheme", "http", ":path", "/", "connection", "close"),
+		"proxy-connection":         fields(":method", "GET", ":scheme", "http", ":path", "/", "proxy-connection", "keep-alive"),
+		"transfer-encoding":        fields(":method", "GET", ":scheme", "http", ":path", "/", "transfer-encoding", "chunked"),
+		"upgrade":                  fields(":method", "GET", ":scheme", "http", ":path", "/", "upgrade", "h2c"),
+		"te other than trailers":   fields(":method", "GET", ":scheme", "http", ":path", "/", "te", "gzip"),
+		"keep-alive":               fields(":method", "GET", ":scheme", "http", ":path", "/", "keep-alive", "timeout=5"),
+		"connection before pseudo": fields("connection", "close", ":method", "GET", ":scheme", "http", ":path", "/"),
+	}
+
+	for name, fields := range cases {
+		_, err := requestFromFields(fields)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestAddTrailers(t *testing.T) {
+	req, err := requestFromFields(fields(":method", "POST", ":scheme", "http", ":path", "/"))
+	require.NoError(t, err)
+
+	require.NoError(t, addTrailers(req, fields("x-checksum", "abc", "host", "evil.example")))
+	checksum, _ := req.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc", checksum)
+
+	// trailers never reach the headers, nor replace one of them
+	_, ok := req.Headers.Get("X-Checksum")
+	assert.False(t, ok)
+	_, ok = req.Headers.Get("Host")
+	assert.False(t, ok)
+
+	assert.Error(t, addTrailers(req, fields(":path", "/")))
+	assert.Error(t, addTrailers(req, fields("x-checksum", "abc", ":status", "200")))
+	assert.Error(t, addTrailers(req, fields("connection", "close")))
+}
@@ -0,0 +1,263 @@
+package negotiate
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+)
+
+const KEY_ACCEPT = "Accept"
+const KEY_ACCEPT_LANGUAGE = "Accept-Language"
+const KEY_ACCEPT_CHARSET = "Accept-Charset"
+
+/*
+Preference is one element of an Accept-* field, like "text/html;level=1;q=0.8":
+the value, its parameters before the weight, and the weight.
+*/
+type Preference struct {
+	Value  string
+	Params map[string]string
+	Weight float64
+}
+
+/*
+According to RFC9110 12.4.2 and 12.5, every Accept-* field is a list of values with an
+optional weight between 0 and 1, 1 when missing. A weight of 0 means "not acceptable".
+
+Parse returns the elements of such a field from the highest weight to the lowest, elements
+of the same weight keep their order. Values are lower case.
+*/
+func Parse(field string) []Preference {
+	preferences := []Preference{}
+
+	for _, element := range strings.Split(field, ",") {
+		parts := strings.Split(element, ";")
+		value := strings.ToLower(strings.TrimSpace(parts[0]))
+
+		if value == "" {
+			continue
+		}
+
+		preference := Preference{Value: value, Params: map[string]string{}, Weight: 1}
+
+		for _, param := range parts[1:] {
+			key, paramValue, ok := strings.Cut(strings.TrimSpace(param), "=")
+
+			if !ok {
+				continue
+			}
+
+			key = strings.ToLower(strings.TrimSpace(key))
+			paramValue = strings.Trim(strings.TrimSpace(paramValue), `"`)
+
+			// parameters after q are accept-ext, they do not qualify the value
+			if key == "q" {
+				preference.Weight = parseWeight(paramValue)
+				break
+			}
+
+			preference.Params[key] = strings.ToLower(paramValue)
+		}
+
+		preferences = append(preferences, preference)
+	}
+
+	sort.SliceStable(preferences, func(i, j int) bool {
+		return preferences[i].Weight > preferences[j].Weight
+	})
+
+	return preferences
+}
+
+/*
+ContentType picks among the media types a handler can produce the one Accept weighs the most.
+According to RFC9110 12.5.1, the most specific range that matches an offer decides its weight:
+"text/html;level=1" over "text/html" over "text/*" over any type. Offers the client weighs
+the same are taken in the order given, and without Accept the first offer is taken.
+*/
+func ContentType(accept string, offers ...string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return first(offers)
+	}
+
+	ranges := Parse(accept)
+
+	return best(offers, func(offer string) (float64, bool) {
+		offerType, offerParams := splitMediaType(offer)
+		offerMain, offerSub, _ := strings.Cut(offerType, "/")
+
+		weight, specificity := 0.0, -1
+
+		for _, r := range ranges {
+			rangeMain, rangeSub, _ := strings.Cut(r.Value, "/")
+			s := 0
+
+			switch {
+			case r.Value == "*/*" || r.Value == "*":
+			case rangeMain == offerMain && rangeSub == "*":
+				s = 1
+			case rangeMain == offerMain && rangeSub == offerSub:
+				s = 2 + len(r.Params)
+			default:
+				continue
+			}
+
+			if !paramsMatch(r.Params, offerParams) {
+				continue
+			}
+
+			if s > specificity {
+				weight, specificity = r.Weight, s
+			}
+		}
+
+		return weight, specificity >= 0
+	})
+}
+
+/*
+Language picks among the language tags a handler has the one Accept-Language weighs the most.
+According to RFC4647 3.3.1 (basic filtering), a range matches a tag equal to it or starting
+with it and a "-": "en" matches "en-GB", and "*" matches any. The longest matching range decides.
+*/
+func Language(acceptLanguage string, offers ...string) (string, bool) {
+	if strings.TrimSpace(acceptLanguage) == "" {
+		return first(offers)
+	}
+
+	ranges := Parse(acceptLanguage)
+
+	return best(offers, func(offer string) (float64, bool) {
+		tag := strings.ToLower(offer)
+		weight, length := 0.0, -1
+
+		for _, r := range ranges {
+			matches := r.Value == "*" || tag == r.Value || strings.HasPrefix(tag, r.Value+"-")
+
+			if !matches {
+				continue
+			}
+
+			l := len(r.Value)
+
+			if r.Value == "*" {
+				l = 0
+			}
+
+			if l > length {
+				weight, length = r.Weight, l
+			}
+		}
+
+		return weight, length >= 0
+	})
+}
+
+// Charset picks among the charsets a handler can encode the one Accept-Charset weighs the most, "*" matches any.
+func Charset(acceptCharset string, offers ...string) (string, bool) {
+	if strings.TrimSpace(acceptCharset) == "" {
+		return first(offers)
+	}
+
+	ranges := Parse(acceptCharset)
+
+	return best(offers, func(offer string) (float64, bool) {
+		wildcard, found := 0.0, false
+
+		for _, r := range ranges {
+			if r.Value == strings.ToLower(offer) {
+				return r.Weight, true
+			}
+
+			if r.Value == "*" && !found {
+				wildcard, found = r.Weight, true
+			}
+		}
+
+		return wildcard, found
+	})
+}
+
+/*
+Pick picks the content type of the response among offers. When the client accepts none of
+them it answers 406 Not Acceptable, listing the offers, and returns false: the handler is done.
+The response varies with Accept, a cache must know it, so the handler adds "Vary: Accept".
+*/
+func Pick(w *response.Writer, req *request.Request, offers ...string) (string, bool) {
+	accept, _ := req.Headers.Get(KEY_ACCEPT)
+
+	if offer, ok := ContentType(accept, offers...); ok {
+		return offer, true
+	}
+
+	body := []byte("none of the available representations is acceptable: " + strings.Join(offers, ", ") + "\n")
+
+	headers := response.GetDefaultHeaders(len(body))
+	headers.Override("Vary", KEY_ACCEPT)
+
+	w.WriteStatusLine(response.NOT_ACCEPTABLE)
+	w.WriteHeaders(headers)
+	w.WriteBody(body)
+
+	return "", false
+}
+
+/**
+* Helpers
+**/
+
+// best returns the offer with the highest positive weight, the first one of a tie.
+func best(offers []string, weigh func(offer string) (float64, bool)) (string, bool) {
+	chosen, chosenWeight := "", 0.0
+
+	for _, offer := range offers {
+		if weight, ok := weigh(offer); ok && weight > chosenWeight {
+			chosen, chosenWeight = offer, weight
+		}
+	}
+
+	return chosen, chosenWeight > 0
+}
+
+func first(offers []string) (string, bool) {
+	if len(offers) == 0 {
+		return "", false
+	}
+
+	return offers[0], true
+}
+
+func splitMediaType(mediaType string) (string, map[string]string) {
+	preferences := Parse(mediaType)
+
+	if len(preferences) == 0 {
+		return "", map[string]string{}
+	}
+
+	return preferences[0].Value, preferences[0].Params
+}
+
+// paramsMatch tells whether every parameter of the range is on the offer with the same value.
+func paramsMatch(rangeParams, offerParams map[string]string) bool {
+	for key, value := range rangeParams {
+		if offerParams[key] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseWeight reads the value of "q=", a malformed weight is 0 so a broken element is never preferred.
+func parseWeight(value string) float64 {
+	weight, err := strconv.ParseFloat(value, 64)
+
+	if err != nil || weight < 0 || weight > 1 {
+		return 0
+	}
+
+	return weight
+}
@@ -0,0 +1,106 @@
+package negotiate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	preferences := Parse(`text/*;q=0.3, text/HTML;level=1, text/plain;q=0.3;ext="x", , */*;q=bad`)
+
+	require.Len(t, preferences, 4)
+	assert.Equal(t, "text/html", preferences[0].Value)
+	assert.Equal(t, map[string]string{"level": "1"}, preferences[0].Params)
+	// ties keep their order
+	assert.Equal(t, "text/*", preferences[1].Value)
+	assert.Equal(t, "text/plain", preferences[2].Value)
+	assert.Equal(t, 0.3, preferences[2].Weight)
+	// accept-ext after q is not a parameter of the value
+	assert.Empty(t, preferences[2].Params)
+	// a malformed weight is 0
+	assert.Equal(t, "*/*", preferences[3].Value)
+	assert.Equal(t, 0.0, preferences[3].Weight)
+
+	assert.Empty(t, Parse(""))
+}
+
+func TestContentType(t *testing.T) {
+	offer, ok := ContentType("", "application/json", "text/html")
+	assert.True(t, ok)
+	assert.Equal(t, "application/json", offer)
+
+	// the most specific range decides, text/html is refused even though text/* is welcome
+	offer, ok = ContentType("text/*, text/html;q=0, application/json;q=0.5", "text/html", "application/json", "text/plain")
+	assert.True(t, ok)
+	assert.Equal(t, "text/plain", offer)
+
+	offer, ok = ContentType("text/html;level=1, text/html;q=0.2, */*;q=0.1", "text/html", "text/html;level=1")
+	assert.True(t, ok)
+	assert.Equal(t, "text/html;level=1", offer)
+
+	// a tie goes to the first offer
+	offer, ok = ContentType("*/*", "text/plain", "application/json")
+	assert.True(t, ok)
+	assert.Equal(t, "text/plain", offer)
+
+	_, ok = ContentType("image/png, */*;q=0", "text/html")
+	assert.False(t, ok)
+
+	_, ok = ContentType("text/html")
+	assert.False(t, ok)
+}
+
+func TestLanguage(t *testing.T) {
+	offer, ok := Language("fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5", "en-GB", "fr", "de")
+	assert.True(t, ok)
+	assert.Equal(t, "fr", offer)
+
+	offer, ok = Language("en", "en-GB")
+	assert.True(t, ok)
+	assert.Equal(t, "en-GB", offer)
+
+	// "en" is not a prefix of "eng"
+	_, ok = Language("en", "eng")
+	assert.False(t, ok)
+
+	offer, ok = Language("de;q=0.9, *;q=0.1", "ja", "de")
+	assert.True(t, ok)
+	assert.Equal(t, "de", offer)
+}
+
+func TestCharset(t *testing.T) {
+	offer, ok := Charset("iso-8859-5, UTF-8;q=0.8", "utf-8", "iso-8859-5")
+	assert.True(t, ok)
+	assert.Equal(t, "iso-8859-5", offer)
+
+	offer, ok = Charset("*;q=0.5, utf-8;q=0", "utf-8", "utf-16")
+	assert.True(t, ok)
+	assert.Equal(t, "utf-16", offer)
+}
+
+func TestPick(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nAccept: application/json\r\n\r\n"))
+	require.NoError(t, err)
+
+	buffer := &bytes.Buffer{}
+	offer, ok := Pick(response.NewWriter(buffer), req, "text/html", "application/json")
+	assert.True(t, ok)
+	assert.Equal(t, "application/json", offer)
+	assert.Empty(t, buffer.String())
+
+	_, ok = Pick(response.NewWriter(buffer), req, "text/html")
+	assert.False(t, ok)
+
+	r, err := response.ResponseFromReader(buffer, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCode(response.NOT_ACCEPTABLE), r.StatusLine.StatusCode)
+	assert.Equal(t, "Not Acceptable", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, "Accept", r.Headers["vary"])
+	assert.Contains(t, string(r.Body), "text/html")
+}
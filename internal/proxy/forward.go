@@ -0,0 +1,307 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+)
+
+const KEY_PROXY_AUTHORIZATION = "Proxy-Authorization"
+const KEY_PROXY_AUTHENTICATE = "Proxy-Authenticate"
+
+const DEFAULT_PROXY_REALM = "proxy"
+
+type ForwardOptions struct {
+	/*
+		Allow lists the destinations clients may reach, as "host:port" patterns:
+		  - "example.com:443" that host on that port
+		  - "*.example.com:443" any subdomain of example.com
+		  - "example.com" or "example.com:*" any port
+		  - "*" anywhere, an open proxy
+		Nothing is allowed when it is empty.
+	*/
+	Allow []string
+
+	// Credentials are the user names and passwords accepted in Proxy-Authorization (Basic),
+	// no credentials means no authentication.
+	Credentials map[string]string
+	// Realm is announced in Proxy-Authenticate, empty means DEFAULT_PROXY_REALM.
+	Realm string
+
+	// DialTimeout bounds connecting to a destination, for a plain request or a CONNECT tunnel,
+	// zero means DEFAULT_DIAL_TIMEOUT.
+	DialTimeout time.Duration
+	// ResponseHeaderTimeout bounds waiting for the head of a response to a plain request,
+	// zero means DEFAULT_RESPONSE_HEADER_TIMEOUT.
+	ResponseHeaderTimeout time.Duration
+
+	// ErrorLog receives dial and tunnel errors, nil means the standard logger.
+	ErrorLog *log.Logger
+}
+
+/*
+ForwardProxy is a proxy clients configure explicitly. A plain HTTP request names its full URL
+(absolute-form, "GET http://example.com/ HTTP/1.1") and is forwarded like the reverse proxy does,
+bodies streamed both ways, anything else, HTTPS included, goes through a "CONNECT example.com:443" tunnel.
+*/
+type ForwardProxy struct {
+	options   ForwardOptions
+	transport transport
+	errorLog  *log.Logger
+}
+
+func NewForward(options ForwardOptions) *ForwardProxy {
+	proxy := &ForwardProxy{
+		options:   options,
+		transport: newTransport(options.DialTimeout, options.ResponseHeaderTimeout, nil),
+		errorLog:  options.ErrorLog,
+	}
+
+	if proxy.errorLog == nil {
+		proxy.errorLog = log.Default()
+	}
+
+	if proxy.options.Realm == "" {
+		proxy.options.Realm = DEFAULT_PROXY_REALM
+	}
+
+	if proxy.options.DialTimeout == 0 {
+		proxy.options.DialTimeout = DEFAULT_DIAL_TIMEOUT
+	}
+
+	return proxy
+}
+
+func (p *ForwardProxy) Handle(w *response.Writer, req *request.Request) {
+	if !p.authenticated(req) {
+		headers := response.GetDefaultHeaders(0)
+		headers.Override(KEY_PROXY_AUTHENTICATE, fmt.Sprintf("Basic realm=%q", p.options.Realm))
+
+		w.WriteStatusLine(response.PROXY_AUTHENTICATION_REQUIRED)
+		w.WriteHeaders(headers)
+		w.WriteBody(nil)
+		return
+	}
+
+	if req.RequestLine.Method == "CONNECT" {
+		p.tunnel(w, req)
+		return
+	}
+
+	p.forward(w, req)
+}
+
+func (p *ForwardProxy) forward(w *response.Writer, req *request.Request) {
+	target, err := url.Parse(req.RequestLine.RequestTarget)
+
+	if err != nil || !target.IsAbs() || target.Host == "" {
+		fail(w, req, response.BAD_REQUEST, "a proxy request needs an absolute URL\n")
+		return
+	}
+
+	// https goes through CONNECT, the proxy never sees those requests
+	if target.Scheme != "http" {
+		fail(w, req, response.BAD_REQUEST, "unsupported scheme "+target.Scheme+"\n")
+		return
+	}
+
+	port := target.Port()
+
+	if port == "" {
+		port = "80"
+	}
+
+	if !p.allowed(target.Hostname(), port) {
+		fail(w, req, response.FORBIDDEN, "destination not allowed\n")
+		return
+	}
+
+	outgoing := newOutgoing(req, target)
+	outgoing.headers.Add("Via", "1.1 httpfromtcp")
+
+	resp, err := p.transport.roundTrip(req.Context(), outgoing)
+
+	if err != nil {
+		p.errorLog.Printf("error: forwarding %s %s: %s", req.RequestLine.Method, target.Redacted(), err)
+
+		if isTimeout(err) {
+			fail(w, req, response.GATEWAY_TIMEOUT, "destination timed out\n")
+			return
+		}
+
+		fail(w, req, response.BAD_GATEWAY, "destination unreachable\n")
+		return
+	}
+	defer resp.Close()
+
+	if err := respond(w, resp.Response, req.RequestLine.Method == "HEAD"); err != nil {
+		p.errorLog.Printf("error: streaming response from %s: %s", target.Host, err)
+	}
+}
+
+/*
+According to RFC9110 9.3.6, CONNECT asks for a TCP tunnel to the "host:port" target. Once
+the proxy answers 2xx, the connection carries raw bytes both ways until either side closes.
+*/
+func (p *ForwardProxy) tunnel(w *response.Writer, req *request.Request) {
+	host, port, err := net.SplitHostPort(req.RequestLine.RequestTarget)
+
+	if err != nil || host == "" {
+		fail(w, req, response.BAD_REQUEST, "CONNECT needs a host:port target\n")
+		return
+	}
+
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		fail(w, req, response.BAD_REQUEST, "malformed port "+port+"\n")
+		return
+	}
+
+	if !p.allowed(host, port) {
+		fail(w, req, response.FORBIDDEN, "destination not allowed\n")
+		return
+	}
+
+	destination, err := net.DialTimeout("tcp", req.RequestLine.RequestTarget, p.options.DialTimeout)
+
+	if err != nil {
+		p.errorLog.Printf("error: connecting to %s: %s", req.RequestLine.RequestTarget, err)
+		fail(w, req, response.BAD_GATEWAY, "destination unreachable\n")
+		return
+	}
+	defer destination.Close()
+
+	conn, reader, err := w.Hijack()
+
+	if err != nil {
+		// an HTTP/2 stream cannot become a tunnel this way
+		fail(w, req, response.BAD_REQUEST, "CONNECT is only supported over HTTP/1.1\n")
+		return
+	}
+
+	// the tunnel lives as long as its peers, the deadlines of a request no longer apply
+	conn.SetDeadline(time.Time{})
+
+	// According to RFC9110 9.3.6, a 2xx answer to CONNECT has neither Content-Length nor Transfer-Encoding
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return
+	}
+
+	splice(conn, reader, destination)
+}
+
+/*
+splice copies both ways until both directions are done. When one side stops sending, the
+other only learns it through a half close, so a request/response protocol can still finish.
+*/
+func splice(client net.Conn, clientReader io.Reader, destination net.Conn) {
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		io.Copy(destination, clientReader)
+		closeWrite(destination)
+	}()
+
+	go func() {
+		defer wg.Done()
+		io.Copy(client, destination)
+		closeWrite(client)
+	}()
+
+	wg.Wait()
+}
+
+func closeWrite(conn net.Conn) {
+	if halfCloser, ok := conn.(interface{ CloseWrite() error }); ok {
+		halfCloser.CloseWrite()
+		return
+	}
+
+	conn.Close()
+}
+
+// authenticated compares in constant time, so a wrong password takes as long to reject as a right one to accept.
+func (p *ForwardProxy) authenticated(req *request.Request) bool {
+	if len(p.options.Credentials) == 0 {
+		return true
+	}
+
+	authorization, ok := req.Headers.Get(KEY_PROXY_AUTHORIZATION)
+
+	if !ok {
+		return false
+	}
+
+	scheme, encoded, ok := strings.Cut(strings.TrimSpace(authorization), " ")
+
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+
+	if err != nil {
+		return false
+	}
+
+	user, password, ok := strings.Cut(string(decoded), ":")
+
+	if !ok {
+		return false
+	}
+
+	expected, ok := p.options.Credentials[user]
+
+	if !ok {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+}
+
+func (p *ForwardProxy) allowed(host, port string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, pattern := range p.options.Allow {
+		if pattern == "*" {
+			return true
+		}
+
+		patternHost, patternPort, err := net.SplitHostPort(pattern)
+
+		if err != nil {
+			patternHost, patternPort = pattern, "*"
+		}
+
+		if patternPort != "*" && patternPort != port {
+			continue
+		}
+
+		patternHost = strings.ToLower(patternHost)
+
+		if suffix, ok := strings.CutPrefix(patternHost, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+
+		if patternHost == host {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startForwardProxy(t *testing.T, options ForwardOptions) string {
+	options.ErrorLog = discardLog()
+
+	s, err := server.ServeWithOptions(NewForward(options).Handle, server.Options{Addr: "127.0.0.1:0"})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	return s.Addr().String()
+}
+
+// startEcho accepts one connection and writes back whatever it reads
+func startEcho(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		io.Copy(conn, conn)
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestForwardsAbsoluteFormRequests(t *testing.T) {
+	upstream := strings.TrimPrefix(startUpstream(t, upstreamHandler("a", &atomic.Int32{})), "http://")
+	addr := startForwardProxy(t, ForwardOptions{Allow: []string{upstream}})
+
+	out := roundTrip(t, addr, "GET http://"+upstream+"/path?q=1 HTTP/1.1\r\nHost: "+upstream+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "a GET /path?q=1\n")
+
+	out = roundTrip(t, addr, "GET /path HTTP/1.1\r\nHost: "+upstream+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request"))
+}
+
+func TestForwardProxyAuthenticationAndAllowlist(t *testing.T) {
+	upstream := strings.TrimPrefix(startUpstream(t, upstreamHandler("a", &atomic.Int32{})), "http://")
+	addr := startForwardProxy(t, ForwardOptions{
+		Allow:       []string{upstream},
+		Credentials: map[string]string{"user": "secret"},
+	})
+
+	request := "GET http://" + upstream + "/ HTTP/1.1\r\nHost: " + upstream + "\r\n"
+	credentials := func(userPassword string) string {
+		return "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(userPassword)) + "\r\n"
+	}
+
+	out := roundTrip(t, addr, request+"\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 407 Proxy Authentication Required"))
+	assert.Contains(t, out, `proxy-authenticate: Basic realm="proxy"`)
+
+	out = roundTrip(t, addr, request+credentials("user:wrong")+"\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 407 Proxy Authentication Required"))
+
+	out = roundTrip(t, addr, request+credentials("user:secret")+"\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"))
+
+	out = roundTrip(t, addr, "GET http://127.0.0.1:1/ HTTP/1.1\r\nHost: 127.0.0.1:1\r\n"+credentials("user:secret")+"\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden"))
+}
+
+func TestConnectTunnel(t *testing.T) {
+	echo := startEcho(t)
+	addr := startForwardProxy(t, ForwardOptions{Allow: []string{echo}})
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// bytes pipelined right after the request must reach the destination too
+	_, err = io.WriteString(conn, "CONNECT "+echo+" HTTP/1.1\r\nHost: "+echo+"\r\n\r\nping")
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	statusLine, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 Connection Established\r\n", statusLine)
+
+	blank, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", blank)
+
+	_, err = io.WriteString(conn, " pong")
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "ping pong", string(rest))
+}
+
+func TestConnectRejectsDisallowedDestinations(t *testing.T) {
+	echo := startEcho(t)
+	addr := startForwardProxy(t, ForwardOptions{Allow: []string{"example.com:443"}})
+
+	out := roundTrip(t, addr, "CONNECT "+echo+" HTTP/1.1\r\nHost: "+echo+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden"))
+
+	out = roundTrip(t, addr, "CONNECT example.com HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request"))
+}
+
+func TestAllowPatterns(t *testing.T) {
+	proxy := NewForward(ForwardOptions{Allow: []string{"example.com:443", "*.example.org:*", "Internal.Host"}})
+
+	cases := []struct {
+		host    string
+		port    string
+		allowed bool
+	}{
+		{"example.com", "443", true},
+		{"example.com", "80", false},
+		{"sub.example.com", "443", false},
+		{"api.example.org", "8080", true},
+		{"example.org", "443", false},
+		{"internal.host", "22", true},
+		{"internal.host.", "22", true},
+		{"evil.com", "443", false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.allowed, proxy.allowed(c.host, c.port), c.host+":"+c.port)
+	}
+
+	assert.True(t, NewForward(ForwardOptions{Allow: []string{"*"}}).allowed("anything", "1"))
+	assert.False(t, NewForward(ForwardOptions{}).allowed("anything", "1"))
+}
+
+func TestForwardStreamsBodiesBothWays(t *testing.T) {
+	echo := strings.TrimPrefix(startUpstream(t, upstreamHandler("a", &atomic.Int32{})), "http://")
+	streaming := strings.TrimPrefix(startUpstream(t, streamingHandler), "http://")
+	addr := startForwardProxy(t, ForwardOptions{Allow: []string{echo, streaming}})
+
+	out := roundTrip(t, addr, "POST http://"+echo+"/ HTTP/1.1\r\nHost: "+echo+"\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n")
+	assert.Contains(t, out, "body=hello world")
+
+	out = roundTrip(t, addr, "GET http://"+streaming+"/ HTTP/1.1\r\nHost: "+streaming+"\r\n\r\n")
+	assert.Contains(t, out, "transfer-encoding: chunked")
+	assert.True(t, strings.HasSuffix(out, "\r\n0\r\nx-checksum: abc\r\n\r\n"), out)
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/sithusan/httpfromtcp/internal/request"
+)
+
+const KEY_X_FORWARDED_FOR = "X-Forwarded-For"
+const KEY_X_FORWARDED_HOST = "X-Forwarded-Host"
+const KEY_X_FORWARDED_PROTO = "X-Forwarded-Proto"
+const KEY_FORWARDED = "Forwarded"
+
+/*
+According to RFC9110 7.6.1, these fields only concern the connection they arrived on,
+a proxy must not forward them, nor any field the Connection header lists.
+*/
+var hopByHopHeaders = map[string]struct{}{
+	"connection":          {},
+	"keep-alive":          {},
+	"proxy-connection":    {},
+	"proxy-authenticate":  {},
+	"proxy-authorization": {},
+	"te":                  {},
+	"trailer":             {},
+	"transfer-encoding":   {},
+	"upgrade":             {},
+}
+
+// removeHopByHop calls keep with every end-to-end field line of h, keys are lower case.
+func removeHopByHop(h headers.Headers, keep func(key, value string)) {
+	listed := map[string]struct{}{}
+
+	if connection, ok := h.Get("Connection"); ok {
+		for _, name := range strings.Split(connection, ",") {
+			listed[strings.ToLower(strings.TrimSpace(name))] = struct{}{}
+		}
+	}
+
+	h.Each(func(key, value string) {
+		if _, ok := hopByHopHeaders[key]; ok {
+			return
+		}
+
+		if _, ok := listed[key]; ok {
+			return
+		}
+
+		keep(key, value)
+	})
+}
+
+/*
+setForwarded tells the upstream who the client is, both the de facto X-Forwarded-* fields
+and the standard Forwarded of RFC7239:
+
+	X-Forwarded-For: 203.0.113.7
+	Forwarded: for=203.0.113.7;host=example.com;proto=https
+
+A chain of proxies appends to For and Forwarded, so the first entry is the original client.
+*/
+func setForwarded(h headers.Headers, req *request.Request, host string) {
+	proto := "http"
+
+	if req.TLS != nil {
+		proto = "https"
+	}
+
+	clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+
+	if err != nil {
+		clientIP = req.RemoteAddr
+	}
+
+	if clientIP != "" {
+		appendField(h, KEY_X_FORWARDED_FOR, clientIP)
+	}
+
+	if host != "" {
+		h.Override(KEY_X_FORWARDED_HOST, host)
+	}
+
+	h.Override(KEY_X_FORWARDED_PROTO, proto)
+
+	element := []string{}
+
+	if clientIP != "" {
+		forwardedFor := clientIP
+
+		// According to RFC7239 6, an IPv6 address is bracketed, and so quoted
+		if strings.Contains(clientIP, ":") {
+			forwardedFor = "[" + clientIP + "]"
+		}
+
+		element = append(element, "for="+quoteIfNeeded(forwardedFor))
+	}
+
+	if host != "" {
+		element = append(element, "host="+quoteIfNeeded(host))
+	}
+
+	element = append(element, "proto="+proto)
+
+	appendField(h, KEY_FORWARDED, strings.Join(element, ";"))
+}
+
+func appendField(h headers.Headers, key, value string) {
+	if existing, _ := h.Get(key); existing != "" {
+		value = existing + ", " + value
+	}
+
+	h.Override(key, value)
+}
+
+// Forwarded values are tokens, anything else (like "host:port") must be a quoted string.
+func quoteIfNeeded(value string) string {
+	for _, char := range value {
+		if !isTokenChar(char) {
+			return "\"" + strings.ReplaceAll(strings.ReplaceAll(value, "\\", "\\\\"), "\"", "\\\"") + "\""
+		}
+	}
+
+	return value
+}
+
+func isTokenChar(char rune) bool {
+	if char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' {
+		return true
+	}
+
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", char)
+}
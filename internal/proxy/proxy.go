@@ -0,0 +1,338 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/sithusan/httpfromtcp/internal/server"
+)
+
+type Options struct {
+	// Upstreams are base URLs like "http://10.0.0.1:8080", their path prefixes every request target.
+	Upstreams []string
+	// DialTimeout bounds connecting to an upstream, TLS handshake included, zero means DEFAULT_DIAL_TIMEOUT.
+	DialTimeout time.Duration
+	// ResponseHeaderTimeout bounds waiting for the head of a response once the request is sent,
+	// zero means DEFAULT_RESPONSE_HEADER_TIMEOUT.
+	ResponseHeaderTimeout time.Duration
+	// TLSConfig is used for https upstreams, nil means the system roots.
+	TLSConfig *tls.Config
+
+	// HealthCheckPath is requested on every upstream each HealthCheckInterval, anything but
+	// a 2xx or 3xx takes the upstream out of the rotation until it answers again.
+	// Empty disables health checks, every upstream is then always used.
+	HealthCheckPath string
+	// HealthCheckInterval zero means DEFAULT_HEALTH_CHECK_INTERVAL.
+	HealthCheckInterval time.Duration
+
+	// PreserveHost forwards the Host the client asked for instead of the host of the upstream.
+	PreserveHost bool
+
+	// ErrorLog receives upstream errors and health changes, nil means the standard logger.
+	ErrorLog *log.Logger
+}
+
+/*
+ReverseProxy forwards requests to its upstreams in round-robin and streams their responses
+back. Handle is the server.Handler, Close stops the health checks.
+*/
+type ReverseProxy struct {
+	upstreams []*upstream
+	counter   atomic.Uint64
+	transport transport
+	options   Options
+	errorLog  *log.Logger
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func New(options Options) (*ReverseProxy, error) {
+	if len(options.Upstreams) == 0 {
+		return nil, fmt.Errorf("reverse proxy needs at least one upstream")
+	}
+
+	upstreams := []*upstream{}
+
+	for _, rawURL := range options.Upstreams {
+		upstream, err := newUpstream(rawURL)
+
+		if err != nil {
+			return nil, err
+		}
+
+		upstreams = append(upstreams, upstream)
+	}
+
+	proxy := &ReverseProxy{
+		upstreams: upstreams,
+		transport: newTransport(options.DialTimeout, options.ResponseHeaderTimeout, options.TLSConfig),
+		options:   options,
+		errorLog:  options.ErrorLog,
+		done:      make(chan struct{}),
+	}
+
+	if proxy.errorLog == nil {
+		proxy.errorLog = log.Default()
+	}
+
+	if options.HealthCheckPath != "" {
+		if options.HealthCheckInterval == 0 {
+			proxy.options.HealthCheckInterval = DEFAULT_HEALTH_CHECK_INTERVAL
+		}
+
+		go proxy.checkHealth()
+	}
+
+	return proxy, nil
+}
+
+func (p *ReverseProxy) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+
+	return nil
+}
+
+func (p *ReverseProxy) Handle(w *response.Writer, req *request.Request) {
+	upstream := p.next()
+
+	if upstream == nil {
+		fail(w, req, response.SERVICE_UNAVAILABLE, "no healthy upstream\n")
+		return
+	}
+
+	outgoing, err := p.outgoing(req, upstream)
+
+	if err != nil {
+		fail(w, req, response.BAD_REQUEST, err.Error()+"\n")
+		return
+	}
+
+	resp, err := p.transport.roundTrip(req.Context(), outgoing)
+
+	if err != nil {
+		p.errorLog.Printf("error: proxying %s %s to %s: %s", req.RequestLine.Method, req.RequestLine.RequestTarget, upstream.url.Host, err)
+
+		if isTimeout(err) {
+			fail(w, req, response.GATEWAY_TIMEOUT, "upstream timed out\n")
+			return
+		}
+
+		fail(w, req, response.BAD_GATEWAY, "upstream unreachable\n")
+		return
+	}
+	defer resp.Close()
+
+	if err := respond(w, resp.Response, req.RequestLine.Method == "HEAD"); err != nil {
+		// the status line is gone already, the client only sees a cut body
+		p.errorLog.Printf("error: streaming response from %s: %s", upstream.url.Host, err)
+	}
+}
+
+// outgoing builds the upstream request: the target joined to the upstream URL, end-to-end headers and forwarding headers.
+func (p *ReverseProxy) outgoing(req *request.Request, upstream *upstream) (*outgoing, error) {
+	target, err := url.ParseRequestURI(req.RequestLine.RequestTarget)
+
+	if err != nil {
+		return nil, fmt.Errorf("malformed request target: %s", req.RequestLine.RequestTarget)
+	}
+
+	outURL := *upstream.url
+	outURL.Path = joinPath(upstream.url.Path, target.Path)
+	outURL.RawPath = ""
+	outURL.RawQuery = joinQuery(upstream.url.RawQuery, target.RawQuery)
+
+	outgoing := newOutgoing(req, &outURL)
+	host, _ := req.Headers.Get("Host")
+
+	if p.options.PreserveHost && host != "" {
+		outgoing.host = host
+	}
+
+	setForwarded(outgoing.headers, req, host)
+
+	return outgoing, nil
+}
+
+/*
+newOutgoing is req sent to outURL with its end-to-end headers. The body streams from the
+client as it arrives: with its length when it is known, chunked otherwise.
+*/
+func newOutgoing(req *request.Request, outURL *url.URL) *outgoing {
+	out := &outgoing{
+		method:  req.RequestLine.Method,
+		url:     outURL,
+		host:    outURL.Host,
+		headers: headers.NewHeaders(),
+		trailers: func() headers.Headers {
+			return req.Trailers
+		},
+	}
+
+	removeHopByHop(req.Headers, func(key, value string) {
+		// the framing of the body is decided again for the upstream connection
+		if key == "host" || key == "content-length" {
+			return
+		}
+
+		out.headers.Add(key, value)
+	})
+
+	out.body, out.length = requestBody(req)
+
+	return out
+}
+
+/*
+respond copies the upstream status and end-to-end headers, then streams the body: with its
+Content-Length when it is known, chunked otherwise, so a long upstream response is never
+held in memory. Upstream trailers are announced with Trailer and sent after the last chunk.
+*/
+func respond(w *response.Writer, resp *response.Response, head bool) error {
+	h := headers.NewHeaders()
+
+	removeHopByHop(resp.Headers, func(key, value string) {
+		h.Add(key, value)
+	})
+
+	h.Override("Connection", "close")
+	statusCode := resp.StatusLine.StatusCode
+
+	if head || !response.BodyAllowed(statusCode) {
+		w.WriteStatusLine(statusCode)
+		w.WriteHeaders(h)
+		_, err := w.WriteBody(nil)
+		return err
+	}
+
+	body := resp.BodyReader()
+	_, chunked := resp.Headers.Get("Transfer-Encoding")
+	contentLength, known := resp.Headers.Get("Content-Length")
+
+	if known && !chunked {
+		h.Override("Content-Length", contentLength)
+
+		w.WriteStatusLine(statusCode)
+		w.WriteHeaders(h)
+		_, err := w.WriteBodyFrom(body)
+		return err
+	}
+
+	h.Remove("Content-Length")
+	h.Override("Transfer-Encoding", "chunked")
+
+	if trailer, ok := resp.Headers.Get("Trailer"); ok {
+		h.Override("Trailer", trailer)
+	}
+
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+
+	buffer := make([]byte, response.BODY_CHUNK_SIZE)
+
+	for {
+		n, err := body.Read(buffer)
+
+		if n > 0 {
+			if _, writeErr := w.WriteChunkedBody(buffer[:n]); writeErr != nil {
+				return writeErr
+			}
+
+			// a streaming upstream (events, long polls) must not wait for the buffer to fill
+			w.Flush()
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// the trailers are only known once the body has been read to the end
+	if resp.Trailers != nil {
+		return w.WriteTrailers(resp.Trailers)
+	}
+
+	_, err := w.WriteChunkedBodyDone()
+
+	return err
+}
+
+/**
+* Helpers
+**/
+
+func newTransport(dialTimeout, responseHeaderTimeout time.Duration, tlsConfig *tls.Config) transport {
+	if dialTimeout == 0 {
+		dialTimeout = DEFAULT_DIAL_TIMEOUT
+	}
+
+	if responseHeaderTimeout == 0 {
+		responseHeaderTimeout = DEFAULT_RESPONSE_HEADER_TIMEOUT
+	}
+
+	return transport{
+		dialTimeout:           dialTimeout,
+		responseHeaderTimeout: responseHeaderTimeout,
+		tlsConfig:             tlsConfig,
+	}
+}
+
+func fail(w *response.Writer, req *request.Request, statusCode response.StatusCode, message string) {
+	server.HandleError{
+		StatusCode: statusCode,
+		Message:    []byte(message),
+	}.RespondTo(w, req)
+}
+
+// requestBody is the body to send upstream and its length, see request.ContentLength. A request without a body has none.
+func requestBody(req *request.Request) (io.Reader, int64) {
+	length := req.ContentLength()
+	method := req.RequestLine.Method
+
+	if length == 0 && method != "POST" && method != "PUT" && method != "PATCH" {
+		return nil, 0
+	}
+
+	return req.BodyReader(), length
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+func joinPath(base, path string) string {
+	if base == "" || base == "/" {
+		return path
+	}
+
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+func joinQuery(base, query string) string {
+	if base == "" || query == "" {
+		return base + query
+	}
+
+	return base + "&" + query
+}
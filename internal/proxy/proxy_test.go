@@ -0,0 +1,326 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/sithusan/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upstreamHandler reports what reached the upstream, health answers with *healthStatus
+func upstreamHandler(name string, healthStatus *atomic.Int32) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/health" {
+			w.WriteStatusLine(response.StatusCode(healthStatus.Load()))
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+			w.WriteBody(nil)
+			return
+		}
+
+		host, _ := req.Headers.Get("Host")
+		forwardedFor, _ := req.Headers.Get("X-Forwarded-For")
+		forwarded, _ := req.Headers.Get("Forwarded")
+		_, secret := req.Headers.Get("X-Secret")
+
+		body := fmt.Sprintf("%s %s %s\nhost=%s\nxff=%s\nforwarded=%s\nsecret=%t\nbody=%s",
+			name, req.RequestLine.Method, req.RequestLine.RequestTarget, host, forwardedFor, forwarded, secret, req.Body)
+
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+}
+
+func streamingHandler(w *response.Writer, req *request.Request) {
+	h := response.GetDefaultHeaders(0)
+	h.Remove("Content-Length")
+	h.Override("Transfer-Encoding", "chunked")
+	h.Override("Trailer", "X-Checksum")
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(h)
+	w.WriteChunkedBody([]byte("first "))
+	w.WriteChunkedBody([]byte("second"))
+
+	trailers := headers.NewHeaders()
+	trailers.Override("X-Checksum", "abc")
+	w.WriteTrailers(trailers)
+}
+
+func startUpstream(t *testing.T, handler server.Handler) string {
+	s, err := server.ServeWithOptions(handler, server.Options{Addr: "127.0.0.1:0"})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	return "http://" + s.Addr().String()
+}
+
+func startProxy(t *testing.T, options Options) string {
+	proxy, err := New(options)
+	require.NoError(t, err)
+	t.Cleanup(func() { proxy.Close() })
+
+	s, err := server.ServeWithOptions(proxy.Handle, server.Options{Addr: "127.0.0.1:0"})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	return s.Addr().String()
+}
+
+func roundTrip(t *testing.T, addr, raw string) string {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	return string(out)
+}
+
+func get(t *testing.T, addr, target string) string {
+	return roundTrip(t, addr, "GET "+target+" HTTP/1.1\r\nHost: example.com\r\n\r\n")
+}
+
+func TestForwardsRequestWithRewrittenHeaders(t *testing.T) {
+	health := &atomic.Int32{}
+	upstream := startUpstream(t, upstreamHandler("a", health))
+	addr := startProxy(t, Options{Upstreams: []string{upstream + "/api"}})
+
+	out := roundTrip(t, addr, "POST /users?page=2 HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"X-Forwarded-For: 198.51.100.1\r\n"+
+		"Connection: X-Secret\r\n"+
+		"X-Secret: 1\r\n"+
+		"Content-Length: 5\r\n"+
+		"\r\n"+
+		"hello")
+
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "a POST /api/users?page=2\n")
+	assert.Contains(t, out, "host="+strings.TrimPrefix(upstream, "http://")+"\n")
+	assert.Contains(t, out, "xff=198.51.100.1, 127.0.0.1\n")
+	assert.Contains(t, out, "forwarded=for=127.0.0.1;host=example.com;proto=http\n")
+	assert.Contains(t, out, "secret=false\n")
+	assert.Contains(t, out, "body=hello")
+}
+
+func TestPreservesHost(t *testing.T) {
+	upstream := startUpstream(t, upstreamHandler("a", &atomic.Int32{}))
+	addr := startProxy(t, Options{Upstreams: []string{upstream}, PreserveHost: true})
+
+	assert.Contains(t, get(t, addr, "/"), "host=example.com\n")
+}
+
+func TestStreamsChunkedBodiesBothWays(t *testing.T) {
+	echo := startUpstream(t, upstreamHandler("a", &atomic.Int32{}))
+	addr := startProxy(t, Options{Upstreams: []string{echo}})
+
+	out := roundTrip(t, addr, "POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n")
+	assert.Contains(t, out, "body=hello world")
+
+	streaming := startUpstream(t, streamingHandler)
+	addr = startProxy(t, Options{Upstreams: []string{streaming}})
+
+	out = get(t, addr, "/")
+	assert.Contains(t, out, "transfer-encoding: chunked")
+	assert.Contains(t, out, "trailer: X-Checksum")
+	assert.True(t, strings.HasSuffix(out, "\r\n0\r\nx-checksum: abc\r\n\r\n"), out)
+
+	decoded, err := response.ResponseFromReader(strings.NewReader(out), "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "first second", string(decoded.Body))
+	assert.Equal(t, "abc", decoded.Trailers["x-checksum"])
+}
+
+func TestKeepsSetCookieFieldsApart(t *testing.T) {
+	upstream := startUpstream(t, func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Add("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT")
+		h.Add("Set-Cookie", "b=2")
+
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteBody(nil)
+	})
+	addr := startProxy(t, Options{Upstreams: []string{upstream}})
+
+	decoded, err := response.ResponseFromReader(strings.NewReader(get(t, addr, "/")), "GET")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2"}, decoded.Headers.Values("Set-Cookie"))
+}
+
+func TestRoundRobinSkipsUnhealthyUpstreams(t *testing.T) {
+	healthA := &atomic.Int32{}
+	healthB := &atomic.Int32{}
+	healthA.Store(200)
+	healthB.Store(200)
+
+	a := startUpstream(t, upstreamHandler("a", healthA))
+	b := startUpstream(t, upstreamHandler("b", healthB))
+	addr := startProxy(t, Options{
+		Upstreams:           []string{a, b},
+		HealthCheckPath:     "/health",
+		HealthCheckInterval: 20 * time.Millisecond,
+		ErrorLog:            discardLog(),
+	})
+
+	names := []string{}
+
+	for range 4 {
+		out := get(t, addr, "/")
+		_, body, _ := strings.Cut(out, "\r\n\r\n")
+		names = append(names, body[:1])
+	}
+
+	assert.Equal(t, []string{"a", "b", "a", "b"}, names)
+
+	healthB.Store(503)
+
+	require.Eventually(t, func() bool {
+		for range 4 {
+			if strings.Contains(get(t, addr, "/"), "b GET") {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 50*time.Millisecond)
+
+	healthA.Store(500)
+
+	require.Eventually(t, func() bool {
+		return strings.HasPrefix(get(t, addr, "/"), "HTTP/1.1 503 Service Unavailable")
+	}, 2*time.Second, 50*time.Millisecond)
+
+	healthB.Store(200)
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(get(t, addr, "/"), "b GET")
+	}, 2*time.Second, 50*time.Millisecond)
+}
+
+func TestUnreachableUpstreamIsBadGateway(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unused := listener.Addr().String()
+	listener.Close()
+
+	addr := startProxy(t, Options{Upstreams: []string{"http://" + unused}, ErrorLog: discardLog()})
+
+	assert.True(t, strings.HasPrefix(get(t, addr, "/"), "HTTP/1.1 502 Bad Gateway"))
+}
+
+func TestNewRejectsBadUpstreams(t *testing.T) {
+	_, err := New(Options{})
+	require.Error(t, err)
+
+	_, err = New(Options{Upstreams: []string{"localhost:8080"}})
+	require.Error(t, err)
+}
+
+func discardLog() *log.Logger {
+	return log.New(io.Discard, "", 0)
+}
+
+func TestStreamsRequestBodyAsItArrives(t *testing.T) {
+	received := make(chan string, 1)
+
+	upstream, err := server.ServeWithOptions(func(w *response.Writer, req *request.Request) {
+		buffer := make([]byte, 5)
+		_, err := io.ReadFull(req.BodyReader(), buffer)
+		require.NoError(t, err)
+		received <- string(buffer)
+
+		rest, err := io.ReadAll(req.BodyReader())
+		require.NoError(t, err)
+
+		body := string(buffer) + string(rest)
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}, server.Options{Addr: "127.0.0.1:0", StreamBody: func(req *request.Request) bool { return true }})
+	require.NoError(t, err)
+	t.Cleanup(func() { upstream.Close() })
+
+	proxy, err := New(Options{Upstreams: []string{"http://" + upstream.Addr().String()}})
+	require.NoError(t, err)
+	t.Cleanup(func() { proxy.Close() })
+
+	s, err := server.ServeWithOptions(proxy.Handle, server.Options{Addr: "127.0.0.1:0", StreamBody: func(req *request.Request) bool { return true }})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n"))
+	require.NoError(t, err)
+
+	// the upstream reads the first chunk while the client still holds the rest
+	select {
+	case first := <-received:
+		assert.Equal(t, "hello", first)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the body did not reach the upstream before its end")
+	}
+
+	_, err = conn.Write([]byte("6\r\n world\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(out), "hello world"), string(out))
+}
+
+func TestCancelsUpstreamWhenClientLeaves(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+
+	upstream := startUpstream(t, func(w *response.Writer, req *request.Request) {
+		close(started)
+
+		select {
+		case <-req.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	})
+	addr := startProxy(t, Options{Upstreams: []string{upstream}, ErrorLog: discardLog()})
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+
+	<-started
+	conn.Close()
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the upstream request kept running after the client left")
+	}
+}
@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/sithusan/httpfromtcp/internal/response"
+)
+
+const DEFAULT_DIAL_TIMEOUT = 10 * time.Second
+const DEFAULT_RESPONSE_HEADER_TIMEOUT = 30 * time.Second
+
+// MAX_RESPONSE_HEADER_BYTES bounds the status line and headers of an upstream response.
+const MAX_RESPONSE_HEADER_BYTES = 1 << 20
+
+/*
+transport sends a request upstream on a connection of its own and reads the answer with the
+response parser, the body left on the connection so it streams through to the client. The
+connection is never reused, "Connection: close" tells the upstream so.
+*/
+type transport struct {
+	dialTimeout           time.Duration
+	responseHeaderTimeout time.Duration
+	tlsConfig             *tls.Config
+}
+
+// outgoing is a request on its way upstream.
+type outgoing struct {
+	method  string
+	url     *url.URL
+	host    string
+	headers headers.Headers
+	// body is nil when there is none, a length of -1 sends it chunked
+	body   io.Reader
+	length int64
+	// trailers are sent after a chunked body, asked for once it has been read
+	trailers func() headers.Headers
+}
+
+// upstreamResponse is a response whose body is still on conn, Close releases the connection.
+type upstreamResponse struct {
+	*response.Response
+	conn net.Conn
+	stop func() bool
+}
+
+func (r *upstreamResponse) Close() error {
+	r.stop()
+
+	return r.conn.Close()
+}
+
+/*
+roundTrip sends out and reads the head of the response. Once ctx is done, like when the client
+went away, the connection is closed, which stops whatever is still written or read on it.
+*/
+func (t transport) roundTrip(ctx context.Context, out *outgoing) (*upstreamResponse, error) {
+	conn, err := t.dial(ctx, out.url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+
+	fail := func(err error) (*upstreamResponse, error) {
+		stop()
+		conn.Close()
+
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %w", ctx.Err(), err)
+		}
+
+		return nil, err
+	}
+
+	if err := out.write(conn); err != nil {
+		return fail(err)
+	}
+
+	if t.responseHeaderTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(t.responseHeaderTimeout))
+	}
+
+	resp, err := response.ResponseHeadFromReader(bufio.NewReader(conn), out.method, response.Limits{
+		MaxHeaderBytes: MAX_RESPONSE_HEADER_BYTES,
+	})
+
+	if err != nil {
+		return fail(err)
+	}
+
+	// the body may take as long as it takes, like a stream of events
+	conn.SetReadDeadline(time.Time{})
+
+	return &upstreamResponse{Response: resp, conn: conn, stop: stop}, nil
+}
+
+func (t transport) dial(ctx context.Context, target *url.URL) (net.Conn, error) {
+	if t.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.dialTimeout)
+		defer cancel()
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", hostPort(target))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if target.Scheme != "https" {
+		return conn, nil
+	}
+
+	config := &tls.Config{}
+
+	if t.tlsConfig != nil {
+		config = t.tlsConfig.Clone()
+	}
+
+	if config.ServerName == "" {
+		config.ServerName = target.Hostname()
+	}
+
+	tlsConn := tls.Client(conn, config)
+
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+/*
+write sends the request in origin-form. A body of unknown length goes chunked, each chunk as
+soon as it is read, followed by the trailers the client sent:
+
+	POST /path?query HTTP/1.1
+	host: example.com
+	connection: close
+	transfer-encoding: chunked
+
+	5
+	hello
+	0
+*/
+func (o *outgoing) write(w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+	h := headers.NewHeaders()
+
+	o.headers.Each(func(key, value string) {
+		h.Add(key, value)
+	})
+
+	h.Override("Host", o.host)
+	h.Override("Connection", "close")
+
+	switch {
+	case o.body == nil:
+	case o.length >= 0:
+		h.Override("Content-Length", strconv.FormatInt(o.length, 10))
+	default:
+		h.Override("Transfer-Encoding", "chunked")
+	}
+
+	fmt.Fprintf(buffered, "%s %s HTTP/1.1\r\n", o.method, o.url.RequestURI())
+
+	h.Each(func(key, value string) {
+		fmt.Fprintf(buffered, "%s: %s\r\n", key, value)
+	})
+
+	buffered.WriteString("\r\n")
+
+	if o.body == nil {
+		return buffered.Flush()
+	}
+
+	if o.length >= 0 {
+		if _, err := io.Copy(buffered, o.body); err != nil {
+			return err
+		}
+
+		return buffered.Flush()
+	}
+
+	buffer := make([]byte, response.BODY_CHUNK_SIZE)
+
+	for {
+		n, err := o.body.Read(buffer)
+
+		if n > 0 {
+			fmt.Fprintf(buffered, "%x\r\n", n)
+			buffered.Write(buffer[:n])
+			buffered.WriteString("\r\n")
+
+			// the upstream sees the body at the pace the client sends it
+			if flushErr := buffered.Flush(); flushErr != nil {
+				return flushErr
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	buffered.WriteString("0\r\n")
+
+	if o.trailers != nil {
+		o.trailers().Each(func(key, value string) {
+			fmt.Fprintf(buffered, "%s: %s\r\n", key, value)
+		})
+	}
+
+	buffered.WriteString("\r\n")
+
+	return buffered.Flush()
+}
+
+/**
+* Helpers
+**/
+
+// hostPort is the host of target with its port, the default of the scheme when it has none.
+func hostPort(target *url.URL) string {
+	port := target.Port()
+
+	if port == "" {
+		port = "80"
+
+		if target.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	return net.JoinHostPort(target.Hostname(), port)
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+)
+
+const DEFAULT_HEALTH_CHECK_INTERVAL = 10 * time.Second
+
+// MAX_HEALTH_CHECK_TIMEOUT bounds a single check, a shorter interval bounds it further.
+const MAX_HEALTH_CHECK_TIMEOUT = 5 * time.Second
+
+type upstream struct {
+	url     *url.URL
+	healthy atomic.Bool
+}
+
+func newUpstream(rawURL string) (*upstream, error) {
+	parsed, err := url.Parse(rawURL)
+
+	if err != nil {
+		return nil, fmt.Errorf("malformed upstream %q: %s", rawURL, err)
+	}
+
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("upstream %q must be an absolute http or https URL", rawURL)
+	}
+
+	upstream := &upstream{url: parsed}
+	// an upstream is trusted until a health check says otherwise
+	upstream.healthy.Store(true)
+
+	return upstream, nil
+}
+
+// next picks upstreams in turn, skipping the unhealthy ones, nil when none is left.
+func (p *ReverseProxy) next() *upstream {
+	start := p.counter.Add(1) - 1
+
+	for i := range len(p.upstreams) {
+		upstream := p.upstreams[(start+uint64(i))%uint64(len(p.upstreams))]
+
+		if upstream.healthy.Load() {
+			return upstream
+		}
+	}
+
+	return nil
+}
+
+func (p *ReverseProxy) checkHealth() {
+	ticker := time.NewTicker(p.options.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		p.checkAll()
+
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkAll checks every upstream at once, so one slow upstream does not delay the verdict on the others.
+func (p *ReverseProxy) checkAll() {
+	timeout := min(p.options.HealthCheckInterval, MAX_HEALTH_CHECK_TIMEOUT)
+	wg := sync.WaitGroup{}
+
+	for _, upstream := range p.upstreams {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			err := p.check(upstream, timeout)
+			healthy := err == nil
+
+			if upstream.healthy.Swap(healthy) == healthy {
+				return
+			}
+
+			if healthy {
+				p.errorLog.Printf("upstream %s is healthy again", upstream.url.Host)
+			} else {
+				p.errorLog.Printf("error: upstream %s is unhealthy: %s", upstream.url.Host, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (p *ReverseProxy) check(upstream *upstream, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	checkURL := *upstream.url
+	checkURL.Path = joinPath(upstream.url.Path, p.options.HealthCheckPath)
+
+	resp, err := p.transport.roundTrip(ctx, &outgoing{
+		method:  "GET",
+		url:     &checkURL,
+		host:    checkURL.Host,
+		headers: headers.NewHeaders(),
+	})
+
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	if _, err := io.Copy(io.Discard, resp.BodyReader()); err != nil {
+		return err
+	}
+
+	if statusCode := resp.StatusLine.StatusCode; statusCode < 200 || statusCode >= 400 {
+		return fmt.Errorf("health check answered %d", statusCode)
+	}
+
+	return nil
+}
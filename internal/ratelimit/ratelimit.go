@@ -0,0 +1,153 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/sithusan/httpfromtcp/internal/server"
+)
+
+const KEY_RETRY_AFTER = "Retry-After"
+const KEY_RATELIMIT_LIMIT = "RateLimit-Limit"
+const KEY_RATELIMIT_REMAINING = "RateLimit-Remaining"
+const KEY_RATELIMIT_RESET = "RateLimit-Reset"
+const KEY_RATELIMIT_POLICY = "RateLimit-Policy"
+
+// KeyFunc names the client a request counts against.
+type KeyFunc func(req *request.Request) string
+
+type Options struct {
+	// Limit and Algorithm build a MemoryStore when Store is nil.
+	Limit     Limit
+	Algorithm Algorithm
+	Store     Store
+	// Key is nil means ByIP.
+	Key KeyFunc
+}
+
+/*
+Middleware counts each request against the key of its client and answers 429 Too Many Requests
+(RFC6585 4) with Retry-After once the limit is reached. Every response carries the RateLimit-*
+fields of draft-ietf-httpapi-ratelimit-headers, so well behaved clients slow down before that:
+
+	RateLimit-Limit: 100
+	RateLimit-Remaining: 42
+	RateLimit-Reset: 17
+	RateLimit-Policy: 100;w=60
+
+A store that fails lets the request through, an outage of the limiter is not one of the API.
+*/
+func Middleware(options Options) (server.Middleware, error) {
+	if options.Store == nil {
+		store, err := NewMemoryStore(options.Algorithm, options.Limit)
+
+		if err != nil {
+			return nil, err
+		}
+
+		options.Store = store
+	}
+
+	if options.Key == nil {
+		options.Key = ByIP
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			result, err := options.Store.Take(options.Key(req), time.Now())
+
+			if err != nil {
+				next(w, req)
+				return
+			}
+
+			stream := &limitStream{next: w.Stream(), result: result}
+			limited := w.Filter(stream)
+
+			if !result.Allowed {
+				server.HandleError{
+					StatusCode: response.TOO_MANY_REQUESTS,
+					Message:    []byte("too many requests, retry in " + strconv.Itoa(seconds(result.RetryAfter)) + "s\n"),
+				}.RespondTo(limited, req)
+				return
+			}
+
+			next(limited, req)
+		}
+	}, nil
+}
+
+/*
+ByIP keys a request by its ClientIP, which is the peer unless a trusted proxy relayed it. An IPv6
+client usually holds a whole /64, so it is keyed by that prefix, otherwise it would get a new
+quota with every address it picks.
+*/
+func ByIP(req *request.Request) string {
+	host := req.ClientIP()
+	ip := net.ParseIP(host)
+
+	if ip == nil {
+		return "ip:" + host
+	}
+
+	if ip.To4() == nil {
+		return "ip:" + ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+
+	return "ip:" + ip.String()
+}
+
+// ByHeader keys a request by a header like an API key, a request without it falls back to ByIP.
+func ByHeader(name string) KeyFunc {
+	return func(req *request.Request) string {
+		if value, ok := req.Headers.Get(name); ok && value != "" {
+			return "header:" + value
+		}
+
+		return ByIP(req)
+	}
+}
+
+// limitStream adds the RateLimit-* fields, and Retry-After to a refusal, to the response.
+type limitStream struct {
+	next   response.Stream
+	result Result
+}
+
+func (s *limitStream) WriteHead(statusCode response.StatusCode, h headers.Headers) error {
+	limit := s.result.Limit
+
+	h.Override(KEY_RATELIMIT_LIMIT, strconv.Itoa(limit.Requests))
+	h.Override(KEY_RATELIMIT_REMAINING, strconv.Itoa(s.result.Remaining))
+	h.Override(KEY_RATELIMIT_RESET, strconv.Itoa(seconds(s.result.Reset)))
+	h.Override(KEY_RATELIMIT_POLICY, fmt.Sprintf("%d;w=%d", limit.Requests, seconds(limit.Window)))
+
+	if !s.result.Allowed {
+		h.Override(KEY_RETRY_AFTER, strconv.Itoa(seconds(s.result.RetryAfter)))
+	}
+
+	return s.next.WriteHead(statusCode, h)
+}
+
+func (s *limitStream) WriteData(p []byte, endStream bool) error {
+	return s.next.WriteData(p, endStream)
+}
+
+func (s *limitStream) WriteTrailers(h headers.Headers) error {
+	return s.next.WriteTrailers(h)
+}
+
+/**
+* Helpers
+**/
+
+// seconds rounds up, a client told 0 while it has to wait would retry at once.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/sithusan/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hello(w *response.Writer, req *request.Request) {
+	body := []byte("hello")
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func serve(t *testing.T, handler server.Handler, remoteAddr string, fields ...string) *response.Response {
+	t.Helper()
+
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+
+	for _, field := range fields {
+		raw += field + "\r\n"
+	}
+
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = remoteAddr
+
+	buffer := &bytes.Buffer{}
+	handler(response.NewWriter(buffer), req)
+
+	resp, err := response.ResponseFromReader(buffer, "GET")
+	require.NoError(t, err)
+
+	return resp
+}
+
+func TestMiddleware(t *testing.T) {
+	mw, err := Middleware(Options{Limit: Limit{Requests: 2, Window: time.Minute}})
+	require.NoError(t, err)
+	handler := server.Chain(hello, mw)
+
+	resp := serve(t, handler, "192.0.2.1:5000")
+	assert.Equal(t, "hello", string(resp.Body))
+	assert.Equal(t, "2", resp.Headers["ratelimit-limit"])
+	assert.Equal(t, "1", resp.Headers["ratelimit-remaining"])
+	assert.Equal(t, "2;w=60", resp.Headers["ratelimit-policy"])
+	assert.NotContains(t, resp.Headers, "retry-after")
+
+	// the port does not matter, the address does
+	serve(t, handler, "192.0.2.1:5001")
+	resp = serve(t, handler, "192.0.2.1:5002", "Accept: application/json")
+	assert.Equal(t, response.StatusCode(response.TOO_MANY_REQUESTS), resp.StatusLine.StatusCode)
+	assert.Equal(t, "Too Many Requests", resp.StatusLine.ReasonPhrase)
+	assert.Equal(t, "30", resp.Headers["retry-after"])
+	assert.Equal(t, "0", resp.Headers["ratelimit-remaining"])
+	assert.Contains(t, string(resp.Body), `"status":429`)
+
+	resp = serve(t, handler, "192.0.2.2:5000")
+	assert.Equal(t, "hello", string(resp.Body))
+}
+
+func TestKeys(t *testing.T) {
+	req := &request.Request{RemoteAddr: "[2001:db8:1:2:aaaa::1]:443"}
+	other := &request.Request{RemoteAddr: "[2001:db8:1:2:bbbb::7]:443"}
+	assert.Equal(t, "ip:2001:db8:1:2::/64", ByIP(req))
+	assert.Equal(t, ByIP(req), ByIP(other))
+
+	mw, err := Middleware(Options{Limit: Limit{Requests: 1, Window: time.Minute}, Algorithm: SLIDING_WINDOW, Key: ByHeader("X-API-Key")})
+	require.NoError(t, err)
+	handler := server.Chain(hello, mw)
+
+	assert.Equal(t, "hello", string(serve(t, handler, "192.0.2.1:1", "X-API-Key: one").Body))
+	assert.Equal(t, "hello", string(serve(t, handler, "192.0.2.1:1", "X-API-Key: two").Body))
+	assert.Equal(t, response.StatusCode(response.TOO_MANY_REQUESTS), serve(t, handler, "192.0.2.9:1", "X-API-Key: one").StatusLine.StatusCode)
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(key string, now time.Time) (Result, error) {
+	return Result{}, errors.New("store unreachable")
+}
+
+func TestFailingStoreLetsRequestsThrough(t *testing.T) {
+	mw, err := Middleware(Options{Store: failingStore{}})
+	require.NoError(t, err)
+
+	resp := serve(t, server.Chain(hello, mw), "192.0.2.1:1")
+	assert.Equal(t, "hello", string(resp.Body))
+	assert.NotContains(t, resp.Headers, "ratelimit-limit")
+}
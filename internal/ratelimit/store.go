@@ -0,0 +1,219 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// SWEEP_INTERVAL is how often the memory store drops the keys that have been idle long enough to be back to a full quota.
+const SWEEP_INTERVAL = time.Minute
+
+type Algorithm int
+
+const (
+	/*
+		TOKEN_BUCKET holds Requests tokens, refilled at Requests per Window, each request takes
+		one. A client that was quiet can burst up to Requests at once, then goes at the refill rate.
+	*/
+	TOKEN_BUCKET Algorithm = iota
+	/*
+		SLIDING_WINDOW counts the requests of the current fixed window plus those of the previous
+		one weighted by how much of it still overlaps the last Window, so there is no burst of
+		twice the limit around the edge of a window.
+	*/
+	SLIDING_WINDOW
+)
+
+// Limit is Requests per Window.
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Result is the outcome of one request, with what the RateLimit-* fields tell the client.
+type Result struct {
+	Allowed bool
+	Limit   Limit
+	// Remaining is how many more requests would be allowed right now.
+	Remaining int
+	// Reset is how long until the whole quota is available again.
+	Reset time.Duration
+	// RetryAfter is how long until a request is allowed again, zero when this one was.
+	RetryAfter time.Duration
+}
+
+/*
+Store keeps the count of each key. Take counts one request for key at now and tells whether it
+fits the limit, a store shared by several servers lets them enforce one limit together.
+*/
+type Store interface {
+	Take(key string, now time.Time) (Result, error)
+}
+
+// MemoryStore keeps the counts in memory, for one process.
+type MemoryStore struct {
+	algorithm Algorithm
+	limit     Limit
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+type entry struct {
+	// tokens and last are the bucket of TOKEN_BUCKET
+	tokens float64
+	last   time.Time
+
+	// start, current and previous are the windows of SLIDING_WINDOW
+	start    time.Time
+	current  int
+	previous int
+}
+
+func NewMemoryStore(algorithm Algorithm, limit Limit) (*MemoryStore, error) {
+	if limit.Requests <= 0 || limit.Window <= 0 {
+		return nil, fmt.Errorf("rate limit needs positive Requests and Window, got %d per %s", limit.Requests, limit.Window)
+	}
+
+	if algorithm != TOKEN_BUCKET && algorithm != SLIDING_WINDOW {
+		return nil, fmt.Errorf("unknown rate limit algorithm %d", algorithm)
+	}
+
+	return &MemoryStore{
+		algorithm: algorithm,
+		limit:     limit,
+		entries:   map[string]*entry{},
+		lastSweep: time.Now(),
+	}, nil
+}
+
+func (s *MemoryStore) Take(key string, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > SWEEP_INTERVAL {
+		s.lastSweep = now
+		s.sweep(now)
+	}
+
+	e, ok := s.entries[key]
+
+	if !ok {
+		e = &entry{tokens: float64(s.limit.Requests), last: now, start: now.Truncate(s.limit.Window)}
+		s.entries[key] = e
+	}
+
+	if s.algorithm == SLIDING_WINDOW {
+		return s.slidingWindow(e, now), nil
+	}
+
+	return s.tokenBucket(e, now), nil
+}
+
+// Len is the number of keys held, idle ones not swept yet included.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.entries)
+}
+
+func (s *MemoryStore) tokenBucket(e *entry, now time.Time) Result {
+	capacity := float64(s.limit.Requests)
+	perToken := s.limit.Window / time.Duration(s.limit.Requests)
+
+	if elapsed := now.Sub(e.last); elapsed > 0 {
+		e.tokens = math.Min(capacity, e.tokens+float64(elapsed)/float64(perToken))
+		e.last = now
+	}
+
+	result := Result{Limit: s.limit}
+
+	if e.tokens >= 1 {
+		e.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - e.tokens) * float64(perToken))
+	}
+
+	result.Remaining = int(e.tokens)
+	result.Reset = time.Duration((capacity - e.tokens) * float64(perToken))
+
+	return result
+}
+
+func (s *MemoryStore) slidingWindow(e *entry, now time.Time) Result {
+	window := s.limit.Window
+	requests := s.limit.Requests
+
+	if start := now.Truncate(window); start.After(e.start) {
+		if start.Sub(e.start) == window {
+			e.previous = e.current
+		} else {
+			e.previous = 0
+		}
+
+		e.current = 0
+		e.start = start
+	}
+
+	elapsed := now.Sub(e.start)
+	overlap := 1 - float64(elapsed)/float64(window)
+	estimate := float64(e.previous)*overlap + float64(e.current)
+
+	result := Result{Limit: s.limit}
+
+	if estimate+1 <= float64(requests) {
+		e.current++
+		estimate++
+		result.Allowed = true
+	} else {
+		result.RetryAfter = s.slidingRetryAfter(e, elapsed)
+	}
+
+	result.Remaining = max(0, requests-int(math.Ceil(estimate)))
+
+	// the last counted request leaves the estimate once its window is no longer overlapped
+	if e.current > 0 {
+		result.Reset = 2*window - elapsed
+	} else {
+		result.Reset = window - elapsed
+	}
+
+	return result
+}
+
+// slidingRetryAfter is how long until the estimate leaves room for one more request.
+func (s *MemoryStore) slidingRetryAfter(e *entry, elapsed time.Duration) time.Duration {
+	window := float64(s.limit.Window)
+	room := float64(s.limit.Requests - 1)
+
+	// in this window the previous count fades until previous*overlap + current <= room
+	if float64(e.current) <= room && e.previous > 0 {
+		wait := window*(1-(room-float64(e.current))/float64(e.previous)) - float64(elapsed)
+		return time.Duration(math.Max(0, wait))
+	}
+
+	// in the next window the current count becomes the one that fades
+	wait := window - float64(elapsed) + window*(1-room/float64(e.current))
+
+	return time.Duration(math.Max(0, wait))
+}
+
+// sweep drops the keys that are back to a full quota, they are the same as keys never seen.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, e := range s.entries {
+		idle := now.Sub(e.last) >= s.limit.Window
+
+		if s.algorithm == SLIDING_WINDOW {
+			idle = now.Sub(e.start) >= 2*s.limit.Window
+		}
+
+		if idle {
+			delete(s.entries, key)
+		}
+	}
+}
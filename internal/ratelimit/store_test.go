@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var epoch = time.Unix(1_700_000_040, 0)
+
+func TestTokenBucket(t *testing.T) {
+	store, err := NewMemoryStore(TOKEN_BUCKET, Limit{Requests: 3, Window: 3 * time.Second})
+	require.NoError(t, err)
+
+	for i := 2; i >= 0; i-- {
+		result, err := store.Take("a", epoch)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, i, result.Remaining)
+	}
+
+	result, _ := store.Take("a", epoch)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+	assert.Equal(t, 3*time.Second, result.Reset)
+
+	// another key has its own bucket
+	result, _ = store.Take("b", epoch)
+	assert.True(t, result.Allowed)
+
+	// one token per second comes back
+	result, _ = store.Take("a", epoch.Add(1500*time.Millisecond))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	result, _ = store.Take("a", epoch.Add(1600*time.Millisecond))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 400*time.Millisecond, result.RetryAfter)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	window := 10 * time.Second
+	store, err := NewMemoryStore(SLIDING_WINDOW, Limit{Requests: 4, Window: window})
+	require.NoError(t, err)
+
+	start := epoch.Truncate(window)
+
+	for i := 0; i < 4; i++ {
+		result, _ := store.Take("a", start.Add(time.Second))
+		assert.True(t, result.Allowed)
+	}
+
+	result, _ := store.Take("a", start.Add(2*time.Second))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	// the next window starts in 8s, and 4 previous requests must fade to 3: a quarter of it more
+	assert.Equal(t, 8*time.Second+window/4, result.RetryAfter)
+
+	// at the edge of the window the previous 4 still weigh almost 4, no burst of 8
+	result, _ = store.Take("a", start.Add(window+time.Second))
+	assert.False(t, result.Allowed)
+
+	result, _ = store.Take("a", start.Add(window+window/4))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	// two windows later nothing is left of them
+	result, _ = store.Take("a", start.Add(3*window))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 3, result.Remaining)
+}
+
+func TestMemoryStoreEvictsIdleKeys(t *testing.T) {
+	store, err := NewMemoryStore(SLIDING_WINDOW, Limit{Requests: 1, Window: time.Second})
+	require.NoError(t, err)
+
+	store.lastSweep = epoch
+
+	for _, key := range []string{"a", "b", "c"} {
+		store.Take(key, epoch)
+	}
+
+	assert.Equal(t, 3, store.Len())
+
+	store.Take("d", epoch.Add(SWEEP_INTERVAL+time.Second))
+	assert.Equal(t, 1, store.Len())
+
+	_, err = NewMemoryStore(TOKEN_BUCKET, Limit{Requests: 0, Window: time.Second})
+	assert.Error(t, err)
+}
@@ -0,0 +1,205 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/sithusan/httpfromtcp/internal/transfer"
+)
+
+/*
+HeadFromReader reads the request line and the headers only, the body is left on reader for
+BodyReader to stream, so an upload never has to be held whole. The length of the body is checked
+against the limits up front, a chunked one while it is read. reader must not be read from
+before the body, what the parser read past the head is kept for BodyReader.
+*/
+func HeadFromReader(reader io.Reader, limits Limits) (*Request, error) {
+	request, pending, err := requestFromReader(reader, limits, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := request.streamBody(reader, pending); err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+/*
+BodyReader reads the body: straight off the connection for a request read by HeadFromReader,
+from Body otherwise, like a request that was buffered whole or came over HTTP/2. A body
+that ends early is io.ErrUnexpectedEOF, one past MaxBodyBytes is ErrBodyTooLarge. Trailers are
+set once it returned io.EOF.
+*/
+func (r *Request) BodyReader() io.Reader {
+	if !r.headOnly {
+		return bytes.NewReader(r.Body)
+	}
+
+	return r.body
+}
+
+// ReadBody reads what is left of a streamed body into Body, for the handlers that want it whole.
+func (r *Request) ReadBody() error {
+	return r.readBody(0)
+}
+
+// readBody is ReadBody failing with ErrBodyTooLarge past maxBytes, zero means no limit but MaxBodyBytes.
+func (r *Request) readBody(maxBytes int) error {
+	if !r.headOnly {
+		return nil
+	}
+
+	reader := r.BodyReader()
+
+	if maxBytes > 0 {
+		reader = transfer.NewLimitReader(reader, int64(maxBytes), ErrBodyTooLarge)
+	}
+
+	body, err := io.ReadAll(reader)
+
+	if err != nil {
+		return err
+	}
+
+	r.Body = append(r.Body, body...)
+	r.headOnly = false
+
+	return nil
+}
+
+/*
+BodyDone is closed once the body has been read to its end, at once for a body that was not
+streamed. Until then the connection still carries it, after that only a closed connection or a
+next request can come.
+*/
+func (r *Request) BodyDone() <-chan struct{} {
+	if r.bodyDone == nil {
+		return closedChannel
+	}
+
+	return r.bodyDone
+}
+
+var closedChannel = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+
+	return c
+}()
+
+/*
+ContentLength is the length of the body, -1 when it is only known once read, like a chunked
+body still on the connection or one decoded as it streams.
+*/
+func (r *Request) ContentLength() int64 {
+	if !r.headOnly {
+		return int64(len(r.Body))
+	}
+
+	return r.length
+}
+
+// Streamed tells whether the body is still on the connection, to be read through BodyReader.
+func (r *Request) Streamed() bool {
+	return r.headOnly
+}
+
+/*
+SetBodyReader hands the request over with its body still arriving through reader, like an
+HTTP/2 stream whose DATA frames come after its headers. length is -1 when it is not known,
+reader returns io.EOF at the end of the body, with the Trailers set before.
+*/
+func (r *Request) SetBodyReader(reader io.Reader, length int64) {
+	r.headOnly = true
+	r.bodyDone = make(chan struct{})
+	r.body = &streamedBody{reader: reader, request: r}
+	r.length = length
+}
+
+// headDone is true once a head-only parse reached the body, see HeadFromReader.
+func (r *Request) headDone() bool {
+	return r.headOnly && r.requestStatus == requestStateParsingBody
+}
+
+// streamBody prepares BodyReader with what the length of the body tells, like requestParsingBody does for a buffered one.
+func (r *Request) streamBody(reader io.Reader, pending []byte) error {
+	chunked, err := r.chunked()
+
+	if err != nil {
+		return err
+	}
+
+	r.bodyDone = make(chan struct{})
+
+	if chunked {
+		chunks := transfer.NewChunkedReader(reader, transfer.ChunkedReaderOptions{
+			Pending:     pending,
+			MaxBytes:    int64(r.limits.MaxBodyBytes),
+			ErrTooLarge: ErrBodyTooLarge,
+		})
+		r.body = &streamedBody{reader: chunks, chunks: chunks, request: r}
+		r.length = -1
+
+		return nil
+	}
+
+	contentLength := 0
+
+	if contentLengthStr, ok := r.Headers.Get(KEY_CONTENT_LENGTH); ok {
+		contentLength, err = strconv.Atoi(contentLengthStr)
+
+		if err != nil || contentLength < 0 {
+			return fmt.Errorf("malformed Content-Length: %s", contentLengthStr)
+		}
+	}
+
+	if r.limits.MaxBodyBytes > 0 && contentLength > r.limits.MaxBodyBytes {
+		return fmt.Errorf("%w: Content-Length %d exceeds %d", ErrBodyTooLarge, contentLength, r.limits.MaxBodyBytes)
+	}
+
+	n := min(len(pending), contentLength)
+
+	if len(pending) > n {
+		r.unread = pending[n:]
+	}
+
+	r.body = &streamedBody{
+		reader:  transfer.NewLengthReader(io.MultiReader(bytes.NewReader(pending[:n]), reader), int64(contentLength)),
+		request: r,
+	}
+	r.length = int64(contentLength)
+
+	return nil
+}
+
+// streamedBody closes bodyDone once the body ends, with the trailers and what was read past a chunked one handed to the request.
+type streamedBody struct {
+	reader  io.Reader
+	chunks  *transfer.ChunkedReader
+	request *Request
+}
+
+func (b *streamedBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+
+	if !errors.Is(err, io.EOF) {
+		return n, err
+	}
+
+	if b.chunks != nil {
+		b.request.Trailers = b.chunks.Trailers()
+		b.request.unread = b.chunks.Unread()
+	}
+
+	b.request.bodyOnce.Do(func() {
+		close(b.request.bodyDone)
+	})
+
+	return n, err
+}
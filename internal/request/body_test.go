@@ -0,0 +1,150 @@
+package request
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeadFromReader(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     string
+		body     string
+		trailer  string
+		unread   string
+		limits   Limits
+		expected error
+	}{
+		{
+			name:   "content length",
+			data:   "POST /upload HTTP/1.1\r\nContent-Length: 12\r\n\r\nhello world!GET /",
+			body:   "hello world!",
+			unread: "GET /",
+		},
+		{
+			name:    "chunked",
+			data:    "POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nhello \r\n6\r\nworld!\r\n0\r\nX-Checksum: abc\r\n\r\nGET /",
+			body:    "hello world!",
+			trailer: "abc",
+			unread:  "GET /",
+		},
+		{
+			name:   "no body",
+			data:   "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n\x16\x03\x01",
+			unread: "\x16\x03\x01",
+		},
+		{
+			name:     "content length cut short",
+			data:     "POST /upload HTTP/1.1\r\nContent-Length: 12\r\n\r\nhello",
+			expected: io.ErrUnexpectedEOF,
+		},
+		{
+			name:     "chunked past the limit",
+			data:     "POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n8\r\nhello wo\r\n8\r\nrld!!!!!\r\n0\r\n\r\n",
+			limits:   Limits{MaxBodyBytes: 12},
+			expected: ErrBodyTooLarge,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &chunkReader{data: tc.data, numBytesPerRead: 7}
+			r, err := HeadFromReader(reader, tc.limits)
+			require.NoError(t, err)
+			assert.True(t, r.Streamed())
+			assert.Empty(t, r.Body)
+
+			body, err := io.ReadAll(r.BodyReader())
+
+			if tc.expected != nil {
+				require.ErrorIs(t, err, tc.expected)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.body, string(body))
+
+			checksum, _ := r.Trailers.Get("X-Checksum")
+			assert.Equal(t, tc.trailer, checksum)
+
+			rest, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, tc.unread, string(r.Unread())+string(rest))
+		})
+	}
+}
+
+func TestHeadFromReaderChecksLengthUpFront(t *testing.T) {
+	_, err := HeadFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 13\r\n\r\n"), Limits{MaxBodyBytes: 12})
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	_, err = HeadFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: x\r\n\r\n"), Limits{})
+	require.Error(t, err)
+
+	_, err = HeadFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n"), Limits{})
+	require.Error(t, err)
+}
+
+func TestReadBody(t *testing.T) {
+	r, err := HeadFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello"), Limits{})
+	require.NoError(t, err)
+
+	require.NoError(t, r.ReadBody())
+	assert.False(t, r.Streamed())
+	assert.Equal(t, "hello", string(r.Body))
+
+	// a buffered body reads back from Body, as many times as asked
+	for range 2 {
+		body, err := io.ReadAll(r.BodyReader())
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+	}
+}
+
+func TestStreamedBodyFormsAndDecoding(t *testing.T) {
+	head := func(headers, body string) *Request {
+		r, err := HeadFromReader(strings.NewReader("POST /?page=2 HTTP/1.1\r\n"+headers+
+			"Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+body), Limits{})
+		require.NoError(t, err)
+
+		return r
+	}
+
+	form, err := head("Content-Type: application/x-www-form-urlencoded\r\n", "q=tcp").ParseForm()
+	require.NoError(t, err)
+	assert.Equal(t, "tcp", form.Get("q"))
+	assert.Equal(t, "2", form.Get("page"))
+
+	_, err = head("Content-Type: application/x-www-form-urlencoded\r\n", "q="+strings.Repeat("a", 64)).
+		ParseFormWithLimits(FormLimits{MaxBytes: 16})
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	var v struct{ Name string }
+	require.NoError(t, head("Content-Type: application/json\r\n", `{"Name":"tcp"}`).DecodeJSON(&v))
+	assert.Equal(t, "tcp", v.Name)
+
+	buffer := &bytes.Buffer{}
+	writer := gzip.NewWriter(buffer)
+	writer.Write([]byte("hello, world"))
+	writer.Close()
+
+	r := head("Content-Encoding: gzip\r\n", buffer.String())
+	require.NoError(t, r.DecodeBody(0))
+	_, ok := r.Headers.Get(KEY_CONTENT_LENGTH)
+	assert.False(t, ok)
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(body))
+
+	r = head("Content-Encoding: gzip\r\n", buffer.String())
+	require.NoError(t, r.DecodeBody(5))
+	_, err = io.ReadAll(r.BodyReader())
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+}
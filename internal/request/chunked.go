@@ -0,0 +1,56 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sithusan/httpfromtcp/internal/transfer"
+)
+
+const KEY_TRANSFER_ENCODING = "Transfer-Encoding"
+
+/*
+According to RFC9112 6.3, Transfer-Encoding wins over Content-Length, and a request whose
+final transfer coding is not chunked has no way to tell where its body ends.
+*/
+func (r *Request) chunked() (bool, error) {
+	transferEncoding, ok := r.Headers.Get(KEY_TRANSFER_ENCODING)
+
+	if !ok {
+		return false, nil
+	}
+
+	codings := strings.Split(transferEncoding, ",")
+
+	if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+		return false, fmt.Errorf("unsupported Transfer-Encoding: %s", transferEncoding)
+	}
+
+	return true, nil
+}
+
+// requestParsingChunkedBody feeds the body to the shared chunked decoder, see transfer.ChunkedDecoder.
+func (r *Request) requestParsingChunkedBody(data []byte) (int, error) {
+	if r.chunks == nil {
+		r.chunks = transfer.NewChunkedDecoder()
+	}
+
+	n, chunk, err := r.chunks.Decode(data)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if r.limits.MaxBodyBytes > 0 && r.chunks.Length() > int64(r.limits.MaxBodyBytes) {
+		return 0, fmt.Errorf("%w: chunked body exceeds %d", ErrBodyTooLarge, r.limits.MaxBodyBytes)
+	}
+
+	r.Body = append(r.Body, chunk...)
+
+	if r.chunks.Done() {
+		r.Trailers = r.chunks.Trailers
+		r.requestStatus = done
+	}
+
+	return n, nil
+}
@@ -0,0 +1,162 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/sithusan/httpfromtcp/internal/transfer"
+)
+
+const KEY_CONTENT_ENCODING = "Content-Encoding"
+
+// SUPPORTED_ENCODINGS is what DecodeBody understands, it is sent back as Accept-Encoding with a 415.
+const SUPPORTED_ENCODINGS = "gzip, deflate"
+
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+/*
+DecodeBody replaces a gzip or deflate encoded body by the decoded bytes, then drops Content-Encoding
+and fixes Content-Length so handlers never see the difference. According to RFC9110 8.4, codings
+are listed in the order they were applied, so they are undone from the last one.
+
+A few compressed bytes can expand to gigabytes, so decoding stops with ErrBodyTooLarge past
+maxBytes (zero means no limit).
+*/
+func (r *Request) DecodeBody(maxBytes int) error {
+	contentEncoding, ok := r.Headers.Get(KEY_CONTENT_ENCODING)
+
+	if !ok {
+		return nil
+	}
+
+	codings := strings.Split(contentEncoding, ",")
+
+	if r.headOnly {
+		return r.decodeStream(codings, maxBytes)
+	}
+
+	body := r.Body
+
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+
+		if coding == "identity" || coding == "" {
+			continue
+		}
+
+		decoder, err := newDecoder(coding, bytes.NewReader(body))
+
+		if err != nil {
+			return err
+		}
+
+		body, err = readLimited(decoder, maxBytes)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	r.Body = body
+	r.Headers.Remove(KEY_CONTENT_ENCODING)
+	r.Headers.Override(KEY_CONTENT_LENGTH, strconv.Itoa(len(body)))
+
+	return nil
+}
+
+/*
+decodeStream decodes a body streamed off the connection as it is read, see HeadFromReader.
+Its decoded length is unknown until then, so Content-Length goes along with Content-Encoding.
+*/
+func (r *Request) decodeStream(codings []string, maxBytes int) error {
+	body := r.body
+
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+
+		if coding == "identity" || coding == "" {
+			continue
+		}
+
+		decoder, err := newDecoder(coding, body)
+
+		if err != nil {
+			return err
+		}
+
+		body = decoder
+	}
+
+	if maxBytes > 0 {
+		body = transfer.NewLimitReader(body, int64(maxBytes), ErrBodyTooLarge)
+	}
+
+	r.body = body
+	r.length = -1
+	r.Headers.Remove(KEY_CONTENT_ENCODING)
+	r.Headers.Remove(KEY_CONTENT_LENGTH)
+
+	return nil
+}
+
+func newDecoder(coding string, body io.Reader) (io.Reader, error) {
+	switch coding {
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(body)
+
+		if err != nil {
+			return nil, fmt.Errorf("malformed gzip body: %s", err)
+		}
+
+		return reader, nil
+	case "deflate":
+		// "deflate" is meant to be zlib wrapped, some clients send a raw deflate stream anyway
+		buffered := bufio.NewReader(body)
+
+		if header, err := buffered.Peek(2); err == nil && isZlibHeader(header) {
+			reader, err := zlib.NewReader(buffered)
+
+			if err != nil {
+				return nil, fmt.Errorf("malformed deflate body: %s", err)
+			}
+
+			return reader, nil
+		}
+
+		return flate.NewReader(buffered), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, coding)
+	}
+}
+
+// According to RFC1950 2.2, CM is 8 (deflate) and CMF*256+FLG is a multiple of 31.
+func isZlibHeader(header []byte) bool {
+	return header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
+
+func readLimited(reader io.Reader, maxBytes int) ([]byte, error) {
+	if maxBytes > 0 {
+		// one byte more than allowed tells "exactly at the limit" from "over it"
+		reader = io.LimitReader(reader, int64(maxBytes)+1)
+	}
+
+	body, err := io.ReadAll(reader)
+
+	if err != nil {
+		return nil, fmt.Errorf("malformed encoded body: %s", err)
+	}
+
+	if maxBytes > 0 && len(body) > maxBytes {
+		return nil, fmt.Errorf("%w: decoded body exceeds %d bytes", ErrBodyTooLarge, maxBytes)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,88 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encode(t *testing.T, coding string, body []byte) []byte {
+	buffer := &bytes.Buffer{}
+	var writer io.WriteCloser
+
+	switch coding {
+	case "gzip":
+		writer = gzip.NewWriter(buffer)
+	case "zlib":
+		writer = zlib.NewWriter(buffer)
+	case "flate":
+		var err error
+		writer, err = flate.NewWriter(buffer, flate.DefaultCompression)
+		require.NoError(t, err)
+	}
+
+	_, err := writer.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	return buffer.Bytes()
+}
+
+func encodedRequest(contentEncoding string, body []byte) *Request {
+	r := NewRequest()
+	r.Headers.Override(KEY_CONTENT_ENCODING, contentEncoding)
+	r.Headers.Override(KEY_CONTENT_LENGTH, "0")
+	r.Body = body
+
+	return r
+}
+
+func TestDecodeBody(t *testing.T) {
+	body := []byte("hello, compressed world")
+
+	cases := map[string][]byte{
+		"gzip":          encode(t, "gzip", body),
+		"x-gzip":        encode(t, "gzip", body),
+		"deflate":       encode(t, "zlib", body),
+		"Deflate":       encode(t, "flate", body),
+		"gzip, deflate": encode(t, "zlib", encode(t, "gzip", body)),
+		"identity":      body,
+	}
+
+	for contentEncoding, encoded := range cases {
+		r := encodedRequest(contentEncoding, encoded)
+		require.NoError(t, r.DecodeBody(0), contentEncoding)
+
+		assert.Equal(t, body, r.Body, contentEncoding)
+		_, ok := r.Headers.Get(KEY_CONTENT_ENCODING)
+		assert.False(t, ok)
+		contentLength, _ := r.Headers.Get(KEY_CONTENT_LENGTH)
+		assert.Equal(t, "23", contentLength)
+	}
+}
+
+func TestDecodeBodyLimitsDecodedSize(t *testing.T) {
+	bomb := encode(t, "gzip", []byte(strings.Repeat("0", 1<<20)))
+	assert.Less(t, len(bomb), 4096)
+
+	err := encodedRequest("gzip", bomb).DecodeBody(1 << 16)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	require.NoError(t, encodedRequest("gzip", bomb).DecodeBody(1<<20))
+}
+
+func TestDecodeBodyRejectsUnknownAndMalformed(t *testing.T) {
+	err := encodedRequest("br", []byte("whatever")).DecodeBody(0)
+	require.ErrorIs(t, err, ErrUnsupportedEncoding)
+
+	err = encodedRequest("gzip", []byte("not gzip")).DecodeBody(0)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnsupportedEncoding)
+}
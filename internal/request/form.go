@@ -0,0 +1,174 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+const KEY_CONTENT_TYPE = "Content-Type"
+
+const FORM_URLENCODED = "application/x-www-form-urlencoded"
+
+const DEFAULT_MAX_FORM_FIELDS = 1000
+const DEFAULT_MAX_FORM_BYTES = 10 << 20
+
+var ErrMalformedForm = errors.New("malformed form")
+var ErrTooManyFormFields = errors.New("too many form fields")
+
+// FormLimits bound what parsing a form allocates, zero means the DEFAULT_MAX_FORM_* values.
+type FormLimits struct {
+	// MaxFields counts the query and the body fields together
+	MaxFields int
+	// MaxBytes bounds the urlencoded body
+	MaxBytes int
+}
+
+/*
+ParseForm returns the fields of an application/x-www-form-urlencoded body followed by those of
+the query string, so Get prefers the body when a name is in both:
+
+	POST /search?page=2 HTTP/1.1
+	Content-Type: application/x-www-form-urlencoded
+
+	q=tcp+server&page=1
+
+gives q=["tcp server"] and page=["1", "2"]. Bodies of another type are left alone and only
+the query is parsed. The result is kept, later calls return it without parsing again.
+*/
+func (r *Request) ParseForm() (url.Values, error) {
+	return r.ParseFormWithLimits(FormLimits{})
+}
+
+func (r *Request) ParseFormWithLimits(limits FormLimits) (url.Values, error) {
+	if r.form != nil {
+		return r.form, nil
+	}
+
+	if limits.MaxFields == 0 {
+		limits.MaxFields = DEFAULT_MAX_FORM_FIELDS
+	}
+
+	if limits.MaxBytes == 0 {
+		limits.MaxBytes = DEFAULT_MAX_FORM_BYTES
+	}
+
+	form := url.Values{}
+	fields := 0
+
+	if r.isURLEncoded() {
+		if err := r.readBody(limits.MaxBytes); err != nil {
+			return nil, err
+		}
+
+		if len(r.Body) > limits.MaxBytes {
+			return nil, fmt.Errorf("%w: form of %d bytes exceeds %d", ErrBodyTooLarge, len(r.Body), limits.MaxBytes)
+		}
+
+		n, err := parseURLEncoded(form, string(r.Body), limits.MaxFields)
+
+		if err != nil {
+			return nil, err
+		}
+
+		fields += n
+	}
+
+	if _, err := parseURLEncoded(form, r.rawQuery(), limits.MaxFields-fields); err != nil {
+		return nil, err
+	}
+
+	r.form = form
+
+	return form, nil
+}
+
+// FormValue is the first value of the field key, empty when it is missing or the form is malformed.
+func (r *Request) FormValue(key string) string {
+	form, err := r.ParseForm()
+
+	if err != nil {
+		return ""
+	}
+
+	return form.Get(key)
+}
+
+func (r *Request) queryValues() (url.Values, error) {
+	query := url.Values{}
+	_, err := parseURLEncoded(query, r.rawQuery(), DEFAULT_MAX_FORM_FIELDS)
+
+	return query, err
+}
+
+func (r *Request) rawQuery() string {
+	_, query, _ := strings.Cut(r.RequestLine.RequestTarget, "?")
+	query, _, _ = strings.Cut(query, "#")
+
+	return query
+}
+
+func (r *Request) isURLEncoded() bool {
+	contentType, ok := r.Headers.Get(KEY_CONTENT_TYPE)
+
+	if !ok {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	return err == nil && mediaType == FORM_URLENCODED
+}
+
+/**
+* Helpers
+**/
+
+/*
+According to the URL Standard 5.1, a urlencoded string is a list of name=value pairs split
+on "&", where "+" stands for a space and bytes are percent-encoded. The fields are counted
+before anything is decoded, so a body made of "&&&&" cannot allocate past maxFields.
+*/
+func parseURLEncoded(form url.Values, encoded string, maxFields int) (int, error) {
+	if encoded == "" {
+		return 0, nil
+	}
+
+	pairs := []string{}
+
+	for rest := encoded; rest != ""; {
+		var pair string
+		pair, rest, _ = strings.Cut(rest, "&")
+
+		if pair == "" {
+			continue
+		}
+
+		if len(pairs) == maxFields {
+			return 0, fmt.Errorf("%w: more than %d", ErrTooManyFormFields, maxFields)
+		}
+
+		pairs = append(pairs, pair)
+	}
+
+	for _, pair := range pairs {
+		name, value, _ := strings.Cut(pair, "=")
+		decodedName, err := url.QueryUnescape(name)
+
+		if err != nil {
+			return 0, fmt.Errorf("%w: field name %q: %s", ErrMalformedForm, name, err)
+		}
+
+		decodedValue, err := url.QueryUnescape(value)
+
+		if err != nil {
+			return 0, fmt.Errorf("%w: value of %q: %s", ErrMalformedForm, decodedName, err)
+		}
+
+		form.Add(decodedName, decodedValue)
+	}
+
+	return len(pairs), nil
+}
@@ -0,0 +1,77 @@
+package request
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func formRequest(t *testing.T, target, contentType, body string) *Request {
+	t.Helper()
+
+	raw := "POST " + target + " HTTP/1.1\r\nHost: localhost\r\n"
+
+	if contentType != "" {
+		raw += "Content-Type: " + contentType + "\r\n"
+	}
+
+	raw += "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+
+	r, err := RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	return r
+}
+
+func TestParseFormMergesBodyAndQuery(t *testing.T) {
+	r := formRequest(t, "/search?page=2&lang=en%2Dgb", "application/x-www-form-urlencoded; charset=utf-8", "q=tcp+server&page=1&empty=&flag&&tag=a%26b")
+
+	form, err := r.ParseForm()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tcp server"}, form["q"])
+	assert.Equal(t, []string{"1", "2"}, form["page"])
+	assert.Equal(t, []string{"en-gb"}, form["lang"])
+	assert.Equal(t, []string{""}, form["empty"])
+	assert.Equal(t, []string{""}, form["flag"])
+	assert.Equal(t, []string{"a&b"}, form["tag"])
+
+	assert.Equal(t, "1", r.FormValue("page"))
+	assert.Equal(t, "", r.FormValue("missing"))
+}
+
+func TestParseFormIgnoresOtherBodies(t *testing.T) {
+	r := formRequest(t, "/items?id=7", "application/json", `{"id":1}`)
+
+	form, err := r.ParseForm()
+	require.NoError(t, err)
+	assert.Equal(t, "7", form.Get("id"))
+	assert.Len(t, form, 1)
+}
+
+func TestMalformedForm(t *testing.T) {
+	r := formRequest(t, "/", FORM_URLENCODED, "name=%zz")
+	_, err := r.ParseForm()
+	require.ErrorIs(t, err, ErrMalformedForm)
+
+	r = formRequest(t, "/?bad%=1", FORM_URLENCODED, "")
+	_, err = r.ParseForm()
+	require.ErrorIs(t, err, ErrMalformedForm)
+}
+
+func TestFormLimits(t *testing.T) {
+	r := formRequest(t, "/?c=3", FORM_URLENCODED, "a=1&b=2")
+	_, err := r.ParseFormWithLimits(FormLimits{MaxFields: 2})
+	require.ErrorIs(t, err, ErrTooManyFormFields)
+
+	// empty pairs are not fields
+	r = formRequest(t, "/", FORM_URLENCODED, "a=1&&&&&&b=2")
+	_, err = r.ParseFormWithLimits(FormLimits{MaxFields: 2})
+	require.NoError(t, err)
+
+	r = formRequest(t, "/", FORM_URLENCODED, "a="+strings.Repeat("x", 100))
+	_, err = r.ParseFormWithLimits(FormLimits{MaxBytes: 64})
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+}
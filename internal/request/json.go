@@ -0,0 +1,83 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+const APPLICATION_JSON = "application/json"
+
+const DEFAULT_MAX_JSON_BYTES = 1 << 20
+
+var ErrMalformedJSON = errors.New("malformed json")
+
+// DecodeJSON decodes the body into v, see DecodeJSONWithLimit, with DEFAULT_MAX_JSON_BYTES.
+func (r *Request) DecodeJSON(v any) error {
+	return r.DecodeJSONWithLimit(v, DEFAULT_MAX_JSON_BYTES)
+}
+
+/*
+DecodeJSONWithLimit decodes a body of type application/json (or any "+json" type) into v, strictly:
+a field v does not have, a value of the wrong type, or anything after the JSON value is an error.
+A client that misspells a field then learns it, instead of the field being silently dropped.
+*/
+func (r *Request) DecodeJSONWithLimit(v any, maxBytes int) error {
+	contentType, _ := r.Headers.Get(KEY_CONTENT_TYPE)
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil || (mediaType != APPLICATION_JSON && !strings.HasSuffix(mediaType, "+json")) {
+		return fmt.Errorf("%w: expected %s, got %q", ErrUnsupportedMediaType, APPLICATION_JSON, contentType)
+	}
+
+	if err := r.readBody(maxBytes); err != nil {
+		return err
+	}
+
+	if maxBytes > 0 && len(r.Body) > maxBytes {
+		return fmt.Errorf("%w: json body of %d bytes exceeds %d", ErrBodyTooLarge, len(r.Body), maxBytes)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(r.Body))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w: %s", ErrMalformedJSON, describeJSONError(err))
+	}
+
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: unexpected data after the json value", ErrMalformedJSON)
+	}
+
+	return nil
+}
+
+/**
+* Helpers
+**/
+
+// describeJSONError says where the body went wrong in words a client can act on.
+func describeJSONError(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return "empty body"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "body ends in the middle of the json value"
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("syntax error at byte %d: %s", syntaxErr.Offset, syntaxErr)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fmt.Sprintf("field %q must be %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value)
+	default:
+		// unknown fields come as `json: unknown field "name"`
+		return strings.TrimPrefix(err.Error(), "json: ")
+	}
+}
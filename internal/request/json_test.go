@@ -0,0 +1,51 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type user struct {
+	Name  string   `json:"name"`
+	Age   int      `json:"age"`
+	Roles []string `json:"roles"`
+}
+
+func TestDecodeJSON(t *testing.T) {
+	r := formRequest(t, "/users", "application/json; charset=utf-8", `{"name":"alice","age":30,"roles":["admin"]}`)
+
+	u := user{}
+	require.NoError(t, r.DecodeJSON(&u))
+	assert.Equal(t, user{Name: "alice", Age: 30, Roles: []string{"admin"}}, u)
+
+	r = formRequest(t, "/users", "application/merge-patch+json", `{"age":31}`)
+	require.NoError(t, r.DecodeJSON(&u))
+	assert.Equal(t, 31, u.Age)
+}
+
+func TestDecodeJSONIsStrict(t *testing.T) {
+	for body, message := range map[string]string{
+		``:                           "empty body",
+		`{"name":"alice"`:            "body ends in the middle",
+		`{"name":"alice",}`:          "syntax error at byte 17",
+		`{"name":"alice","admin":1}`: `unknown field "admin"`,
+		`{"age":"thirty"}`:           `field "age" must be int, got string`,
+		`[1, 2]`:                     "expected request.user, got array",
+		`{"name":"a"} {"name":"b"}`:  "unexpected data after the json value",
+	} {
+		err := formRequest(t, "/", APPLICATION_JSON, body).DecodeJSON(&user{})
+		require.ErrorIs(t, err, ErrMalformedJSON, body)
+		assert.Contains(t, err.Error(), message)
+	}
+}
+
+func TestDecodeJSONLimits(t *testing.T) {
+	err := formRequest(t, "/", "text/plain", `{}`).DecodeJSON(&user{})
+	require.ErrorIs(t, err, ErrUnsupportedMediaType)
+
+	err = formRequest(t, "/", APPLICATION_JSON, `{"name":"`+strings.Repeat("a", 100)+`"}`).DecodeJSONWithLimit(&user{}, 64)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+}
@@ -0,0 +1,264 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+)
+
+const MULTIPART_FORM_DATA = "multipart/form-data"
+
+// MAX_BOUNDARY is the longest boundary RFC2046 5.1.1 allows.
+const MAX_BOUNDARY = 70
+
+// MAX_PART_HEADER_BYTES bounds the header section of one part.
+const MAX_PART_HEADER_BYTES = 16 * 1024
+
+var ErrNotMultipart = fmt.Errorf("%w: request is not multipart/form-data", ErrUnsupportedMediaType)
+
+/*
+According to RFC7578 and RFC2046 5.1.1, a multipart/form-data body is a list of parts, each
+introduced by "--" and the boundary of the Content-Type, with its own header section:
+
+	Content-Type: multipart/form-data; boundary=xyz
+
+	--xyz
+	Content-Disposition: form-data; name="title"
+
+	holiday
+	--xyz
+	Content-Disposition: form-data; name="photo"; filename="beach.jpg"
+	Content-Type: image/jpeg
+
+	...bytes...
+	--xyz--
+
+MultipartReader hands out the parts one at a time, the bytes of a part are read as they are
+asked for. With a body streamed off the connection, see HeadFromReader, a file never has to be held whole.
+*/
+type MultipartReader struct {
+	reader *bufio.Reader
+	// delimiter ends the data of a part, the CRLF before it belongs to the delimiter
+	delimiter []byte
+	dashes    []byte
+	current   *Part
+	started   bool
+	done      bool
+}
+
+type Part struct {
+	Headers headers.Headers
+
+	reader *MultipartReader
+	eof    bool
+	name   string
+	file   string
+}
+
+// MultipartReader reads the body as multipart/form-data, with the boundary of its Content-Type.
+func (r *Request) MultipartReader() (*MultipartReader, error) {
+	contentType, _ := r.Headers.Get(KEY_CONTENT_TYPE)
+	mediaType, params, err := mime.ParseMediaType(contentType)
+
+	if err != nil || mediaType != MULTIPART_FORM_DATA {
+		return nil, fmt.Errorf("%w: Content-Type %q", ErrNotMultipart, contentType)
+	}
+
+	return NewMultipartReader(r.BodyReader(), params["boundary"])
+}
+
+func NewMultipartReader(body io.Reader, boundary string) (*MultipartReader, error) {
+	if boundary == "" || len(boundary) > MAX_BOUNDARY || strings.TrimRight(boundary, " ") != boundary {
+		return nil, fmt.Errorf("%w: invalid boundary %q", ErrMalformedForm, boundary)
+	}
+
+	delimiter := []byte("\r\n--" + boundary)
+
+	return &MultipartReader{
+		reader:    bufio.NewReaderSize(body, max(4096, 2*len(delimiter))),
+		delimiter: delimiter,
+		dashes:    delimiter[len(CLRF):],
+	}, nil
+}
+
+// NextPart skips what is left of the current part and returns the next one, io.EOF after the last.
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.done {
+		return nil, io.EOF
+	}
+
+	if mr.current != nil {
+		if _, err := io.Copy(io.Discard, mr.current); err != nil {
+			return nil, err
+		}
+	}
+
+	// right after a delimiter: "--" closes the body, anything else is transport padding up to the CRLF
+	var line []byte
+	var err error
+
+	if mr.started {
+		line, err = mr.readLine()
+	} else {
+		line, err = mr.skipPreamble()
+		mr.started = true
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: body ends before a delimiter: %s", ErrMalformedForm, err)
+	}
+
+	if bytes.HasPrefix(line, []byte("--")) {
+		mr.done = true
+		return nil, io.EOF
+	}
+
+	if len(bytes.TrimRight(line, " \t")) != 0 {
+		return nil, fmt.Errorf("%w: unexpected %q after the boundary", ErrMalformedForm, line)
+	}
+
+	part := &Part{Headers: headers.NewHeaders(), reader: mr}
+	headerBytes := 0
+
+	for {
+		line, err := mr.readLine()
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: body ends inside part headers", ErrMalformedForm)
+		}
+
+		headerBytes += len(line) + len(CLRF)
+
+		if headerBytes > MAX_PART_HEADER_BYTES {
+			return nil, fmt.Errorf("%w: part headers exceed %d bytes", ErrHeadersTooLarge, MAX_PART_HEADER_BYTES)
+		}
+
+		_, headersDone, err := part.Headers.Parse(append(line, CLRF...))
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrMalformedForm, err)
+		}
+
+		if headersDone {
+			break
+		}
+	}
+
+	if disposition, ok := part.Headers.Get("Content-Disposition"); ok {
+		if kind, params, err := mime.ParseMediaType(disposition); err == nil && kind == "form-data" {
+			part.name = params["name"]
+			part.file = params["filename"]
+		}
+	}
+
+	mr.current = part
+
+	return part, nil
+}
+
+// skipPreamble drops whatever comes before the first delimiter, which starts a line but has no CRLF before it.
+// It returns the rest of the delimiter line.
+func (mr *MultipartReader) skipPreamble() ([]byte, error) {
+	for {
+		line, err := mr.readLine()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if rest, ok := bytes.CutPrefix(line, mr.dashes); ok {
+			return rest, nil
+		}
+	}
+}
+
+// readLine returns a line without its CRLF, a bare LF is accepted like most parsers do.
+func (mr *MultipartReader) readLine() ([]byte, error) {
+	line := []byte{}
+
+	for {
+		fragment, isPrefix, err := mr.reader.ReadLine()
+
+		if err != nil {
+			return nil, err
+		}
+
+		line = append(line, fragment...)
+
+		if len(line) > MAX_PART_HEADER_BYTES {
+			return nil, fmt.Errorf("%w: line longer than %d bytes", ErrMalformedForm, MAX_PART_HEADER_BYTES)
+		}
+
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+// FormName is the name of the field in Content-Disposition.
+func (p *Part) FormName() string {
+	return p.name
+}
+
+// FileName is the file name in Content-Disposition without any directory, empty for a plain field.
+func (p *Part) FileName() string {
+	if p.file == "" {
+		return ""
+	}
+
+	// a client may send "../../etc/passwd" or "C:\\Users\\x.txt"
+	name := filepath.Base(strings.ReplaceAll(p.file, "\\", "/"))
+
+	if name == "." || name == "/" {
+		return ""
+	}
+
+	return name
+}
+
+/*
+Read returns the data of the part up to the next delimiter. Bytes that could be the start of
+the delimiter are held back until enough follow to tell.
+*/
+func (p *Part) Read(b []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+
+	mr := p.reader
+	want := max(len(mr.delimiter), mr.reader.Buffered())
+	data, err := mr.reader.Peek(want)
+
+	if idx := bytes.Index(data, mr.delimiter); idx >= 0 {
+		n := copy(b, data[:idx])
+		mr.reader.Discard(n)
+
+		if n < idx {
+			return n, nil
+		}
+
+		mr.reader.Discard(len(mr.delimiter))
+		p.eof = true
+
+		if n == 0 {
+			return 0, io.EOF
+		}
+
+		return n, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("%w: part not closed by the boundary", ErrMalformedForm)
+	}
+
+	n := copy(b, data[:len(data)-len(mr.delimiter)+1])
+	mr.reader.Discard(n)
+
+	return n, nil
+}
@@ -0,0 +1,242 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+)
+
+const DEFAULT_MAX_MEMORY = 10 << 20
+const DEFAULT_MAX_PART_BYTES = 32 << 20
+const DEFAULT_MAX_MULTIPART_BYTES = 64 << 20
+
+// MultipartLimits bound parsing a multipart form, zero means the defaults.
+type MultipartLimits struct {
+	// MaxMemory is how much of the files is kept in memory, a file that does not fit goes to a
+	// temporary file on disk. Zero means DEFAULT_MAX_MEMORY.
+	MaxMemory int
+	// MaxPartBytes bounds one part, zero means DEFAULT_MAX_PART_BYTES.
+	MaxPartBytes int
+	// MaxTotalBytes bounds the data of all the parts, zero means DEFAULT_MAX_MULTIPART_BYTES.
+	MaxTotalBytes int
+	// MaxParts counts fields and files, zero means DEFAULT_MAX_FORM_FIELDS.
+	MaxParts int
+	// TempDir is where files are spilled, empty means os.TempDir.
+	TempDir string
+}
+
+type MultipartForm struct {
+	// Values are the plain fields followed by the query, like ParseForm.
+	Values url.Values
+	Files  map[string][]*FileHeader
+}
+
+// FileHeader is an uploaded file, in memory or in a temporary file.
+type FileHeader struct {
+	FileName string
+	Headers  headers.Headers
+	Size     int64
+
+	content []byte
+	path    string
+}
+
+/*
+ParseMultipartForm reads a multipart/form-data body part by part. Plain fields are kept in
+memory, files too until MaxMemory is used up, the rest is written to temporary files the
+caller removes with RemoveAll once done. The fields are also what ParseForm returns afterwards.
+
+The limits are checked as the parts are read, so on a streamed body, see HeadFromReader, an
+upload past them fails before the rest of it is read. A body that was buffered whole is already in memory.
+*/
+func (r *Request) ParseMultipartForm(limits MultipartLimits) (*MultipartForm, error) {
+	if limits.MaxMemory == 0 {
+		limits.MaxMemory = DEFAULT_MAX_MEMORY
+	}
+
+	if limits.MaxPartBytes == 0 {
+		limits.MaxPartBytes = DEFAULT_MAX_PART_BYTES
+	}
+
+	if limits.MaxTotalBytes == 0 {
+		limits.MaxTotalBytes = DEFAULT_MAX_MULTIPART_BYTES
+	}
+
+	if limits.MaxParts == 0 {
+		limits.MaxParts = DEFAULT_MAX_FORM_FIELDS
+	}
+
+	mr, err := r.MultipartReader()
+
+	if err != nil {
+		return nil, err
+	}
+
+	form := &MultipartForm{Values: url.Values{}, Files: map[string][]*FileHeader{}}
+	memory := limits.MaxMemory
+	total := 0
+
+	for parts := 0; ; parts++ {
+		part, err := mr.NextPart()
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+
+		if parts == limits.MaxParts {
+			form.RemoveAll()
+			return nil, fmt.Errorf("%w: more than %d parts", ErrTooManyFormFields, limits.MaxParts)
+		}
+
+		// one more byte than allowed tells a part at the limit from one past it
+		maxPart := min(limits.MaxPartBytes, limits.MaxTotalBytes-total)
+		limited := io.LimitReader(part, int64(maxPart)+1)
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(limited)
+
+			if err == nil && len(value) > maxPart {
+				err = fmt.Errorf("%w: field %q exceeds the limit", ErrBodyTooLarge, part.FormName())
+			}
+
+			if err != nil {
+				form.RemoveAll()
+				return nil, err
+			}
+
+			total += len(value)
+			form.Values.Add(part.FormName(), string(value))
+			continue
+		}
+
+		file, err := saveFile(part, limited, &memory, limits.TempDir)
+
+		if err == nil && file.Size > int64(maxPart) {
+			file.remove()
+			err = fmt.Errorf("%w: file %q exceeds the limit", ErrBodyTooLarge, file.FileName)
+		}
+
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+
+		total += int(file.Size)
+		form.Files[part.FormName()] = append(form.Files[part.FormName()], file)
+	}
+
+	query, err := r.queryValues()
+
+	if err != nil {
+		form.RemoveAll()
+		return nil, err
+	}
+
+	for key, values := range query {
+		form.Values[key] = append(form.Values[key], values...)
+	}
+
+	if r.form == nil {
+		r.form = form.Values
+	}
+
+	return form, nil
+}
+
+// RemoveAll deletes the temporary files of the form.
+func (f *MultipartForm) RemoveAll() error {
+	var errs []error
+
+	for _, files := range f.Files {
+		for _, file := range files {
+			errs = append(errs, file.remove())
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Open returns the content of the file, from memory or from its temporary file.
+func (fh *FileHeader) Open() (io.ReadCloser, error) {
+	if fh.path != "" {
+		return os.Open(fh.path)
+	}
+
+	return io.NopCloser(bytes.NewReader(fh.content)), nil
+}
+
+// InMemory tells whether the file fit in MaxMemory.
+func (fh *FileHeader) InMemory() bool {
+	return fh.path == ""
+}
+
+func (fh *FileHeader) remove() error {
+	if fh.path == "" {
+		return nil
+	}
+
+	err := os.Remove(fh.path)
+	fh.path = ""
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+/**
+* Helpers
+**/
+
+// saveFile keeps the part in memory while *memory allows, then moves it to a temporary file and streams the rest there.
+func saveFile(part *Part, reader io.Reader, memory *int, tempDir string) (*FileHeader, error) {
+	file := &FileHeader{FileName: part.FileName(), Headers: part.Headers}
+	buffer := &bytes.Buffer{}
+
+	n, err := io.Copy(buffer, io.LimitReader(reader, int64(*memory)+1))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if n <= int64(*memory) {
+		*memory -= int(n)
+		file.content = buffer.Bytes()
+		file.Size = n
+		return file, nil
+	}
+
+	temp, err := os.CreateTemp(tempDir, "upload-*")
+
+	if err != nil {
+		return nil, err
+	}
+
+	file.path = temp.Name()
+
+	size, err := io.Copy(temp, io.MultiReader(buffer, reader))
+
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		file.remove()
+		return nil, err
+	}
+
+	file.Size = size
+
+	return file, nil
+}
@@ -0,0 +1,195 @@
+package request
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const multipartBody = "preamble to ignore\r\n" +
+	"--xyz\r\n" +
+	"Content-Disposition: form-data; name=\"title\"\r\n" +
+	"\r\n" +
+	"holiday\r\n" +
+	"--xyz  \r\n" +
+	"Content-Disposition: form-data; name=\"photo\"; filename=\"../../beach.jpg\"\r\n" +
+	"Content-Type: image/jpeg\r\n" +
+	"\r\n" +
+	"\r\n--xy not the boundary\r\n\r\n" +
+	"--xyz\r\n" +
+	"Content-Disposition: form-data; name=\"empty\"\r\n" +
+	"\r\n" +
+	"\r\n" +
+	"--xyz--\r\n" +
+	"epilogue to ignore"
+
+func multipartRequest(t *testing.T, target, body string) *Request {
+	t.Helper()
+
+	r, err := RequestFromReader(strings.NewReader("POST " + target + " HTTP/1.1\r\nHost: localhost\r\n" +
+		"Content-Type: multipart/form-data; boundary=xyz\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body))
+	require.NoError(t, err)
+
+	return r
+}
+
+func TestMultipartReaderStreamsParts(t *testing.T) {
+	for _, bytesPerRead := range []int{1, 3, 4096} {
+		mr, err := NewMultipartReader(&chunkReader{data: multipartBody, numBytesPerRead: bytesPerRead}, "xyz")
+		require.NoError(t, err)
+
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "title", part.FormName())
+		assert.Equal(t, "", part.FileName())
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, "holiday", string(data))
+
+		part, err = mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "photo", part.FormName())
+		assert.Equal(t, "beach.jpg", part.FileName())
+		assert.Equal(t, "image/jpeg", part.Headers["content-type"])
+		data, err = io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, "\r\n--xy not the boundary\r\n", string(data))
+
+		// left unread, NextPart skips it
+		part, err = mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "empty", part.FormName())
+
+		_, err = mr.NextPart()
+		require.ErrorIs(t, err, io.EOF)
+	}
+}
+
+func TestMalformedMultipart(t *testing.T) {
+	for _, body := range []string{
+		"no boundary at all",
+		"--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nnever closed",
+		"--xyz\r\nBad Header : x\r\n\r\nv\r\n--xyz--",
+		"--xyzjunk\r\n\r\nv\r\n--xyz--",
+	} {
+		_, err := multipartRequest(t, "/", body).ParseMultipartForm(MultipartLimits{})
+		require.ErrorIs(t, err, ErrMalformedForm, body)
+	}
+
+	r := formRequest(t, "/", FORM_URLENCODED, "a=1")
+	_, err := r.ParseMultipartForm(MultipartLimits{})
+	require.ErrorIs(t, err, ErrNotMultipart)
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	r := multipartRequest(t, "/upload?album=2024", multipartBody)
+
+	form, err := r.ParseMultipartForm(MultipartLimits{})
+	require.NoError(t, err)
+	defer form.RemoveAll()
+
+	assert.Equal(t, []string{"holiday"}, form.Values["title"])
+	assert.Equal(t, []string{""}, form.Values["empty"])
+	assert.Equal(t, []string{"2024"}, form.Values["album"])
+	assert.Equal(t, "holiday", r.FormValue("title"))
+
+	require.Len(t, form.Files["photo"], 1)
+	file := form.Files["photo"][0]
+	assert.Equal(t, "beach.jpg", file.FileName)
+	assert.Equal(t, int64(25), file.Size)
+	assert.True(t, file.InMemory())
+}
+
+func TestMultipartFilesSpillToDisk(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	body := "--xyz\r\nContent-Disposition: form-data; name=\"small\"; filename=\"a.txt\"\r\n\r\nsmall\r\n" +
+		"--xyz\r\nContent-Disposition: form-data; name=\"big\"; filename=\"b.bin\"\r\n\r\n" + content + "\r\n--xyz--"
+	dir := t.TempDir()
+
+	form, err := multipartRequest(t, "/", body).ParseMultipartForm(MultipartLimits{MaxMemory: 100, TempDir: dir})
+	require.NoError(t, err)
+
+	assert.True(t, form.Files["small"][0].InMemory())
+
+	big := form.Files["big"][0]
+	assert.False(t, big.InMemory())
+	assert.Equal(t, int64(len(content)), big.Size)
+
+	reader, err := big.Open()
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, form.RemoveAll())
+	entries, _ = os.ReadDir(dir)
+	assert.Empty(t, entries)
+}
+
+func TestMultipartLimits(t *testing.T) {
+	part := func(name, value string) string {
+		return "--xyz\r\nContent-Disposition: form-data; name=\"" + name + "\"; filename=\"" + name + "\"\r\n\r\n" + value + "\r\n"
+	}
+	body := part("a", strings.Repeat("a", 60)) + part("b", strings.Repeat("b", 60)) + "--xyz--"
+	dir := t.TempDir()
+
+	_, err := multipartRequest(t, "/", body).ParseMultipartForm(MultipartLimits{MaxPartBytes: 50, MaxMemory: 10, TempDir: dir})
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	_, err = multipartRequest(t, "/", body).ParseMultipartForm(MultipartLimits{MaxTotalBytes: 100, MaxMemory: 10, TempDir: dir})
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	_, err = multipartRequest(t, "/", body).ParseMultipartForm(MultipartLimits{MaxParts: 1})
+	require.ErrorIs(t, err, ErrTooManyFormFields)
+
+	// nothing is left behind by a rejected form
+	entries, _ := os.ReadDir(dir)
+	assert.Empty(t, entries)
+
+	form, err := multipartRequest(t, "/", body).ParseMultipartForm(MultipartLimits{MaxPartBytes: 60, MaxTotalBytes: 120})
+	require.NoError(t, err)
+	assert.Len(t, form.Files, 2)
+}
+
+// stopReader fails the test if the body is read past where a limit should have stopped it.
+type stopReader struct {
+	t       *testing.T
+	reader  io.Reader
+	read    int
+	stopped int
+}
+
+func (s *stopReader) Read(p []byte) (int, error) {
+	n, err := s.reader.Read(p[:min(len(p), 64)])
+	s.read += n
+
+	if s.read > s.stopped {
+		s.t.Errorf("read %d bytes, past the %d the limit needed", s.read, s.stopped)
+	}
+
+	return n, err
+}
+
+func TestStreamedMultipartStopsAtTheLimit(t *testing.T) {
+	body := "--xyz\r\nContent-Disposition: form-data; name=\"big\"; filename=\"b.bin\"\r\n\r\n" +
+		strings.Repeat("0123456789", 10_000) + "\r\n--xyz--"
+	head := "POST / HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=xyz\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n"
+	reader := &stopReader{t: t, reader: strings.NewReader(head + body), stopped: len(head) + 8*1024}
+
+	r, err := HeadFromReader(reader, Limits{})
+	require.NoError(t, err)
+
+	_, err = r.ParseMultipartForm(MultipartLimits{MaxPartBytes: 1024, TempDir: t.TempDir()})
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+}
@@ -2,13 +2,20 @@ package request
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/sithusan/httpfromtcp/internal/transfer"
 )
 
 type requestStatus int
@@ -22,6 +29,17 @@ const (
 
 const KEY_CONTENT_LENGTH = "Content-Length"
 
+var ErrHeadersTooLarge = errors.New("request headers too large")
+var ErrBodyTooLarge = errors.New("request body too large")
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+// Limits bound what a client can make the parser buffer, zero means no limit.
+type Limits struct {
+	// MaxHeaderBytes counts the request line and the header section
+	MaxHeaderBytes int
+	MaxBodyBytes   int
+}
+
 type RequestLine struct {
 	HttpVersion   string
 	RequestTarget string
@@ -32,9 +50,87 @@ type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
+	// Trailers are the fields sent after a chunked body, nil when there were none.
+	Trailers headers.Headers
+
+	// RemoteAddr is the address of the peer that sent the request, "ip:port".
+	RemoteAddr string
+
+	// TLS is the negotiated connection state (version, cipher suite, peer certificates),
+	// it is nil when the request came over plain TCP.
+	TLS *tls.ConnectionState
+
+	// ctx carries what middleware attach for the handlers after them, see Context
+	ctx context.Context
+	// form is kept by ParseForm
+	form url.Values
+	// clientIP is set by SetClientIP, see ClientIP
+	clientIP string
 
 	requestStatus  requestStatus
 	readBodyLength int
+	headerBytes    int
+	limits         Limits
+	chunks         *transfer.ChunkedDecoder
+	unread         []byte
+
+	// headOnly stops the parser at the body, which is then streamed through body, see HeadFromReader
+	headOnly bool
+	body     io.Reader
+	length   int64
+	bodyDone chan struct{}
+	bodyOnce sync.Once
+}
+
+// ClientCertificate is the client certificate verified against the server's client CAs, nil when there is none.
+func (r *Request) ClientCertificate() *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// Context is the context of the request, never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+
+	return r.ctx
+}
+
+// SetContext replaces the context, like a middleware attaching a value with context.WithValue.
+func (r *Request) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+/*
+ClientIP is the address of the client without the port: the host of RemoteAddr, unless a
+trusted proxy in between told who it forwards for, see SetClientIP.
+*/
+func (r *Request) ClientIP() string {
+	if r.clientIP != "" {
+		return r.clientIP
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// SetClientIP replaces the address ClientIP returns, like the server does for a request relayed by a trusted proxy.
+func (r *Request) SetClientIP(ip string) {
+	r.clientIP = ip
+}
+
+// Unread is what was read from the connection past the end of the request, like the first bytes sent through a tunnel.
+func (r *Request) Unread() []byte {
+	return r.unread
 }
 
 func (r *Request) done() bool {
@@ -44,7 +140,7 @@ func (r *Request) done() bool {
 func (r *Request) parse(data []byte) (int, error) {
 	totalByteParsed := 0
 
-	for !r.done() {
+	for !r.done() && !r.headDone() {
 		singleByteParsed, err := r.parseSingle(data[totalByteParsed:])
 
 		if err != nil {
@@ -67,9 +163,13 @@ State Machine
 func (r *Request) parseSingle(data []byte) (int, error) {
 	switch r.requestStatus {
 	case initialized:
-		return r.requestParsingRequestLine(data)
+		n, err := r.requestParsingRequestLine(data)
+		r.headerBytes += n
+		return n, err
 	case requestStateParsingHeaders:
-		return r.requestParsingHeaders(data)
+		n, err := r.requestParsingHeaders(data)
+		r.headerBytes += n
+		return n, err
 	case requestStateParsingBody:
 		return r.requestParsingBody(data)
 	case done:
@@ -127,13 +227,25 @@ This word, or the adjective "RECOMMENDED", mean that
 there may exist valid reasons in particular circumstances to ignore a particular item,
 but the full implications must be understood and carefully weighed before choosing a different course.
 So, going to assume that if there is no Content-Length header, there is no body present.
+Unless the body is chunked, then its length is told chunk by chunk.
 */
 func (r *Request) requestParsingBody(data []byte) (int, error) {
+	chunked, err := r.chunked()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if chunked {
+		return r.requestParsingChunkedBody(data)
+	}
+
 	contentLengthStr, ok := r.Headers.Get(KEY_CONTENT_LENGTH)
 
 	if !ok {
 		r.requestStatus = done
-		return len(data), nil
+		// whatever follows belongs to the next request, or to the tunnel of a CONNECT
+		return 0, nil
 	}
 
 	contentLength, err := strconv.Atoi(contentLengthStr)
@@ -142,6 +254,10 @@ func (r *Request) requestParsingBody(data []byte) (int, error) {
 		return 0, fmt.Errorf("malformed Content-Length: %s", err)
 	}
 
+	if r.limits.MaxBodyBytes > 0 && contentLength > r.limits.MaxBodyBytes {
+		return 0, fmt.Errorf("%w: Content-Length %d exceeds %d", ErrBodyTooLarge, contentLength, r.limits.MaxBodyBytes)
+	}
+
 	r.Body = append(r.Body, data...)
 	r.readBodyLength += len(data)
 
@@ -229,15 +345,38 @@ var supportedMethods = map[string]struct{}{
 	"PATCH":  {},
 	"DELETE": {},
 	"HEAD":   {},
+	// "OPTIONS *" asks about the server as a whole rather than a resource (RFC9110 9.3.7)
+	"OPTIONS": {},
+	// the target of CONNECT is a "host:port" authority, used to open a tunnel through a proxy
+	"CONNECT": {},
 }
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	return RequestFromReaderWithLimits(reader, Limits{})
+}
+
+func RequestFromReaderWithLimits(reader io.Reader, limits Limits) (*Request, error) {
+	request, unread, err := requestFromReader(reader, limits, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.unread = unread
+
+	return request, nil
+}
+
+// requestFromReader returns what was read past the request, or past the head when headOnly.
+func requestFromReader(reader io.Reader, limits Limits, headOnly bool) (*Request, []byte, error) {
 
 	buffer := make([]byte, 8)
 	readToIndex := 0
 	request := NewRequest()
+	request.limits = limits
+	request.headOnly = headOnly
 
-	for !request.done() {
+	for !request.done() && !request.headDone() {
 		// buffer resizing
 		if len(buffer) <= readToIndex {
 			newBuffer := make([]byte, (len(buffer) * 2))
@@ -250,17 +389,17 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				if request.requestStatus != done {
-					return nil, fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d", request.requestStatus, readedBytes)
+					return nil, nil, fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d", request.requestStatus, readedBytes)
 				}
 			}
-			return nil, err
+			return nil, nil, err
 		}
 
 		readToIndex += readedBytes
 		parsedBytes, err := request.parse(buffer[:readToIndex])
 
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		// remove the used ones
@@ -268,9 +407,24 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		// Example: if parsedBytes is 3, copy buffer[3:] to buffer[0:]
 		copy(buffer, buffer[parsedBytes:readToIndex])
 		readToIndex -= parsedBytes
+
+		// unparsed bytes still waiting for a CRLF count too, otherwise an endless header line would grow the buffer forever
+		pendingHeaderBytes := 0
+
+		if request.requestStatus < requestStateParsingBody {
+			pendingHeaderBytes = readToIndex
+		}
+
+		if limits.MaxHeaderBytes > 0 && request.headerBytes+pendingHeaderBytes > limits.MaxHeaderBytes {
+			return nil, nil, fmt.Errorf("%w: more than %d bytes", ErrHeadersTooLarge, limits.MaxHeaderBytes)
+		}
 	}
 
-	return request, nil
+	if readToIndex == 0 {
+		return request, nil, nil
+	}
+
+	return request, append([]byte{}, buffer[:readToIndex]...), nil
 }
 
 /**
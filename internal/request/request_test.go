@@ -2,6 +2,7 @@ package request
 
 import (
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -257,3 +258,121 @@ func TestBodyBiggerThanReportedContentLength(t *testing.T) {
 	_, err := RequestFromReader(reader)
 	require.Error(t, err)
 }
+
+func TestHeadersBiggerThanLimit(t *testing.T) {
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+
+	_, err := RequestFromReaderWithLimits(reader, Limits{MaxHeaderBytes: 32})
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+}
+
+func TestEndlessHeaderLineBiggerThanLimit(t *testing.T) {
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nX-Padding: " + strings.Repeat("a", 1024),
+		numBytesPerRead: 64,
+	}
+
+	_, err := RequestFromReaderWithLimits(reader, Limits{MaxHeaderBytes: 256})
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+}
+
+func TestBodyBiggerThanLimit(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+
+	_, err := RequestFromReaderWithLimits(reader, Limits{MaxBodyBytes: 12})
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestRequestWithinLimits(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 100,
+	}
+
+	r, err := RequestFromReaderWithLimits(reader, Limits{MaxHeaderBytes: 68, MaxBodyBytes: 13})
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+}
+
+func TestChunkedBody(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6;name=value\r\n" +
+			"hello \r\n" +
+			"7\r\n" +
+			"world!\n\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, "abc", r.Trailers["x-checksum"])
+}
+
+func TestMalformedChunkedBody(t *testing.T) {
+	for _, body := range []string{
+		"zz\r\nhello\r\n0\r\n\r\n",
+		"5\r\nhelloX\r\n0\r\n\r\n",
+		"-5\r\nhello\r\n0\r\n\r\n",
+	} {
+		reader := &chunkReader{
+			data:            "POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" + body,
+			numBytesPerRead: 3,
+		}
+
+		_, err := RequestFromReader(reader)
+		require.Error(t, err, body)
+	}
+
+	reader := &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\nhello",
+		numBytesPerRead: 3,
+	}
+
+	_, err := RequestFromReader(reader)
+	require.Error(t, err)
+}
+
+func TestChunkedBodyBiggerThanLimit(t *testing.T) {
+	reader := &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n8\r\nhello wo\r\n8\r\nrld!!!!!\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+
+	_, err := RequestFromReaderWithLimits(reader, Limits{MaxBodyBytes: 12})
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestUnreadBytesPastTheRequest(t *testing.T) {
+	reader := &chunkReader{
+		data:            "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n\x16\x03\x01",
+		numBytesPerRead: 100,
+	}
+
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "CONNECT", r.RequestLine.Method)
+	assert.Equal(t, "example.com:443", r.RequestLine.RequestTarget)
+	assert.Equal(t, []byte("\x16\x03\x01"), r.Unread())
+}
@@ -9,9 +9,9 @@ import (
 
 func GetDefaultHeaders(contentLen int) headers.Headers {
 	headers := headers.NewHeaders()
-	headers["Content-Length"] = strconv.Itoa(contentLen)
-	headers["Content-Type"] = "text/plain"
-	headers["Connection"] = "close" // Keep alive will later
+	headers.Override("Content-Length", strconv.Itoa(contentLen))
+	headers.Override("Content-Type", "text/plain")
+	headers.Override("Connection", "close") // Keep alive will later
 
 	return headers
 }
@@ -25,11 +25,24 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 		w.WriterState = WriteBody
 	}()
 
+	w.bodyless = w.suppressBody || !BodyAllowed(w.statusCode)
+
+	// According to RFC9110 8.6 and RFC9112 6.1, 1xx and 204 responses carry no framing fields at all.
+	// A 304 keeps its Content-Length, which is that of the 200 it stands for, like a HEAD response does.
+	if w.statusCode < 200 || w.statusCode == NO_CONTENT {
+		headers.Remove("Content-Length")
+		headers.Remove("Transfer-Encoding")
+	}
+
+	if w.stream != nil {
+		return w.stream.WriteHead(w.statusCode, headers)
+	}
+
 	headerString := ""
 
-	for key, value := range headers {
+	headers.Each(func(key, value string) {
 		headerString += fmt.Sprintf("%s: %s \r\n", key, value)
-	}
+	})
 
 	headerString += "\r\n"
 
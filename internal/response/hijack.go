@@ -0,0 +1,53 @@
+package response
+
+import (
+	"bufio"
+	"errors"
+	"net"
+)
+
+var ErrNotHijackable = errors.New("response writer is not hijackable")
+
+// NewConnWriter is a Writer on a connection that a handler can take over with Hijack.
+func NewConnWriter(conn net.Conn, reader *bufio.Reader) *Writer {
+	writer := NewWriter(conn)
+	writer.conn = conn
+	writer.reader = reader
+
+	return writer
+}
+
+// OnHijack sets f to run before the connection is handed over, like the server stopping its own reads on it.
+func (w *Writer) OnHijack(f func()) {
+	w.onHijack = f
+}
+
+/*
+Hijack hands the connection to the handler, for protocols that stop speaking HTTP like a CONNECT
+tunnel. The reader holds what the client already sent past the request. Nothing can be written
+through w afterwards, and the server closes the connection once the handler returns.
+Only an HTTP/1.1 Writer that has not written anything yet can be hijacked.
+*/
+func (w *Writer) Hijack() (net.Conn, *bufio.Reader, error) {
+	if w.parent != nil && w.WriterState == WriteStatusLine {
+		conn, reader, err := w.parent.Hijack()
+
+		if err == nil {
+			w.WriterState = Done
+		}
+
+		return conn, reader, err
+	}
+
+	if w.conn == nil || w.WriterState != WriteStatusLine {
+		return nil, nil, ErrNotHijackable
+	}
+
+	w.WriterState = Done
+
+	if w.onHijack != nil {
+		w.onHijack()
+	}
+
+	return w.conn, w.reader, nil
+}
@@ -0,0 +1,117 @@
+package response
+
+import (
+	"encoding/json"
+)
+
+const CONTENT_TYPE_JSON = "application/json"
+const CONTENT_TYPE_PROBLEM_JSON = "application/problem+json"
+
+/*
+WriteJSON writes v as the whole response with its status, Content-Type and Content-Length.
+v is encoded before anything is written, so when it cannot be the handler can still answer 500.
+*/
+func WriteJSON(w *Writer, statusCode StatusCode, v any) error {
+	body, err := json.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	return writeJSONBody(w, statusCode, CONTENT_TYPE_JSON, append(body, '\n'))
+}
+
+/*
+According to RFC9457, a problem details object tells a client what went wrong in a form it can
+read, instead of a page meant for a person:
+
+	HTTP/1.1 403 Forbidden
+	Content-Type: application/problem+json
+
+	{"type":"https://example.com/probs/out-of-credit","title":"You do not have enough credit.",
+	 "status":403,"detail":"Your balance is 30, but that costs 50.","balance":30}
+
+Type defaults to "about:blank", the problem is then just the status code, and Title to its reason phrase.
+Extensions are extra members, like "balance" above.
+*/
+type Problem struct {
+	Type       string
+	Title      string
+	Status     StatusCode
+	Detail     string
+	Instance   string
+	Extensions map[string]any
+}
+
+func NewProblem(statusCode StatusCode, detail string) Problem {
+	return Problem{Status: statusCode, Detail: detail}
+}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	members := map[string]any{}
+
+	for key, value := range p.Extensions {
+		members[key] = value
+	}
+
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+
+	if p.Title == "" && p.Type == "about:blank" {
+		p.Title = ReasonPhrase(p.Status)
+	}
+
+	// the standard members win over extensions with the same name
+	members["type"] = p.Type
+	members["status"] = int(p.Status)
+
+	for key, value := range map[string]string{"title": p.Title, "detail": p.Detail, "instance": p.Instance} {
+		if value != "" {
+			members[key] = value
+		} else {
+			delete(members, key)
+		}
+	}
+
+	return json.Marshal(members)
+}
+
+// WriteProblem writes p as application/problem+json with p.Status.
+func WriteProblem(w *Writer, p Problem) error {
+	body, err := json.Marshal(p)
+
+	if err != nil {
+		return err
+	}
+
+	return writeJSONBody(w, p.Status, CONTENT_TYPE_PROBLEM_JSON, append(body, '\n'))
+}
+
+/**
+* Helpers
+**/
+
+// According to RFC9110 15.3.5 and 15.4.5, 204 and 304 responses end with their headers.
+func writeJSONBody(w *Writer, statusCode StatusCode, contentType string, body []byte) error {
+	headers := GetDefaultHeaders(len(body))
+	headers.Override("Content-Type", contentType)
+
+	if statusCode == NO_CONTENT || statusCode == NOT_MODIFIED {
+		body = nil
+		headers.Remove("Content-Length")
+		headers.Remove("Content-Type")
+	}
+
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+
+	if err := w.WriteHeaders(headers); err != nil {
+		return err
+	}
+
+	_, err := w.WriteBody(body)
+
+	return err
+}
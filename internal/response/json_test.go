@@ -0,0 +1,63 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteJSON(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	require.NoError(t, WriteJSON(NewWriter(buffer), CREATED, map[string]any{"id": 7}))
+
+	r, err := ResponseFromReader(buffer, "POST")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(CREATED), r.StatusLine.StatusCode)
+	assert.Equal(t, "Created", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, CONTENT_TYPE_JSON, r.Headers["content-type"])
+	assert.Equal(t, "9", r.Headers["content-length"])
+	assert.Equal(t, "{\"id\":7}\n", string(r.Body))
+
+	// a value that cannot be encoded writes nothing
+	buffer.Reset()
+	w := NewWriter(buffer)
+	require.Error(t, WriteJSON(w, OK, math.Inf(1)))
+	assert.Empty(t, buffer.String())
+	assert.Equal(t, WriteStatusLine, w.WriterState)
+
+	buffer.Reset()
+	require.NoError(t, WriteJSON(NewWriter(buffer), NO_CONTENT, map[string]any{"ignored": true}))
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\nconnection: close \r\n\r\n", buffer.String())
+}
+
+func TestWriteProblem(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	problem := NewProblem(FORBIDDEN, "Your balance is 30, but that costs 50.")
+	problem.Extensions = map[string]any{"balance": 30, "status": "overridden"}
+
+	require.NoError(t, WriteProblem(NewWriter(buffer), problem))
+
+	r, err := ResponseFromReader(buffer, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(FORBIDDEN), r.StatusLine.StatusCode)
+	assert.Equal(t, CONTENT_TYPE_PROBLEM_JSON, r.Headers["content-type"])
+
+	members := map[string]any{}
+	require.NoError(t, json.Unmarshal(r.Body, &members))
+	assert.Equal(t, map[string]any{
+		"type":    "about:blank",
+		"title":   "Forbidden",
+		"status":  float64(403),
+		"detail":  "Your balance is 30, but that costs 50.",
+		"balance": float64(30),
+	}, members)
+
+	// a problem type of its own has no default title
+	body, err := json.Marshal(Problem{Type: "https://example.com/probs/out-of-credit", Status: FORBIDDEN})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"https://example.com/probs/out-of-credit","status":403}`, string(body))
+}
@@ -0,0 +1,482 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/sithusan/httpfromtcp/internal/transfer"
+)
+
+type responseStatus int
+
+const (
+	responseStateStatusLine responseStatus = iota
+	responseStateParsingHeaders
+	responseStateParsingBody
+	responseStateDone
+)
+
+const KEY_CONTENT_LENGTH = "Content-Length"
+const KEY_TRANSFER_ENCODING = "Transfer-Encoding"
+
+var ErrHeadersTooLarge = errors.New("response headers too large")
+var ErrBodyTooLarge = errors.New("response body too large")
+
+var CLRF = []byte("\r\n")
+
+// Limits bound what a server can make the parser buffer, zero means no limit.
+type Limits struct {
+	// MaxHeaderBytes counts the status line and the header section
+	MaxHeaderBytes int
+	MaxBodyBytes   int
+}
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+	Body       []byte
+	// Trailers are the fields sent after a chunked body, nil when there were none.
+	Trailers headers.Headers
+
+	// method is the method of the request this response answers, it decides whether a body follows
+	method         string
+	responseStatus responseStatus
+	headerBytes    int
+	limits         Limits
+	contentLength  int
+	closeDelimited bool
+	chunked        bool
+	chunks         *transfer.ChunkedDecoder
+	unread         []byte
+
+	// headOnly stops the parser at the body, which is then read through BodyReader from reader, pending first
+	headOnly bool
+	reader   io.Reader
+	pending  []byte
+	body     io.Reader
+}
+
+func NewResponse(method string) *Response {
+	return &Response{
+		Headers:        headers.NewHeaders(),
+		method:         method,
+		responseStatus: responseStateStatusLine,
+	}
+}
+
+// Unread is what was read from the connection past the end of the response, like the next response or the bytes of a switched protocol.
+func (r *Response) Unread() []byte {
+	return r.unread
+}
+
+// According to RFC9112 9.3, HTTP/1.1 connections persist unless "Connection: close", HTTP/1.0 ones only with "keep-alive".
+// A body that ends when the connection closes leaves nothing to persist.
+func (r *Response) Persistent() bool {
+	if r.closeDelimited {
+		return false
+	}
+
+	connection, _ := r.Headers.Get("Connection")
+	connection = strings.ToLower(connection)
+
+	if r.StatusLine.HttpVersion == "1.0" {
+		return strings.Contains(connection, "keep-alive")
+	}
+
+	return !strings.Contains(connection, "close")
+}
+
+func (r *Response) done() bool {
+	return r.responseStatus == responseStateDone
+}
+
+// headDone is true once a head-only parse reached the body, see ResponseHeadFromReader.
+func (r *Response) headDone() bool {
+	return r.headOnly && r.responseStatus == responseStateParsingBody
+}
+
+/*
+BodyReader streams the body of a response read by ResponseHeadFromReader off the connection,
+decoding chunks as they come, with the limits of the parse. It ends with io.EOF once the body is complete,
+the Trailers and Unread are set from then on. For a response parsed whole it reads Body.
+*/
+func (r *Response) BodyReader() io.Reader {
+	if r.body != nil {
+		return r.body
+	}
+
+	if r.reader == nil {
+		r.body = bytes.NewReader(r.Body)
+		return r.body
+	}
+
+	switch {
+	case r.chunked:
+		r.body = &chunkedBody{
+			ChunkedReader: transfer.NewChunkedReader(r.reader, transfer.ChunkedReaderOptions{
+				Pending:     r.pending,
+				MaxBytes:    int64(r.limits.MaxBodyBytes),
+				ErrTooLarge: ErrBodyTooLarge,
+			}),
+			response: r,
+		}
+	case r.closeDelimited:
+		// According to RFC9112 6.3, a body without a length is complete when the connection closes
+		r.body = io.MultiReader(bytes.NewReader(r.pending), r.reader)
+
+		if r.limits.MaxBodyBytes > 0 {
+			r.body = transfer.NewLimitReader(r.body, int64(r.limits.MaxBodyBytes), ErrBodyTooLarge)
+		}
+	default:
+		n := min(len(r.pending), r.contentLength)
+
+		if len(r.pending) > n {
+			r.unread = r.pending[n:]
+		}
+
+		r.body = transfer.NewLengthReader(io.MultiReader(bytes.NewReader(r.pending[:n]), r.reader), int64(r.contentLength))
+	}
+
+	r.pending = nil
+
+	return r.body
+}
+
+// chunkedBody hands the trailers and what was read past the body to the response once the body ends.
+type chunkedBody struct {
+	*transfer.ChunkedReader
+	response *Response
+}
+
+func (b *chunkedBody) Read(p []byte) (int, error) {
+	n, err := b.ChunkedReader.Read(p)
+
+	if errors.Is(err, io.EOF) {
+		b.response.Trailers = b.Trailers()
+		b.response.unread = b.Unread()
+	}
+
+	return n, err
+}
+
+func (r *Response) parse(data []byte) (int, error) {
+	totalByteParsed := 0
+
+	for !r.done() && !r.headDone() {
+		singleByteParsed, err := r.parseSingle(data[totalByteParsed:])
+
+		if err != nil {
+			return 0, err
+		}
+
+		totalByteParsed += singleByteParsed
+
+		if singleByteParsed == 0 {
+			break
+		}
+	}
+
+	return totalByteParsed, nil
+}
+
+/*
+State Machine
+*/
+func (r *Response) parseSingle(data []byte) (int, error) {
+	switch r.responseStatus {
+	case responseStateStatusLine:
+		n, err := r.responseParsingStatusLine(data)
+		r.headerBytes += n
+		return n, err
+	case responseStateParsingHeaders:
+		n, err := r.responseParsingHeaders(data)
+		r.headerBytes += n
+		return n, err
+	case responseStateParsingBody:
+		return r.responseParsingBody(data)
+	case responseStateDone:
+		return 0, fmt.Errorf("error: trying to read the data in done state")
+	default:
+		return 0, fmt.Errorf("error: unknown state")
+	}
+}
+
+/*
+status line --> parsing headers --> parsing body -> done
+
+According to RFC9110 15.2, interim responses (100 Continue, 103 Early Hints) come before
+the final one, so their headers are dropped and the parser starts over on the next status line.
+101 Switching Protocols is final, whatever follows it speaks the new protocol.
+*/
+func (r *Response) responseParsingHeaders(data []byte) (int, error) {
+	n, headerDone, err := r.Headers.Parse(data)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !headerDone {
+		return n, nil
+	}
+
+	statusCode := r.StatusLine.StatusCode
+
+	if statusCode >= 100 && statusCode < 200 && statusCode != SWITCHING_PROTOCOLS {
+		r.Headers = headers.NewHeaders()
+		r.responseStatus = responseStateStatusLine
+		return n, nil
+	}
+
+	if err := r.bodyLength(); err != nil {
+		return 0, err
+	}
+
+	r.responseStatus = responseStateParsingBody
+
+	return n, nil
+}
+
+/*
+According to RFC9112 6.3, the length of a response body is decided in this order:
+  - responses to HEAD, 1xx, 204, 304 and 2xx to CONNECT never have one
+  - a Transfer-Encoding ending in chunked is read chunk by chunk, any other ends with the connection
+  - then Content-Length
+  - without either the body runs until the server closes the connection
+*/
+func (r *Response) bodyLength() error {
+	statusCode := r.StatusLine.StatusCode
+
+	if r.method == "HEAD" || statusCode < 200 || statusCode == 204 || statusCode == NOT_MODIFIED ||
+		(r.method == "CONNECT" && statusCode < 300) {
+		return nil
+	}
+
+	if transferEncoding, ok := r.Headers.Get(KEY_TRANSFER_ENCODING); ok {
+		codings := strings.Split(transferEncoding, ",")
+		r.chunked = strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+		r.closeDelimited = !r.chunked
+		return nil
+	}
+
+	contentLengthStr, ok := r.Headers.Get(KEY_CONTENT_LENGTH)
+
+	if !ok {
+		r.closeDelimited = true
+		return nil
+	}
+
+	contentLength, err := strconv.Atoi(strings.TrimSpace(contentLengthStr))
+
+	if err != nil || contentLength < 0 {
+		return fmt.Errorf("malformed Content-Length: %q", contentLengthStr)
+	}
+
+	if r.limits.MaxBodyBytes > 0 && contentLength > r.limits.MaxBodyBytes {
+		return fmt.Errorf("%w: Content-Length %d exceeds %d", ErrBodyTooLarge, contentLength, r.limits.MaxBodyBytes)
+	}
+
+	r.contentLength = contentLength
+
+	return nil
+}
+
+func (r *Response) responseParsingBody(data []byte) (int, error) {
+	if r.chunked {
+		return r.responseParsingChunkedBody(data)
+	}
+
+	if r.closeDelimited {
+		if r.limits.MaxBodyBytes > 0 && len(r.Body)+len(data) > r.limits.MaxBodyBytes {
+			return 0, fmt.Errorf("%w: body exceeds %d", ErrBodyTooLarge, r.limits.MaxBodyBytes)
+		}
+
+		// only the end of the connection ends it, see ResponseFromReaderWithLimits
+		r.Body = append(r.Body, data...)
+		return len(data), nil
+	}
+
+	n := min(len(data), r.contentLength-len(r.Body))
+	r.Body = append(r.Body, data[:n]...)
+
+	if len(r.Body) == r.contentLength {
+		r.responseStatus = responseStateDone
+	}
+
+	return n, nil
+}
+
+// responseParsingChunkedBody feeds the body to the decoder the request parser uses too, see transfer.ChunkedDecoder.
+func (r *Response) responseParsingChunkedBody(data []byte) (int, error) {
+	if r.chunks == nil {
+		r.chunks = transfer.NewChunkedDecoder()
+	}
+
+	n, chunk, err := r.chunks.Decode(data)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if r.limits.MaxBodyBytes > 0 && r.chunks.Length() > int64(r.limits.MaxBodyBytes) {
+		return 0, fmt.Errorf("%w: chunked body exceeds %d", ErrBodyTooLarge, r.limits.MaxBodyBytes)
+	}
+
+	r.Body = append(r.Body, chunk...)
+
+	if r.chunks.Done() {
+		r.Trailers = r.chunks.Trailers
+		r.responseStatus = responseStateDone
+	}
+
+	return n, nil
+}
+
+func (r *Response) responseParsingStatusLine(data []byte) (int, error) {
+	idx := bytes.Index(data, CLRF)
+
+	// just needs more data
+	if idx == -1 {
+		return 0, nil
+	}
+
+	statusLine, err := parseStatusLine(data[:idx])
+
+	if err != nil {
+		return 0, err
+	}
+
+	r.StatusLine = statusLine
+	r.responseStatus = responseStateParsingHeaders
+
+	return idx + len(CLRF), nil
+}
+
+// ResponseFromReader reads one response to a request made with method, "HEAD" responses have no body.
+func ResponseFromReader(reader io.Reader, method string) (*Response, error) {
+	return ResponseFromReaderWithLimits(reader, method, Limits{})
+}
+
+func ResponseFromReaderWithLimits(reader io.Reader, method string, limits Limits) (*Response, error) {
+	return responseFromReader(reader, method, limits, false)
+}
+
+/*
+ResponseHeadFromReader reads the status line and the headers only, so a proxy can pass the body
+on as it arrives instead of holding it whole. The body is then read through BodyReader,
+reader must not be read from before that.
+*/
+func ResponseHeadFromReader(reader io.Reader, method string, limits Limits) (*Response, error) {
+	return responseFromReader(reader, method, limits, true)
+}
+
+func responseFromReader(reader io.Reader, method string, limits Limits, headOnly bool) (*Response, error) {
+
+	buffer := make([]byte, 8)
+	readToIndex := 0
+	response := NewResponse(method)
+	response.limits = limits
+	response.headOnly = headOnly
+
+	for !response.done() && !response.headDone() {
+		// buffer resizing
+		if len(buffer) <= readToIndex {
+			newBuffer := make([]byte, (len(buffer) * 2))
+			copy(newBuffer, buffer)
+			buffer = newBuffer
+		}
+
+		readedBytes, readErr := reader.Read(buffer[readToIndex:])
+
+		readToIndex += readedBytes
+		parsedBytes, err := response.parse(buffer[:readToIndex])
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Shift the unparsed data to the beginning of the buffer
+		copy(buffer, buffer[parsedBytes:readToIndex])
+		readToIndex -= parsedBytes
+
+		pendingHeaderBytes := 0
+
+		if response.responseStatus < responseStateParsingBody {
+			pendingHeaderBytes = readToIndex
+		}
+
+		if limits.MaxHeaderBytes > 0 && response.headerBytes+pendingHeaderBytes > limits.MaxHeaderBytes {
+			return nil, fmt.Errorf("%w: more than %d bytes", ErrHeadersTooLarge, limits.MaxHeaderBytes)
+		}
+
+		if readErr == nil || response.done() || response.headDone() {
+			continue
+		}
+
+		if !errors.Is(readErr, io.EOF) {
+			return nil, readErr
+		}
+
+		// According to RFC9112 6.3, a body without a length is complete when the connection closes
+		if response.responseStatus == responseStateParsingBody && response.closeDelimited {
+			break
+		}
+
+		// a connection closed before the first byte is not a broken response, it is none at all
+		if response.headerBytes == 0 && readToIndex == 0 {
+			return nil, io.EOF
+		}
+
+		return nil, fmt.Errorf("incomplete response, in state: %d: %w", response.responseStatus, io.ErrUnexpectedEOF)
+	}
+
+	if response.headDone() {
+		response.reader = reader
+		response.pending = append([]byte{}, buffer[:readToIndex]...)
+		return response, nil
+	}
+
+	response.responseStatus = responseStateDone
+
+	if readToIndex > 0 {
+		response.unread = append([]byte{}, buffer[:readToIndex]...)
+	}
+
+	return response, nil
+}
+
+/**
+* Helpers
+**/
+
+// parseStatusLine reads "HTTP/1.1 200 OK", the reason phrase may be empty.
+func parseStatusLine(line []byte) (StatusLine, error) {
+	version, rest, ok := strings.Cut(string(line), " ")
+
+	if !ok || (version != "HTTP/1.1" && version != "HTTP/1.0") {
+		return StatusLine{}, fmt.Errorf("malformed status line: %q", line)
+	}
+
+	code, reason, _ := strings.Cut(rest, " ")
+	statusCode, err := strconv.Atoi(code)
+
+	if err != nil || len(code) != 3 || statusCode < 100 {
+		return StatusLine{}, fmt.Errorf("malformed status code: %q", code)
+	}
+
+	return StatusLine{
+		HttpVersion:  strings.TrimPrefix(version, "HTTP/"),
+		StatusCode:   StatusCode(statusCode),
+		ReasonPhrase: strings.TrimSpace(reason),
+	}, nil
+}
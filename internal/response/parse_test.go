@@ -0,0 +1,263 @@
+package response
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call
+// its useful for simulating reading a variable number of bytes per chunk from a network connection
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+
+	endIndex := min(cr.pos+cr.numBytesPerRead, len(cr.data))
+
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+
+	return n, nil
+}
+
+func TestResponseWithContentLength(t *testing.T) {
+	reader := &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 13\r\n\r\nhello, world!HTTP/1.1 204",
+		numBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", r.StatusLine.HttpVersion)
+	assert.Equal(t, StatusCode(OK), r.StatusLine.StatusCode)
+	assert.Equal(t, "OK", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, "text/plain", r.Headers["content-type"])
+	assert.Equal(t, "hello, world!", string(r.Body))
+	assert.True(t, r.Persistent())
+
+	// whatever was read past the body is kept for the next response
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 204", string(r.Unread())+string(rest))
+}
+
+func TestResponseWithChunkedBody(t *testing.T) {
+	reader := &chunkReader{
+		data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"5;ext=1\r\nhello\r\n7\r\n, world\r\n0\r\nX-Checksum: abc\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(r.Body))
+	assert.Equal(t, "abc", r.Trailers["x-checksum"])
+
+	// Malformed chunk
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+}
+
+func TestResponsesWithoutBody(t *testing.T) {
+	// HEAD keeps Content-Length but sends no body
+	reader := &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 13\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err := ResponseFromReader(reader, "HEAD")
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+
+	for _, data := range []string{
+		"HTTP/1.1 204 No Content\r\n\r\n",
+		"HTTP/1.1 304 Not Modified\r\nContent-Length: 13\r\nETag: \"x\"\r\n\r\n",
+	} {
+		r, err := ResponseFromReader(&chunkReader{data: data, numBytesPerRead: 3}, "GET")
+		require.NoError(t, err)
+		assert.Empty(t, r.Body)
+		assert.True(t, r.Persistent())
+	}
+
+	// Interim responses are skipped
+	reader = &chunkReader{
+		data:            "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </style.css>\r\n\r\nHTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok",
+		numBytesPerRead: 7,
+	}
+	r, err = ResponseFromReader(reader, "POST")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(201), r.StatusLine.StatusCode)
+	assert.Equal(t, "Created", r.StatusLine.ReasonPhrase)
+	_, ok := r.Headers.Get("Link")
+	assert.False(t, ok)
+	assert.Equal(t, "ok", string(r.Body))
+
+	// 101 is final, what follows belongs to the new protocol
+	reader = &chunkReader{
+		data:            "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n\x81\x02hi",
+		numBytesPerRead: 64,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(SWITCHING_PROTOCOLS), r.StatusLine.StatusCode)
+	assert.Equal(t, "\x81\x02hi", string(r.Unread()))
+}
+
+func TestCloseDelimitedResponse(t *testing.T) {
+	reader := &chunkReader{
+		data:            "HTTP/1.0 200 OK\r\n\r\nuntil the connection closes",
+		numBytesPerRead: 6,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.StatusLine.HttpVersion)
+	assert.Equal(t, "until the connection closes", string(r.Body))
+	assert.False(t, r.Persistent())
+
+	// A body with a length cut short is an error
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort",
+		numBytesPerRead: 6,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Nothing at all is io.EOF
+	_, err = ResponseFromReader(strings.NewReader(""), "GET")
+	require.ErrorIs(t, err, io.EOF)
+	require.NotErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestMalformedResponses(t *testing.T) {
+	for _, data := range []string{
+		"HTTP/2 200 OK\r\n\r\n",
+		"HTTP/1.1 20 OK\r\n\r\n",
+		"HTTP/1.1 abc OK\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nBad : x\r\n\r\n",
+	} {
+		_, err := ResponseFromReader(&chunkReader{data: data, numBytesPerRead: 3}, "GET")
+		require.Error(t, err, data)
+	}
+}
+
+func TestResponseLimits(t *testing.T) {
+	reader := &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nX-Long: " + strings.Repeat("a", 100) + "\r\n\r\n",
+		numBytesPerRead: 16,
+	}
+	_, err := ResponseFromReaderWithLimits(reader, "GET", Limits{MaxHeaderBytes: 64})
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\n\r\n" + strings.Repeat("a", 100),
+		numBytesPerRead: 16,
+	}
+	_, err = ResponseFromReaderWithLimits(reader, "GET", Limits{MaxBodyBytes: 64})
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestResponseHeadFromReader(t *testing.T) {
+	cases := []struct {
+		name     string
+		method   string
+		data     string
+		body     string
+		trailer  string
+		unread   string
+		limits   Limits
+		expected error
+	}{
+		{
+			name:   "content length",
+			method: "GET",
+			data:   "HTTP/1.1 200 OK\r\nContent-Length: 13\r\n\r\nhello, world!HTTP/1.1 204",
+			body:   "hello, world!",
+			unread: "HTTP/1.1 204",
+		},
+		{
+			name:    "chunked",
+			method:  "GET",
+			data:    "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n7\r\n, world\r\n0\r\nX-Checksum: abc\r\n\r\nnext",
+			body:    "hello, world",
+			trailer: "abc",
+			unread:  "next",
+		},
+		{
+			name:   "close delimited",
+			method: "GET",
+			data:   "HTTP/1.0 200 OK\r\n\r\nuntil the connection closes",
+			body:   "until the connection closes",
+		},
+		{
+			name:   "HEAD",
+			method: "HEAD",
+			data:   "HTTP/1.1 200 OK\r\nContent-Length: 13\r\n\r\nHTTP/1.1 204",
+			unread: "HTTP/1.1 204",
+		},
+		{
+			name:     "content length cut short",
+			method:   "GET",
+			data:     "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort",
+			expected: io.ErrUnexpectedEOF,
+		},
+		{
+			name:     "chunked too large",
+			method:   "GET",
+			data:     "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n80\r\n" + strings.Repeat("a", 128) + "\r\n0\r\n\r\n",
+			limits:   Limits{MaxBodyBytes: 64},
+			expected: ErrBodyTooLarge,
+		},
+		{
+			name:     "close delimited too large",
+			method:   "GET",
+			data:     "HTTP/1.1 200 OK\r\n\r\n" + strings.Repeat("a", 100),
+			limits:   Limits{MaxBodyBytes: 64},
+			expected: ErrBodyTooLarge,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &chunkReader{data: tc.data, numBytesPerRead: 5}
+			r, err := ResponseHeadFromReader(reader, tc.method, tc.limits)
+			require.NoError(t, err)
+			assert.Empty(t, r.Body)
+
+			body, err := io.ReadAll(r.BodyReader())
+
+			if tc.expected != nil {
+				require.ErrorIs(t, err, tc.expected)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.body, string(body))
+
+			checksum, _ := r.Trailers.Get("X-Checksum")
+			assert.Equal(t, tc.trailer, checksum)
+
+			rest, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, tc.unread, string(r.Unread())+string(rest))
+		})
+	}
+
+	// a response parsed whole reads its body back
+	r, err := ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nhi"), "GET")
+	require.NoError(t, err)
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(body))
+}
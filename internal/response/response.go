@@ -1,16 +1,43 @@
 package response
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
 )
 
 type StatusCode int
 
 const (
-	OK                    = 200
-	BAD_REQUEST           = 400
-	INTERNAL_SERVER_ERROR = 500
+	SWITCHING_PROTOCOLS             = 101
+	OK                              = 200
+	CREATED                         = 201
+	NO_CONTENT                      = 204
+	PARTIAL_CONTENT                 = 206
+	MOVED_PERMANENTLY               = 301
+	NOT_MODIFIED                    = 304
+	BAD_REQUEST                     = 400
+	UNAUTHORIZED                    = 401
+	FORBIDDEN                       = 403
+	NOT_FOUND                       = 404
+	METHOD_NOT_ALLOWED              = 405
+	NOT_ACCEPTABLE                  = 406
+	PROXY_AUTHENTICATION_REQUIRED   = 407
+	PRECONDITION_FAILED             = 412
+	CONTENT_TOO_LARGE               = 413
+	UNSUPPORTED_MEDIA_TYPE          = 415
+	RANGE_NOT_SATISFIABLE           = 416
+	UNPROCESSABLE_CONTENT           = 422
+	TOO_MANY_REQUESTS               = 429
+	REQUEST_HEADER_FIELDS_TOO_LARGE = 431
+	INTERNAL_SERVER_ERROR           = 500
+	BAD_GATEWAY                     = 502
+	SERVICE_UNAVAILABLE             = 503
+	GATEWAY_TIMEOUT                 = 504
 )
 
 type writerState int
@@ -25,6 +52,22 @@ const (
 type Writer struct {
 	Writer      io.Writer
 	WriterState writerState
+
+	// stream is set for protocols that frame the response themselves, see NewStreamWriter
+	stream     Stream
+	statusCode StatusCode
+
+	// suppressBody is set by SuppressBody, bodyless is decided with the headers, see WriteHeaders
+	suppressBody bool
+	bodyless     bool
+
+	// conn and reader are set by NewConnWriter, see Hijack
+	conn     net.Conn
+	reader   *bufio.Reader
+	onHijack func()
+
+	// parent is the Writer a filter writes to, see Filter
+	parent *Writer
 }
 
 func NewWriter(w io.Writer) *Writer {
@@ -34,6 +77,19 @@ func NewWriter(w io.Writer) *Writer {
 	}
 }
 
+/*
+SuppressBody makes w drop the body whatever the handler writes, like for a HEAD request: the
+headers, Content-Length included, are those of the GET response and the bytes go nowhere.
+*/
+func (w *Writer) SuppressBody() {
+	w.suppressBody = true
+}
+
+// Bodyless tells whether the body written to w is dropped, known once the headers are written.
+func (w *Writer) Bodyless() bool {
+	return w.bodyless
+}
+
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 	if w.WriterState != WriteStatusLine {
 		return fmt.Errorf("error: writing status line in incorrect state: state %v", w.WriterState)
@@ -43,6 +99,13 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 		w.WriterState = WriteHeaders
 	}()
 
+	w.statusCode = statusCode
+
+	if w.stream != nil {
+		// the status goes out together with the headers
+		return nil
+	}
+
 	statusLine := getStatusLine(statusCode)
 
 	_, err := w.Writer.Write(statusLine)
@@ -60,20 +123,286 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 		w.WriterState = Done
 	}()
 
+	if w.stream != nil {
+		if err := w.stream.WriteData(w.data(p), true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if w.bodyless {
+		return len(p), nil
+	}
+
 	return w.Writer.Write(p)
 }
 
+const BODY_CHUNK_SIZE = 32 * 1024
+
+/*
+WriteBodyFrom streams the body from reader with a fixed size buffer, so a large file is never
+held in memory as a whole. Like WriteBody it is the whole body, Content-Length must already be sent.
+*/
+func (w *Writer) WriteBodyFrom(reader io.Reader) (int64, error) {
+	if w.WriterState != WriteBody {
+		return 0, fmt.Errorf("error: writing body in incorrect state: state %v", w.WriterState)
+	}
+
+	defer func() {
+		w.WriterState = Done
+	}()
+
+	// nothing would be sent, so there is no reason to read
+	if w.bodyless {
+		if w.stream != nil {
+			return 0, w.stream.WriteData(nil, true)
+		}
+		return 0, nil
+	}
+
+	buffer := make([]byte, BODY_CHUNK_SIZE)
+	written := int64(0)
+
+	for {
+		n, err := reader.Read(buffer)
+
+		if n > 0 {
+			if writeErr := w.writeBodyPart(buffer[:n]); writeErr != nil {
+				return written, writeErr
+			}
+			written += int64(n)
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return written, err
+		}
+	}
+
+	if w.stream != nil {
+		return written, w.stream.WriteData(nil, true)
+	}
+
+	return written, nil
+}
+
+func (w *Writer) writeBodyPart(p []byte) error {
+	if w.bodyless {
+		return nil
+	}
+
+	if w.stream != nil {
+		return w.stream.WriteData(p, false)
+	}
+
+	_, err := w.Writer.Write(p)
+
+	return err
+}
+
+/*
+According to RFC9112 7.1, a chunked body is a series of chunks, each prefixed with
+its size in hex, followed by a zero-sized last chunk, optional trailer fields and a final CRLF.
+The caller is responsible for sending "Transfer-Encoding: chunked" instead of Content-Length.
+*/
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.WriterState != WriteBody {
+		return 0, fmt.Errorf("error: writing chunked body in incorrect state: state %v", w.WriterState)
+	}
+
+	// a zero sized chunk is the last chunk, so an empty write must not produce one
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if w.bodyless {
+		return len(p), nil
+	}
+
+	if w.stream != nil {
+		if err := w.stream.WriteData(p, false); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	chunk := make([]byte, 0, len(p)+16)
+	chunk = fmt.Appendf(chunk, "%x\r\n", len(p))
+	chunk = append(chunk, p...)
+	chunk = append(chunk, "\r\n"...)
+
+	if _, err := w.Writer.Write(chunk); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if w.WriterState != WriteBody {
+		return 0, fmt.Errorf("error: writing chunked body done in incorrect state: state %v", w.WriterState)
+	}
+
+	defer func() {
+		w.WriterState = Done
+	}()
+
+	if w.stream != nil {
+		return 0, w.stream.WriteData(nil, true)
+	}
+
+	if w.bodyless {
+		return 0, nil
+	}
+
+	return w.Writer.Write([]byte("0\r\n\r\n"))
+}
+
+// WriteTrailers ends a chunked body with the given trailer fields, so it replaces WriteChunkedBodyDone.
+func (w *Writer) WriteTrailers(trailers headers.Headers) error {
+	if w.WriterState != WriteBody {
+		return fmt.Errorf("error: writing trailers in incorrect state: state %v", w.WriterState)
+	}
+
+	defer func() {
+		w.WriterState = Done
+	}()
+
+	// trailers are part of the body, a response without one ends without them
+	if w.stream != nil && w.bodyless {
+		return w.stream.WriteData(nil, true)
+	}
+
+	if w.stream != nil {
+		return w.stream.WriteTrailers(trailers)
+	}
+
+	if w.bodyless {
+		return nil
+	}
+
+	trailerString := "0\r\n"
+
+	trailers.Each(func(key, value string) {
+		trailerString += fmt.Sprintf("%s: %s\r\n", key, value)
+	})
+
+	trailerString += "\r\n"
+
+	_, err := w.Writer.Write([]byte(trailerString))
+
+	return err
+}
+
+type flusher interface {
+	Flush() error
+}
+
+// Flush pushes buffered bytes to the client when the underlying writer buffers them.
+// Writing straight to a net.Conn needs no flushing, so it is a no-op there.
+// Through a Filter, the filter is flushed first, then the Writer it writes to.
+func (w *Writer) Flush() error {
+	if w.stream != nil {
+		if f, ok := w.stream.(flusher); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+
+		if w.parent != nil {
+			return w.parent.Flush()
+		}
+
+		return nil
+	}
+
+	if f, ok := w.Writer.(flusher); ok {
+		return f.Flush()
+	}
+
+	return nil
+}
+
+// data is what of p goes to the stream, nothing when the response has no body.
+func (w *Writer) data(p []byte) []byte {
+	if w.bodyless {
+		return nil
+	}
+
+	return p
+}
+
+/*
+According to RFC9110 6.4.1, 1xx, 204 (No Content) and 304 (Not Modified) responses never have
+a body, whatever their header fields say.
+*/
+func BodyAllowed(statusCode StatusCode) bool {
+	return statusCode >= 200 && statusCode != NO_CONTENT && statusCode != NOT_MODIFIED
+}
+
 func getStatusLine(statusCode StatusCode) []byte {
+	return []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, ReasonPhrase(statusCode)))
+}
+
+// ReasonPhrase is the text that goes with the status code, empty for codes not listed above.
+func ReasonPhrase(statusCode StatusCode) string {
 	reasonPhrase := ""
 
 	switch statusCode {
+	case SWITCHING_PROTOCOLS:
+		reasonPhrase = "Switching Protocols"
 	case OK:
 		reasonPhrase = "OK"
+	case CREATED:
+		reasonPhrase = "Created"
+	case NO_CONTENT:
+		reasonPhrase = "No Content"
+	case PARTIAL_CONTENT:
+		reasonPhrase = "Partial Content"
+	case MOVED_PERMANENTLY:
+		reasonPhrase = "Moved Permanently"
+	case NOT_MODIFIED:
+		reasonPhrase = "Not Modified"
 	case BAD_REQUEST:
 		reasonPhrase = "Bad Request"
+	case UNAUTHORIZED:
+		reasonPhrase = "Unauthorized"
+	case FORBIDDEN:
+		reasonPhrase = "Forbidden"
+	case NOT_FOUND:
+		reasonPhrase = "Not Found"
+	case METHOD_NOT_ALLOWED:
+		reasonPhrase = "Method Not Allowed"
+	case NOT_ACCEPTABLE:
+		reasonPhrase = "Not Acceptable"
+	case PROXY_AUTHENTICATION_REQUIRED:
+		reasonPhrase = "Proxy Authentication Required"
+	case PRECONDITION_FAILED:
+		reasonPhrase = "Precondition Failed"
+	case CONTENT_TOO_LARGE:
+		reasonPhrase = "Content Too Large"
+	case UNSUPPORTED_MEDIA_TYPE:
+		reasonPhrase = "Unsupported Media Type"
+	case RANGE_NOT_SATISFIABLE:
+		reasonPhrase = "Range Not Satisfiable"
+	case UNPROCESSABLE_CONTENT:
+		reasonPhrase = "Unprocessable Content"
+	case TOO_MANY_REQUESTS:
+		reasonPhrase = "Too Many Requests"
+	case REQUEST_HEADER_FIELDS_TOO_LARGE:
+		reasonPhrase = "Request Header Fields Too Large"
 	case INTERNAL_SERVER_ERROR:
 		reasonPhrase = "Internal Server Error"
+	case BAD_GATEWAY:
+		reasonPhrase = "Bad Gateway"
+	case SERVICE_UNAVAILABLE:
+		reasonPhrase = "Service Unavailable"
+	case GATEWAY_TIMEOUT:
+		reasonPhrase = "Gateway Timeout"
 	}
 
-	return []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, reasonPhrase))
+	return reasonPhrase
 }
@@ -0,0 +1,152 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordStream keeps what reaches it, like a connection would
+type recordStream struct {
+	statusCode StatusCode
+	data       []byte
+	ended      bool
+	trailers   headers.Headers
+}
+
+func (s *recordStream) WriteHead(statusCode StatusCode, h headers.Headers) error {
+	s.statusCode = statusCode
+	return nil
+}
+
+func (s *recordStream) WriteData(p []byte, endStream bool) error {
+	s.data = append(s.data, p...)
+	s.ended = s.ended || endStream
+	return nil
+}
+
+func (s *recordStream) WriteTrailers(h headers.Headers) error {
+	s.trailers = h
+	s.ended = true
+	return nil
+}
+
+func TestSuppressBodyKeepsHeaders(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	w := NewWriter(buffer)
+	w.SuppressBody()
+
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	assert.True(t, w.Bodyless())
+
+	n, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, Done, w.WriterState)
+
+	r, err := ResponseFromReader(buffer, "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "5", r.Headers["content-length"])
+	assert.Empty(t, r.Body)
+	assert.Empty(t, r.Unread())
+
+	// chunked framing and trailers are part of the body too
+	buffer.Reset()
+	w = NewWriter(buffer)
+	w.SuppressBody()
+
+	h := headers.NewHeaders()
+	h.Override("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.Headers{"x-checksum": "1"}))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ntransfer-encoding: chunked \r\n\r\n", buffer.String())
+
+	buffer.Reset()
+	w = NewWriter(buffer)
+	w.SuppressBody()
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(3)))
+	reader := strings.NewReader("abc")
+	_, err = w.WriteBodyFrom(reader)
+	require.NoError(t, err)
+	assert.Equal(t, 3, reader.Len(), "the body is not even read")
+	assert.True(t, strings.HasSuffix(buffer.String(), "\r\n\r\n"))
+}
+
+func TestNoBodyFor204And304(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	w := NewWriter(buffer)
+
+	require.NoError(t, w.WriteStatusLine(NO_CONTENT))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(buffer.String(), "\r\n\r\n"))
+	assert.NotContains(t, buffer.String(), "content-length")
+
+	// a 304 keeps the Content-Length of the 200 it stands for
+	buffer.Reset()
+	w = NewWriter(buffer)
+
+	require.NoError(t, w.WriteStatusLine(NOT_MODIFIED))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err = w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+
+	r, err := ResponseFromReader(buffer, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "5", r.Headers["content-length"])
+	assert.Empty(t, r.Body)
+	assert.Empty(t, r.Unread())
+
+	buffer.Reset()
+	w = NewWriter(buffer)
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	assert.False(t, w.Bodyless())
+}
+
+func TestBodylessStream(t *testing.T) {
+	stream := &recordStream{}
+	w := NewStreamWriter(stream)
+	w.SuppressBody()
+
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Empty(t, stream.data)
+	assert.True(t, stream.ended)
+
+	// a filter writing to Stream() directly is held to the same rule
+	stream = &recordStream{}
+	w = NewStreamWriter(stream)
+	w.SuppressBody()
+
+	filtered := NewStreamWriter(w.Stream())
+	require.NoError(t, filtered.WriteStatusLine(OK))
+	require.NoError(t, filtered.WriteHeaders(headers.NewHeaders()))
+	_, err = filtered.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, filtered.WriteTrailers(headers.Headers{"x-checksum": "1"}))
+	assert.Empty(t, stream.data)
+	assert.Nil(t, stream.trailers)
+	assert.True(t, stream.ended)
+
+	stream = &recordStream{}
+	w = NewStreamWriter(stream)
+
+	s := w.Stream()
+	require.NoError(t, s.WriteHead(NOT_MODIFIED, headers.NewHeaders()))
+	require.NoError(t, s.WriteData([]byte("hello"), true))
+	assert.Empty(t, stream.data)
+	assert.True(t, stream.ended)
+}
@@ -0,0 +1,134 @@
+package response
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+)
+
+/*
+Stream is implemented by protocols that frame a response themselves, like HTTP/2,
+where the status, headers and body are frames on a multiplexed connection instead of
+text on the wire. Handlers keep using the same Writer, chunked writes simply become
+DATA frames and the end of the body ends the stream.
+*/
+type Stream interface {
+	WriteHead(statusCode StatusCode, h headers.Headers) error
+	// WriteData sends body bytes, endStream marks the last of them (p may be empty then).
+	WriteData(p []byte, endStream bool) error
+	// WriteTrailers sends trailer fields and ends the stream.
+	WriteTrailers(h headers.Headers) error
+}
+
+func NewStreamWriter(stream Stream) *Writer {
+	return &Writer{
+		stream:      stream,
+		WriterState: WriteStatusLine,
+	}
+}
+
+/*
+Filter is NewStreamWriter(filter) for a filter that writes to w.Stream(). The Writer it returns
+keeps Hijack and Flush of w working: a handler can still take over the connection before
+writing anything, and Flush reaches the filter, when it has a Flush() error method, then w.
+*/
+func (w *Writer) Filter(filter Stream) *Writer {
+	filtered := NewStreamWriter(filter)
+	filtered.parent = w
+
+	return filtered
+}
+
+/*
+Stream exposes w itself as a Stream, so a filter like compression can stand between a handler
+and the connection: the handler writes to NewStreamWriter(filter) and the filter writes the
+transformed response to w.Stream(). A Writer that is already backed by a Stream returns it,
+still dropping the body of a response that has none.
+*/
+func (w *Writer) Stream() Stream {
+	if w.stream != nil {
+		return &bodylessStream{next: w.stream, suppressBody: w.suppressBody}
+	}
+
+	return &writerStream{writer: w}
+}
+
+// bodylessStream drops the body of HEAD, 1xx, 204 and 304 responses on their way to next, like Writer does.
+type bodylessStream struct {
+	next         Stream
+	suppressBody bool
+	bodyless     bool
+}
+
+func (s *bodylessStream) WriteHead(statusCode StatusCode, h headers.Headers) error {
+	s.bodyless = s.suppressBody || !BodyAllowed(statusCode)
+
+	return s.next.WriteHead(statusCode, h)
+}
+
+func (s *bodylessStream) WriteData(p []byte, endStream bool) error {
+	if !s.bodyless {
+		return s.next.WriteData(p, endStream)
+	}
+
+	if endStream {
+		return s.next.WriteData(nil, true)
+	}
+
+	return nil
+}
+
+func (s *bodylessStream) WriteTrailers(h headers.Headers) error {
+	if s.bodyless {
+		return s.next.WriteData(nil, true)
+	}
+
+	return s.next.WriteTrailers(h)
+}
+
+// writerStream maps Stream calls back onto an HTTP/1.1 Writer.
+type writerStream struct {
+	writer  *Writer
+	chunked bool
+}
+
+func (s *writerStream) WriteHead(statusCode StatusCode, h headers.Headers) error {
+	if err := s.writer.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+
+	transferEncoding, _ := h.Get("Transfer-Encoding")
+	s.chunked = strings.Contains(strings.ToLower(transferEncoding), "chunked")
+
+	return s.writer.WriteHeaders(h)
+}
+
+func (s *writerStream) WriteData(p []byte, endStream bool) error {
+	if s.chunked {
+		if _, err := s.writer.WriteChunkedBody(p); err != nil {
+			return err
+		}
+
+		if endStream {
+			_, err := s.writer.WriteChunkedBodyDone()
+			return err
+		}
+
+		return nil
+	}
+
+	if s.writer.WriterState != WriteBody {
+		return fmt.Errorf("error: writing body in incorrect state: state %v", s.writer.WriterState)
+	}
+
+	if endStream {
+		s.writer.WriterState = Done
+	}
+
+	return s.writer.writeBodyPart(p)
+}
+
+func (s *writerStream) WriteTrailers(h headers.Headers) error {
+	return s.writer.WriteTrailers(h)
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+)
+
+// decodeBody rejects a body it cannot decode, with the status parseErrorStatus gives the error.
+func decodeBody(maxBytes int) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			err := req.DecodeBody(maxBytes)
+
+			if err == nil {
+				next(w, req)
+				return
+			}
+
+			message := []byte(err.Error())
+			statusCode := parseErrorStatus(err)
+			headers := response.GetDefaultHeaders(len(message))
+
+			// According to RFC9110 15.5.16, a 415 for a content coding lists the ones that are accepted
+			if statusCode == response.UNSUPPORTED_MEDIA_TYPE {
+				headers.Override("Accept-Encoding", request.SUPPORTED_ENCODINGS)
+			}
+
+			w.WriteStatusLine(statusCode)
+			w.WriteHeaders(headers)
+			w.WriteBody(message)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+)
+
+const KEY_FORWARDED = "Forwarded"
+const KEY_X_FORWARDED_FOR = "X-Forwarded-For"
+
+/*
+resolveClientIP sets the ClientIP of requests relayed by a trusted proxy. Each proxy appends
+the address it received the request from, so the list is walked from the end: as long as the
+address at hand is a trusted proxy, the one before it is believed, the first untrusted one is
+the client. Anything a client wrote itself at the start of the list is never reached:
+
+	X-Forwarded-For: <forged by client>, 203.0.113.7, 10.0.0.2    (peer 10.0.0.3, both 10.x trusted)
+
+gives 203.0.113.7. Only field is read, the one the trusted proxies write: a proxy that appends
+to X-Forwarded-For passes a Forwarded the client made up along untouched, and the other way round.
+*/
+func resolveClientIP(trusted []netip.Prefix, field string) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			if ip, ok := forwardedClientIP(req, trusted, field); ok {
+				req.SetClientIP(ip)
+			}
+
+			next(w, req)
+		}
+	}
+}
+
+func forwardedClientIP(req *request.Request, trusted []netip.Prefix, field string) (string, bool) {
+	peer, err := netip.ParseAddr(req.ClientIP())
+
+	if err != nil || !containsAddr(trusted, peer.Unmap()) {
+		return "", false
+	}
+
+	client := peer.Unmap()
+	value, ok := req.Headers.Get(field)
+
+	if !ok {
+		return client.String(), true
+	}
+
+	var hops []string
+
+	if field == KEY_FORWARDED {
+		hops = forwardedFor(value)
+	} else {
+		for hop := range strings.SplitSeq(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+
+		// "unknown" or an obfuscated identifier (RFC7239 6.3), the trusted proxy that wrote it is as far as we know
+		if !ok {
+			break
+		}
+
+		client = addr
+
+		if !containsAddr(trusted, addr) {
+			break
+		}
+	}
+
+	return client.String(), true
+}
+
+// forwardedField is the field named by Options.ForwardedHeader, X-Forwarded-For when empty.
+func forwardedField(name string) (string, error) {
+	switch {
+	case name == "", strings.EqualFold(name, KEY_X_FORWARDED_FOR):
+		return KEY_X_FORWARDED_FOR, nil
+	case strings.EqualFold(name, KEY_FORWARDED):
+		return KEY_FORWARDED, nil
+	}
+
+	return "", fmt.Errorf("error: ForwardedHeader must be %s or %s, got %s", KEY_FORWARDED, KEY_X_FORWARDED_FOR, name)
+}
+
+// forwardedFor returns the for= parameter of every element of a Forwarded field, in order.
+func forwardedFor(field string) []string {
+	hops := []string{}
+
+	for element := range strings.SplitSeq(field, ",") {
+		hop := ""
+
+		for pair := range strings.SplitSeq(element, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+
+			if strings.EqualFold(key, "for") {
+				hop = strings.Trim(value, `"`)
+			}
+		}
+
+		hops = append(hops, hop)
+	}
+
+	return hops
+}
+
+// parseHop reads "192.0.2.1", "192.0.2.1:4711", "[2001:db8::1]:4711" or "2001:db8::1".
+func parseHop(hop string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+
+	addr, err := netip.ParseAddr(strings.Trim(hop, "[]"))
+
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
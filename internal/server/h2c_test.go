@@ -0,0 +1,296 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	body := []byte(req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + string(req.Body))
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func priorKnowledgeClient() *http.Client {
+	return &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		},
+		Timeout: 5 * time.Second,
+	}
+}
+
+func TestH2CPriorKnowledge(t *testing.T) {
+	server, err := Serve(0, echoHandler)
+	require.NoError(t, err)
+	defer server.Close()
+
+	url := "http://" + server.listener.Addr().String()
+	res, err := priorKnowledgeClient().Post(url+"/coffee", "text/plain", strings.NewReader("latte"))
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, res.ProtoMajor)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "POST /coffee latte", string(body))
+	assert.Empty(t, res.Header.Get("Connection"))
+}
+
+func TestH2CMultiplexesStreams(t *testing.T) {
+	// every handler waits until all of them run, which only works if the streams are served concurrently
+	const streams = 5
+	var arrived sync.WaitGroup
+	arrived.Add(streams)
+
+	server, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		arrived.Done()
+		arrived.Wait()
+		echoHandler(w, req)
+	})
+	require.NoError(t, err)
+	defer server.Close()
+
+	client := priorKnowledgeClient()
+	url := "http://" + server.listener.Addr().String()
+	var done sync.WaitGroup
+
+	for range streams {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			res, err := client.Get(url + "/")
+			if assert.NoError(t, err) {
+				res.Body.Close()
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+			}
+		}()
+	}
+
+	done.Wait()
+}
+
+func TestH2CLargeChunkedBodyRespectsFlowControl(t *testing.T) {
+	// bigger than the default 65535 byte window, so the server must wait for WINDOW_UPDATE
+	chunk := bytes.Repeat([]byte("x"), 50_000)
+
+	server, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		headers := response.GetDefaultHeaders(0)
+		headers.Remove("Content-Length")
+		headers.Override("Transfer-Encoding", "chunked")
+
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(headers)
+		for range 4 {
+			w.WriteChunkedBody(chunk)
+		}
+		w.WriteChunkedBodyDone()
+	})
+	require.NoError(t, err)
+	defer server.Close()
+
+	res, err := priorKnowledgeClient().Get("http://" + server.listener.Addr().String() + "/")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Len(t, body, 4*len(chunk))
+}
+
+func TestH2CUpgrade(t *testing.T) {
+	server, err := Serve(0, echoHandler)
+	require.NoError(t, err)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	settings := base64.RawURLEncoding.EncodeToString([]byte{0, 4, 0, 1, 0, 0}) // INITIAL_WINDOW_SIZE 65536
+	_, err = conn.Write([]byte("GET /upgraded HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\n" +
+		"HTTP2-Settings: " + settings + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	statusLine, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", statusLine)
+
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+
+	framer := http2.NewFramer(conn, reader)
+	require.NoError(t, framer.WriteSettings())
+
+	var status, body string
+	decoder := hpack.NewDecoder(4096, func(f hpack.HeaderField) {
+		if f.Name == ":status" {
+			status = f.Value
+		}
+	})
+
+	for done := false; !done; {
+		frame, err := framer.ReadFrame()
+		require.NoError(t, err)
+
+		switch f := frame.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				require.NoError(t, framer.WriteSettingsAck())
+			}
+		case *http2.HeadersFrame:
+			assert.Equal(t, uint32(1), f.StreamID)
+			_, err := decoder.Write(f.HeaderBlockFragment())
+			require.NoError(t, err)
+		case *http2.DataFrame:
+			body += string(f.Data())
+			done = f.StreamEnded()
+		}
+	}
+
+	assert.Equal(t, "200", status)
+	assert.Equal(t, "GET /upgraded ", body)
+}
+
+func TestHTTP11StillServedAlongsideH2C(t *testing.T) {
+	server, err := Serve(0, echoHandler)
+	require.NoError(t, err)
+	defer server.Close()
+
+	// shorter than the HTTP/2 preface, the sniffing must not wait for more bytes
+	conn, err := net.Dial("tcp", server.listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK"))
+}
+
+func TestH2CHeadHasNoBody(t *testing.T) {
+	server, err := Serve(0, echoHandler)
+	require.NoError(t, err)
+	defer server.Close()
+
+	res, err := priorKnowledgeClient().Head("http://" + server.listener.Addr().String() + "/coffee")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, int64(len("HEAD /coffee ")), res.ContentLength)
+	assert.Empty(t, body)
+}
+
+func TestH2CEnforcesLimits(t *testing.T) {
+	server, err := ServeWithOptions(echoHandler, Options{Addr: "127.0.0.1:0", MaxBodyBytes: 4})
+	require.NoError(t, err)
+	defer server.Close()
+
+	client := priorKnowledgeClient()
+	url := "http://" + server.Addr().String()
+
+	post := func(body io.Reader) int {
+		res, err := client.Post(url, "text/plain", body)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		return res.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, post(strings.NewReader("tea")))
+	// announced in content-length, and only found while it streams
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(strings.NewReader("latte")))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(io.MultiReader(strings.NewReader(strings.Repeat("a", 1<<20)))))
+}
+
+func TestH2CReadTimeout(t *testing.T) {
+	server, err := ServeWithOptions(echoHandler, Options{Addr: "127.0.0.1:0", ReadTimeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+
+	framer := http2.NewFramer(conn, conn)
+	require.NoError(t, framer.WriteSettings())
+
+	header := &bytes.Buffer{}
+	encoder := hpack.NewEncoder(header)
+	for _, field := range [][2]string{{":method", "POST"}, {":scheme", "http"}, {":path", "/"}, {":authority", "localhost"}} {
+		encoder.WriteField(hpack.HeaderField{Name: field[0], Value: field[1]})
+	}
+
+	// the body never comes, the connection is closed rather than held
+	require.NoError(t, framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: header.Bytes(), EndHeaders: true}))
+
+	_, err = io.Copy(io.Discard, conn)
+	require.NoError(t, err)
+}
+
+func TestH2CCanBeDisabled(t *testing.T) {
+	server, err := ServeWithOptions(echoHandler, Options{Addr: "127.0.0.1:0", DisableH2C: true})
+	require.NoError(t, err)
+	defer server.Close()
+
+	addr := server.Addr().String()
+
+	out := roundTrip(t, "tcp", addr, "GET /upgraded HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Connection: Upgrade, HTTP2-Settings\r\n"+
+		"Upgrade: h2c\r\n"+
+		"HTTP2-Settings: AAQAAQAA\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK"))
+	assert.True(t, strings.HasSuffix(out, "GET /upgraded "))
+
+	out = roundTrip(t, "tcp", addr, http2.ClientPreface)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 4"))
+}
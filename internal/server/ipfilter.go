@@ -0,0 +1,109 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+)
+
+/*
+IPFilter lists which addresses may connect, see ParsePrefixes. Deny wins over Allow, so
+Allow 10.0.0.0/8 with Deny 10.6.6.0/24 lets in the private network but one subnet of it.
+An empty Allow lets in every address that is not denied.
+*/
+type IPFilter struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+var ipForbiddenMessage = []byte("address not allowed\n")
+
+// ParsePrefixes reads CIDRs like "192.0.2.0/24" or "2001:db8::/32", a bare address is a prefix of its own.
+func ParsePrefixes(cidrs ...string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+
+			if err != nil {
+				return nil, fmt.Errorf("error: invalid address %q: %w", cidr, err)
+			}
+
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(cidr)
+
+		if err != nil {
+			return nil, fmt.Errorf("error: invalid CIDR %q: %w", cidr, err)
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// Allows tells whether addr may connect. An IPv4 address mapped into IPv6, like a dual stack listener reports it, counts as IPv4.
+func (f IPFilter) Allows(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	if containsAddr(f.Deny, addr) {
+		return false
+	}
+
+	return len(f.Allow) == 0 || containsAddr(f.Allow, addr)
+}
+
+// RequireIP rejects with 403 requests whose ClientIP the filter does not allow, for the routes it wraps.
+func RequireIP(filter IPFilter) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			addr, err := netip.ParseAddr(req.ClientIP())
+
+			if err != nil || !filter.Allows(addr) {
+				HandleError{
+					StatusCode: response.FORBIDDEN,
+					Message:    ipForbiddenMessage,
+				}.RespondTo(w, req)
+				return
+			}
+
+			next(w, req)
+		}
+	}
+}
+
+/**
+* Helpers
+**/
+
+// acceptable checks a new connection against the filter, before a byte of it is read.
+// Peers without an IP, on a unix socket, are local and always accepted.
+func (f IPFilter) acceptable(remote net.Addr) bool {
+	tcpAddr, ok := remote.(*net.TCPAddr)
+
+	if !ok {
+		return true
+	}
+
+	return f.Allows(tcpAddr.AddrPort().Addr())
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustPrefixes(t *testing.T, cidrs ...string) []netip.Prefix {
+	prefixes, err := ParsePrefixes(cidrs...)
+	require.NoError(t, err)
+
+	return prefixes
+}
+
+// clientIPHandler answers with the ClientIP of the request
+func clientIPHandler(w *response.Writer, req *request.Request) {
+	body := []byte(req.ClientIP())
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestIPFilterAllows(t *testing.T) {
+	filter := IPFilter{
+		Allow: mustPrefixes(t, "10.0.0.0/8", "2001:db8::/32", "192.0.2.7"),
+		Deny:  mustPrefixes(t, "10.6.6.0/24"),
+	}
+
+	for addr, allowed := range map[string]bool{
+		"10.1.2.3":         true,
+		"10.6.6.6":         false,
+		"192.0.2.7":        true,
+		"192.0.2.8":        false,
+		"::ffff:10.1.2.3":  true,
+		"2001:db8::1":      true,
+		"2001:db9::1":      false,
+		"::ffff:10.6.6.10": false,
+	} {
+		assert.Equal(t, allowed, filter.Allows(netip.MustParseAddr(addr)), addr)
+	}
+
+	assert.True(t, IPFilter{Deny: mustPrefixes(t, "10.6.6.0/24")}.Allows(netip.MustParseAddr("203.0.113.1")))
+
+	_, err := ParsePrefixes("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = ParsePrefixes("example.com")
+	assert.Error(t, err)
+}
+
+func TestIPFilterAtAccept(t *testing.T) {
+	server, err := ServeWithOptions(clientIPHandler, Options{
+		Addr:     "127.0.0.1:0",
+		IPFilter: &IPFilter{Deny: mustPrefixes(t, "127.0.0.0/8")},
+	})
+	require.NoError(t, err)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+
+	// closed without an answer
+	out, _ := io.ReadAll(conn)
+	assert.Empty(t, out)
+
+	allowed, err := ServeWithOptions(clientIPHandler, Options{
+		Addr:     "127.0.0.1:0",
+		IPFilter: &IPFilter{Allow: mustPrefixes(t, "127.0.0.1")},
+	})
+	require.NoError(t, err)
+	defer allowed.Close()
+
+	reply := roundTrip(t, "tcp", allowed.Addr().String(), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(reply, "\r\n\r\n127.0.0.1"))
+}
+
+func TestTrustedProxies(t *testing.T) {
+	server, err := ServeWithOptions(clientIPHandler, Options{
+		Addr:           "127.0.0.1:0",
+		TrustedProxies: mustPrefixes(t, "127.0.0.0/8", "10.0.0.0/8"),
+	})
+	require.NoError(t, err)
+	defer server.Close()
+
+	addr := server.Addr().String()
+	clientIP := func(fields ...string) string {
+		raw := "GET / HTTP/1.1\r\nHost: localhost\r\n" + strings.Join(fields, "") + "\r\n"
+		reply := roundTrip(t, "tcp", addr, raw)
+		_, body, _ := strings.Cut(reply, "\r\n\r\n")
+
+		return body
+	}
+
+	assert.Equal(t, "127.0.0.1", clientIP())
+	assert.Equal(t, "203.0.113.7", clientIP("X-Forwarded-For: 203.0.113.7\r\n"))
+	// what the client wrote before the first untrusted hop is ignored
+	assert.Equal(t, "203.0.113.7", clientIP("X-Forwarded-For: 6.6.6.6, 203.0.113.7, 10.0.0.2\r\n"))
+	// repeated fields are combined in order
+	assert.Equal(t, "203.0.113.7", clientIP("X-Forwarded-For: 6.6.6.6\r\n", "X-Forwarded-For: 203.0.113.7\r\n"))
+	// every hop trusted, the first one is the client
+	assert.Equal(t, "10.9.9.9", clientIP("X-Forwarded-For: 10.9.9.9, 10.0.0.2\r\n"))
+	// not the field the proxies write
+	assert.Equal(t, "127.0.0.1", clientIP("Forwarded: for=203.0.113.7\r\n"))
+}
+
+func TestTrustedProxiesWritingForwarded(t *testing.T) {
+	server, err := ServeWithOptions(clientIPHandler, Options{
+		Addr:            "127.0.0.1:0",
+		TrustedProxies:  mustPrefixes(t, "127.0.0.0/8", "10.0.0.0/8"),
+		ForwardedHeader: "forwarded",
+	})
+	require.NoError(t, err)
+	defer server.Close()
+
+	addr := server.Addr().String()
+	clientIP := func(fields ...string) string {
+		raw := "GET / HTTP/1.1\r\nHost: localhost\r\n" + strings.Join(fields, "") + "\r\n"
+		reply := roundTrip(t, "tcp", addr, raw)
+		_, body, _ := strings.Cut(reply, "\r\n\r\n")
+
+		return body
+	}
+
+	assert.Equal(t, "2001:db8:cafe::17", clientIP(`Forwarded: for="[2001:db8:cafe::17]:4711", for=10.0.0.2;proto=https`+"\r\n", "X-Forwarded-For: 6.6.6.6\r\n"))
+	assert.Equal(t, "10.0.0.2", clientIP("Forwarded: for=unknown, for=10.0.0.2\r\n"))
+	assert.Equal(t, "127.0.0.1", clientIP("X-Forwarded-For: 203.0.113.7\r\n"))
+
+	_, err = ServeWithOptions(clientIPHandler, Options{Addr: "127.0.0.1:0", ForwardedHeader: "X-Real-IP"})
+	assert.ErrorContains(t, err, "ForwardedHeader")
+}
+
+func TestSpoofedForwardedNextToProxyXFF(t *testing.T) {
+	// the client made up a Forwarded inside the allowed range, its proxy only appended to X-Forwarded-For
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n" +
+		"Forwarded: for=10.0.0.1\r\n" +
+		"X-Forwarded-For: 203.0.113.9\r\n\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = "192.0.2.1:5000"
+
+	buffer := &bytes.Buffer{}
+	handler := Chain(clientIPHandler,
+		resolveClientIP(mustPrefixes(t, "192.0.2.1"), KEY_X_FORWARDED_FOR),
+		RequireIP(IPFilter{Allow: mustPrefixes(t, "10.0.0.0/8")}),
+	)
+	handler(response.NewWriter(buffer), req)
+
+	assert.Equal(t, "203.0.113.9", req.ClientIP())
+	assert.True(t, strings.HasPrefix(buffer.String(), "HTTP/1.1 403 Forbidden"))
+}
+
+func TestForwardedIgnoredFromUntrustedPeer(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nX-Forwarded-For: 10.0.0.1\r\n\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = "203.0.113.9:5000"
+
+	buffer := &bytes.Buffer{}
+	handler := Chain(clientIPHandler, resolveClientIP(mustPrefixes(t, "10.0.0.0/8"), KEY_X_FORWARDED_FOR), RequireIP(IPFilter{Allow: mustPrefixes(t, "10.0.0.0/8")}))
+	handler(response.NewWriter(buffer), req)
+
+	assert.True(t, strings.HasPrefix(buffer.String(), "HTTP/1.1 403 Forbidden"))
+	assert.Contains(t, buffer.String(), "address not allowed")
+}
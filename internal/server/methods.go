@@ -0,0 +1,77 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+)
+
+/*
+Methods is a handler per request method:
+
+	server.Serve(8080, server.Methods{
+		"GET":  list,
+		"POST": create,
+	}.Handle)
+
+According to RFC9110 9.3.2, HEAD is GET without the body, so without a "HEAD" entry the GET
+handler answers it, the server drops what it writes as body. Without an "OPTIONS" entry, OPTIONS
+is answered with the Allow field (RFC9110 9.3.7). Other methods get 405 with Allow.
+*/
+type Methods map[string]Handler
+
+func (m Methods) Handle(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+	handler, ok := m[method]
+
+	if !ok && method == "HEAD" {
+		handler, ok = m["GET"]
+	}
+
+	if !ok && method == "OPTIONS" {
+		headers := response.GetDefaultHeaders(0)
+		headers.Override("Allow", m.Allow())
+
+		w.WriteStatusLine(response.NO_CONTENT)
+		w.WriteHeaders(headers)
+		w.WriteBody(nil)
+		return
+	}
+
+	if !ok {
+		headers := response.GetDefaultHeaders(0)
+		headers.Override("Allow", m.Allow())
+
+		w.WriteStatusLine(response.METHOD_NOT_ALLOWED)
+		w.WriteHeaders(headers)
+		w.WriteBody(nil)
+		return
+	}
+
+	handler(w, req)
+}
+
+// Allow lists the methods m answers, for the Allow field.
+func (m Methods) Allow() string {
+	methods := make([]string, 0, len(m)+2)
+
+	for method := range m {
+		methods = append(methods, method)
+	}
+
+	if _, ok := m["HEAD"]; !ok {
+		if _, ok := m["GET"]; ok {
+			methods = append(methods, "HEAD")
+		}
+	}
+
+	if _, ok := m["OPTIONS"]; !ok {
+		methods = append(methods, "OPTIONS")
+	}
+
+	sort.Strings(methods)
+
+	return strings.Join(methods, ", ")
+}
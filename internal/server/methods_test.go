@@ -0,0 +1,35 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMethodsRoutesHeadToGet(t *testing.T) {
+	server, err := ServeWithOptions(Methods{"GET": echoHandler, "POST": echoHandler}.Handle, Options{Addr: "127.0.0.1:0"})
+	require.NoError(t, err)
+	defer server.Close()
+
+	addr := server.Addr().String()
+
+	out := roundTrip(t, "tcp", addr, "HEAD /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	r, err := response.ResponseFromReader(strings.NewReader(out), "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCode(response.OK), r.StatusLine.StatusCode)
+	// the length of what GET would send, which is not sent
+	assert.Equal(t, fmt.Sprint(len("HEAD /coffee ")), r.Headers["content-length"])
+	assert.Empty(t, r.Unread())
+
+	out = roundTrip(t, "tcp", addr, "DELETE /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed"))
+	assert.Contains(t, out, "allow: GET, HEAD, OPTIONS, POST")
+
+	out = roundTrip(t, "tcp", addr, "OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content"))
+	assert.Contains(t, out, "allow: GET, HEAD, OPTIONS, POST")
+}
@@ -0,0 +1,16 @@
+package server
+
+// Middleware wraps a handler to run code before and/or after it, or to answer in its place.
+type Middleware func(Handler) Handler
+
+/*
+Chain wraps handler with middlewares, the first one is the outermost:
+Chain(h, a, b) runs a, then b, then h.
+*/
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"crypto/x509"
+	"slices"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+)
+
+/*
+ClientCertPolicy lists who may call a route. A verified client certificate passes
+when any of its subject or SANs is listed, an empty policy accepts any verified certificate.
+*/
+type ClientCertPolicy struct {
+	// Subjects are full distinguished names as printed by pkix.Name.String, e.g. "CN=agent,O=Acme"
+	Subjects       []string
+	CommonNames    []string
+	DNSNames       []string
+	EmailAddresses []string
+	URIs           []string
+}
+
+var forbiddenMessage = []byte("client certificate not allowed\n")
+
+// RequireClientCert rejects with 403 requests without a verified client certificate allowed by policy.
+func RequireClientCert(policy ClientCertPolicy) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			cert := req.ClientCertificate()
+
+			if cert == nil || !policy.Allows(cert) {
+				HandleError{
+					StatusCode: response.FORBIDDEN,
+					Message:    forbiddenMessage,
+				}.RespondTo(w, req)
+				return
+			}
+
+			next(w, req)
+		}
+	}
+}
+
+func (p ClientCertPolicy) Allows(cert *x509.Certificate) bool {
+	if p.empty() {
+		return true
+	}
+
+	if slices.Contains(p.Subjects, cert.Subject.String()) {
+		return true
+	}
+
+	if slices.Contains(p.CommonNames, cert.Subject.CommonName) {
+		return true
+	}
+
+	for _, name := range cert.DNSNames {
+		if slices.Contains(p.DNSNames, name) {
+			return true
+		}
+	}
+
+	for _, email := range cert.EmailAddresses {
+		if slices.Contains(p.EmailAddresses, email) {
+			return true
+		}
+	}
+
+	for _, uri := range cert.URIs {
+		if slices.Contains(p.URIs, uri.String()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p ClientCertPolicy) empty() bool {
+	return len(p.Subjects) == 0 &&
+		len(p.CommonNames) == 0 &&
+		len(p.DNSNames) == 0 &&
+		len(p.EmailAddresses) == 0 &&
+		len(p.URIs) == 0
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) writeBundle(t *testing.T, dir string) string {
+	t.Helper()
+
+	file := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600))
+
+	return file
+}
+
+func (ca *testCA) clientCertificate(t *testing.T, commonName string, dnsNames ...string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"Acme"}},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func subjectHandler(w *response.Writer, req *request.Request) {
+	body := []byte(req.ClientCertificate().Subject.String())
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func getOverTLS(t *testing.T, server *Server, clientCerts ...tls.Certificate) string {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", server.listener.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       clientCerts,
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	return string(out)
+}
+
+func serveMutualTLS(t *testing.T, ca *testCA, policy ClientCertPolicy) *Server {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	server, err := ServeTLS(0, Chain(subjectHandler, RequireClientCert(policy)), TLSConfig{
+		KeyPairs:     []KeyPair{writeKeyPair(t, dir, "localhost")},
+		ClientCAFile: ca.writeBundle(t, dir),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+
+	return server
+}
+
+func TestRequireClientCertExposesVerifiedSubject(t *testing.T) {
+	ca := newTestCA(t)
+	server := serveMutualTLS(t, ca, ClientCertPolicy{CommonNames: []string{"agent"}})
+
+	out := getOverTLS(t, server, ca.clientCertificate(t, "agent"))
+
+	assert.Contains(t, out, "HTTP/1.1 200 OK")
+	assert.Contains(t, out, "CN=agent,O=Acme")
+}
+
+func TestRequireClientCertRejectsMissingCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	server := serveMutualTLS(t, ca, ClientCertPolicy{})
+
+	out := getOverTLS(t, server)
+
+	assert.Contains(t, out, "HTTP/1.1 403 Forbidden")
+}
+
+func TestRequireClientCertRejectsSubjectOutsidePolicy(t *testing.T) {
+	ca := newTestCA(t)
+	server := serveMutualTLS(t, ca, ClientCertPolicy{DNSNames: []string{"billing.internal"}})
+
+	assert.Contains(t, getOverTLS(t, server, ca.clientCertificate(t, "agent", "search.internal")), "HTTP/1.1 403 Forbidden")
+	assert.Contains(t, getOverTLS(t, server, ca.clientCertificate(t, "agent", "billing.internal")), "HTTP/1.1 200 OK")
+}
+
+func TestMutualTLSRejectsCertificateFromUnknownCA(t *testing.T) {
+	ca := newTestCA(t)
+	server := serveMutualTLS(t, ca, ClientCertPolicy{})
+
+	conn, err := tls.Dial("tcp", server.listener.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{newTestCA(t).clientCertificate(t, "intruder")},
+	})
+
+	if err == nil {
+		defer conn.Close()
+		// with TLS 1.3 the server rejects the certificate after the client side of the handshake completes
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		if err == nil {
+			_, err = io.ReadAll(conn)
+		}
+	}
+
+	require.Error(t, err)
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+)
+
+const DEFAULT_MAX_HEADER_BYTES = 1 << 20
+const DEFAULT_MAX_DECODED_BODY_BYTES = 32 << 20
+
+/*
+Options configure where and how a server listens. The zero value listens on every interface
+on a port picked by the system, with no timeouts and no body limit.
+*/
+type Options struct {
+	// Addr is the address to bind, "127.0.0.1:0" picks a free loopback port, read it back with Server.Addr.
+	// For the "unix" network it is the socket path.
+	Addr string
+	// Network is "tcp" (dual stack, the default), "tcp4", "tcp6" or "unix".
+	Network string
+	// Listener is served as is instead of binding Addr, e.g. a socket passed by systemd socket activation.
+	Listener net.Listener
+
+	TLS *TLSConfig
+
+	// ErrorLog receives accept, handshake and protocol errors, nil means the standard logger.
+	ErrorLog *log.Logger
+
+	// ReadTimeout bounds reading a whole request, including the TLS handshake.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds writing the response, counted from the end of the request.
+	WriteTimeout time.Duration
+
+	// MaxHeaderBytes bounds the request line and headers, zero means DEFAULT_MAX_HEADER_BYTES.
+	MaxHeaderBytes int
+	// MaxBodyBytes bounds the request body, zero means no limit.
+	MaxBodyBytes int
+
+	// StreamBody picks the requests whose handler reads the body off the connection through
+	// BodyReader, like a large upload, once the headers are read. The others are read whole
+	// first, like all of them when it is nil.
+	StreamBody func(req *request.Request) bool
+
+	// DisableH2C turns HTTP/2 over cleartext off, the preface and Upgrade: h2c are then
+	// answered as HTTP/1.1.
+	DisableH2C bool
+
+	// DecodeBody decodes gzip and deflate request bodies before the handler sees them,
+	// other encodings are answered with 415.
+	DecodeBody bool
+	// MaxDecodedBodyBytes bounds a body once decoded, zero means DEFAULT_MAX_DECODED_BODY_BYTES.
+	MaxDecodedBodyBytes int
+
+	// IPFilter closes connections from addresses it does not allow as soon as they are accepted,
+	// nil accepts all. Behind a proxy it sees the proxy, RequireIP per route sees the client.
+	IPFilter *IPFilter
+	// TrustedProxies are the peers whose Forwarded or X-Forwarded-For is believed to tell
+	// the ClientIP of a request, empty ignores both fields.
+	TrustedProxies []netip.Prefix
+	// ForwardedHeader is the field the trusted proxies write, KEY_FORWARDED or KEY_X_FORWARDED_FOR
+	// when empty. The other one is never read, it can only come from the client.
+	ForwardedHeader string
+}
+
+func ServeWithOptions(handler Handler, options Options) (*Server, error) {
+	forwarded, err := forwardedField(options.ForwardedHeader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := options.listen()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var store *CertificateStore
+
+	if options.TLS != nil {
+		var tlsConfig *tls.Config
+
+		tlsConfig, store, err = options.TLS.build()
+
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	if options.MaxHeaderBytes == 0 {
+		options.MaxHeaderBytes = DEFAULT_MAX_HEADER_BYTES
+	}
+
+	if options.MaxDecodedBodyBytes == 0 {
+		options.MaxDecodedBodyBytes = DEFAULT_MAX_DECODED_BODY_BYTES
+	}
+
+	// as a middleware it covers HTTP/2 streams too, they never go through the HTTP/1.1 parser
+	if options.DecodeBody {
+		handler = Chain(handler, decodeBody(options.MaxDecodedBodyBytes))
+	}
+
+	// outermost, so every other middleware sees the resolved client
+	if len(options.TrustedProxies) > 0 {
+		handler = Chain(handler, resolveClientIP(options.TrustedProxies, forwarded))
+	}
+
+	server := newServer(listener, handler, options)
+
+	if store != nil {
+		go store.watch(options.TLS.ReloadInterval, server.done, server.errorLog)
+	}
+
+	return server, nil
+}
+
+// Addr is the address the server is bound to, with the real port when it was chosen by the system.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (o Options) listen() (net.Listener, error) {
+	if o.Listener != nil {
+		return o.Listener, nil
+	}
+
+	network := o.Network
+
+	if network == "" {
+		network = "tcp"
+	}
+
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return net.Listen(network, o.Addr)
+	case "unix":
+		if err := removeStaleSocket(o.Addr); err != nil {
+			return nil, err
+		}
+		return net.Listen(network, o.Addr)
+	default:
+		return nil, fmt.Errorf("error: unsupported network %s", network)
+	}
+}
+
+/**
+* Helpers
+**/
+
+/*
+A socket file left by a crashed process makes Listen fail. It is only removed when nobody
+accepts on it anymore, anything that is not a socket is left alone.
+*/
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("error: %s exists and is not a socket", path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("error: %s is in use", path)
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer lets the test read what the server goroutines log
+type syncBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buffer.String()
+}
+
+func roundTrip(t *testing.T, network, addr, raw string) string {
+	t.Helper()
+
+	conn, err := net.Dial(network, addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	return string(out)
+}
+
+func TestServeWithOptionsReportsChosenAddress(t *testing.T) {
+	server, err := ServeWithOptions(echoHandler, Options{Addr: "127.0.0.1:0"})
+	require.NoError(t, err)
+	defer server.Close()
+
+	addr := server.Addr().(*net.TCPAddr)
+	assert.True(t, addr.IP.IsLoopback())
+	assert.NotZero(t, addr.Port)
+
+	out := roundTrip(t, "tcp", addr.String(), "GET /port HTTP/1.1\r\n\r\n")
+	assert.Contains(t, out, "GET /port")
+}
+
+func TestServeWithOptionsOnUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.sock")
+
+	server, err := ServeWithOptions(echoHandler, Options{Network: "unix", Addr: path})
+	require.NoError(t, err)
+
+	out := roundTrip(t, "unix", path, "GET /unix HTTP/1.1\r\n\r\n")
+	assert.Contains(t, out, "GET /unix")
+
+	// a second server must not steal a socket that is still served
+	_, err = ServeWithOptions(echoHandler, Options{Network: "unix", Addr: path})
+	require.Error(t, err)
+
+	server.Close()
+}
+
+func TestServeWithOptionsOnExistingListener(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server, err := ServeWithOptions(echoHandler, Options{Listener: listener})
+	require.NoError(t, err)
+	defer server.Close()
+
+	assert.Equal(t, listener.Addr(), server.Addr())
+	assert.Contains(t, roundTrip(t, "tcp", listener.Addr().String(), "GET /socket HTTP/1.1\r\n\r\n"), "GET /socket")
+}
+
+func TestServeWithOptionsRejectsUnknownNetwork(t *testing.T) {
+	_, err := ServeWithOptions(echoHandler, Options{Network: "udp", Addr: "127.0.0.1:0"})
+	require.Error(t, err)
+}
+
+func TestServeWithOptionsEnforcesLimits(t *testing.T) {
+	server, err := ServeWithOptions(echoHandler, Options{
+		Addr:           "127.0.0.1:0",
+		MaxHeaderBytes: 64,
+		MaxBodyBytes:   4,
+	})
+	require.NoError(t, err)
+	defer server.Close()
+
+	addr := server.Addr().String()
+
+	out := roundTrip(t, "tcp", addr, "GET / HTTP/1.1\r\nX-Padding: "+strings.Repeat("a", 128)+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 431 Request Header Fields Too Large"))
+
+	out = roundTrip(t, "tcp", addr, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large"))
+}
+
+func TestServeWithOptionsReadTimeoutAndErrorLog(t *testing.T) {
+	logs := &syncBuffer{}
+
+	server, err := ServeWithOptions(echoHandler, Options{
+		Addr:        "127.0.0.1:0",
+		ReadTimeout: 50 * time.Millisecond,
+		TLS:         &TLSConfig{KeyPairs: []KeyPair{writeKeyPair(t, t.TempDir(), "localhost")}},
+		ErrorLog:    log.New(logs, "", 0),
+	})
+	require.NoError(t, err)
+	defer server.Close()
+
+	// a client that never starts the handshake is cut off by the read timeout
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	assert.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), "error: tls handshake")
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServeWithOptionsDecodesBody(t *testing.T) {
+	server, err := ServeWithOptions(echoHandler, Options{
+		Addr:                "127.0.0.1:0",
+		DecodeBody:          true,
+		MaxDecodedBodyBytes: 8,
+	})
+	require.NoError(t, err)
+	defer server.Close()
+
+	addr := server.Addr().String()
+	encode := func(body string) string {
+		buffer := &bytes.Buffer{}
+		writer := gzip.NewWriter(buffer)
+		writer.Write([]byte(body))
+		writer.Close()
+
+		return buffer.String()
+	}
+
+	body := encode("hello")
+	out := roundTrip(t, "tcp", addr, fmt.Sprintf("POST / HTTP/1.1\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", len(body), body))
+	assert.True(t, strings.HasSuffix(out, "POST / hello"))
+
+	body = encode("too long for the limit")
+	out = roundTrip(t, "tcp", addr, fmt.Sprintf("POST / HTTP/1.1\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", len(body), body))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 Content Too Large"))
+
+	out = roundTrip(t, "tcp", addr, "POST / HTTP/1.1\r\nContent-Encoding: br\r\nContent-Length: 2\r\n\r\nhi")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 415 Unsupported Media Type"))
+	assert.Contains(t, out, "accept-encoding: gzip, deflate")
+}
+
+func TestServeWithOptionsStreamsPickedBodies(t *testing.T) {
+	started := make(chan struct{}, 1)
+
+	server, err := ServeWithOptions(func(w *response.Writer, req *request.Request) {
+		if !req.Streamed() {
+			echoHandler(w, req)
+			return
+		}
+
+		started <- struct{}{}
+		body, err := io.ReadAll(req.BodyReader())
+
+		if err != nil {
+			ErrorFrom(err).Respond(w)
+			return
+		}
+
+		req.Body = body
+		echoHandler(w, req)
+	}, Options{
+		Addr:         "127.0.0.1:0",
+		MaxBodyBytes: 16,
+		StreamBody: func(req *request.Request) bool {
+			return req.RequestLine.RequestTarget == "/upload"
+		},
+	})
+	require.NoError(t, err)
+	defer server.Close()
+
+	addr := server.Addr().String()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// the handler runs before the rest of the body is sent
+	_, err = conn.Write([]byte("POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n"))
+	require.NoError(t, err)
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not start before the end of the body")
+	}
+
+	_, err = conn.Write([]byte("6\r\n world\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(out), "POST /upload hello world"))
+
+	out = []byte(roundTrip(t, "tcp", addr, "POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n11\r\n"+strings.Repeat("a", 17)+"\r\n0\r\n\r\n"))
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 413 Content Too Large"))
+	<-started
+
+	// the others are read whole first
+	out = []byte(roundTrip(t, "tcp", addr, "POST /form HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello"))
+	assert.True(t, strings.HasSuffix(string(out), "POST /form hello"))
+}
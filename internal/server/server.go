@@ -1,12 +1,21 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"strings"
 	"sync/atomic"
+	"time"
 
+	"github.com/sithusan/httpfromtcp/internal/http2"
+	"github.com/sithusan/httpfromtcp/internal/negotiate"
 	"github.com/sithusan/httpfromtcp/internal/request"
 	"github.com/sithusan/httpfromtcp/internal/response"
 )
@@ -19,7 +28,11 @@ type HandleError struct {
 }
 
 func (hE HandleError) Write(w io.Writer) {
-	writer := response.NewWriter(w)
+	hE.Respond(response.NewWriter(w))
+}
+
+// Respond writes the error through a writer a handler already holds, it is what middlewares use to reject a request.
+func (hE HandleError) Respond(writer *response.Writer) {
 	headers := response.GetDefaultHeaders(len(hE.Message))
 	headers.Override("Content-Type", "text/html")
 
@@ -28,33 +41,78 @@ func (hE HandleError) Write(w io.Writer) {
 	writer.WriteBody(hE.Message)
 }
 
+/*
+RespondTo is Respond in the format the client asks for with Accept: the text/html of Respond
+by default, text/plain, or an RFC9457 problem details object for a client that wants JSON.
+*/
+func (hE HandleError) RespondTo(writer *response.Writer, req *request.Request) {
+	accept, _ := req.Headers.Get(negotiate.KEY_ACCEPT)
+	contentType, _ := negotiate.ContentType(accept, "text/html", response.CONTENT_TYPE_PROBLEM_JSON, response.CONTENT_TYPE_JSON, "text/plain")
+	detail := strings.TrimSpace(string(hE.Message))
+
+	switch contentType {
+	case response.CONTENT_TYPE_PROBLEM_JSON:
+		response.WriteProblem(writer, response.NewProblem(hE.StatusCode, detail))
+	case response.CONTENT_TYPE_JSON:
+		// a client that only knows application/json still gets the members of a problem
+		response.WriteJSON(writer, hE.StatusCode, response.NewProblem(hE.StatusCode, detail))
+	case "text/plain":
+		headers := response.GetDefaultHeaders(len(hE.Message))
+
+		writer.WriteStatusLine(hE.StatusCode)
+		writer.WriteHeaders(headers)
+		writer.WriteBody(hE.Message)
+	default:
+		// an error is still sent to a client that accepts none of these, RFC9110 12.5.1 allows it
+		hE.Respond(writer)
+	}
+}
+
+// ErrorFrom answers err with the status it calls for, like 400 for a malformed form or 413 for one too large.
+func ErrorFrom(err error) HandleError {
+	return HandleError{
+		StatusCode: parseErrorStatus(err),
+		Message:    []byte(err.Error() + "\n"),
+	}
+}
+
 type Server struct {
 	listener net.Listener
 	closed   atomic.Bool
 	handler  Handler
+	done     chan struct{}
+	options  Options
+	errorLog *log.Logger
 }
 
 func Serve(port int, handler Handler) (*Server, error) {
-	addr := fmt.Sprintf(":%d", port)
-
-	listener, err := net.Listen("tcp", addr)
-
-	if err != nil {
-		return nil, err
-	}
+	return ServeWithOptions(handler, Options{Addr: fmt.Sprintf(":%d", port)})
+}
 
+func newServer(listener net.Listener, handler Handler, options Options) *Server {
 	server := &Server{
 		listener: listener,
 		handler:  handler,
+		done:     make(chan struct{}),
+		options:  options,
+		errorLog: options.ErrorLog,
+	}
+
+	if server.errorLog == nil {
+		server.errorLog = log.Default()
 	}
 
 	go server.listen()
 
-	return server, nil
+	return server
 }
 
 func (s *Server) Close() error {
-	s.closed.Store(true)
+	if s.closed.Swap(true) {
+		return nil
+	}
+
+	close(s.done)
 
 	if s.listener != nil {
 		return s.listener.Close()
@@ -71,29 +129,178 @@ func (s *Server) listen() {
 			if s.closed.Load() {
 				return
 			}
-			log.Printf("error: listening http %s", err)
+			s.errorLog.Printf("error: listening http %s", err)
 			continue
 		}
 
-		s.handle(conn)
+		if s.options.IPFilter != nil && !s.options.IPFilter.acceptable(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
+
+		// each connection gets its own goroutine, so a long lived stream does not block the others
+		go s.handle(conn)
 	}
 }
 
 func (s *Server) handle(conn net.Conn) {
 	defer conn.Close()
 
-	request, err := request.RequestFromReader(conn)
+	if s.options.ReadTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.options.ReadTimeout))
+	}
+
+	var tlsState *tls.ConnectionState
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// handshake up front, a failed one has no channel left to answer with 400
+		if err := tlsConn.Handshake(); err != nil {
+			s.errorLog.Printf("error: tls handshake from %s: %s", conn.RemoteAddr(), err)
+			return
+		}
+
+		state := tlsConn.ConnectionState()
+		tlsState = &state
+	}
+
+	reader := bufio.NewReader(conn)
+
+	if s.h2c(tlsState) && http2.HasPreface(reader) {
+		// the connection times out reads per stream itself, see http2.Options
+		if err := http2.ServeConn(conn, reader, s.serveHTTP2, s.http2Options()); err != nil {
+			s.errorLog.Printf("error: serving http2 %s", err)
+		}
+		return
+	}
+
+	request, err := s.readRequest(reader, tlsState, conn.RemoteAddr())
+
+	if s.options.WriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.options.WriteTimeout))
+	}
 
 	if err != nil {
 		hErr := &HandleError{
-			StatusCode: response.BAD_REQUEST,
+			StatusCode: parseErrorStatus(err),
 			Message:    []byte(err.Error()),
 		}
 		hErr.Write(conn)
 		return
 	}
 
-	s.handler(
-		response.NewWriter(conn),
-		request)
+	// the parser may have read past the request, those bytes come first for whoever reads next
+	if unread := request.Unread(); len(unread) > 0 {
+		reader = bufio.NewReader(io.MultiReader(bytes.NewReader(unread), reader))
+	}
+
+	if s.h2c(tlsState) && http2.IsUpgradeRequest(request) {
+		conn.SetWriteDeadline(time.Time{})
+
+		if err := http2.ServeUpgrade(conn, reader, request, s.serveHTTP2, s.http2Options()); err != nil {
+			s.errorLog.Printf("error: upgrading to http2 %s", err)
+		}
+		return
+	}
+
+	w := response.NewConnWriter(conn, reader)
+
+	// the handler answers HEAD like GET, only the headers go out
+	if request.RequestLine.Method == "HEAD" {
+		w.SuppressBody()
+	}
+
+	// the context of the request ends with the handler, or before when the client leaves
+	ctx, cancel := context.WithCancel(request.Context())
+	request.SetContext(ctx)
+	defer cancel()
+
+	stop := watchClose(conn, reader, request, cancel)
+	defer stop()
+
+	// a hijacked connection is read by the handler only
+	w.OnHijack(stop)
+
+	s.handler(w, request)
+}
+
+/*
+readRequest reads the whole request, unless StreamBody picks it to be handed over with its body
+still on the connection.
+*/
+func (s *Server) readRequest(reader io.Reader, tlsState *tls.ConnectionState, remoteAddr net.Addr) (*request.Request, error) {
+	limits := request.Limits{
+		MaxHeaderBytes: s.options.MaxHeaderBytes,
+		MaxBodyBytes:   s.options.MaxBodyBytes,
+	}
+
+	var req *request.Request
+	var err error
+
+	if s.options.StreamBody == nil {
+		req, err = request.RequestFromReaderWithLimits(reader, limits)
+	} else {
+		req, err = request.HeadFromReader(reader, limits)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.TLS = tlsState
+	req.RemoteAddr = remoteAddr.String()
+
+	if s.options.StreamBody == nil {
+		return req, nil
+	}
+
+	// an upgrade to h2c hands the body over as the first stream, whole
+	if (s.h2c(tlsState) && http2.IsUpgradeRequest(req)) || !s.options.StreamBody(req) {
+		if err := req.ReadBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	return req, nil
+}
+
+/*
+serveHTTP2 runs the handler of an HTTP/2 stream, which starts while its body is still arriving.
+Like readRequest does for HTTP/1.1, the body is read whole first, unless StreamBody picks the
+request, so MaxBodyBytes is answered with 413 before the handler runs.
+*/
+func (s *Server) serveHTTP2(w *response.Writer, req *request.Request) {
+	if s.options.StreamBody == nil || !s.options.StreamBody(req) {
+		if err := req.ReadBody(); err != nil {
+			ErrorFrom(err).RespondTo(w, req)
+			return
+		}
+	}
+
+	s.handler(w, req)
+}
+
+func (s *Server) http2Options() http2.Options {
+	return http2.Options{
+		MaxHeaderBytes: s.options.MaxHeaderBytes,
+		MaxBodyBytes:   s.options.MaxBodyBytes,
+		ReadTimeout:    s.options.ReadTimeout,
+	}
+}
+
+// h2c tells whether HTTP/2 over cleartext is served on a connection, never over TLS, where it would be negotiated with ALPN.
+func (s *Server) h2c(tlsState *tls.ConnectionState) bool {
+	return tlsState == nil && !s.options.DisableH2C
+}
+
+func parseErrorStatus(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, request.ErrHeadersTooLarge):
+		return response.REQUEST_HEADER_FIELDS_TOO_LARGE
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.CONTENT_TOO_LARGE
+	case errors.Is(err, request.ErrUnsupportedEncoding), errors.Is(err, request.ErrUnsupportedMediaType):
+		return response.UNSUPPORTED_MEDIA_TYPE
+	default:
+		return response.BAD_REQUEST
+	}
 }
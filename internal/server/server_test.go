@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorFromAnswersFormErrors(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		if _, err := req.ParseFormWithLimits(request.FormLimits{MaxBytes: 16}); err != nil {
+			ErrorFrom(err).Respond(w)
+			return
+		}
+
+		body := []byte(req.FormValue("name"))
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+
+	server, err := ServeWithOptions(handler, Options{Addr: "127.0.0.1:0"})
+	require.NoError(t, err)
+	defer server.Close()
+
+	addr := server.Addr().String()
+	post := func(body string) string {
+		return roundTrip(t, "tcp", addr, fmt.Sprintf("POST / HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: %d\r\n\r\n%s", len(body), body))
+	}
+
+	assert.True(t, strings.HasSuffix(post("name=tcp+server"), "tcp server"))
+
+	out := post("name=%zz")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request"))
+	assert.Contains(t, out, "malformed form")
+
+	assert.True(t, strings.HasPrefix(post("name="+strings.Repeat("x", 32)), "HTTP/1.1 413 Content Too Large"))
+}
+
+func TestHandleErrorRespondToNegotiatesFormat(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		HandleError{StatusCode: response.NOT_FOUND, Message: []byte("not found\n")}.RespondTo(w, req)
+	}
+
+	server, err := ServeWithOptions(handler, Options{Addr: "127.0.0.1:0"})
+	require.NoError(t, err)
+	defer server.Close()
+
+	addr := server.Addr().String()
+	get := func(accept string) string {
+		return roundTrip(t, "tcp", addr, "GET / HTTP/1.1\r\nHost: localhost\r\nAccept: "+accept+"\r\n\r\n")
+	}
+
+	out := get("text/html,*/*;q=0.8")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found"))
+	assert.Contains(t, out, "content-type: text/html")
+
+	out = get("application/json")
+	assert.Contains(t, out, "content-type: application/json")
+	assert.Contains(t, out, `"status":404`)
+	assert.Contains(t, out, `"detail":"not found"`)
+
+	out = get("application/problem+json")
+	assert.Contains(t, out, "content-type: application/problem+json")
+
+	out = get("text/plain")
+	assert.Contains(t, out, "content-type: text/plain")
+	assert.True(t, strings.HasSuffix(out, "not found\n"))
+
+	// nothing acceptable still gets the error, as HTML
+	out = get("image/png")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found"))
+	assert.Contains(t, out, "content-type: text/html")
+}
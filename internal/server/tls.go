@@ -0,0 +1,261 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const DEFAULT_CERT_RELOAD_INTERVAL = 10 * time.Second
+
+type KeyPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+/*
+TLSConfig either points at certificate/key files, or carries a ready *tls.Config, or both.
+With several key pairs, the certificate is picked by the SNI server name of the ClientHello.
+Certificates loaded from files are reloaded when the files change on disk, so renewing
+a certificate does not need a restart.
+*/
+type TLSConfig struct {
+	KeyPairs []KeyPair
+	// Config is cloned, certificates from KeyPairs are served through its GetCertificate.
+	Config *tls.Config
+	// ReloadInterval is how often the files are checked for changes, zero means DEFAULT_CERT_RELOAD_INTERVAL.
+	ReloadInterval time.Duration
+
+	// ClientCAFile is a PEM bundle of CAs that client certificates are verified against.
+	ClientCAFile string
+	// ClientAuth defaults to tls.VerifyClientCertIfGiven when ClientCAFile is set,
+	// so routes can decide with RequireClientCert whether a certificate is needed.
+	ClientAuth tls.ClientAuthType
+}
+
+func ServeTLS(port int, handler Handler, config TLSConfig) (*Server, error) {
+	return ServeWithOptions(handler, Options{
+		Addr: fmt.Sprintf(":%d", port),
+		TLS:  &config,
+	})
+}
+
+func (c TLSConfig) build() (*tls.Config, *CertificateStore, error) {
+	if len(c.KeyPairs) == 0 && c.Config == nil {
+		return nil, nil, fmt.Errorf("error: tls needs key pairs or a tls config")
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if c.Config != nil {
+		tlsConfig = c.Config.Clone()
+	}
+
+	if c.ClientCAFile != "" {
+		pool, err := loadCertPool(c.ClientCAFile)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = c.ClientAuth
+
+		if tlsConfig.ClientAuth == tls.NoClientCert {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	if len(c.KeyPairs) == 0 {
+		return tlsConfig, nil, nil
+	}
+
+	store, err := NewCertificateStore(c.KeyPairs...)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig.GetCertificate = store.GetCertificate
+
+	return tlsConfig, store, nil
+}
+
+type certificate struct {
+	keyPair KeyPair
+	modTime time.Time
+	cert    *tls.Certificate
+}
+
+// CertificateStore holds certificates loaded from files and serves them by SNI.
+type CertificateStore struct {
+	mu           sync.RWMutex
+	certificates []*certificate
+}
+
+func NewCertificateStore(keyPairs ...KeyPair) (*CertificateStore, error) {
+	store := &CertificateStore{}
+
+	for _, keyPair := range keyPairs {
+		cert, err := loadCertificate(keyPair)
+
+		if err != nil {
+			return nil, err
+		}
+
+		store.certificates = append(store.certificates, cert)
+	}
+
+	return store, nil
+}
+
+/*
+GetCertificate picks the first certificate valid for the requested server name.
+Clients that send no SNI, or a name no certificate covers, get the first one.
+*/
+func (s *CertificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.certificates) == 0 {
+		return nil, fmt.Errorf("error: no certificate loaded")
+	}
+
+	if hello.ServerName != "" {
+		for _, cert := range s.certificates {
+			if hello.SupportsCertificate(cert.cert) == nil {
+				return cert.cert, nil
+			}
+		}
+	}
+
+	return s.certificates[0].cert, nil
+}
+
+// Reload loads again every key pair whose files changed, a broken pair keeps its previous certificate.
+func (s *CertificateStore) Reload() error {
+	s.mu.RLock()
+	certificates := append([]*certificate{}, s.certificates...)
+	s.mu.RUnlock()
+
+	var firstErr error
+
+	for i, current := range certificates {
+		modTime, err := keyPairModTime(current.keyPair)
+
+		if err != nil {
+			firstErr = orFirst(firstErr, err)
+			continue
+		}
+
+		if !modTime.After(current.modTime) {
+			continue
+		}
+
+		reloaded, err := loadCertificate(current.keyPair)
+
+		if err != nil {
+			firstErr = orFirst(firstErr, err)
+			continue
+		}
+
+		s.mu.Lock()
+		s.certificates[i] = reloaded
+		s.mu.Unlock()
+	}
+
+	return firstErr
+}
+
+func (s *CertificateStore) watch(interval time.Duration, done <-chan struct{}, errorLog *log.Logger) {
+	if interval <= 0 {
+		interval = DEFAULT_CERT_RELOAD_INTERVAL
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := s.Reload(); err != nil {
+				errorLog.Printf("error: reloading certificates %s", err)
+			}
+		}
+	}
+}
+
+/**
+* Helpers
+**/
+
+func loadCertificate(keyPair KeyPair) (*certificate, error) {
+	modTime, err := keyPairModTime(keyPair)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(keyPair.CertFile, keyPair.KeyFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("error: loading key pair %s: %w", keyPair.CertFile, err)
+	}
+
+	return &certificate{
+		keyPair: keyPair,
+		modTime: modTime,
+		cert:    &cert,
+	}, nil
+}
+
+// the newer of both files, so replacing only the key (or only the cert) still triggers a reload
+func keyPairModTime(keyPair KeyPair) (time.Time, error) {
+	certInfo, err := os.Stat(keyPair.CertFile)
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	keyInfo, err := os.Stat(keyPair.KeyFile)
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+
+	return certInfo.ModTime(), nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	bundle, err := os.ReadFile(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("error: no certificate found in %s", file)
+	}
+
+	return pool, nil
+}
+
+func orFirst(first, err error) error {
+	if first != nil {
+		return first
+	}
+
+	return err
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeyPair writes a self signed certificate for dnsName into dir and returns its files
+func writeKeyPair(t *testing.T, dir, dnsName string) KeyPair {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	keyPair := KeyPair{
+		CertFile: filepath.Join(dir, dnsName+".crt"),
+		KeyFile:  filepath.Join(dir, dnsName+".key"),
+	}
+
+	require.NoError(t, os.WriteFile(keyPair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyPair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+
+	return keyPair
+}
+
+func tlsVersionHandler(w *response.Writer, req *request.Request) {
+	body := []byte(tls.VersionName(req.TLS.Version))
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestServeTLSSelectsCertificateBySNI(t *testing.T) {
+	dir := t.TempDir()
+	first := writeKeyPair(t, dir, "first.test")
+	second := writeKeyPair(t, dir, "second.test")
+
+	server, err := ServeTLS(0, tlsVersionHandler, TLSConfig{KeyPairs: []KeyPair{first, second}})
+	require.NoError(t, err)
+	defer server.Close()
+
+	for _, name := range []string{"first.test", "second.test"} {
+		conn, err := tls.Dial("tcp", server.listener.Addr().String(), &tls.Config{
+			ServerName:         name,
+			InsecureSkipVerify: true,
+			MinVersion:         tls.VersionTLS13,
+		})
+		require.NoError(t, err)
+
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + name + "\r\n\r\n"))
+		require.NoError(t, err)
+
+		out, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		conn.Close()
+
+		assert.Equal(t, name, conn.ConnectionState().PeerCertificates[0].Subject.CommonName)
+		assert.Contains(t, string(out), "TLS 1.3")
+	}
+}
+
+func TestCertificateStoreReloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	keyPair := writeKeyPair(t, dir, "reload.test")
+
+	store, err := NewCertificateStore(keyPair)
+	require.NoError(t, err)
+
+	before, err := store.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+
+	writeKeyPair(t, dir, "reload.test")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(keyPair.CertFile, future, future))
+	require.NoError(t, store.Reload())
+
+	after, err := store.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.NotEqual(t, before.Leaf.SerialNumber, after.Leaf.SerialNumber)
+}
+
+func TestCertificateStoreKeepsCertificateOnBrokenReload(t *testing.T) {
+	dir := t.TempDir()
+	keyPair := writeKeyPair(t, dir, "broken.test")
+
+	store, err := NewCertificateStore(keyPair)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(keyPair.CertFile, []byte("not a certificate"), 0o600))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(keyPair.CertFile, future, future))
+	require.Error(t, store.Reload())
+
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, "broken.test", cert.Leaf.Subject.CommonName)
+}
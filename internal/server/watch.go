@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+)
+
+/*
+watchClose calls cancel when the client closes the connection while the handler still runs, so work done on its behalf, like a proxy waiting on its upstream, stops with it.
+It only reads once the body has been read, and stops at the first byte of anything the client
+sends next, which is left in reader. stop ends the watch and waits for it.
+*/
+func watchClose(conn net.Conn, reader *bufio.Reader, req *request.Request, cancel context.CancelFunc) (stop func()) {
+	mu := sync.Mutex{}
+	stopping := make(chan struct{})
+	stopped := false
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		select {
+		case <-req.BodyDone():
+		case <-stopping:
+			return
+		}
+
+		mu.Lock()
+
+		if stopped {
+			mu.Unlock()
+			return
+		}
+
+		// the read timeout was for the request, waiting for the client to leave is not bound by it
+		conn.SetReadDeadline(time.Time{})
+		mu.Unlock()
+
+		_, err := reader.Peek(1)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		// a Peek cut short by stop is not the client leaving
+		if err != nil && !stopped {
+			cancel()
+		}
+	}()
+
+	return func() {
+		mu.Lock()
+
+		if !stopped {
+			stopped = true
+			close(stopping)
+			// wakes the Peek up
+			conn.SetReadDeadline(time.Now())
+		}
+
+		mu.Unlock()
+
+		<-done
+		conn.SetReadDeadline(time.Time{})
+	}
+}
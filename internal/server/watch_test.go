@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCancelsRequestContextWhenClientLeaves(t *testing.T) {
+	started := make(chan struct{}, 1)
+	ended := make(chan error, 1)
+
+	server, err := ServeWithOptions(func(w *response.Writer, req *request.Request) {
+		started <- struct{}{}
+
+		select {
+		case <-req.Context().Done():
+			ended <- req.Context().Err()
+		case <-time.After(time.Second):
+			ended <- nil
+			echoHandler(w, req)
+		}
+	}, Options{Addr: "127.0.0.1:0"})
+	require.NoError(t, err)
+	defer server.Close()
+
+	addr := server.Addr().String()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+
+	<-started
+	conn.Close()
+	assert.Error(t, <-ended)
+
+	// a client that waits for its answer keeps the context alive, and gets the answer
+	out := roundTrip(t, "tcp", addr, "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello")
+	<-started
+	assert.NoError(t, <-ended)
+	assert.Contains(t, out, "POST / hello")
+}
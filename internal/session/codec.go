@@ -0,0 +1,158 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MIN_KEY_SIZE is the shortest signing key accepted, the size of the HMAC-SHA256 output.
+const MIN_KEY_SIZE = 32
+
+var errInvalidCookie = errors.New("invalid session cookie")
+
+/*
+codec turns a value into a cookie value the client can hold but not forge:
+
+	base64url(issued at | value) "." base64url(HMAC-SHA256(name "." first part))
+
+When encryption keys are set, value is nonce | AES-GCM ciphertext, so the client cannot read it either.
+The cookie name is part of the signature and of the additional data, a cookie cannot be replayed under another name.
+Keys rotate: the first key signs and encrypts, every key is tried to verify and decrypt.
+*/
+type codec struct {
+	hashKeys [][]byte
+	aeads    []cipher.AEAD
+	maxAge   time.Duration
+}
+
+func newCodec(hashKeys, encryptionKeys [][]byte, maxAge time.Duration) (*codec, error) {
+	if len(hashKeys) == 0 {
+		return nil, fmt.Errorf("sessions need at least one signing key")
+	}
+
+	for _, key := range hashKeys {
+		if len(key) < MIN_KEY_SIZE {
+			return nil, fmt.Errorf("signing keys must be at least %d bytes, got %d", MIN_KEY_SIZE, len(key))
+		}
+	}
+
+	c := &codec{hashKeys: hashKeys, maxAge: maxAge}
+
+	for _, key := range encryptionKeys {
+		// aes.NewCipher only accepts 16, 24 and 32 byte keys, for AES-128, AES-192 and AES-256
+		block, err := aes.NewCipher(key)
+
+		if err != nil {
+			return nil, fmt.Errorf("encryption key: %s", err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+
+		if err != nil {
+			return nil, err
+		}
+
+		c.aeads = append(c.aeads, aead)
+	}
+
+	return c, nil
+}
+
+func (c *codec) encode(name string, value []byte, now time.Time) (string, error) {
+	if len(c.aeads) > 0 {
+		aead := c.aeads[0]
+		nonce := make([]byte, aead.NonceSize())
+
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+
+		value = aead.Seal(nonce, nonce, value, []byte(name))
+	}
+
+	data := binary.BigEndian.AppendUint64(nil, uint64(now.Unix()))
+	data = append(data, value...)
+
+	encoded := base64.RawURLEncoding.EncodeToString(data)
+	signature := base64.RawURLEncoding.EncodeToString(sign(c.hashKeys[0], name, encoded))
+
+	return encoded + "." + signature, nil
+}
+
+func (c *codec) decode(name, cookieValue string, now time.Time) ([]byte, error) {
+	encoded, encodedSignature, ok := strings.Cut(cookieValue, ".")
+
+	if !ok {
+		return nil, errInvalidCookie
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+
+	if err != nil || !c.verify(name, encoded, signature) {
+		return nil, errInvalidCookie
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+
+	if err != nil || len(data) < 8 {
+		return nil, errInvalidCookie
+	}
+
+	// the signature covers the time it was issued, an old cookie kept by the client expires anyway
+	issuedAt := time.Unix(int64(binary.BigEndian.Uint64(data[:8])), 0)
+
+	if c.maxAge > 0 && now.Sub(issuedAt) > c.maxAge {
+		return nil, fmt.Errorf("%w: expired", errInvalidCookie)
+	}
+
+	value := data[8:]
+
+	if len(c.aeads) == 0 {
+		return value, nil
+	}
+
+	for _, aead := range c.aeads {
+		if len(value) < aead.NonceSize() {
+			continue
+		}
+
+		nonce, ciphertext := value[:aead.NonceSize()], value[aead.NonceSize():]
+
+		if plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(name)); err == nil {
+			return plaintext, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: cannot decrypt", errInvalidCookie)
+}
+
+// verify compares with hmac.Equal, in constant time, against every key.
+func (c *codec) verify(name, encoded string, signature []byte) bool {
+	for _, key := range c.hashKeys {
+		if hmac.Equal(signature, sign(key, name, encoded)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/**
+* Helpers
+**/
+
+func sign(key []byte, name, encoded string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "." + encoded))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,390 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/cookie"
+	"github.com/sithusan/httpfromtcp/internal/headers"
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/sithusan/httpfromtcp/internal/server"
+)
+
+const DEFAULT_COOKIE_NAME = "session"
+const DEFAULT_MAX_AGE = 24 * time.Hour
+
+// ID_SIZE is the number of random bytes in a session ID, it is sent as hex.
+const ID_SIZE = 32
+
+// MAX_COOKIE_SIZE is the size browsers are required to keep for a cookie (RFC6265 6.1).
+const MAX_COOKIE_SIZE = 4096
+
+type Options struct {
+	// Keys sign the cookie with HMAC-SHA256, at least MIN_KEY_SIZE bytes each.
+	// The first one signs and all of them verify, so a new key goes first and the old one stays until its cookies expire.
+	Keys [][]byte
+	// EncryptionKeys also encrypt the cookie with AES-GCM, 16, 24 or 32 bytes each, rotated like Keys.
+	EncryptionKeys [][]byte
+
+	// Store keeps the values on the server and the cookie only carries the session ID,
+	// nil keeps the values in the cookie itself.
+	Store Store
+
+	// CookieName is empty means DEFAULT_COOKIE_NAME.
+	CookieName string
+	// MaxAge is how long a session lives after its last response, zero means DEFAULT_MAX_AGE.
+	MaxAge time.Duration
+	// Path is empty means "/".
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite cookie.SameSite
+}
+
+/*
+Session holds the values of one client across requests. Handlers get it with FromRequest
+and change it freely until they write the response headers, that is when it is saved and the
+cookie is sent. With a Store, later changes are saved once the handler returns.
+*/
+type Session struct {
+	mu     sync.Mutex
+	id     string
+	values map[string]string
+	isNew  bool
+
+	modified  bool
+	destroyed bool
+	// previousID is the ID to forget after Regenerate
+	previousID string
+}
+
+type contextKey struct{}
+
+// FromRequest returns the session the middleware attached to req, nil without the middleware.
+func FromRequest(req *request.Request) *Session {
+	s, _ := req.Context().Value(contextKey{}).(*Session)
+
+	return s
+}
+
+// ID is empty for a session kept in its cookie, there is nothing to identify on the server.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.id
+}
+
+// IsNew tells whether the client came without a valid session.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.isNew
+}
+
+func (s *Session) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.values[key]
+
+	return value, ok
+}
+
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values[key] = value
+	s.modified = true
+	s.destroyed = false
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.values, key)
+	s.modified = true
+}
+
+// Destroy drops every value, the client is told to forget its cookie, like on log out.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values = map[string]string{}
+	s.modified = true
+	s.destroyed = true
+}
+
+/*
+Regenerate keeps the values under a new ID. Calling it when privileges change, like on log in,
+defeats session fixation: an ID planted or seen before is no good afterwards.
+*/
+func (s *Session) Regenerate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.id == "" {
+		return
+	}
+
+	if s.previousID == "" {
+		s.previousID = s.id
+	}
+
+	s.id = newID()
+	s.modified = true
+}
+
+/*
+Middleware loads the session named by the cookie of each request, or starts an empty one,
+and attaches it to the request for FromRequest. The cookie goes out with the response headers
+only once the session holds something, so visitors that never use it get no cookie.
+*/
+func Middleware(options Options) (server.Middleware, error) {
+	if options.CookieName == "" {
+		options.CookieName = DEFAULT_COOKIE_NAME
+	}
+
+	if options.MaxAge == 0 {
+		options.MaxAge = DEFAULT_MAX_AGE
+	}
+
+	if options.Path == "" {
+		options.Path = "/"
+	}
+
+	codec, err := newCodec(options.Keys, options.EncryptionKeys, options.MaxAge)
+
+	if err != nil {
+		return nil, err
+	}
+
+	m := &manager{options: options, codec: codec}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			s, err := m.load(req)
+
+			if err != nil {
+				server.HandleError{StatusCode: response.INTERNAL_SERVER_ERROR, Message: []byte("session unavailable\n")}.RespondTo(w, req)
+				return
+			}
+
+			req.SetContext(context.WithValue(req.Context(), contextKey{}, s))
+
+			stream := &sessionStream{next: w.Stream(), manager: m, session: s}
+			next(w.Filter(stream), req)
+
+			// with a store, what changed after the headers went out can still be kept
+			if stream.committed {
+				m.saveLate(s)
+			}
+		}
+	}, nil
+}
+
+type manager struct {
+	options Options
+	codec   *codec
+}
+
+func (m *manager) load(req *request.Request) (*Session, error) {
+	s := &Session{values: map[string]string{}, isNew: true}
+
+	if m.options.Store != nil {
+		s.id = newID()
+	}
+
+	value, ok := cookie.Get(req, m.options.CookieName)
+
+	if !ok {
+		return s, nil
+	}
+
+	// a tampered, expired or undecryptable cookie is the same as none
+	decoded, err := m.codec.decode(m.options.CookieName, value, time.Now())
+
+	if err != nil {
+		return s, nil
+	}
+
+	if m.options.Store == nil {
+		if json.Unmarshal(decoded, &s.values) != nil || s.values == nil {
+			s.values = map[string]string{}
+			return s, nil
+		}
+
+		s.isNew = false
+		return s, nil
+	}
+
+	id := string(decoded)
+
+	if !validID(id) {
+		return s, nil
+	}
+
+	values, found, err := m.options.Store.Load(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return s, nil
+	}
+
+	s.id = id
+	s.values = values
+	s.isNew = false
+
+	return s, nil
+}
+
+// commit saves s and adds the Set-Cookie that goes with it to h.
+func (m *manager) commit(s *Session, h headers.Headers) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	store := m.options.Store
+
+	if store != nil && s.previousID != "" {
+		if err := store.Delete(s.previousID); err != nil {
+			return err
+		}
+	}
+
+	if s.destroyed || (len(s.values) == 0 && !s.isNew) {
+		if store != nil {
+			if err := store.Delete(s.id); err != nil {
+				return err
+			}
+		}
+
+		s.modified = false
+
+		return cookie.Delete(h, m.options.CookieName, m.options.Path, m.options.Domain)
+	}
+
+	if len(s.values) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	value := []byte(s.id)
+
+	if store != nil {
+		if err := store.Save(s.id, s.values, now.Add(m.options.MaxAge)); err != nil {
+			return err
+		}
+	} else {
+		encoded, err := json.Marshal(s.values)
+
+		if err != nil {
+			return err
+		}
+
+		value = encoded
+	}
+
+	s.modified = false
+
+	encoded, err := m.codec.encode(m.options.CookieName, value, now)
+
+	if err != nil {
+		return err
+	}
+
+	c := cookie.Cookie{
+		Name:     m.options.CookieName,
+		Value:    encoded,
+		Path:     m.options.Path,
+		Domain:   m.options.Domain,
+		MaxAge:   int(m.options.MaxAge / time.Second),
+		Secure:   m.options.Secure,
+		HttpOnly: true,
+		SameSite: m.options.SameSite,
+	}
+
+	line, err := c.Line()
+
+	if err != nil {
+		return err
+	}
+
+	if len(line) > MAX_COOKIE_SIZE {
+		return fmt.Errorf("session cookie of %d bytes exceeds %d, use a Store", len(line), MAX_COOKIE_SIZE)
+	}
+
+	h.Add(cookie.KEY_SET_COOKIE, line)
+
+	return nil
+}
+
+// saveLate keeps the changes made after the headers were written, only a store can.
+func (m *manager) saveLate(s *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m.options.Store == nil || !s.modified {
+		return
+	}
+
+	if s.destroyed {
+		m.options.Store.Delete(s.id)
+		return
+	}
+
+	m.options.Store.Save(s.id, s.values, time.Now().Add(m.options.MaxAge))
+}
+
+/*
+sessionStream is the filter between the handler and the connection, it commits the session
+when the handler writes its headers, the last moment a Set-Cookie can still be added.
+A session that cannot be saved fails WriteHeaders with the error.
+*/
+type sessionStream struct {
+	next      response.Stream
+	manager   *manager
+	session   *Session
+	committed bool
+}
+
+func (s *sessionStream) WriteHead(statusCode response.StatusCode, h headers.Headers) error {
+	if err := s.manager.commit(s.session, h); err != nil {
+		return err
+	}
+
+	s.committed = true
+
+	return s.next.WriteHead(statusCode, h)
+}
+
+func (s *sessionStream) WriteData(p []byte, endStream bool) error {
+	return s.next.WriteData(p, endStream)
+}
+
+func (s *sessionStream) WriteTrailers(h headers.Headers) error {
+	return s.next.WriteTrailers(h)
+}
+
+/**
+* Helpers
+**/
+
+func newID() string {
+	id := make([]byte, ID_SIZE)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
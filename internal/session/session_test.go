@@ -0,0 +1,221 @@
+package session
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/sithusan/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var key = []byte("0123456789abcdef0123456789abcdef")
+var otherKey = []byte("fedcba9876543210fedcba9876543210")
+
+// counter counts visits in the session, "/login" regenerates it and "/logout" destroys it
+func counter(w *response.Writer, req *request.Request) {
+	s := FromRequest(req)
+
+	switch req.RequestLine.RequestTarget {
+	case "/login":
+		s.Regenerate()
+		s.Set("user", "alice")
+	case "/logout":
+		s.Destroy()
+	case "/peek":
+	default:
+		visits, _ := s.Get("visits")
+		s.Set("visits", visits+"I")
+	}
+
+	visits, _ := s.Get("visits")
+	body := []byte(visits)
+
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// serve runs handler for one request carrying sessionCookie, and returns the body and the new cookie value if any
+func serve(t *testing.T, handler server.Handler, target, sessionCookie string) (string, string, []string) {
+	t.Helper()
+
+	raw := "GET " + target + " HTTP/1.1\r\nHost: localhost\r\n"
+
+	if sessionCookie != "" {
+		raw += "Cookie: other=1; session=" + sessionCookie + "\r\n"
+	}
+
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	buffer := &bytes.Buffer{}
+	handler(response.NewWriter(buffer), req)
+
+	resp, err := response.ResponseFromReader(buffer, "GET")
+	require.NoError(t, err)
+
+	lines := resp.Headers.Values("Set-Cookie")
+	value := ""
+
+	if len(lines) > 0 {
+		pair, _, _ := strings.Cut(lines[0], ";")
+		value = strings.TrimPrefix(pair, "session=")
+	}
+
+	return string(resp.Body), value, lines
+}
+
+func middleware(t *testing.T, options Options) server.Handler {
+	mw, err := Middleware(options)
+	require.NoError(t, err)
+
+	return server.Chain(counter, mw)
+}
+
+func TestCookieSessionRoundTrip(t *testing.T) {
+	handler := middleware(t, Options{Keys: [][]byte{key}})
+
+	body, value, lines := serve(t, handler, "/", "")
+	assert.Equal(t, "I", body)
+	require.Len(t, lines, 1)
+	assert.Contains(t, lines[0], "Path=/; Max-Age=86400; HttpOnly")
+
+	body, value, _ = serve(t, handler, "/", value)
+	assert.Equal(t, "II", body)
+
+	// a tampered cookie is no session at all
+	body, _, _ = serve(t, handler, "/", value[:len(value)-2]+"AA")
+	assert.Equal(t, "I", body)
+
+	// a session nobody wrote to gets no cookie
+	_, _, lines = serve(t, handler, "/peek", "")
+	assert.Empty(t, lines)
+}
+
+func TestEncryptedCookieSession(t *testing.T) {
+	handler := middleware(t, Options{Keys: [][]byte{key}, EncryptionKeys: [][]byte{key[:16]}})
+
+	_, value, _ := serve(t, handler, "/login", "")
+	assert.NotContains(t, value, "alice")
+
+	encoded, _, _ := strings.Cut(value, ".")
+	assert.NotContains(t, encoded, "YWxpY2") // base64 of "alice"
+
+	body, _, _ := serve(t, handler, "/", value)
+	assert.Equal(t, "I", body)
+
+	// another encryption key cannot read it
+	other := middleware(t, Options{Keys: [][]byte{key}, EncryptionKeys: [][]byte{otherKey[:16]}})
+	_, _, lines := serve(t, other, "/peek", value)
+	assert.Empty(t, lines)
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := middleware(t, Options{Keys: [][]byte{key}})
+	_, value, _ := serve(t, old, "/", "")
+
+	rotated := middleware(t, Options{Keys: [][]byte{otherKey, key}})
+	body, newValue, _ := serve(t, rotated, "/", value)
+	assert.Equal(t, "II", body)
+
+	retired := middleware(t, Options{Keys: [][]byte{otherKey}})
+	body, _, _ = serve(t, retired, "/", value)
+	assert.Equal(t, "I", body)
+
+	// the rotated handler re-issued the cookie with the new key
+	body, _, _ = serve(t, retired, "/", newValue)
+	assert.Equal(t, "III", body)
+}
+
+func TestExpiredCookieIsRejected(t *testing.T) {
+	c, err := newCodec([][]byte{key}, nil, time.Hour)
+	require.NoError(t, err)
+
+	value, err := c.encode("session", []byte("x"), time.Now().Add(-2*time.Hour))
+	require.NoError(t, err)
+
+	_, err = c.decode("session", value, time.Now())
+	require.ErrorIs(t, err, errInvalidCookie)
+
+	// a cookie only verifies under its own name
+	value, err = c.encode("session", []byte("x"), time.Now())
+	require.NoError(t, err)
+
+	_, err = c.decode("other", value, time.Now())
+	require.ErrorIs(t, err, errInvalidCookie)
+}
+
+func TestMemoryStoreSession(t *testing.T) {
+	store := NewMemoryStore()
+	handler := middleware(t, Options{Keys: [][]byte{key}, Store: store})
+
+	_, value, _ := serve(t, handler, "/", "")
+	body, value, _ := serve(t, handler, "/", value)
+	assert.Equal(t, "II", body)
+	assert.Equal(t, 1, store.Len())
+
+	// log in moves the values to a new ID, the old one no longer works
+	_, loggedIn, _ := serve(t, handler, "/login", value)
+	assert.NotEqual(t, value, loggedIn)
+	assert.Equal(t, 1, store.Len())
+
+	body, _, _ = serve(t, handler, "/", value)
+	assert.Equal(t, "I", body)
+
+	body, _, _ = serve(t, handler, "/peek", loggedIn)
+	assert.Equal(t, "II", body)
+
+	_, _, lines := serve(t, handler, "/logout", loggedIn)
+	require.Len(t, lines, 1)
+	assert.Contains(t, lines[0], "Max-Age=0")
+
+	body, _, _ = serve(t, handler, "/peek", loggedIn)
+	assert.Equal(t, "", body)
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	require.NoError(t, err)
+
+	id := newID()
+	require.NoError(t, store.Save(id, map[string]string{"user": "alice"}, time.Now().Add(time.Hour)))
+
+	reopened, err := NewFileStore(dir)
+	require.NoError(t, err)
+
+	values, ok, err := reopened.Load(id)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"user": "alice"}, values)
+
+	expired := newID()
+	require.NoError(t, store.Save(expired, map[string]string{"user": "bob"}, time.Now().Add(-time.Second)))
+
+	_, ok, err = store.Load(expired)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, store.Delete(id))
+	_, ok, _ = store.Load(id)
+	assert.False(t, ok)
+
+	_, _, err = store.Load("../../etc/passwd")
+	require.Error(t, err)
+}
+
+func TestRejectsWeakKeys(t *testing.T) {
+	_, err := Middleware(Options{})
+	require.Error(t, err)
+
+	_, err = Middleware(Options{Keys: [][]byte{[]byte("short")}})
+	require.Error(t, err)
+
+	_, err = Middleware(Options{Keys: [][]byte{key}, EncryptionKeys: [][]byte{[]byte("not 16 bytes")}})
+	require.Error(t, err)
+}
@@ -0,0 +1,265 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SWEEP_INTERVAL is how often a store drops the sessions that expired without being loaded again.
+const SWEEP_INTERVAL = time.Minute
+
+/*
+Store keeps session values on the server, the cookie then only carries the session ID.
+Load reports false for a session that does not exist or has expired.
+*/
+type Store interface {
+	Load(id string) (map[string]string, bool, error)
+	Save(id string, values map[string]string, expires time.Time) error
+	Delete(id string) error
+}
+
+type entry struct {
+	Values  map[string]string `json:"values"`
+	Expires time.Time         `json:"expires"`
+}
+
+// MemoryStore keeps sessions in memory, they are lost when the process exits.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]entry
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries:   map[string]entry{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryStore) Load(id string) (map[string]string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[id]
+
+	if !ok {
+		return nil, false, nil
+	}
+
+	if time.Now().After(e.Expires) {
+		delete(s.entries, id)
+		return nil, false, nil
+	}
+
+	return copyValues(e.Values), true, nil
+}
+
+func (s *MemoryStore) Save(id string, values map[string]string, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[id] = entry{Values: copyValues(values), Expires: expires}
+
+	if now := time.Now(); now.Sub(s.lastSweep) > SWEEP_INTERVAL {
+		s.lastSweep = now
+
+		for id, e := range s.entries {
+			if now.After(e.Expires) {
+				delete(s.entries, id)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, id)
+
+	return nil
+}
+
+// Len is the number of sessions held, expired ones not swept yet included.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.entries)
+}
+
+/*
+FileStore keeps one JSON file per session in a directory, so sessions survive a restart.
+A file is written to a temporary name first and renamed, a crash never leaves half a session.
+*/
+type FileStore struct {
+	dir       string
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir, lastSweep: time.Now()}, nil
+}
+
+func (s *FileStore) Load(id string) (map[string]string, bool, error) {
+	path, err := s.path(id)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	e := entry{}
+
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, false, fmt.Errorf("corrupt session file %s: %s", path, err)
+	}
+
+	if time.Now().After(e.Expires) {
+		os.Remove(path)
+		return nil, false, nil
+	}
+
+	if e.Values == nil {
+		e.Values = map[string]string{}
+	}
+
+	return e.Values, true, nil
+}
+
+func (s *FileStore) Save(id string, values map[string]string, expires time.Time) error {
+	path, err := s.path(id)
+
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entry{Values: values, Expires: expires})
+
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(s.dir, ".session-*")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = temp.Write(data)
+
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+
+	s.sweep()
+
+	return nil
+}
+
+func (s *FileStore) Delete(id string) error {
+	path, err := s.path(id)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// sweep removes the files of expired sessions, at most once per SWEEP_INTERVAL.
+func (s *FileStore) sweep() {
+	s.mu.Lock()
+	now := time.Now()
+
+	if now.Sub(s.lastSweep) <= SWEEP_INTERVAL {
+		s.mu.Unlock()
+		return
+	}
+
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	paths, _ := filepath.Glob(filepath.Join(s.dir, "*.json"))
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+
+		if err != nil {
+			continue
+		}
+
+		e := entry{}
+
+		if json.Unmarshal(data, &e) == nil && now.After(e.Expires) {
+			os.Remove(path)
+		}
+	}
+}
+
+// path refuses anything but an ID this package generated, a forged one could point outside the directory.
+func (s *FileStore) path(id string) (string, error) {
+	if !validID(id) {
+		return "", fmt.Errorf("invalid session id %q", id)
+	}
+
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+/**
+* Helpers
+**/
+
+func copyValues(values map[string]string) map[string]string {
+	copied := make(map[string]string, len(values))
+
+	for key, value := range values {
+		copied[key] = value
+	}
+
+	return copied
+}
+
+func validID(id string) bool {
+	if len(id) != ID_SIZE*2 {
+		return false
+	}
+
+	return strings.Trim(id, "0123456789abcdef") == ""
+}
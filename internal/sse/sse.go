@@ -0,0 +1,187 @@
+package sse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+)
+
+const KEY_LAST_EVENT_ID = "Last-Event-ID"
+
+const CONTENT_TYPE = "text/event-stream"
+
+/*
+According to the HTML Living Standard 9.2, an event stream is a sequence of records
+separated by a blank line. Each record is made of "field: value" lines, where the
+field is one of event, data, id or retry. Lines starting with a colon are comments
+and are ignored by the client, which makes them handy as heartbeats.
+*/
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+type Stream struct {
+	mu     sync.Mutex
+	writer *response.Writer
+	closed bool
+}
+
+// NewStream writes the status line and the event stream headers, after that only events can be sent.
+func NewStream(w *response.Writer) (*Stream, error) {
+	headers := response.GetDefaultHeaders(0)
+	headers.Remove("Content-Length")
+	headers.Override("Content-Type", CONTENT_TYPE)
+	headers.Override("Cache-Control", "no-cache")
+	headers.Override("Transfer-Encoding", "chunked")
+
+	if err := w.WriteStatusLine(response.OK); err != nil {
+		return nil, err
+	}
+
+	if err := w.WriteHeaders(headers); err != nil {
+		return nil, err
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return &Stream{writer: w}, nil
+}
+
+func (s *Stream) Send(event Event) error {
+	record, err := event.encode()
+
+	if err != nil {
+		return err
+	}
+
+	return s.write(record)
+}
+
+// Comment sends a line that the client ignores, it is mostly used to keep idle connections open.
+func (s *Stream) Comment(text string) error {
+	if strings.ContainsAny(text, "\r\n") {
+		return fmt.Errorf("error: comment must be a single line")
+	}
+
+	return s.write([]byte(": " + text + "\n\n"))
+}
+
+/*
+Heartbeat sends a comment every interval until stop is called or a write fails,
+which usually means the client went away. A non-positive interval sends nothing.
+*/
+func (s *Stream) Heartbeat(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := s.Comment("heartbeat"); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+
+	_, err := s.writer.WriteChunkedBodyDone()
+
+	return err
+}
+
+func (s *Stream) write(record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("error: writing to a closed event stream")
+	}
+
+	if _, err := s.writer.WriteChunkedBody(record); err != nil {
+		return err
+	}
+
+	return s.writer.Flush()
+}
+
+// LastEventID is the id of the last event the client saw before reconnecting, or empty on the first connection.
+func LastEventID(req *request.Request) string {
+	id, _ := req.Headers.Get(KEY_LAST_EVENT_ID)
+
+	return id
+}
+
+/**
+* Helpers
+**/
+
+func (e Event) encode() ([]byte, error) {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return nil, fmt.Errorf("error: event id must be a single line without NULL")
+	}
+
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return nil, fmt.Errorf("error: event name must be a single line")
+	}
+
+	var record strings.Builder
+
+	if e.Event != "" {
+		record.WriteString("event: " + e.Event + "\n")
+	}
+
+	if e.ID != "" {
+		record.WriteString("id: " + e.ID + "\n")
+	}
+
+	if e.Retry > 0 {
+		record.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+
+	// a multi line payload is sent as one data field per line, the client joins them back with "\n"
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+
+	for line := range strings.SplitSeq(data, "\n") {
+		record.WriteString("data: " + line + "\n")
+	}
+
+	record.WriteString("\n")
+
+	return []byte(record.String()), nil
+}
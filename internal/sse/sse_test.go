@@ -0,0 +1,81 @@
+package sse
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sithusan/httpfromtcp/internal/request"
+	"github.com/sithusan/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStreamWritesEventStreamHeaders(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	_, err := NewStream(response.NewWriter(buffer))
+	require.NoError(t, err)
+
+	out := buffer.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-type: text/event-stream")
+	assert.Contains(t, out, "transfer-encoding: chunked")
+	assert.NotContains(t, out, "content-length")
+}
+
+func TestSendEventAsChunk(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	stream, err := NewStream(response.NewWriter(buffer))
+	require.NoError(t, err)
+	buffer.Reset()
+
+	err = stream.Send(Event{ID: "7", Event: "log", Data: "first\nsecond", Retry: 3 * time.Second})
+	require.NoError(t, err)
+
+	record := "event: log\nid: 7\nretry: 3000\ndata: first\ndata: second\n\n"
+	assert.Equal(t, fmt.Sprintf("%x\r\n%s\r\n", len(record), record), buffer.String())
+}
+
+func TestSendRejectsMultiLineID(t *testing.T) {
+	stream, err := NewStream(response.NewWriter(&bytes.Buffer{}))
+	require.NoError(t, err)
+
+	err = stream.Send(Event{ID: "1\n2", Data: "x"})
+	require.Error(t, err)
+}
+
+func TestCommentAndClose(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	stream, err := NewStream(response.NewWriter(buffer))
+	require.NoError(t, err)
+	buffer.Reset()
+
+	require.NoError(t, stream.Comment("ping"))
+	require.NoError(t, stream.Close())
+	assert.Equal(t, "8\r\n: ping\n\n\r\n0\r\n\r\n", buffer.String())
+
+	require.Error(t, stream.Send(Event{Data: "late"}))
+}
+
+func TestHeartbeatIgnoresNonPositiveInterval(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	stream, err := NewStream(response.NewWriter(buffer))
+	require.NoError(t, err)
+	buffer.Reset()
+
+	stop := stream.Heartbeat(0)
+	stop()
+	stop()
+
+	assert.Empty(t, buffer.String())
+}
+
+func TestLastEventID(t *testing.T) {
+	req := request.NewRequest()
+	assert.Equal(t, "", LastEventID(req))
+
+	req.Headers.Override("Last-Event-ID", "42")
+	assert.Equal(t, "42", LastEventID(req))
+}
@@ -0,0 +1,288 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sithusan/httpfromtcp/internal/headers"
+)
+
+// MAX_CHUNK_LINE bounds a chunk size line, extensions included, so it cannot grow the buffer forever.
+const MAX_CHUNK_LINE = 4096
+
+// MAX_TRAILER_BYTES bounds the trailer section read by a ChunkedReader.
+const MAX_TRAILER_BYTES = 64 * 1024
+
+const READ_SIZE = 4096
+
+var ErrBodyTooLarge = errors.New("body too large")
+var ErrTrailersTooLarge = errors.New("chunked trailers too large")
+
+var CLRF = []byte("\r\n")
+
+type chunkState int
+
+const (
+	chunkStateSize chunkState = iota
+	chunkStateData
+	chunkStateDataEnd
+	chunkStateTrailers
+	chunkStateDone
+)
+
+/*
+According to RFC9112 7.1, a chunked body is a series of chunks, each prefixed with
+its size in hex (and optional extensions), followed by a zero-sized last chunk,
+optional trailer fields and a final CRLF:
+
+	5;ext=1\r\n
+	hello\r\n
+	0\r\n
+	Checksum: abc\r\n
+	\r\n
+
+ChunkedDecoder is the state machine the request and response parsers share. Like them, each call
+to Decode consumes one piece and returns 0 when it needs more data.
+*/
+type ChunkedDecoder struct {
+	// Trailers are the fields sent after the last chunk, set once it is reached.
+	Trailers headers.Headers
+
+	chunkState     chunkState
+	chunkRemaining int64
+	length         int64
+}
+
+func NewChunkedDecoder() *ChunkedDecoder {
+	return &ChunkedDecoder{chunkState: chunkStateSize}
+}
+
+func (d *ChunkedDecoder) Done() bool {
+	return d.chunkState == chunkStateDone
+}
+
+// Length is the sum of the chunk sizes announced so far, a body limit can be checked against it before the data arrives.
+func (d *ChunkedDecoder) Length() int64 {
+	return d.length
+}
+
+// Decode consumes one piece of data, chunk is the part of it that belongs to the body, if any.
+func (d *ChunkedDecoder) Decode(data []byte) (n int, chunk []byte, err error) {
+	switch d.chunkState {
+	case chunkStateSize:
+		idx := bytes.Index(data, CLRF)
+
+		if idx == -1 {
+			if len(data) > MAX_CHUNK_LINE {
+				return 0, nil, fmt.Errorf("chunk size line longer than %d bytes", MAX_CHUNK_LINE)
+			}
+			return 0, nil, nil
+		}
+
+		size, err := parseChunkSize(data[:idx])
+
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if size > math.MaxInt64-d.length {
+			return 0, nil, fmt.Errorf("chunked body length overflows")
+		}
+
+		d.length += size
+
+		if size == 0 {
+			d.chunkState = chunkStateTrailers
+		} else {
+			d.chunkRemaining = size
+			d.chunkState = chunkStateData
+		}
+
+		return idx + len(CLRF), nil, nil
+	case chunkStateData:
+		n := int(min(int64(len(data)), d.chunkRemaining))
+		d.chunkRemaining -= int64(n)
+
+		if d.chunkRemaining == 0 {
+			d.chunkState = chunkStateDataEnd
+		}
+
+		return n, data[:n], nil
+	case chunkStateDataEnd:
+		if len(data) < len(CLRF) {
+			return 0, nil, nil
+		}
+
+		if !bytes.HasPrefix(data, CLRF) {
+			return 0, nil, fmt.Errorf("chunk data not followed by CRLF")
+		}
+
+		d.chunkState = chunkStateSize
+
+		return len(CLRF), nil, nil
+	case chunkStateTrailers:
+		if d.Trailers == nil {
+			d.Trailers = headers.NewHeaders()
+		}
+
+		n, trailersDone, err := d.Trailers.Parse(data)
+
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if trailersDone {
+			d.chunkState = chunkStateDone
+		}
+
+		return n, nil, nil
+	case chunkStateDone:
+		return 0, nil, fmt.Errorf("error: trying to decode the data in done state")
+	default:
+		return 0, nil, fmt.Errorf("error: unknown chunk state")
+	}
+}
+
+/*
+ChunkedReader streams a chunked body from reader, the chunk framing removed, instead of buffering
+it whole. It ends with io.EOF after the trailers, see Trailers. Reading from reader is done
+in blocks, so it may read past the body, Unread returns those bytes.
+*/
+type ChunkedReader struct {
+	reader  io.Reader
+	decoder *ChunkedDecoder
+	options ChunkedReaderOptions
+
+	// pending is read but not decoded, chunk is decoded but not returned yet
+	pending      []byte
+	chunk        []byte
+	scratch      []byte
+	trailerBytes int
+	err          error
+}
+
+type ChunkedReaderOptions struct {
+	// Pending are bytes of the body already read from reader, like what a parser read past the head.
+	Pending []byte
+	// MaxBytes fails the body with ErrTooLarge once the chunks announce more, before their data
+	// is read. Zero means no limit.
+	MaxBytes int64
+	// ErrTooLarge is wrapped by that failure, like the ErrBodyTooLarge of a parser.
+	ErrTooLarge error
+}
+
+func NewChunkedReader(reader io.Reader, options ChunkedReaderOptions) *ChunkedReader {
+	if options.ErrTooLarge == nil {
+		options.ErrTooLarge = ErrBodyTooLarge
+	}
+
+	return &ChunkedReader{
+		reader:  reader,
+		decoder: NewChunkedDecoder(),
+		options: options,
+		pending: options.Pending,
+	}
+}
+
+func (r *ChunkedReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.decoder.Done() {
+			return 0, io.EOF
+		}
+
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		n, chunk, err := r.decoder.Decode(r.pending)
+
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+
+		if r.options.MaxBytes > 0 && r.decoder.Length() > r.options.MaxBytes {
+			r.err = fmt.Errorf("%w: chunked body exceeds %d", r.options.ErrTooLarge, r.options.MaxBytes)
+			return 0, r.err
+		}
+
+		// a trailer line still waiting for its CRLF counts too
+		if r.decoder.chunkState >= chunkStateTrailers {
+			r.trailerBytes += n
+
+			if r.trailerBytes+len(r.pending)-n > MAX_TRAILER_BYTES {
+				r.err = ErrTrailersTooLarge
+				return 0, r.err
+			}
+		}
+
+		// chunk points into pending, which is only appended to once chunk has been returned
+		r.chunk = chunk
+		r.pending = r.pending[n:]
+
+		if n == 0 {
+			r.err = r.fill()
+		}
+	}
+
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+
+	return n, nil
+}
+
+// Trailers are the fields after the last chunk, nil until Read returned io.EOF.
+func (r *ChunkedReader) Trailers() headers.Headers {
+	if !r.decoder.Done() {
+		return nil
+	}
+
+	return r.decoder.Trailers
+}
+
+// Unread is what was read past the end of the body, once Read returned io.EOF.
+func (r *ChunkedReader) Unread() []byte {
+	return r.pending
+}
+
+func (r *ChunkedReader) fill() error {
+	if r.scratch == nil {
+		r.scratch = make([]byte, READ_SIZE)
+	}
+
+	n, err := r.reader.Read(r.scratch)
+	r.pending = append(r.pending, r.scratch[:n]...)
+
+	if n > 0 || err == nil {
+		return nil
+	}
+
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("incomplete chunked body: %w", io.ErrUnexpectedEOF)
+	}
+
+	return err
+}
+
+/**
+* Helpers
+**/
+
+// parseChunkSize reads the hex size in front of the extensions of a chunk size line.
+func parseChunkSize(line []byte) (int64, error) {
+	sizeStr, _, _ := strings.Cut(string(line), ";")
+	sizeStr = strings.TrimSpace(sizeStr)
+
+	size, err := strconv.ParseInt(sizeStr, 16, 64)
+
+	if err != nil || size < 0 || sizeStr == "" || strings.HasPrefix(sizeStr, "+") {
+		return 0, fmt.Errorf("malformed chunk size: %q", sizeStr)
+	}
+
+	return size, nil
+}
@@ -0,0 +1,134 @@
+package transfer
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call
+// its useful for simulating reading a variable number of bytes per chunk from a network connection
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+
+	endIndex := min(cr.pos+cr.numBytesPerRead, len(cr.data))
+
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+
+	return n, nil
+}
+
+// decode feeds data to a ChunkedDecoder numBytesPerRead at a time, like a parser reading a connection.
+func decode(data string, numBytesPerRead int) (string, *ChunkedDecoder, error) {
+	decoder := NewChunkedDecoder()
+	body := []byte{}
+	pending := []byte{}
+
+	for pos := 0; !decoder.Done(); {
+		if pos < len(data) {
+			end := min(pos+numBytesPerRead, len(data))
+			pending = append(pending, data[pos:end]...)
+			pos = end
+		} else if len(pending) == 0 {
+			return "", nil, io.ErrUnexpectedEOF
+		}
+
+		for !decoder.Done() {
+			n, chunk, err := decoder.Decode(pending)
+
+			if err != nil {
+				return "", nil, err
+			}
+
+			body = append(body, chunk...)
+			pending = pending[n:]
+
+			if n == 0 {
+				break
+			}
+		}
+
+		if pos >= len(data) && !decoder.Done() && len(pending) > 0 {
+			return "", nil, io.ErrUnexpectedEOF
+		}
+	}
+
+	return string(body), decoder, nil
+}
+
+func TestChunkedDecoder(t *testing.T) {
+	body, decoder, err := decode("5;ext=1\r\nhello\r\n7\r\n, world\r\n0\r\nX-Checksum: abc\r\n\r\n", 3)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", body)
+	assert.Equal(t, "abc", decoder.Trailers["x-checksum"])
+	assert.Equal(t, int64(12), decoder.Length())
+
+	body, decoder, err = decode("A\r\n0123456789\r\n0\r\n\r\n", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", body)
+	assert.Empty(t, decoder.Trailers)
+}
+
+func TestChunkedDecoderRejectsMalformedBodies(t *testing.T) {
+	cases := map[string]string{
+		"not hex":           "zz\r\nhello\r\n0\r\n\r\n",
+		"empty size":        "\r\nhello\r\n0\r\n\r\n",
+		"signed size":       "+5\r\nhello\r\n0\r\n\r\n",
+		"data without CRLF": "5\r\nhelloXX0\r\n\r\n",
+		"endless size line": strings.Repeat("1", MAX_CHUNK_LINE+1),
+		"truncated":         "5\r\nhel",
+	}
+
+	for name, data := range cases {
+		_, _, err := decode(data, 64)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestChunkedReader(t *testing.T) {
+	reader := NewChunkedReader(&chunkReader{
+		data:            "2\r\nhe\r\n3\r\nllo\r\n0\r\nX-Checksum: abc\r\n\r\nGET / HTTP/1.1\r\n",
+		numBytesPerRead: 5,
+	}, ChunkedReaderOptions{Pending: []byte("5\r\nsay, \r\n")})
+
+	assert.Nil(t, reader.Trailers())
+
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "say, hello", string(body))
+	assert.Equal(t, "abc", reader.Trailers()["x-checksum"])
+
+	// what was read past the body is left for the next request
+	assert.True(t, strings.HasPrefix("GET / HTTP/1.1\r\n", string(reader.Unread())))
+}
+
+func TestChunkedReaderErrors(t *testing.T) {
+	errTooLarge := errors.New("too large")
+
+	reader := NewChunkedReader(strings.NewReader("5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"), ChunkedReaderOptions{MaxBytes: 8, ErrTooLarge: errTooLarge})
+	body, err := io.ReadAll(reader)
+	require.ErrorIs(t, err, errTooLarge)
+	// the second chunk is refused from its size line, before its data is read
+	assert.Equal(t, "hello", string(body))
+
+	reader = NewChunkedReader(strings.NewReader("5\r\nhel"), ChunkedReaderOptions{})
+	_, err = io.ReadAll(reader)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	reader = NewChunkedReader(strings.NewReader("0\r\nX-Big: "+strings.Repeat("a", MAX_TRAILER_BYTES)), ChunkedReaderOptions{})
+	_, err = io.ReadAll(reader)
+	require.ErrorIs(t, err, ErrTrailersTooLarge)
+}
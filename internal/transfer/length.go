@@ -0,0 +1,81 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+LengthReader reads a body of a known length, like a Content-Length, off reader. A connection
+that ends before length bytes is io.ErrUnexpectedEOF, so a cut body is never taken for a whole one.
+*/
+type LengthReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func NewLengthReader(reader io.Reader, length int64) *LengthReader {
+	return &LengthReader{reader: reader, remaining: length}
+}
+
+func (r *LengthReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+
+	if r.remaining > 0 && errors.Is(err, io.EOF) {
+		return n, fmt.Errorf("body ended %d bytes early: %w", r.remaining, io.ErrUnexpectedEOF)
+	}
+
+	if r.remaining == 0 && err == nil {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+/*
+LimitReader fails with errTooLarge, wrapped, once reader gives more than maxBytes, where
+io.LimitReader would stop quietly and pass a cut body on as a whole one.
+*/
+type LimitReader struct {
+	reader      io.Reader
+	remaining   int64
+	maxBytes    int64
+	errTooLarge error
+}
+
+func NewLimitReader(reader io.Reader, maxBytes int64, errTooLarge error) *LimitReader {
+	if errTooLarge == nil {
+		errTooLarge = ErrBodyTooLarge
+	}
+
+	return &LimitReader{reader: reader, remaining: maxBytes, maxBytes: maxBytes, errTooLarge: errTooLarge}
+}
+
+func (r *LimitReader) Read(p []byte) (int, error) {
+	// one byte more than allowed tells a body of exactly maxBytes from a longer one
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+
+	n, err := r.reader.Read(p)
+
+	if int64(n) > r.remaining {
+		n = int(r.remaining)
+		r.remaining = 0
+		return n, fmt.Errorf("%w: body exceeds %d", r.errTooLarge, r.maxBytes)
+	}
+
+	r.remaining -= int64(n)
+
+	return n, err
+}
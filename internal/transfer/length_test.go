@@ -0,0 +1,44 @@
+package transfer
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLengthReader(t *testing.T) {
+	reader := strings.NewReader("hello, worldGET /")
+	body, err := io.ReadAll(NewLengthReader(&chunkReader{data: "hello, worldGET /", numBytesPerRead: 5}, 12))
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(body))
+
+	// the bytes past the length are left on the reader
+	length := NewLengthReader(reader, 12)
+	_, err = io.ReadAll(length)
+	require.NoError(t, err)
+	rest, _ := io.ReadAll(reader)
+	assert.Equal(t, "GET /", string(rest))
+
+	_, err = io.ReadAll(NewLengthReader(strings.NewReader("hel"), 5))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	body, err = io.ReadAll(NewLengthReader(strings.NewReader("ignored"), 0))
+	require.NoError(t, err)
+	assert.Empty(t, body)
+}
+
+func TestLimitReader(t *testing.T) {
+	errTooLarge := errors.New("too large")
+
+	body, err := io.ReadAll(NewLimitReader(strings.NewReader("hello"), 5, errTooLarge))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	body, err = io.ReadAll(NewLimitReader(&chunkReader{data: "hello, world", numBytesPerRead: 3}, 5, errTooLarge))
+	require.ErrorIs(t, err, errTooLarge)
+	assert.Equal(t, "hello", string(body))
+}